@@ -0,0 +1,658 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// maxBitOffset is the largest bit offset of a string value
+const maxBitOffset = maxStringSize*8 - 1
+
+// `growString` zero pads str up to length bytes
+func growString(str []byte, length int) []byte {
+	if length <= len(str) {
+		return str
+	}
+	grown := make([]byte, length)
+	copy(grown, str)
+	return grown
+}
+
+// `getBitAt` returns the bit at offset, bits past the end of
+// the string are 0. Bit 0 is the most significant bit of the
+// first byte
+func getBitAt(str []byte, offset int64) int {
+	byteIndex := offset >> 3
+	if byteIndex >= int64(len(str)) {
+		return 0
+	}
+	return int(str[byteIndex]>>(7-uint(offset&7))) & 1
+}
+
+// `setBitAt` sets the bit at offset, the string must be
+// large enough to hold it
+func setBitAt(str []byte, offset int64, bit int) {
+	byteIndex := offset >> 3
+	mask := byte(1 << (7 - uint(offset&7)))
+	if bit == 1 {
+		str[byteIndex] |= mask
+	} else {
+		str[byteIndex] &^= mask
+	}
+}
+
+// `parseBitOffset` parses a bit offset. BITFIELD offsets prefixed
+// with '#' are multiplied by the width of the field
+func parseBitOffset(arg []byte, hash bool, width int64) (int64, bool) {
+	if hash && len(arg) > 0 && arg[0] == '#' {
+		offset, ok := parseInteger(arg[1:])
+		if !ok || offset < 0 || offset > maxBitOffset/width {
+			return 0, false
+		}
+		offset *= width
+		return offset, offset+width-1 <= maxBitOffset
+	}
+	offset, ok := parseInteger(arg)
+	// compared without adding the two, which could overflow
+	if !ok || offset < 0 || offset > maxBitOffset-(width-1) {
+		return 0, false
+	}
+	return offset, true
+}
+
+// `getStringForBitOp` retrieves the string stored at key, creating
+// the key if needed, so that the bit operation can modify it in place
+func (s *store) getStringForBitOp(key string) (*redisValue, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return &redisValue{
+			value:     []byte{},
+			valueType: "string",
+		}, true
+	}
+	if value.valueType != "string" {
+		return nil, false
+	}
+	return value, true
+}
+
+// SETBIT command sets or clears the bit at offset in the string
+// stored at key, responding back with the previous bit
+func setbit(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("setbit")
+	}
+	key := string(args[0])
+	offset, ok := parseBitOffset(args[1], false, 1)
+	if !ok {
+		return errorReply("bit offset is not an integer or out of range")
+	}
+	if string(args[2]) != "0" && string(args[2]) != "1" {
+		return errorReply("bit is not an integer or out of range")
+	}
+	bit := int(args[2][0] - '0')
+	value, ok := s.getStringForBitOp(key)
+	if !ok {
+		return errorReply("value is not of string type")
+	}
+	str := growString(value.value.([]byte), int(offset>>3)+1)
+	previous := getBitAt(str, offset)
+	setBitAt(str, offset, bit)
+	value.value = str
+	s.set(key, value)
+	s.notifyKeyspaceEvent(notifyString, "setbit", key)
+	response := resp.Integer{
+		Data: int64(previous),
+	}
+	return response.Serialise()
+}
+
+// GETBIT command returns the bit at offset in the string stored at key
+func getbit(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("getbit")
+	}
+	offset, ok := parseBitOffset(args[1], false, 1)
+	if !ok {
+		return errorReply("bit offset is not an integer or out of range")
+	}
+	str, _, isString := s.getString(string(args[0]))
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	response := resp.Integer{
+		Data: int64(getBitAt(str, offset)),
+	}
+	return response.Serialise()
+}
+
+// `bitRange` converts the start and end offsets of BITCOUNT and
+// BITPOS, counted in bytes or in bits, into an inclusive range of
+// bits. Negative offsets are counted from the end of the string.
+// It returns false if the range is empty
+func bitRange(length int64, start, end int64, isBit bool) (int64, int64, bool) {
+	total := length
+	if isBit {
+		total = length * 8
+	}
+	if start < 0 {
+		start = total + start
+	}
+	if end < 0 {
+		end = total + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	if isBit {
+		return start, end, true
+	}
+	return start * 8, end*8 + 7, true
+}
+
+// `parseBitRangeArgs` parses the optional start, end and BYTE|BIT
+// arguments of BITCOUNT and BITPOS
+func parseBitRangeArgs(args [][]byte) (start, end int64, endGiven, isBit bool, message string) {
+	var ok bool
+	if len(args) > 3 {
+		return 0, 0, false, false, "invalid syntax"
+	}
+	if len(args) > 0 {
+		if start, ok = parseInteger(args[0]); !ok {
+			return 0, 0, false, false, "value is not an integer or out of range"
+		}
+	}
+	if len(args) > 1 {
+		if end, ok = parseInteger(args[1]); !ok {
+			return 0, 0, false, false, "value is not an integer or out of range"
+		}
+		endGiven = true
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(string(args[2])) {
+		case "BYTE":
+		case "BIT":
+			isBit = true
+		default:
+			return 0, 0, false, false, "invalid syntax"
+		}
+	}
+	return start, end, endGiven, isBit, ""
+}
+
+// BITCOUNT command counts the set bits in the string stored at key,
+// optionally limited to a range of bytes or bits
+func bitcount(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("bitcount")
+	}
+	// start and end must be passed together
+	if len(args) == 2 {
+		return errorReply("invalid syntax")
+	}
+	start, end, _, isBit, message := parseBitRangeArgs(args[1:])
+	if message != "" {
+		return errorReply(message)
+	}
+	str, _, isString := s.getString(string(args[0]))
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	if len(args) == 1 {
+		start, end = 0, -1
+	}
+	response := resp.Integer{}
+	startBit, endBit, ok := bitRange(int64(len(str)), start, end, isBit)
+	if !ok {
+		return response.Serialise()
+	}
+	count := 0
+	for bit := startBit; bit <= endBit; {
+		// count whole bytes at once
+		if bit&7 == 0 && bit+7 <= endBit {
+			count += bits.OnesCount8(str[bit>>3])
+			bit += 8
+			continue
+		}
+		count += getBitAt(str, bit)
+		bit++
+	}
+	response.Data = int64(count)
+	return response.Serialise()
+}
+
+// BITPOS command returns the position of the first bit set to
+// 1 or 0 in the string stored at key
+func bitpos(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("bitpos")
+	}
+	if string(args[1]) != "0" && string(args[1]) != "1" {
+		return errorReply("the bit argument must be 1 or 0")
+	}
+	bit := int(args[1][0] - '0')
+	start, end, endGiven, isBit, message := parseBitRangeArgs(args[2:])
+	if message != "" {
+		return errorReply(message)
+	}
+	str, exists, isString := s.getString(string(args[0]))
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	response := resp.Integer{
+		Data: -1,
+	}
+	// a missing key is an empty string, which is all clear bits
+	if !exists {
+		if bit == 0 {
+			response.Data = 0
+		}
+		return response.Serialise()
+	}
+	if !endGiven {
+		end = -1
+	}
+	startBit, endBit, ok := bitRange(int64(len(str)), start, end, isBit)
+	if !ok {
+		return response.Serialise()
+	}
+	skip := byte(0xff)
+	if bit == 1 {
+		skip = 0
+	}
+	for position := startBit; position <= endBit; {
+		// skip bytes that can't contain the bit
+		if position&7 == 0 && position+7 <= endBit && str[position>>3] == skip {
+			position += 8
+			continue
+		}
+		if getBitAt(str, position) == bit {
+			response.Data = position
+			return response.Serialise()
+		}
+		position++
+	}
+	// when looking for a clear bit without an end, the string
+	// is considered to be padded with zeros on the right
+	if bit == 0 && !endGiven {
+		response.Data = endBit + 1
+	}
+	return response.Serialise()
+}
+
+// BITOP command performs a bitwise operation between strings and
+// stores the result in destkey. Supported operations are AND, OR,
+// XOR, NOT, DIFF, DIFF1, ANDOR and ONE
+func bitop(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("bitop")
+	}
+	operation := strings.ToUpper(string(args[0]))
+	destination := string(args[1])
+	keys := args[2:]
+	switch operation {
+	case "AND", "OR", "XOR", "ONE":
+	case "NOT":
+		if len(keys) != 1 {
+			return errorReply("BITOP NOT must be called with a single source key.")
+		}
+	case "DIFF", "DIFF1", "ANDOR":
+		if len(keys) < 2 {
+			return errorReply("BITOP " + operation + " must be called with at least two source keys.")
+		}
+	default:
+		return errorReply("invalid syntax")
+	}
+	sources := make([][]byte, len(keys))
+	maxLength := 0
+	for i, key := range keys {
+		str, _, isString := s.getString(string(key))
+		if !isString {
+			return errorReply("value is not of string type")
+		}
+		sources[i] = str
+		maxLength = max(maxLength, len(str))
+	}
+	// missing bytes of shorter strings are treated as zeros
+	byteAt := func(str []byte, i int) byte {
+		if i < len(str) {
+			return str[i]
+		}
+		return 0
+	}
+	result := make([]byte, maxLength)
+	for i := 0; i < maxLength; i++ {
+		first := byteAt(sources[0], i)
+		// others is the OR of every source but the first
+		var others byte
+		for _, str := range sources[1:] {
+			others |= byteAt(str, i)
+		}
+		switch operation {
+		case "AND":
+			result[i] = first
+			for _, str := range sources[1:] {
+				result[i] &= byteAt(str, i)
+			}
+		case "OR":
+			result[i] = first | others
+		case "XOR":
+			result[i] = first
+			for _, str := range sources[1:] {
+				result[i] ^= byteAt(str, i)
+			}
+		case "NOT":
+			result[i] = ^first
+		case "DIFF":
+			result[i] = first &^ others
+		case "DIFF1":
+			result[i] = others &^ first
+		case "ANDOR":
+			result[i] = first & others
+		case "ONE":
+			// bits set in exactly one of the sources
+			var seenOnce, seenTwice byte
+			for _, str := range sources {
+				b := byteAt(str, i)
+				seenTwice |= seenOnce & b
+				seenOnce ^= b
+				seenOnce &^= seenTwice
+			}
+			result[i] = seenOnce
+		}
+	}
+	if maxLength == 0 {
+		if _, ok := s.get(destination); ok {
+			delete(s.db, destination)
+			s.notifyKeyspaceEvent(notifyGeneric, "del", destination)
+		}
+	} else {
+		s.set(destination, &redisValue{
+			value:     result,
+			valueType: "string",
+		})
+		s.notifyKeyspaceEvent(notifyString, "set", destination)
+	}
+	response := resp.Integer{
+		Data: int64(maxLength),
+	}
+	return response.Serialise()
+}
+
+// BITFIELD overflow behaviours
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// `bitfieldOperation` is a single GET, SET or INCRBY
+// subcommand of BITFIELD
+type bitfieldOperation struct {
+	command  string
+	signed   bool
+	width    int64
+	offset   int64
+	argument int64
+	overflow int
+}
+
+// `parseBitfieldType` parses a type like i16 or u8. Signed
+// fields are up to 64 bits wide, unsigned up to 63
+func parseBitfieldType(arg []byte) (bool, int64, bool) {
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'u' && arg[0] != 'I' && arg[0] != 'U') {
+		return false, 0, false
+	}
+	signed := arg[0] == 'i' || arg[0] == 'I'
+	width, ok := parseInteger(arg[1:])
+	if !ok || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, false
+	}
+	return signed, width, true
+}
+
+// `getField` reads a field of width bits at offset
+func getField(str []byte, offset int64, width int64, signed bool) int64 {
+	var value uint64
+	for i := int64(0); i < width; i++ {
+		value = value<<1 | uint64(getBitAt(str, offset+i))
+	}
+	// sign extend negative values
+	if signed && width < 64 && value&(1<<(width-1)) != 0 {
+		value |= math.MaxUint64 << width
+	}
+	return int64(value)
+}
+
+// `setField` writes a field of width bits at offset, the
+// string must be large enough to hold it
+func setField(str []byte, offset int64, width int64, value int64) {
+	for i := int64(0); i < width; i++ {
+		setBitAt(str, offset+i, int(uint64(value)>>(width-1-i))&1)
+	}
+}
+
+// `wrapField` truncates value to width bits, sign extending it
+// for signed fields
+func wrapField(value uint64, width int64, signed bool) int64 {
+	if width == 64 {
+		return int64(value)
+	}
+	mask := uint64(math.MaxUint64) << width
+	if signed && value&(1<<(width-1)) != 0 {
+		return int64(value | mask)
+	}
+	return int64(value &^ mask)
+}
+
+// `applyFieldOverflow` adds increment to value, handling overflows
+// of the field's range according to the overflow behaviour. It
+// returns false if the operation fails because of an overflow
+func applyFieldOverflow(value, increment int64, width int64, signed bool, overflow int) (int64, bool) {
+	if !signed {
+		maximum := uint64(1)<<width - 1
+		unsignedValue := uint64(value)
+		var overflowed, underflowed bool
+		if unsignedValue > maximum || (increment > 0 && uint64(increment) > maximum-unsignedValue) {
+			overflowed = true
+		} else if increment < 0 && uint64(-increment) > unsignedValue {
+			underflowed = true
+		}
+		if !overflowed && !underflowed {
+			return int64(unsignedValue + uint64(increment)), true
+		}
+		switch overflow {
+		case overflowWrap:
+			return wrapField(unsignedValue+uint64(increment), width, false), true
+		case overflowSat:
+			if overflowed {
+				return int64(maximum), true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	maximum := int64(math.MaxInt64)
+	if width < 64 {
+		maximum = int64(1)<<(width-1) - 1
+	}
+	minimum := -maximum - 1
+	var overflowed, underflowed bool
+	if value > maximum || (increment > 0 && value >= 0 && increment > maximum-value) ||
+		(width != 64 && increment > 0 && value < 0 && value+increment > maximum) {
+		overflowed = true
+	} else if value < minimum || (increment < 0 && value < 0 && increment < minimum-value) ||
+		(width != 64 && increment < 0 && value >= 0 && value+increment < minimum) {
+		underflowed = true
+	}
+	if !overflowed && !underflowed {
+		return value + increment, true
+	}
+	switch overflow {
+	case overflowWrap:
+		return wrapField(uint64(value)+uint64(increment), width, true), true
+	case overflowSat:
+		if overflowed {
+			return maximum, true
+		}
+		return minimum, true
+	}
+	return 0, false
+}
+
+// `bitfieldGeneric` implements BITFIELD and BITFIELD_RO
+func bitfieldGeneric(args [][]byte, s *store, readOnly bool) ([]byte, error) {
+	command := "bitfield"
+	if readOnly {
+		command = "bitfield_ro"
+	}
+	if len(args) < 1 {
+		return wrongNumberOfArgs(command)
+	}
+	key := string(args[0])
+	var operations []bitfieldOperation
+	overflow := overflowWrap
+	writes := false
+	for i := 1; i < len(args); i++ {
+		subcommand := strings.ToUpper(string(args[i]))
+		remaining := len(args) - i - 1
+		switch subcommand {
+		case "OVERFLOW":
+			if remaining < 1 {
+				return errorReply("invalid syntax")
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return errorReply("invalid OVERFLOW type specified")
+			}
+			i++
+			continue
+		case "GET":
+			if remaining < 2 {
+				return errorReply("invalid syntax")
+			}
+		case "SET", "INCRBY":
+			if remaining < 3 {
+				return errorReply("invalid syntax")
+			}
+			if readOnly {
+				return errorReply("BITFIELD_RO only supports the GET subcommand")
+			}
+			writes = true
+		default:
+			return errorReply("invalid syntax")
+		}
+		signed, width, ok := parseBitfieldType(args[i+1])
+		if !ok {
+			return errorReply("invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		offset, ok := parseBitOffset(args[i+2], true, width)
+		if !ok {
+			return errorReply("bit offset is not an integer or out of range")
+		}
+		operation := bitfieldOperation{
+			command:  subcommand,
+			signed:   signed,
+			width:    width,
+			offset:   offset,
+			overflow: overflow,
+		}
+		if subcommand != "GET" {
+			argument, ok := parseInteger(args[i+3])
+			if !ok {
+				return errorReply("value is not an integer or out of range")
+			}
+			operation.argument = argument
+			i++
+		}
+		operations = append(operations, operation)
+		i += 2
+	}
+	var str []byte
+	var value *redisValue
+	if writes {
+		var ok bool
+		value, ok = s.getStringForBitOp(key)
+		if !ok {
+			return errorReply("value is not of string type")
+		}
+		str = value.value.([]byte)
+	} else {
+		var isString bool
+		str, _, isString = s.getString(key)
+		if !isString {
+			return errorReply("value is not of string type")
+		}
+	}
+	response := resp.Array{
+		Size: len(operations),
+	}
+	changed := false
+	for _, operation := range operations {
+		if operation.command == "GET" {
+			response.Elements = append(response.Elements, &resp.Integer{
+				Data: getField(str, operation.offset, operation.width, operation.signed),
+			})
+			continue
+		}
+		str = growString(str, int((operation.offset+operation.width-1)>>3)+1)
+		old := getField(str, operation.offset, operation.width, operation.signed)
+		var updated int64
+		var ok bool
+		if operation.command == "SET" {
+			updated, ok = applyFieldOverflow(operation.argument, 0, operation.width, operation.signed, operation.overflow)
+		} else {
+			updated, ok = applyFieldOverflow(old, operation.argument, operation.width, operation.signed, operation.overflow)
+		}
+		if !ok {
+			response.Elements = append(response.Elements, &resp.BulkString{Size: -1})
+			continue
+		}
+		setField(str, operation.offset, operation.width, updated)
+		changed = true
+		// SET replies with the old value, INCRBY with the new one
+		reply := updated
+		if operation.command == "SET" {
+			reply = old
+		}
+		response.Elements = append(response.Elements, &resp.Integer{
+			Data: reply,
+		})
+	}
+	if changed {
+		value.value = str
+		s.set(key, value)
+		s.notifyKeyspaceEvent(notifyString, "setbit", key)
+	}
+	return response.Serialise()
+}
+
+// BITFIELD command reads and writes integer fields of
+// arbitrary width stored in a string
+func bitfield(args [][]byte, s *store) ([]byte, error) {
+	return bitfieldGeneric(args, s, false)
+}
+
+// BITFIELD_RO command is the read only variant of BITFIELD,
+// which only supports GET
+func bitfieldRO(args [][]byte, s *store) ([]byte, error) {
+	return bitfieldGeneric(args, s, true)
+}
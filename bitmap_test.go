@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestBitmapCommands(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SETBIT", "bits", "7", "1"}, integerReply(0)},
+		{[]string{"SETBIT", "bits", "7", "1"}, integerReply(1)},
+		{[]string{"GETBIT", "bits", "7"}, integerReply(1)},
+		{[]string{"GETBIT", "bits", "100"}, integerReply(0)},
+		{[]string{"GET", "bits"}, bulkReply("\x01")},
+		{[]string{"SET", "str", "foobar"}, "+OK\r\n"},
+		{[]string{"BITCOUNT", "str"}, integerReply(26)},
+		{[]string{"BITCOUNT", "str", "1", "1"}, integerReply(6)},
+		{[]string{"BITCOUNT", "str", "5", "30", "BIT"}, integerReply(17)},
+		{[]string{"BITPOS", "bits", "1"}, integerReply(7)},
+		{[]string{"BITPOS", "bits", "0"}, integerReply(0)},
+		{[]string{"BITFIELD", "field", "SET", "u8", "0", "255", "GET", "u8", "0"}, "*2\r\n:0\r\n:255\r\n"},
+		{[]string{"BITFIELD", "field", "INCRBY", "u8", "0", "1"}, "*1\r\n:0\r\n"},
+		{[]string{"BITFIELD", "field", "OVERFLOW", "SAT", "INCRBY", "i8", "#1", "200"}, "*1\r\n:127\r\n"},
+		{[]string{"SETBIT", "bits", "4294967296", "1"}, "-bit offset is not an integer or out of range\r\n"},
+		// offsets that would overflow once the width is added
+		{[]string{"BITFIELD", "field", "SET", "u8", "9223372036854775807", "1"}, "-bit offset is not an integer or out of range\r\n"},
+		{[]string{"BITFIELD", "field", "GET", "i64", "#9223372036854775807"}, "-bit offset is not an integer or out of range\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// `blockedClient` is a client waiting for one of its keys to
+// receive data
+type blockedClient struct {
+	keys []string
+	// timeout is zero when the client blocks indefinitely
+	timeout time.Duration
+	// serve tries to serve the client from key. It reports false
+	// when key holds nothing the client can consume
+	serve func(key string, s *store) ([]byte, bool, error)
+	// serveAll, when set, tries to serve the client before it
+	// blocks from all of its keys at once, as XREAD does
+	serveAll func(s *store) ([]byte, bool, error)
+	// nilReply is sent once the timeout elapses
+	nilReply func() ([]byte, error)
+	// reply receives the response once the client is served
+	reply  chan blockedReply
+	served bool
+}
+
+type blockedReply struct {
+	data []byte
+	err  error
+}
+
+// `isBlockingCommand` reports whether command may block the client,
+// XREAD and XREADGROUP only block when given the BLOCK option
+func isBlockingCommand(command [][]byte) bool {
+	switch string(command[0]) {
+	case "BLPOP", "BRPOP", "BLMOVE", "BLMPOP", "BZPOPMIN", "BZPOPMAX", "BZMPOP":
+		return true
+	case "XREAD", "XREADGROUP":
+		for i := 1; i < len(command); i++ {
+			switch strings.ToUpper(string(command[i])) {
+			case "BLOCK":
+				return true
+			case "GROUP":
+				// skip the group and consumer names
+				i += 2
+			case "STREAMS":
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// `parseTimeout` parses the timeout of a blocking command, given
+// in seconds with an optional fractional part
+func parseTimeout(arg []byte) (time.Duration, string) {
+	seconds, ok := parseFloat(arg)
+	if !ok || math.IsInf(seconds, 0) {
+		return 0, "timeout is not a float or out of range"
+	}
+	if seconds < 0 {
+		return 0, "timeout is negative"
+	}
+	if seconds*float64(time.Second) >= math.MaxInt64 {
+		return 0, "timeout is out of range"
+	}
+	return time.Duration(seconds * float64(time.Second)), ""
+}
+
+// `parseDirection` parses the LEFT|RIGHT argument of the list
+// commands, it returns true for LEFT
+func parseDirection(arg []byte) (bool, bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// `parseMpop` parses the numkeys key [key ...] where [COUNT count]
+// arguments of LMPOP, ZMPOP and their blocking variants, where is
+// parsed by parseWhere: LEFT|RIGHT or MIN|MAX. A non empty string
+// is the error to reply with
+func parseMpop(args [][]byte, parseWhere func([]byte) (bool, bool)) ([]string, bool, int64, string) {
+	numkeys, ok := parseInteger(args[0])
+	if !ok {
+		return nil, false, 0, "value is not an integer or out of range"
+	}
+	if numkeys <= 0 {
+		return nil, false, 0, "numkeys should be greater than 0"
+	}
+	if numkeys > int64(len(args)-2) {
+		return nil, false, 0, "invalid syntax"
+	}
+	keys := make([]string, numkeys)
+	for i := range keys {
+		keys[i] = string(args[1+i])
+	}
+	args = args[1+numkeys:]
+	where, ok := parseWhere(args[0])
+	if !ok {
+		return nil, false, 0, "invalid syntax"
+	}
+	count := int64(1)
+	args = args[1:]
+	if len(args) == 0 {
+		return keys, where, count, ""
+	}
+	if len(args) != 2 || strings.ToUpper(string(args[0])) != "COUNT" {
+		return nil, false, 0, "invalid syntax"
+	}
+	count, ok = parseInteger(args[1])
+	if !ok || count <= 0 {
+		return nil, false, 0, "count should be greater than 0"
+	}
+	return keys, where, count, ""
+}
+
+// `serveMpop` pops up to count elements from the list at key,
+// replying with the key and the popped elements
+func serveMpop(key string, head bool, count int64, s *store) ([]byte, bool, error) {
+	l, exists, isList := s.getList(key)
+	if !isList {
+		response, err := errorReply("value not of list type")
+		return response, true, err
+	}
+	if !exists {
+		return nil, false, nil
+	}
+	elements := s.listPop(key, l, head, count)
+	response := resp.Array{
+		Size: 2,
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte(key), Size: len(key)},
+			&resp.Array{Size: len(elements), Elements: elements},
+		},
+	}
+	data, err := response.Serialise()
+	return data, true, err
+}
+
+func nilArray() ([]byte, error) {
+	response := resp.Array{
+		Size: -1,
+	}
+	return response.Serialise()
+}
+
+func nilBulkString() ([]byte, error) {
+	response := resp.BulkString{
+		Size: -1,
+	}
+	return response.Serialise()
+}
+
+// `parseBlockingCommand` parses a blocking command into the state the
+// client blocks with. When the command is malformed the returned
+// client is nil and the reply holds the error to send back.
+// The caller must hold the store lock
+func parseBlockingCommand(command [][]byte, s *store) (*blockedClient, []byte, error) {
+	name := string(command[0])
+	args := command[1:]
+	b := &blockedClient{
+		reply: make(chan blockedReply, 1),
+	}
+	var message string
+	switch name {
+	case "BLPOP", "BRPOP":
+		// BLPOP key [key ...] timeout
+		if len(args) < 2 {
+			response, err := wrongNumberOfArgs(strings.ToLower(name))
+			return nil, response, err
+		}
+		b.timeout, message = parseTimeout(args[len(args)-1])
+		for _, key := range args[:len(args)-1] {
+			b.keys = append(b.keys, string(key))
+		}
+		head := name == "BLPOP"
+		b.serve = func(key string, s *store) ([]byte, bool, error) {
+			l, exists, isList := s.getList(key)
+			if !isList {
+				response, err := errorReply("value not of list type")
+				return response, true, err
+			}
+			if !exists {
+				return nil, false, nil
+			}
+			elements := s.listPop(key, l, head, 1)
+			response := resp.Array{
+				Size: 2,
+				Elements: []resp.RESPDatatype{
+					&resp.BulkString{Data: []byte(key), Size: len(key)},
+					elements[0],
+				},
+			}
+			data, err := response.Serialise()
+			return data, true, err
+		}
+		b.nilReply = nilArray
+	case "BLMOVE":
+		// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+		if len(args) != 5 {
+			response, err := wrongNumberOfArgs("blmove")
+			return nil, response, err
+		}
+		fromHead, fromOk := parseDirection(args[2])
+		toHead, toOk := parseDirection(args[3])
+		if !fromOk || !toOk {
+			message = "invalid syntax"
+			break
+		}
+		b.timeout, message = parseTimeout(args[4])
+		b.keys = []string{string(args[0])}
+		destination := string(args[1])
+		b.serve = func(key string, s *store) ([]byte, bool, error) {
+			return listMove(key, destination, fromHead, toHead, s)
+		}
+		b.nilReply = nilBulkString
+	case "BLMPOP":
+		// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+		if len(args) < 4 {
+			response, err := wrongNumberOfArgs("blmpop")
+			return nil, response, err
+		}
+		b.timeout, message = parseTimeout(args[0])
+		if message != "" {
+			break
+		}
+		var head bool
+		var count int64
+		b.keys, head, count, message = parseMpop(args[1:], parseDirection)
+		b.serve = func(key string, s *store) ([]byte, bool, error) {
+			return serveMpop(key, head, count, s)
+		}
+		b.nilReply = nilArray
+	case "BZPOPMIN", "BZPOPMAX":
+		// BZPOPMIN key [key ...] timeout
+		if len(args) < 2 {
+			response, err := wrongNumberOfArgs(strings.ToLower(name))
+			return nil, response, err
+		}
+		b.timeout, message = parseTimeout(args[len(args)-1])
+		for _, key := range args[:len(args)-1] {
+			b.keys = append(b.keys, string(key))
+		}
+		max := name == "BZPOPMAX"
+		b.serve = func(key string, s *store) ([]byte, bool, error) {
+			return serveZpop(key, max, s)
+		}
+		b.nilReply = nilArray
+	case "BZMPOP":
+		// BZMPOP timeout numkeys key [key ...] MIN|MAX [COUNT count]
+		if len(args) < 4 {
+			response, err := wrongNumberOfArgs("bzmpop")
+			return nil, response, err
+		}
+		b.timeout, message = parseTimeout(args[0])
+		if message != "" {
+			break
+		}
+		var max bool
+		var count int64
+		b.keys, max, count, message = parseMpop(args[1:], parseMinMax)
+		b.serve = func(key string, s *store) ([]byte, bool, error) {
+			return serveZmpop(key, max, count, s)
+		}
+		b.nilReply = nilArray
+	case "XREAD", "XREADGROUP":
+		// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+		// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds]
+		//   [NOACK] STREAMS key [key ...] id [id ...]
+		command := strings.ToLower(name)
+		if (name == "XREAD" && len(args) < 3) || (name == "XREADGROUP" && len(args) < 6) {
+			response, err := wrongNumberOfArgs(command)
+			return nil, response, err
+		}
+		request, response, err := parseXread(args, s, command)
+		if request == nil {
+			return nil, response, err
+		}
+		b = xreadClient(request)
+	}
+	if message != "" {
+		response, err := errorReply(message)
+		return nil, response, err
+	}
+	return b, nil, nil
+}
+
+// `tryServe` serves the client from the first of its keys
+// holding data. It reports false when none of them do
+func (b *blockedClient) tryServe(s *store) ([]byte, bool, error) {
+	if b.serveAll != nil {
+		return b.serveAll(s)
+	}
+	for _, key := range b.keys {
+		data, ok, err := b.serve(key, s)
+		if ok {
+			return data, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// `block` queues the client on each of its keys.
+// The caller must hold the store lock
+func (s *store) block(b *blockedClient) {
+	for _, key := range b.keys {
+		s.blocked[key] = append(s.blocked[key], b)
+	}
+}
+
+// `unblock` removes the client from the queues of its keys.
+// The caller must hold the store lock
+func (s *store) unblock(b *blockedClient) {
+	for _, key := range b.keys {
+		queue := s.blocked[key]
+		remaining := queue[:0]
+		for _, waiter := range queue {
+			if waiter != b {
+				remaining = append(remaining, waiter)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(s.blocked, key)
+		} else {
+			s.blocked[key] = remaining
+		}
+	}
+}
+
+// `signalKeyAsReady` marks key as ready when clients are blocked
+// on it, they're served by `serveBlockedClients`.
+// The caller must hold the store lock
+func (s *store) signalKeyAsReady(key string) {
+	if len(s.blocked[key]) > 0 {
+		s.readyKeys = append(s.readyKeys, key)
+	}
+}
+
+// `serveBlockedClients` serves the clients blocked on the ready
+// keys, in the order they blocked, for as long as the keys hold
+// data. A client that can't be served, such as XREAD waiting for
+// entries past a given ID, doesn't hold back the clients after it.
+// Serving a client may feed other keys (BLMOVE), so this runs
+// until no key is left ready.
+// The caller must hold the store lock
+func (s *store) serveBlockedClients() {
+	for len(s.readyKeys) > 0 {
+		key := s.readyKeys[0]
+		s.readyKeys = s.readyKeys[1:]
+		for _, b := range slices.Clone(s.blocked[key]) {
+			if b.served {
+				continue
+			}
+			data, ok, err := b.serve(key, s)
+			if !ok {
+				continue
+			}
+			s.unblock(b)
+			b.served = true
+			b.reply <- blockedReply{data: data, err: err}
+		}
+	}
+}
+
+// `executeNoWait` runs a blocking command without blocking, replying
+// with nil when there is nothing to pop or read, the way redis runs
+// blocking commands from within a transaction. It also runs XREAD and
+// XREADGROUP without the BLOCK option, which share the parsing of
+// their blocking form
+func executeNoWait(command [][]byte, s *store) ([]byte, error) {
+	b, response, err := parseBlockingCommand(command, s)
+	if b == nil {
+		return response, err
+	}
+	data, ok, err := b.tryServe(s)
+	if ok {
+		return data, err
+	}
+	return b.nilReply()
+}
+
+// `executeBlocking` runs a blocking command. If none of the command's
+// keys hold data, the client is queued on them and waits, without
+// holding the store lock, until it is served by a command feeding one
+// of the keys, the timeout elapses or the client disconnects
+func executeBlocking(command [][]byte, s *store, disconnected <-chan struct{}) ([]byte, error) {
+	s.lock.Lock()
+	b, response, err := parseBlockingCommand(command, s)
+	if b == nil {
+		s.lock.Unlock()
+		return response, err
+	}
+	data, ok, err := b.tryServe(s)
+	if ok {
+		s.serveBlockedClients()
+		s.lock.Unlock()
+		return data, err
+	}
+	s.block(b)
+	s.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if b.timeout > 0 {
+		timer := time.NewTimer(b.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case reply := <-b.reply:
+		return reply.data, reply.err
+	case <-timeout:
+	case <-disconnected:
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// the client may have been served before it got the lock back
+	if b.served {
+		reply := <-b.reply
+		return reply.data, reply.err
+	}
+	s.unblock(b)
+	return b.nilReply()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// `runBlocking` runs a blocking command the way a client does
+func runBlocking(t *testing.T, s *store, disconnected <-chan struct{}, args ...string) string {
+	t.Helper()
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	reply, err := executeBlocking(command, s, disconnected)
+	if err != nil {
+		t.Errorf("%q: %v", args, err)
+	}
+	return string(reply)
+}
+
+// `waitForBlocked` waits until n clients are blocked on key
+func waitForBlocked(t *testing.T, s *store, key string, n int) {
+	t.Helper()
+	var blocked int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		s.lock.Lock()
+		blocked = len(s.blocked[key])
+		s.lock.Unlock()
+		if blocked == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d clients blocked on %s, want %d", blocked, key, n)
+}
+
+func TestBlockingPopServesClientsInOrder(t *testing.T) {
+	s := newStore()
+	replies := make([]chan string, 3)
+	for i := range replies {
+		replies[i] = make(chan string, 1)
+		go func(reply chan string) {
+			reply <- runBlocking(t, s, nil, "BLPOP", "queue", "0")
+		}(replies[i])
+		// block the clients one after the other
+		waitForBlocked(t, s, "queue", i+1)
+	}
+	run(t, s, "RPUSH", "queue", "a", "b", "c")
+	for i, want := range []string{"a", "b", "c"} {
+		select {
+		case got := <-replies[i]:
+			if want := "*2\r\n" + bulkReply("queue") + bulkReply(want); got != want {
+				t.Errorf("client %d got %q, want %q", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("client %d wasn't served", i)
+		}
+	}
+}
+
+func TestBlockingPopTimesOutAndDisconnects(t *testing.T) {
+	s := newStore()
+	if got := runBlocking(t, s, nil, "BRPOP", "queue", "0.01"); got != "*-1\r\n" {
+		t.Errorf("BRPOP timed out with %q, want a nil array", got)
+	}
+	disconnected := make(chan struct{})
+	done := make(chan string)
+	go func() {
+		done <- runBlocking(t, s, disconnected, "BLMOVE", "queue", "other", "LEFT", "RIGHT", "0")
+	}()
+	waitForBlocked(t, s, "queue", 1)
+	close(disconnected)
+	<-done
+	waitForBlocked(t, s, "queue", 0)
+}
+
+// TestPushWithoutValues checks that LPUSH and RPUSH don't leave
+// an empty list behind
+func TestPushWithoutValues(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"LPUSH", "list"}, "-wrong number of arguments for 'lpush' command\r\n"},
+		{[]string{"RPUSH", "list"}, "-wrong number of arguments for 'rpush' command\r\n"},
+		{[]string{"EXISTS", "list"}, integerReply(0)},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// TestBlockingPopWithoutWaiting checks that blocking commands run
+// without blocking when executed in one step, like in a transaction
+func TestBlockingPopWithoutWaiting(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"BLPOP", "list", "0"}, "*-1\r\n"},
+		{[]string{"BLMOVE", "list", "other", "LEFT", "RIGHT", "0"}, "$-1\r\n"},
+		{[]string{"BZPOPMIN", "zset", "0"}, "*-1\r\n"},
+		{[]string{"RPUSH", "list", "a", "b"}, integerReply(2)},
+		{[]string{"BLPOP", "list", "0"}, bulkArray("list", "a")},
+		{[]string{"BLMOVE", "list", "other", "LEFT", "RIGHT", "0"}, bulkReply("b")},
+		{[]string{"EXISTS", "list"}, integerReply(0)},
+		{[]string{"BRPOP", "list", "other", "0"}, bulkArray("other", "b")},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
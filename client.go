@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maximum number of replies and published messages waiting
+// to be written to a client
+const clientQueueSize = 1024
+
+// time given to a disconnecting client to receive the
+// replies still queued for it
+const clientFlushTimeout = time.Second
+
+// `client` represents a connected client
+type client struct {
+	conn net.Conn
+	// replies and published messages waiting to be written to
+	// the connection. They're written by the client's own writer
+	// goroutine, so that publishing never waits on a slow client
+	queue chan []byte
+	// closed once the client disconnects
+	done      chan struct{}
+	closeOnce sync.Once
+	// channels and patterns the client is subscribed to.
+	// Guarded by the pubSub lock
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// `newClient` returns an instance of `client` and starts
+// its writer goroutine
+func newClient(conn net.Conn) *client {
+	c := &client{
+		conn:     conn,
+		queue:    make(chan []byte, clientQueueSize),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	go c.writeQueued()
+	return c
+}
+
+// `writeQueued` writes the queued data to the connection until
+// the client disconnects, then flushes what's left and closes
+// the connection
+func (c *client) writeQueued() {
+	defer c.conn.Close()
+	for {
+		select {
+		case data := <-c.queue:
+			if _, err := c.conn.Write(data); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(clientFlushTimeout))
+			for {
+				select {
+				case data := <-c.queue:
+					if _, err := c.conn.Write(data); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// `write` queues data to be written to the client's connection,
+// waiting for room in the queue. It's used for the replies to
+// the client's own commands
+func (c *client) write(data []byte) (int, error) {
+	select {
+	case c.queue <- data:
+		return len(data), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	}
+}
+
+// `push` queues a message published to the client without
+// waiting. A client that doesn't keep up with its messages and
+// lets its queue fill up is disconnected, the way redis enforces
+// the pubsub output buffer limit. It reports whether the
+// message was queued
+func (c *client) push(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.queue <- data:
+		return true
+	default:
+		// closing the connection unblocks a pending write
+		// and the read loop of the client
+		c.conn.Close()
+		c.close()
+		return false
+	}
+}
+
+// `close` disconnects the client, the queued data is
+// flushed before the connection is closed
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
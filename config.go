@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// `config` holds the server parameters that can be changed
+// at runtime. It must only be accessed with the store lock held
+type config struct {
+	notifyKeyspaceEvents int
+	// number of list chunks left uncompressed at each end of a
+	// list, 0 disables compression. Applies to lists created
+	// after it's set
+	listCompressDepth int
+}
+
+var serverConfig = config{}
+
+// `configParameter` describes how a parameter is read and
+// written by CONFIG GET and CONFIG SET
+type configParameter struct {
+	get func() string
+	set func(value string) error
+}
+
+var configParameters = map[string]configParameter{
+	"notify-keyspace-events": {
+		get: func() string {
+			return keyspaceEventsToString(serverConfig.notifyKeyspaceEvents)
+		},
+		set: func(value string) error {
+			flags, err := keyspaceEventsFromString(value)
+			if err != nil {
+				return err
+			}
+			serverConfig.notifyKeyspaceEvents = flags
+			return nil
+		},
+	},
+	"list-compress-depth": {
+		get: func() string {
+			return strconv.Itoa(serverConfig.listCompressDepth)
+		},
+		set: func(value string) error {
+			depth, ok := parseInteger([]byte(value))
+			if !ok {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if depth < 0 || depth > math.MaxInt32 {
+				return errors.New("argument must be between 0 and 2147483647 inclusive")
+			}
+			serverConfig.listCompressDepth = int(depth)
+			return nil
+		},
+	},
+}
+
+// CONFIG command reads and writes the server configuration
+func configCommand(args [][]byte) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("config")
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "GET":
+		if len(args) < 2 {
+			return wrongNumberOfArgs("config|get")
+		}
+		var response resp.Array
+		for name, parameter := range configParameters {
+			for _, pattern := range args[1:] {
+				if globMatch([]byte(strings.ToLower(string(pattern))), []byte(name)) {
+					value := parameter.get()
+					response.Elements = append(response.Elements,
+						&resp.BulkString{Data: []byte(name), Size: len(name)},
+						&resp.BulkString{Data: []byte(value), Size: len(value)},
+					)
+					break
+				}
+			}
+		}
+		response.Size = len(response.Elements)
+		return response.Serialise()
+	case "SET":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return wrongNumberOfArgs("config|set")
+		}
+		// parameters are set atomically, restore the
+		// previous configuration if any of them fails
+		previous := serverConfig
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(string(args[i]))
+			parameter, ok := configParameters[name]
+			if !ok {
+				serverConfig = previous
+				return errorReply("unknown config parameter '" + name + "'")
+			}
+			if err := parameter.set(string(args[i+1])); err != nil {
+				serverConfig = previous
+				return errorReply("invalid argument '" + string(args[i+1]) +
+					"' for CONFIG SET '" + name + "' - " + err.Error())
+			}
+		}
+		response := resp.SimpleString{
+			Data: "OK",
+		}
+		return response.Serialise()
+	}
+	return errorReply("unknown subcommand '" + string(args[0]) + "' for 'config' command")
+}
@@ -0,0 +1,1218 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// A consumer group tracks the last entry delivered to its consumers
+// and the entries delivered but not acknowledged yet, its pending
+// entries list (PEL). Each pending entry is owned by a consumer, which
+// also lists the pending entries it owns
+type pendingEntry struct {
+	id            streamID
+	consumer      *streamConsumer
+	deliveryTime  time.Time
+	deliveryCount uint64
+}
+
+// `pendingList` holds pending entries ordered by ID
+type pendingList struct {
+	ids     []streamID
+	entries map[streamID]*pendingEntry
+}
+
+func newPendingList() *pendingList {
+	return &pendingList{
+		entries: make(map[streamID]*pendingEntry),
+	}
+}
+
+func (p *pendingList) length() int {
+	return len(p.ids)
+}
+
+// `from` returns the index of the first pending entry with
+// an ID at or above id
+func (p *pendingList) from(id streamID) int {
+	return sort.Search(len(p.ids), func(i int) bool {
+		return p.ids[i].compare(id) >= 0
+	})
+}
+
+// `add` adds a pending entry, which mustn't already be in the list
+func (p *pendingList) add(entry *pendingEntry) {
+	p.entries[entry.id] = entry
+	// entries are mostly delivered in order
+	if len(p.ids) == 0 || p.ids[len(p.ids)-1].compare(entry.id) < 0 {
+		p.ids = append(p.ids, entry.id)
+		return
+	}
+	p.ids = slices.Insert(p.ids, p.from(entry.id), entry.id)
+}
+
+// `remove` removes the pending entry with the given ID, it reports
+// false if there is no such entry
+func (p *pendingList) remove(id streamID) bool {
+	if _, ok := p.entries[id]; !ok {
+		return false
+	}
+	delete(p.entries, id)
+	i := p.from(id)
+	p.ids = slices.Delete(p.ids, i, i+1)
+	return true
+}
+
+type streamConsumer struct {
+	name string
+	// seenTime is the last time the consumer attempted an
+	// interaction, activeTime the last time one succeeded, which
+	// is the zero time if none did
+	seenTime   time.Time
+	activeTime time.Time
+	pending    *pendingList
+}
+
+// entriesRead is set to `invalidEntriesRead` when the number of
+// entries the group read can't be worked out
+const invalidEntriesRead = -1
+
+type consumerGroup struct {
+	name string
+	// lastID is the ID of the last entry delivered to the group
+	lastID      streamID
+	entriesRead int64
+	pending     *pendingList
+	consumers   map[string]*streamConsumer
+}
+
+// `createGroup` creates a consumer group, it reports false if the
+// stream already has a group by that name
+func (st *stream) createGroup(name string, lastID streamID, entriesRead int64) bool {
+	if _, ok := st.groups[name]; ok {
+		return false
+	}
+	if st.groups == nil {
+		st.groups = make(map[string]*consumerGroup)
+	}
+	st.groups[name] = &consumerGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pending:     newPendingList(),
+		consumers:   make(map[string]*streamConsumer),
+	}
+	return true
+}
+
+// `groupNames` returns the names of the groups of the stream in order
+func (st *stream) groupNames() []string {
+	names := make([]string, 0, len(st.groups))
+	for name := range st.groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// `consumerNames` returns the names of the consumers of the group
+// in order
+func (group *consumerGroup) consumerNames() []string {
+	names := make([]string, 0, len(group.consumers))
+	for name := range group.consumers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// `createConsumer` creates a consumer, it reports false if the
+// group already has a consumer by that name
+func (group *consumerGroup) createConsumer(name string) (*streamConsumer, bool) {
+	if consumer, ok := group.consumers[name]; ok {
+		return consumer, false
+	}
+	consumer := &streamConsumer{
+		name:     name,
+		seenTime: time.Now(),
+		pending:  newPendingList(),
+	}
+	group.consumers[name] = consumer
+	return consumer, true
+}
+
+// `deleteConsumer` deletes a consumer along with its pending
+// entries, it returns the number of entries it had pending
+func (group *consumerGroup) deleteConsumer(name string) int {
+	consumer, ok := group.consumers[name]
+	if !ok {
+		return 0
+	}
+	for _, id := range consumer.pending.ids {
+		group.pending.remove(id)
+	}
+	delete(group.consumers, name)
+	return consumer.pending.length()
+}
+
+// `assign` makes consumer the owner of a pending entry
+func (group *consumerGroup) assign(entry *pendingEntry, consumer *streamConsumer) {
+	if entry.consumer == consumer {
+		return
+	}
+	if entry.consumer != nil {
+		entry.consumer.pending.remove(entry.id)
+	}
+	entry.consumer = consumer
+	consumer.pending.add(entry)
+}
+
+// `deliver` records the delivery of an entry to consumer. An entry
+// that is already pending, which happens when the group's last ID is
+// moved back, changes owner and its delivery count starts over
+func (group *consumerGroup) deliver(id streamID, consumer *streamConsumer, now time.Time) {
+	entry, ok := group.pending.entries[id]
+	if !ok {
+		entry = &pendingEntry{id: id}
+		group.pending.add(entry)
+	}
+	group.assign(entry, consumer)
+	entry.deliveryTime = now
+	entry.deliveryCount = 1
+}
+
+// `ack` removes an entry from the group's pending entries, it
+// reports false if the entry isn't pending
+func (group *consumerGroup) ack(id streamID) bool {
+	entry, ok := group.pending.entries[id]
+	if !ok {
+		return false
+	}
+	group.pending.remove(id)
+	entry.consumer.pending.remove(id)
+	return true
+}
+
+// `firstID` returns the ID of the first entry of the stream,
+// 0-0 when the stream is empty
+func (st *stream) firstID() streamID {
+	first, _ := st.firstEntry()
+	return first.id
+}
+
+// `hasTombstones` reports whether entries with an ID at or above
+// start may have been deleted
+func (st *stream) hasTombstones(start streamID) bool {
+	if st.length == 0 || st.maxDeletedID == (streamID{}) {
+		return false
+	}
+	return start.compare(st.maxDeletedID) <= 0
+}
+
+// `estimateEntriesRead` works out the number of entries added to the
+// stream up to id, it returns `invalidEntriesRead` when entries were
+// deleted in a way that makes it impossible to tell
+func (st *stream) estimateEntriesRead(id streamID) int64 {
+	if st.entriesAdded == 0 {
+		return 0
+	}
+	if st.length == 0 && id.compare(st.lastID) <= 0 {
+		return int64(st.entriesAdded)
+	}
+	switch id.compare(st.lastID) {
+	case 0:
+		return int64(st.entriesAdded)
+	case 1:
+		return invalidEntriesRead
+	}
+	first := st.firstID()
+	if st.maxDeletedID == (streamID{}) || st.maxDeletedID.compare(first) < 0 {
+		// no entry was deleted past the first one
+		switch id.compare(first) {
+		case -1:
+			return int64(st.entriesAdded) - int64(st.length)
+		case 0:
+			return int64(st.entriesAdded) - int64(st.length) + 1
+		}
+	}
+	return invalidEntriesRead
+}
+
+// `lag` returns the number of entries of the stream the group has
+// yet to read, it reports false when it can't be worked out
+func (st *stream) lag(group *consumerGroup) (int64, bool) {
+	if st.entriesAdded == 0 {
+		return 0, true
+	}
+	if group.entriesRead != invalidEntriesRead && !st.hasTombstones(group.lastID) {
+		return int64(st.entriesAdded) - group.entriesRead, true
+	}
+	entriesRead := st.estimateEntriesRead(group.lastID)
+	if entriesRead == invalidEntriesRead {
+		return 0, false
+	}
+	return int64(st.entriesAdded) - entriesRead, true
+}
+
+// `advance` moves the group's last ID forward to id, an entry
+// delivered to the group, keeping count of the entries read
+func (st *stream) advance(group *consumerGroup, id streamID) {
+	if id.compare(group.lastID) <= 0 {
+		return
+	}
+	if group.entriesRead != invalidEntriesRead && !st.hasTombstones(id) {
+		group.entriesRead++
+	} else if st.entriesAdded > 0 {
+		group.entriesRead = st.estimateEntriesRead(id)
+	}
+	group.lastID = id
+}
+
+// `entry` returns the entry of the stream with the given ID
+func (st *stream) entry(id streamID) (streamEntry, bool) {
+	entries := st.rangeEntries(id, id, 1, false)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// `bulkStringOf` returns a string as a bulk string
+func bulkStringOf(value string) *resp.BulkString {
+	return &resp.BulkString{Data: []byte(value), Size: len(value)}
+}
+
+// `noGroup` serialises the error reply for a group missing from
+// the stream at key, or for a missing key
+func noGroup(key, group string) ([]byte, error) {
+	return errorReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// `getGroup` retrieves a group of the stream stored at key, it
+// reports false when the key is missing or holds another type
+func (s *store) getGroup(key, name string) (*stream, *consumerGroup, bool) {
+	st, exists, isStream := s.getStream(key)
+	if !exists || !isStream {
+		return nil, nil, false
+	}
+	group, ok := st.groups[name]
+	return st, group, ok
+}
+
+// `createConsumer` creates a consumer of a group of the stream
+// at key, unless it exists
+func (s *store) createConsumer(key string, group *consumerGroup, name string) *streamConsumer {
+	consumer, created := group.createConsumer(name)
+	if created {
+		s.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+	}
+	return consumer
+}
+
+// `parseGroupStart` parses the ID a group starts reading after,
+// "$" standing for the last ID of the stream
+func parseGroupStart(arg []byte, st *stream) (streamID, bool) {
+	if string(arg) == "$" {
+		if st == nil {
+			return streamID{}, true
+		}
+		return st.lastID, true
+	}
+	return parseStreamID(arg, 0)
+}
+
+// `parseEntriesRead` parses the ENTRIESREAD option of XGROUP
+func parseEntriesRead(args [][]byte) (int64, string) {
+	entriesRead := int64(invalidEntriesRead)
+	if len(args) == 0 {
+		return entriesRead, ""
+	}
+	if len(args) != 2 || strings.ToUpper(string(args[0])) != "ENTRIESREAD" {
+		return 0, "invalid syntax"
+	}
+	entriesRead, ok := parseInteger(args[1])
+	if !ok {
+		return 0, "value is not an integer or out of range"
+	}
+	if entriesRead < 0 && entriesRead != invalidEntriesRead {
+		return 0, "value for ENTRIESREAD must be positive or -1"
+	}
+	return entriesRead, ""
+}
+
+// XGROUP command manages the consumer groups of a stream
+func xgroup(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("xgroup")
+	}
+	subcommand := strings.ToUpper(string(args[0]))
+	arity := map[string]int{"CREATE": 4, "SETID": 4, "DESTROY": 3, "CREATECONSUMER": 4, "DELCONSUMER": 4}
+	required, ok := arity[subcommand]
+	if !ok {
+		return errorReply("unknown subcommand '" + string(args[0]) + "' for 'xgroup' command")
+	}
+	if len(args) < required || (subcommand != "CREATE" && subcommand != "SETID" && len(args) != required) {
+		return wrongNumberOfArgs("xgroup|" + strings.ToLower(subcommand))
+	}
+	key, name := string(args[1]), string(args[2])
+	st, exists, isStream := s.getStream(key)
+	if !isStream {
+		return wrongType()
+	}
+	mkStream := subcommand == "CREATE" && len(args) > 4 && strings.ToUpper(string(args[4])) == "MKSTREAM"
+	if !exists && !mkStream {
+		return errorReply("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	var group *consumerGroup
+	if exists {
+		group = st.groups[name]
+	}
+	if subcommand == "DESTROY" && group == nil {
+		response := resp.Integer{}
+		return response.Serialise()
+	}
+	if subcommand != "CREATE" && group == nil {
+		return errorReply("NOGROUP No such consumer group '" + name + "' for key name '" + key + "'")
+	}
+	switch subcommand {
+	case "CREATE":
+		// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+		id, ok := parseGroupStart(args[3], st)
+		if !ok {
+			return errorReply(invalidStreamID)
+		}
+		options := args[4:]
+		if mkStream {
+			options = options[1:]
+		}
+		entriesRead, message := parseEntriesRead(options)
+		if message != "" {
+			return errorReply(message)
+		}
+		if group != nil {
+			return errorReply("BUSYGROUP Consumer Group name already exists")
+		}
+		if !exists {
+			st = newStream()
+			s.set(key, &redisValue{
+				value:     st,
+				valueType: "stream",
+			})
+		}
+		st.createGroup(name, id, entriesRead)
+		s.notifyKeyspaceEvent(notifyStream, "xgroup-create", key)
+		response := resp.SimpleString{Data: "OK"}
+		return response.Serialise()
+	case "SETID":
+		// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+		id, ok := parseGroupStart(args[3], st)
+		if !ok {
+			return errorReply(invalidStreamID)
+		}
+		entriesRead, message := parseEntriesRead(args[4:])
+		if message != "" {
+			return errorReply(message)
+		}
+		group.lastID = id
+		group.entriesRead = entriesRead
+		s.notifyKeyspaceEvent(notifyStream, "xgroup-setid", key)
+		response := resp.SimpleString{Data: "OK"}
+		return response.Serialise()
+	case "DESTROY":
+		delete(st.groups, name)
+		s.notifyKeyspaceEvent(notifyStream, "xgroup-destroy", key)
+		// clients blocked reading from the group get an error
+		s.signalKeyAsReady(key)
+		response := resp.Integer{Data: 1}
+		return response.Serialise()
+	case "CREATECONSUMER":
+		response := resp.Integer{}
+		if _, created := group.createConsumer(string(args[3])); created {
+			response.Data = 1
+			s.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+		}
+		return response.Serialise()
+	default:
+		// DELCONSUMER
+		response := resp.Integer{}
+		if _, ok := group.consumers[string(args[3])]; ok {
+			response.Data = int64(group.deleteConsumer(string(args[3])))
+			s.notifyKeyspaceEvent(notifyStream, "xgroup-delconsumer", key)
+		}
+		return response.Serialise()
+	}
+}
+
+// `serveGroup` serves the j-th key of an XREADGROUP request, with
+// new entries, which are added to the group's pending entries unless
+// NOACK is given, or with the consumer's pending entries. A pending
+// entry deleted from the stream is returned with nil fields
+func (request *xreadRequest) serveGroup(j int, s *store) (*resp.Array, bool, []byte, error) {
+	key := request.keys[j]
+	st, group, ok := s.getGroup(key, request.group)
+	if !ok {
+		response, err := errorReply("NOGROUP the consumer group this client was blocked on no longer exists")
+		return nil, false, response, err
+	}
+	now := time.Now()
+	consumer := s.createConsumer(key, group, request.consumer)
+	consumer.seenTime = now
+	var entries []streamEntry
+	if request.history[j] {
+		start, ok := request.ids[j].next()
+		if ok {
+			ids := consumer.pending.ids[consumer.pending.from(start):]
+			if request.count > 0 {
+				ids = ids[:min(request.count, len(ids))]
+			}
+			for _, id := range ids {
+				entry, _ := st.entry(id)
+				entry.id = id
+				entries = append(entries, entry)
+				pending := consumer.pending.entries[id]
+				pending.deliveryTime = now
+				pending.deliveryCount++
+			}
+		}
+	} else {
+		start, ok := group.lastID.next()
+		if ok {
+			entries = st.rangeEntries(start, maxStreamID, request.count, false)
+		}
+		if len(entries) == 0 {
+			return nil, false, nil, nil
+		}
+		for _, entry := range entries {
+			st.advance(group, entry.id)
+			if !request.noAck {
+				group.deliver(entry.id, consumer, now)
+			}
+		}
+	}
+	if len(entries) > 0 {
+		consumer.activeTime = now
+	}
+	return &resp.Array{
+		Size: 2,
+		Elements: []resp.RESPDatatype{
+			bulkStringOf(key),
+			streamEntriesArray(entries),
+		},
+	}, true, nil, nil
+}
+
+// XACK command removes entries from the pending entries of a group
+func xack(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("xack")
+	}
+	ids := make([]streamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return errorReply(invalidStreamID)
+		}
+		ids[i] = id
+	}
+	if _, _, isStream := s.getStream(string(args[0])); !isStream {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	_, group, ok := s.getGroup(string(args[0]), string(args[1]))
+	if !ok {
+		return response.Serialise()
+	}
+	for _, id := range ids {
+		if group.ack(id) {
+			response.Data++
+		}
+	}
+	return response.Serialise()
+}
+
+// XPENDING command inspects the pending entries of a group
+func xpending(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("xpending")
+	}
+	key, name := string(args[0]), string(args[1])
+	extended := len(args) > 2
+	var minIdle int64
+	var start, end streamID
+	var count int64
+	var consumerName string
+	if extended {
+		rest := args[2:]
+		if strings.ToUpper(string(rest[0])) == "IDLE" && len(rest) >= 2 {
+			idle, ok := parseInteger(rest[1])
+			if !ok {
+				return errorReply("value is not an integer or out of range")
+			}
+			minIdle = idle
+			rest = rest[2:]
+		}
+		if len(rest) != 3 && len(rest) != 4 {
+			return errorReply("invalid syntax")
+		}
+		var message string
+		if start, message = parseRangeID(rest[0], true); message != "" {
+			return errorReply(message)
+		}
+		if end, message = parseRangeID(rest[1], false); message != "" {
+			return errorReply(message)
+		}
+		var ok bool
+		if count, ok = parseInteger(rest[2]); !ok {
+			return errorReply("value is not an integer or out of range")
+		}
+		count = max(count, 0)
+		if len(rest) == 4 {
+			consumerName = string(rest[3])
+		}
+	}
+	if _, _, isStream := s.getStream(key); !isStream {
+		return wrongType()
+	}
+	_, group, ok := s.getGroup(key, name)
+	if !ok {
+		return noGroup(key, name)
+	}
+	if !extended {
+		// XPENDING key group
+		if group.pending.length() == 0 {
+			response := resp.Array{
+				Size: 4,
+				Elements: []resp.RESPDatatype{
+					&resp.Integer{}, &resp.BulkString{Size: -1}, &resp.BulkString{Size: -1}, &resp.Array{Size: -1},
+				},
+			}
+			return response.Serialise()
+		}
+		ids := group.pending.ids
+		consumers := &resp.Array{}
+		for _, consumerName := range group.consumerNames() {
+			pending := group.consumers[consumerName].pending.length()
+			if pending == 0 {
+				continue
+			}
+			consumers.Elements = append(consumers.Elements, &resp.Array{
+				Size:     2,
+				Elements: []resp.RESPDatatype{bulkStringOf(consumerName), bulkStringOf(strconv.Itoa(pending))},
+			})
+		}
+		consumers.Size = len(consumers.Elements)
+		response := resp.Array{
+			Size: 4,
+			Elements: []resp.RESPDatatype{
+				&resp.Integer{Data: int64(len(ids))},
+				streamIDBulkString(ids[0]),
+				streamIDBulkString(ids[len(ids)-1]),
+				consumers,
+			},
+		}
+		return response.Serialise()
+	}
+	// XPENDING key group [IDLE min-idle-time] start end count [consumer]
+	pending := group.pending
+	if consumerName != "" {
+		consumer, ok := group.consumers[consumerName]
+		if !ok {
+			return streamEntriesArray(nil).Serialise()
+		}
+		pending = consumer.pending
+	}
+	now := time.Now()
+	response := resp.Array{}
+	for _, id := range pending.ids[pending.from(start):] {
+		if int64(len(response.Elements)) >= count || id.compare(end) > 0 {
+			break
+		}
+		entry := pending.entries[id]
+		idle := now.Sub(entry.deliveryTime).Milliseconds()
+		if idle < minIdle {
+			continue
+		}
+		response.Elements = append(response.Elements, &resp.Array{
+			Size: 4,
+			Elements: []resp.RESPDatatype{
+				streamIDBulkString(id),
+				bulkStringOf(entry.consumer.name),
+				&resp.Integer{Data: idle},
+				&resp.Integer{Data: int64(entry.deliveryCount)},
+			},
+		})
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `claimOptions` holds the options of XCLAIM and XAUTOCLAIM
+type claimOptions struct {
+	deliveryTime time.Time
+	retryCount   int64
+	hasRetry     bool
+	justID       bool
+}
+
+// `claim` makes consumer the owner of a pending entry, updating its
+// delivery time and count
+func (group *consumerGroup) claim(entry *pendingEntry, consumer *streamConsumer, options claimOptions) {
+	group.assign(entry, consumer)
+	entry.deliveryTime = options.deliveryTime
+	if options.hasRetry {
+		entry.deliveryCount = uint64(options.retryCount)
+	} else if !options.justID {
+		entry.deliveryCount++
+	}
+}
+
+// `claimedReply` appends a claimed entry to reply, as its ID alone
+// with JUSTID
+func claimedReply(reply *resp.Array, entry streamEntry, justID bool) {
+	if justID {
+		reply.Elements = append(reply.Elements, streamIDBulkString(entry.id))
+	} else {
+		reply.Elements = append(reply.Elements, streamEntryArray(entry))
+	}
+	reply.Size = len(reply.Elements)
+}
+
+// XCLAIM command changes the owner of pending entries idle for
+// at least min-idle-time milliseconds
+func xclaim(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 5 {
+		return wrongNumberOfArgs("xclaim")
+	}
+	key, name := string(args[0]), string(args[1])
+	if _, _, isStream := s.getStream(key); !isStream {
+		return wrongType()
+	}
+	st, group, ok := s.getGroup(key, name)
+	if !ok {
+		return noGroup(key, name)
+	}
+	minIdle, ok := parseInteger(args[3])
+	if !ok {
+		return errorReply("Invalid min-idle-time argument for XCLAIM")
+	}
+	// IDs are followed by the options
+	i := 4
+	var ids []streamID
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	now := time.Now()
+	options := claimOptions{deliveryTime: now}
+	var force bool
+	var lastID *streamID
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		hasValue := i+1 < len(args)
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			options.justID = true
+		case option == "IDLE" && hasValue:
+			idle, ok := parseInteger(args[i+1])
+			if !ok {
+				return errorReply("Invalid IDLE option argument for XCLAIM")
+			}
+			options.deliveryTime = now.Add(-time.Duration(min(max(idle, 0), math.MaxInt64/int64(time.Millisecond))) * time.Millisecond)
+			i++
+		case option == "TIME" && hasValue:
+			unixTime, ok := parseInteger(args[i+1])
+			if !ok {
+				return errorReply("Invalid TIME option argument for XCLAIM")
+			}
+			options.deliveryTime = time.UnixMilli(unixTime)
+			if unixTime < 0 || options.deliveryTime.After(now) {
+				options.deliveryTime = now
+			}
+			i++
+		case option == "RETRYCOUNT" && hasValue:
+			retryCount, ok := parseInteger(args[i+1])
+			if !ok || retryCount < 0 {
+				return errorReply("Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			options.retryCount, options.hasRetry = retryCount, true
+			i++
+		case option == "LASTID" && hasValue:
+			id, ok := parseStreamID(args[i+1], 0)
+			if !ok {
+				return errorReply(invalidStreamID)
+			}
+			lastID = &id
+			i++
+		default:
+			return errorReply("Unrecognized XCLAIM option '" + string(args[i]) + "'")
+		}
+	}
+	if lastID != nil && lastID.compare(group.lastID) > 0 {
+		group.lastID = *lastID
+	}
+	response := resp.Array{}
+	for _, id := range ids {
+		pending, isPending := group.pending.entries[id]
+		entry, exists := st.entry(id)
+		if !isPending {
+			if !force || !exists {
+				continue
+			}
+		} else if !exists {
+			// the entry was deleted from the stream
+			group.ack(id)
+			continue
+		} else if minIdle > 0 && now.Sub(pending.deliveryTime).Milliseconds() < minIdle {
+			continue
+		}
+		consumer := s.createConsumer(key, group, string(args[2]))
+		consumer.seenTime, consumer.activeTime = now, now
+		if !isPending {
+			// FORCE adds entries of the stream to the pending entries
+			pending = &pendingEntry{id: id}
+			group.pending.add(pending)
+		}
+		group.claim(pending, consumer, options)
+		claimedReply(&response, entry, options.justID)
+	}
+	return response.Serialise()
+}
+
+// XAUTOCLAIM command changes the owner of pending entries idle for at
+// least min-idle-time milliseconds, scanning the pending entries from
+// start
+func xautoclaim(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 5 {
+		return wrongNumberOfArgs("xautoclaim")
+	}
+	key, name := string(args[0]), string(args[1])
+	if _, _, isStream := s.getStream(key); !isStream {
+		return wrongType()
+	}
+	st, group, ok := s.getGroup(key, name)
+	if !ok {
+		return noGroup(key, name)
+	}
+	minIdle, ok := parseInteger(args[3])
+	if !ok {
+		return errorReply("Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, message := parseRangeID(args[4], true)
+	if message != "" {
+		return errorReply(message)
+	}
+	now := time.Now()
+	options := claimOptions{deliveryTime: now}
+	count := int64(100)
+	for i := 5; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); {
+		case option == "COUNT" && i+1 < len(args):
+			var ok bool
+			count, ok = parseInteger(args[i+1])
+			if !ok || count < 1 || count > math.MaxInt32 {
+				return errorReply("COUNT must be > 0")
+			}
+			i++
+		case option == "JUSTID":
+			options.justID = true
+		default:
+			return errorReply("invalid syntax")
+		}
+	}
+	consumer := s.createConsumer(key, group, string(args[2]))
+	consumer.seenTime = now
+	// at most count * 10 pending entries are scanned
+	attempts := count * 10
+	claimed := &resp.Array{}
+	deleted := &resp.Array{}
+	next := streamID{}
+	// acknowledging deleted entries changes the pending entries
+	ids := slices.Clone(group.pending.ids[group.pending.from(start):])
+	for _, id := range ids {
+		if attempts == 0 || count == 0 {
+			next = id
+			break
+		}
+		attempts--
+		pending := group.pending.entries[id]
+		if minIdle > 0 && now.Sub(pending.deliveryTime).Milliseconds() < minIdle {
+			continue
+		}
+		entry, exists := st.entry(id)
+		if !exists {
+			group.ack(id)
+			deleted.Elements = append(deleted.Elements, streamIDBulkString(id))
+			deleted.Size = len(deleted.Elements)
+			continue
+		}
+		group.claim(pending, consumer, options)
+		consumer.activeTime = now
+		claimedReply(claimed, entry, options.justID)
+		count--
+	}
+	response := resp.Array{
+		Size:     3,
+		Elements: []resp.RESPDatatype{streamIDBulkString(next), claimed, deleted},
+	}
+	return response.Serialise()
+}
+
+// `infoArray` returns an array of the given names each followed
+// by its value
+func infoArray(pairs ...any) *resp.Array {
+	response := &resp.Array{}
+	for i := 0; i < len(pairs); i += 2 {
+		response.Elements = append(response.Elements, bulkStringOf(pairs[i].(string)))
+		var value resp.RESPDatatype
+		switch v := pairs[i+1].(type) {
+		case int:
+			value = &resp.Integer{Data: int64(v)}
+		case int64:
+			value = &resp.Integer{Data: v}
+		case string:
+			value = bulkStringOf(v)
+		case streamID:
+			value = streamIDBulkString(v)
+		case resp.RESPDatatype:
+			value = v
+		}
+		response.Elements = append(response.Elements, value)
+	}
+	response.Size = len(response.Elements)
+	return response
+}
+
+// `entriesReadValue` returns the entries read by a group, nil
+// when they're unknown
+func entriesReadValue(group *consumerGroup) resp.RESPDatatype {
+	if group.entriesRead == invalidEntriesRead {
+		return &resp.BulkString{Size: -1}
+	}
+	return &resp.Integer{Data: group.entriesRead}
+}
+
+// `lagValue` returns the lag of a group, nil when it's unknown
+func lagValue(st *stream, group *consumerGroup) resp.RESPDatatype {
+	lag, ok := st.lag(group)
+	if !ok {
+		return &resp.BulkString{Size: -1}
+	}
+	return &resp.Integer{Data: lag}
+}
+
+// `xinfoStream` implements XINFO STREAM key [FULL [COUNT count]]
+func xinfoStream(st *stream, options [][]byte) ([]byte, error) {
+	full := false
+	count := 10
+	if len(options) > 0 {
+		if strings.ToUpper(string(options[0])) != "FULL" {
+			return errorReply("invalid syntax")
+		}
+		full = true
+		if len(options) > 1 {
+			if len(options) != 3 || strings.ToUpper(string(options[1])) != "COUNT" {
+				return errorReply("invalid syntax")
+			}
+			parsed, ok := parseInteger(options[2])
+			if !ok {
+				return errorReply("value is not an integer or out of range")
+			}
+			count = int(max(min(parsed, math.MaxInt32), 0))
+		}
+	}
+	pairs := []any{
+		"length", st.length,
+		"radix-tree-keys", len(st.chunks),
+		"radix-tree-nodes", len(st.chunks),
+		"last-generated-id", st.lastID,
+		"max-deleted-entry-id", st.maxDeletedID,
+		"entries-added", int64(st.entriesAdded),
+		"recorded-first-entry-id", st.firstID(),
+	}
+	if !full {
+		first, last := resp.RESPDatatype(&resp.Array{Size: -1}), resp.RESPDatatype(&resp.Array{Size: -1})
+		if entry, ok := st.firstEntry(); ok {
+			first = streamEntryArray(entry)
+		}
+		if entry, ok := st.lastEntry(); ok {
+			last = streamEntryArray(entry)
+		}
+		pairs = append(pairs, "groups", len(st.groups), "first-entry", first, "last-entry", last)
+		return infoArray(pairs...).Serialise()
+	}
+	// limit returns the first count IDs, all of them when count is 0
+	limit := func(ids []streamID) []streamID {
+		if count > 0 {
+			return ids[:min(count, len(ids))]
+		}
+		return ids
+	}
+	groups := &resp.Array{}
+	for _, name := range st.groupNames() {
+		group := st.groups[name]
+		pending := &resp.Array{}
+		for _, id := range limit(group.pending.ids) {
+			entry := group.pending.entries[id]
+			pending.Elements = append(pending.Elements, &resp.Array{
+				Size: 4,
+				Elements: []resp.RESPDatatype{
+					streamIDBulkString(id),
+					bulkStringOf(entry.consumer.name),
+					&resp.Integer{Data: entry.deliveryTime.UnixMilli()},
+					&resp.Integer{Data: int64(entry.deliveryCount)},
+				},
+			})
+		}
+		pending.Size = len(pending.Elements)
+		consumers := &resp.Array{}
+		for _, consumerName := range group.consumerNames() {
+			consumer := group.consumers[consumerName]
+			consumerPending := &resp.Array{}
+			for _, id := range limit(consumer.pending.ids) {
+				entry := consumer.pending.entries[id]
+				consumerPending.Elements = append(consumerPending.Elements, &resp.Array{
+					Size: 3,
+					Elements: []resp.RESPDatatype{
+						streamIDBulkString(id),
+						&resp.Integer{Data: entry.deliveryTime.UnixMilli()},
+						&resp.Integer{Data: int64(entry.deliveryCount)},
+					},
+				})
+			}
+			consumerPending.Size = len(consumerPending.Elements)
+			activeTime := int64(-1)
+			if !consumer.activeTime.IsZero() {
+				activeTime = consumer.activeTime.UnixMilli()
+			}
+			consumers.Elements = append(consumers.Elements, infoArray(
+				"name", consumer.name,
+				"seen-time", consumer.seenTime.UnixMilli(),
+				"active-time", activeTime,
+				"pel-count", consumer.pending.length(),
+				"pending", consumerPending,
+			))
+		}
+		consumers.Size = len(consumers.Elements)
+		groups.Elements = append(groups.Elements, infoArray(
+			"name", group.name,
+			"last-delivered-id", group.lastID,
+			"entries-read", entriesReadValue(group),
+			"lag", lagValue(st, group),
+			"pel-count", group.pending.length(),
+			"pending", pending,
+			"consumers", consumers,
+		))
+	}
+	groups.Size = len(groups.Elements)
+	entries := st.rangeEntries(streamID{}, maxStreamID, count, false)
+	pairs = append(pairs, "entries", streamEntriesArray(entries), "groups", groups)
+	return infoArray(pairs...).Serialise()
+}
+
+// XINFO command inspects a stream, its groups and their consumers
+func xinfo(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("xinfo")
+	}
+	subcommand := strings.ToUpper(string(args[0]))
+	switch subcommand {
+	case "STREAM", "GROUPS", "CONSUMERS":
+	default:
+		return errorReply("unknown subcommand '" + string(args[0]) + "' for 'xinfo' command")
+	}
+	if len(args) < 2 || (subcommand == "GROUPS" && len(args) != 2) || (subcommand == "CONSUMERS" && len(args) != 3) {
+		return wrongNumberOfArgs("xinfo|" + strings.ToLower(subcommand))
+	}
+	key := string(args[1])
+	st, exists, isStream := s.getStream(key)
+	if !isStream {
+		return wrongType()
+	}
+	if !exists {
+		return errorReply("no such key")
+	}
+	switch subcommand {
+	case "STREAM":
+		return xinfoStream(st, args[2:])
+	case "GROUPS":
+		response := resp.Array{}
+		for _, name := range st.groupNames() {
+			group := st.groups[name]
+			response.Elements = append(response.Elements, infoArray(
+				"name", group.name,
+				"consumers", len(group.consumers),
+				"pending", group.pending.length(),
+				"last-delivered-id", group.lastID,
+				"entries-read", entriesReadValue(group),
+				"lag", lagValue(st, group),
+			))
+		}
+		response.Size = len(response.Elements)
+		return response.Serialise()
+	default:
+		// CONSUMERS
+		name := string(args[2])
+		group, ok := st.groups[name]
+		if !ok {
+			return errorReply("NOGROUP No such consumer group '" + name + "' for key name '" + key + "'")
+		}
+		now := time.Now()
+		response := resp.Array{}
+		for _, consumerName := range group.consumerNames() {
+			consumer := group.consumers[consumerName]
+			inactive := int64(-1)
+			if !consumer.activeTime.IsZero() {
+				inactive = now.Sub(consumer.activeTime).Milliseconds()
+			}
+			response.Elements = append(response.Elements, infoArray(
+				"name", consumer.name,
+				"pending", consumer.pending.length(),
+				"idle", now.Sub(consumer.seenTime).Milliseconds(),
+				"inactive", inactive,
+			))
+		}
+		response.Size = len(response.Elements)
+		return response.Serialise()
+	}
+}
+
+// `appendGroupElements` appends the consumer groups of the stream to
+// its elements: the number of groups, then for each group its name,
+// last ID, entries read and number of consumers, each consumer as its
+// name, seen time and active time, -1 if it was never active, and
+// its number of pending entries, each as its ID, consumer, delivery
+// time and delivery count. Times are in unix milliseconds
+func (st *stream) appendGroupElements(elements [][]byte) [][]byte {
+	appendString := func(value string) {
+		elements = append(elements, []byte(value))
+	}
+	appendInt := func(value int64) {
+		appendString(strconv.FormatInt(value, 10))
+	}
+	appendInt(int64(len(st.groups)))
+	for _, name := range st.groupNames() {
+		group := st.groups[name]
+		appendString(name)
+		appendString(group.lastID.String())
+		appendInt(group.entriesRead)
+		appendInt(int64(len(group.consumers)))
+		for _, consumerName := range group.consumerNames() {
+			consumer := group.consumers[consumerName]
+			appendString(consumerName)
+			appendInt(consumer.seenTime.UnixMilli())
+			if consumer.activeTime.IsZero() {
+				appendInt(-1)
+			} else {
+				appendInt(consumer.activeTime.UnixMilli())
+			}
+		}
+		appendInt(int64(group.pending.length()))
+		for _, id := range group.pending.ids {
+			entry := group.pending.entries[id]
+			appendString(id.String())
+			appendString(entry.consumer.name)
+			appendInt(entry.deliveryTime.UnixMilli())
+			appendString(strconv.FormatUint(entry.deliveryCount, 10))
+		}
+	}
+	return elements
+}
+
+// `readGroupElements` reads back the consumer groups appended by
+// `appendGroupElements`, streams saved without groups have no
+// elements left
+func (st *stream) readGroupElements(elements [][]byte) error {
+	if len(elements) == 0 {
+		return nil
+	}
+	next := func() ([]byte, bool) {
+		if len(elements) == 0 {
+			return nil, false
+		}
+		element := elements[0]
+		elements = elements[1:]
+		return element, true
+	}
+	nextInt := func() (int64, bool) {
+		element, ok := next()
+		if !ok {
+			return 0, false
+		}
+		return parseInteger(element)
+	}
+	nextID := func() (streamID, bool) {
+		element, ok := next()
+		if !ok {
+			return streamID{}, false
+		}
+		return parseStreamID(element, 0)
+	}
+	groupCount, ok := nextInt()
+	if !ok || groupCount < 0 {
+		return resp.ErrInvalidClientData
+	}
+	for ; groupCount > 0; groupCount-- {
+		name, nameOk := next()
+		lastID, idOk := nextID()
+		entriesRead, readOk := nextInt()
+		consumerCount, countOk := nextInt()
+		if !nameOk || !idOk || !readOk || !countOk || consumerCount < 0 ||
+			!st.createGroup(string(name), lastID, entriesRead) {
+			return resp.ErrInvalidClientData
+		}
+		group := st.groups[string(name)]
+		for ; consumerCount > 0; consumerCount-- {
+			consumerName, nameOk := next()
+			seenTime, seenOk := nextInt()
+			activeTime, activeOk := nextInt()
+			if !nameOk || !seenOk || !activeOk {
+				return resp.ErrInvalidClientData
+			}
+			consumer, created := group.createConsumer(string(consumerName))
+			if !created {
+				return resp.ErrInvalidClientData
+			}
+			consumer.seenTime = time.UnixMilli(seenTime)
+			if activeTime >= 0 {
+				consumer.activeTime = time.UnixMilli(activeTime)
+			}
+		}
+		pendingCount, ok := nextInt()
+		if !ok || pendingCount < 0 {
+			return resp.ErrInvalidClientData
+		}
+		for ; pendingCount > 0; pendingCount-- {
+			id, idOk := nextID()
+			consumerName, nameOk := next()
+			deliveryTime, timeOk := nextInt()
+			deliveryCount, countOk := nextInt()
+			consumer := group.consumers[string(consumerName)]
+			if !idOk || !nameOk || !timeOk || !countOk || deliveryCount < 0 || consumer == nil ||
+				group.pending.entries[id] != nil {
+				return resp.ErrInvalidClientData
+			}
+			entry := &pendingEntry{
+				id:            id,
+				deliveryTime:  time.UnixMilli(deliveryTime),
+				deliveryCount: uint64(deliveryCount),
+			}
+			group.pending.add(entry)
+			group.assign(entry, consumer)
+		}
+	}
+	if len(elements) != 0 {
+		return resp.ErrInvalidClientData
+	}
+	return nil
+}
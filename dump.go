@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// A DUMP payload is made up of
+// <value type><encoded value><version: 2 bytes><crc64: 8 bytes>
+// where the version and checksum are little endian. Strings
+// and list elements are encoded as <uvarint length><bytes>.
+// Hashes are encoded as <uvarint field count> followed by each
+// field and its value. When fields have a TTL, each value is
+// followed by the field's <uvarint expiration unix milliseconds>,
+// 0 if the field doesn't expire. Sets are encoded as
+// <uvarint member count> followed by each member, and sorted
+// sets the same way with each member followed by its score as a
+// little endian float64. Streams are encoded as <uvarint count>
+// followed by the elements the stream flattens into, and JSON
+// documents as their serialised JSON text
+const (
+	dumpVersion    uint16 = 1
+	dumpFooterSize        = 2 + 8
+	dumpTypeString byte   = 0
+	dumpTypeList   byte   = 1
+	dumpTypeSet    byte   = 2
+	dumpTypeZset   byte   = 5
+	dumpTypeHash   byte   = 4
+	dumpTypeJSON   byte   = 7
+	dumpTypeStream byte   = 21
+	// a hash with fields that have a TTL
+	dumpTypeHashMetadata byte = 24
+	dumpPayloadError          = "DUMP payload version or checksum are wrong"
+)
+
+var (
+	crcTable           = crc64.MakeTable(crc64.ECMA)
+	errInvalidDump     = errors.New("invalid dump payload")
+	errUnknownDumpType = errors.New("unknown value type in dump payload")
+)
+
+// `appendDumpString` appends a length prefixed byte slice
+func appendDumpString(buffer []byte, data []byte) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(len(data)))
+	return append(buffer, data...)
+}
+
+// `readDumpString` reads a length prefixed byte slice. It returns
+// the data and the number of bytes consumed
+func readDumpString(data []byte) ([]byte, int, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return nil, 0, errInvalidDump
+	}
+	return data[n : n+int(length)], n + int(length), nil
+}
+
+// `readDumpLength` reads a uvarint length. It returns the
+// length and the number of bytes consumed
+func readDumpLength(data []byte) (int, int, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)) {
+		return 0, 0, errInvalidDump
+	}
+	return int(length), n, nil
+}
+
+// `readDumpCount` reads the number of elements of a list, set,
+// sorted set or hash. These are never empty, their key is deleted
+// with their last element, so a count of 0 is a corrupted payload
+func readDumpCount(data []byte) (int, int, error) {
+	length, n, err := readDumpLength(data)
+	if err == nil && length == 0 {
+		return 0, 0, errInvalidDump
+	}
+	return length, n, err
+}
+
+// `encodeValue` serialises a value into the DUMP format
+func encodeValue(value *redisValue) ([]byte, error) {
+	var payload []byte
+	switch value.valueType {
+	case "string":
+		payload = append(payload, dumpTypeString)
+		payload = appendDumpString(payload, value.value.([]byte))
+	case "list":
+		l := value.value.(*list)
+		payload = append(payload, dumpTypeList)
+		payload = binary.AppendUvarint(payload, uint64(l.length))
+		it := l.iterator(0, true)
+		for elem, ok := it.next(); ok; elem, ok = it.next() {
+			payload = appendDumpString(payload, elem)
+		}
+	case "set":
+		members := value.value.(*redisSet).list()
+		payload = append(payload, dumpTypeSet)
+		payload = binary.AppendUvarint(payload, uint64(len(members)))
+		for _, member := range members {
+			payload = appendDumpString(payload, []byte(member))
+		}
+	case "zset":
+		z := value.value.(*zset)
+		payload = append(payload, dumpTypeZset)
+		payload = binary.AppendUvarint(payload, uint64(z.length()))
+		for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			payload = appendDumpString(payload, []byte(x.member))
+			payload = binary.LittleEndian.AppendUint64(payload, math.Float64bits(x.score))
+		}
+	case "stream":
+		elements := value.value.(*stream).elements()
+		payload = append(payload, dumpTypeStream)
+		payload = binary.AppendUvarint(payload, uint64(len(elements)))
+		for _, element := range elements {
+			payload = appendDumpString(payload, element)
+		}
+	case "json":
+		payload = append(payload, dumpTypeJSON)
+		payload = appendDumpString(payload, serialiseJSON(value.value.(*jsonDocument).root))
+	case "hash":
+		h := value.value.(*hash)
+		withExpires := len(h.expires) > 0
+		if withExpires {
+			payload = append(payload, dumpTypeHashMetadata)
+		} else {
+			payload = append(payload, dumpTypeHash)
+		}
+		payload = binary.AppendUvarint(payload, uint64(len(h.fields)))
+		for field, value := range h.fields {
+			payload = appendDumpString(payload, []byte(field))
+			payload = appendDumpString(payload, value)
+			if withExpires {
+				var expire int64
+				if t, ok := h.expiry(field); ok {
+					expire = t.UnixMilli()
+				}
+				payload = binary.AppendUvarint(payload, uint64(expire))
+			}
+		}
+	default:
+		return nil, errUnknownDumpType
+	}
+	payload = binary.LittleEndian.AppendUint16(payload, dumpVersion)
+	return binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload, crcTable)), nil
+}
+
+// `decodeValue` deserialises a DUMP payload, verifying
+// its version and checksum
+func decodeValue(payload []byte) (*redisValue, error) {
+	if len(payload) < 1+dumpFooterSize {
+		return nil, errInvalidDump
+	}
+	footer := payload[len(payload)-dumpFooterSize:]
+	version := binary.LittleEndian.Uint16(footer)
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+	if version > dumpVersion || crc64.Checksum(payload[:len(payload)-8], crcTable) != checksum {
+		return nil, errInvalidDump
+	}
+	data := payload[1 : len(payload)-dumpFooterSize]
+	value := &redisValue{}
+	switch payload[0] {
+	case dumpTypeString:
+		str, consumed, err := readDumpString(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[consumed:]
+		value.valueType = "string"
+		value.value = append([]byte{}, str...)
+	case dumpTypeList:
+		length, consumed, err := readDumpCount(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[consumed:]
+		elements := make([][]byte, length)
+		for i := 0; i < length; i++ {
+			elem, consumed, err := readDumpString(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[consumed:]
+			elements[i] = elem
+		}
+		l := newList()
+		l.tpush(elements)
+		value.valueType = "list"
+		value.value = l
+	case dumpTypeSet:
+		length, consumed, err := readDumpCount(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[consumed:]
+		st := newRedisSet()
+		for i := 0; i < length; i++ {
+			member, consumed, err := readDumpString(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[consumed:]
+			st.add(string(member))
+		}
+		value.valueType = "set"
+		value.value = st
+	case dumpTypeZset:
+		length, consumed, err := readDumpCount(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[consumed:]
+		z := newZset()
+		for i := 0; i < length; i++ {
+			member, consumed, err := readDumpString(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[consumed:]
+			if len(data) < 8 {
+				return nil, errInvalidDump
+			}
+			score := math.Float64frombits(binary.LittleEndian.Uint64(data))
+			if math.IsNaN(score) {
+				return nil, errInvalidDump
+			}
+			data = data[8:]
+			z.add(string(member), score)
+		}
+		value.valueType = "zset"
+		value.value = z
+	case dumpTypeStream:
+		length, consumed, err := readDumpLength(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[consumed:]
+		elements := make([][]byte, length)
+		for i := range elements {
+			element, consumed, err := readDumpString(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[consumed:]
+			elements[i] = element
+		}
+		st, err := streamFromElements(elements)
+		if err != nil {
+			return nil, errInvalidDump
+		}
+		value.valueType = "stream"
+		value.value = st
+	case dumpTypeJSON:
+		text, consumed, err := readDumpString(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[consumed:]
+		root, err := parseJSON(text)
+		if err != nil {
+			return nil, errInvalidDump
+		}
+		value.valueType = "json"
+		value.value = &jsonDocument{root: root}
+	case dumpTypeHash, dumpTypeHashMetadata:
+		length, consumed, err := readDumpCount(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[consumed:]
+		h := newHash()
+		for i := 0; i < length; i++ {
+			field, consumed, err := readDumpString(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[consumed:]
+			fieldValue, consumed, err := readDumpString(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[consumed:]
+			h.setField(string(field), append([]byte{}, fieldValue...))
+			if payload[0] == dumpTypeHashMetadata {
+				expire, consumed := binary.Uvarint(data)
+				if consumed <= 0 || expire > maxFieldExpire {
+					return nil, errInvalidDump
+				}
+				data = data[consumed:]
+				if expire != 0 {
+					h.setExpiry(string(field), time.UnixMilli(int64(expire)))
+				}
+			}
+		}
+		value.valueType = "hash"
+		value.value = h
+	default:
+		return nil, errUnknownDumpType
+	}
+	// trailing bytes imply a corrupted payload
+	if len(data) != 0 {
+		return nil, errInvalidDump
+	}
+	return value, nil
+}
+
+// DUMP command serialises the value stored at key
+func dump(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("dump")
+	}
+	value, ok := s.get(string(args[0]))
+	if !ok {
+		response := resp.BulkString{
+			Size: -1,
+		}
+		return response.Serialise()
+	}
+	payload, err := encodeValue(value)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	response := resp.BulkString{
+		Data: payload,
+		Size: len(payload),
+	}
+	return response.Serialise()
+}
+
+// RESTORE command creates a key from a DUMP payload
+func restore(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("restore")
+	}
+	key := string(args[0])
+	var replace, absTTL bool
+	idleTime, frequency := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME":
+			if i+1 >= len(args) || frequency != -1 {
+				return errorReply("invalid syntax")
+			}
+			parsed, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || parsed < 0 {
+				return errorReply("invalid IDLETIME value, must be >= 0")
+			}
+			idleTime = parsed
+			i++
+		case "FREQ":
+			if i+1 >= len(args) || idleTime != -1 {
+				return errorReply("invalid syntax")
+			}
+			parsed, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || parsed < 0 || parsed > 255 {
+				return errorReply("invalid FREQ value, must be >= 0 and <= 255")
+			}
+			frequency = parsed
+			i++
+		default:
+			return errorReply("invalid syntax")
+		}
+	}
+	// goRed doesn't track access time or frequency, IDLETIME
+	// and FREQ are validated and otherwise ignored
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return errorReply("value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return errorReply("invalid TTL value, must be >= 0")
+	}
+	if _, ok := s.get(key); ok && !replace {
+		return errorReply("BUSYKEY Target key name already exists.")
+	}
+	value, err := decodeValue(args[2])
+	if err != nil {
+		return errorReply(dumpPayloadError)
+	}
+	if ttl > 0 {
+		if absTTL {
+			value.expire = time.UnixMilli(ttl)
+		} else {
+			value.expire = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+	response := resp.SimpleString{
+		Data: "OK",
+	}
+	// a key restored with an expiry time in the past is deleted right away
+	if !value.expire.IsZero() && value.expire.Before(time.Now()) {
+		if _, ok := s.get(key); ok {
+			delete(s.db, key)
+			s.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+		return response.Serialise()
+	}
+	s.set(key, value)
+	s.notifyKeyspaceEvent(notifyGeneric, "restore", key)
+	return response.Serialise()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// `bulkData` returns the data of a serialised bulk string reply
+func bulkData(t *testing.T, reply string) string {
+	t.Helper()
+	header, data, ok := strings.Cut(reply, "\r\n")
+	size, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+	if !ok || err != nil || header[0] != '$' || len(data) != size+2 {
+		t.Fatalf("%q isn't a bulk string", reply)
+	}
+	return data[:size]
+}
+
+func TestDumpRestore(t *testing.T) {
+	s := newStore()
+	run(t, s, "SET", "string", "hello\x00world")
+	run(t, s, "RPUSH", "list", "a", "b", "c")
+	run(t, s, "SADD", "intset", "3", "1", "2")
+	run(t, s, "SADD", "set", "x", "y", "z")
+	run(t, s, "ZADD", "zset", "1.5", "a", "-2", "b", "inf", "c")
+	run(t, s, "HSET", "hash", "f", "v", "g", "w")
+	run(t, s, "HSET", "volatile", "f", "v", "g", "w")
+	run(t, s, "HPEXPIREAT", "volatile", "9999999999999", "FIELDS", "1", "f")
+	run(t, s, "XADD", "stream", "1-1", "a", "1")
+	run(t, s, "XADD", "stream", "2-1", "b", "2", "c", "3")
+	run(t, s, "JSON.SET", "json", "$", `{"a":[1,2.5,"x"],"b":null}`)
+	// commands whose replies must be the same for a value and its copy
+	reads := map[string][]string{
+		"string":   {"GET"},
+		"list":     {"LRANGE", "", "0", "-1"},
+		"intset":   {"SMEMBERS"},
+		"set":      {"SMISMEMBER", "", "x", "y", "z"},
+		"zset":     {"ZRANGE", "", "0", "-1", "WITHSCORES"},
+		"hash":     {"HMGET", "", "f", "g"},
+		"volatile": {"HMGET", "", "f", "g"},
+		"stream":   {"XRANGE", "", "-", "+"},
+		"json":     {"JSON.GET"},
+	}
+	for key, read := range reads {
+		payload := bulkData(t, run(t, s, "DUMP", key))
+		copyKey := key + ":copy"
+		if got := run(t, s, "RESTORE", copyKey, "0", payload); got != "+OK\r\n" {
+			t.Fatalf("RESTORE of %s replied %q", key, got)
+		}
+		args := append([]string{read[0], key}, read[min(2, len(read)):]...)
+		want := run(t, s, args...)
+		args[1] = copyKey
+		if got := run(t, s, args...); got != want {
+			t.Errorf("%s was restored as %q, want %q", key, got, want)
+		}
+		if got, want := s.db[copyKey].valueType, s.db[key].valueType; got != want {
+			t.Errorf("%s was restored as a %s, want a %s", key, got, want)
+		}
+	}
+
+	h := s.db["volatile:copy"].value.(*hash)
+	if expire, ok := h.expiry("f"); !ok || expire.UnixMilli() != 9999999999999 {
+		t.Errorf("the TTL of a field was restored as %v", expire)
+	}
+	if _, ok := h.expiry("g"); ok {
+		t.Error("a field without a TTL was restored with one")
+	}
+
+	payload := bulkData(t, run(t, s, "DUMP", "string"))
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"DUMP", "missing"}, "$-1\r\n"},
+		{[]string{"RESTORE", "string", "0", payload}, "-BUSYKEY Target key name already exists.\r\n"},
+		{[]string{"RESTORE", "string", "0", payload, "REPLACE"}, "+OK\r\n"},
+		{[]string{"RESTORE", "ttl", "100000", payload}, "+OK\r\n"},
+		{[]string{"RESTORE", "past", "1", payload, "ABSTTL"}, "+OK\r\n"},
+		{[]string{"EXISTS", "past"}, integerReply(0)},
+		{[]string{"RESTORE", "negative", "-1", payload}, "-invalid TTL value, must be >= 0\r\n"},
+		{[]string{"RESTORE", "freq", "0", payload, "FREQ", "256"}, "-invalid FREQ value, must be >= 0 and <= 255\r\n"},
+		{[]string{"RESTORE", "both", "0", payload, "FREQ", "1", "IDLETIME", "1"}, "-invalid syntax\r\n"},
+		{[]string{"RESTORE", "short", "0", "x"}, "-" + dumpPayloadError + "\r\n"},
+		{[]string{"RESTORE", "checksum", "0", payload[:len(payload)-1] + "?"}, "-" + dumpPayloadError + "\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q replied %q, want %q", test.args, got, test.want)
+		}
+	}
+	if ttl := time.Until(s.db["ttl"].expire); ttl <= 0 || ttl > 100*time.Second {
+		t.Errorf("a key restored with a TTL of 100s expires in %v", ttl)
+	}
+}
+
+// TestRestoreEmptyCollections checks that payloads of an empty list,
+// set, sorted set or hash are rejected, since their keys are deleted
+// with their last element rather than left empty
+func TestRestoreEmptyCollections(t *testing.T) {
+	s := newStore()
+	for _, valueType := range []byte{dumpTypeList, dumpTypeSet, dumpTypeZset, dumpTypeHash, dumpTypeHashMetadata} {
+		payload := []byte{valueType, 0}
+		payload = binary.LittleEndian.AppendUint16(payload, dumpVersion)
+		payload = binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload, crcTable))
+		if got := run(t, s, "RESTORE", "empty", "0", string(payload)); got != "-"+dumpPayloadError+"\r\n" {
+			t.Errorf("RESTORE of an empty value of type %d replied %q", valueType, got)
+		}
+		if got := run(t, s, "EXISTS", "empty"); got != integerReply(0) {
+			t.Fatalf("an empty value of type %d was restored", valueType)
+		}
+	}
+}
+
+// TestDecodeCorruptedPayloads checks that payloads with a valid
+// checksum and corrupted contents are rejected rather than crashing
+// the server or being half restored
+func TestDecodeCorruptedPayloads(t *testing.T) {
+	s := newStore()
+	run(t, s, "RPUSH", "list", "a", "b", "c")
+	run(t, s, "SADD", "set", "x", "y", "1")
+	run(t, s, "ZADD", "zset", "1.5", "a", "-2", "b")
+	run(t, s, "HSET", "hash", "f", "v")
+	run(t, s, "HPEXPIREAT", "hash", "9999999999999", "FIELDS", "1", "f")
+	run(t, s, "XADD", "stream", "1-1", "a", "1")
+	run(t, s, "JSON.SET", "json", "$", `{"a":[1,2]}`)
+	for key := range s.db {
+		payload := []byte(bulkData(t, run(t, s, "DUMP", key)))
+		body := payload[:len(payload)-dumpFooterSize]
+		for i := 0; i < 2000; i++ {
+			corrupted := append([]byte{}, body...)
+			switch rand.Intn(3) {
+			case 0:
+				corrupted[rand.Intn(len(corrupted))] = byte(rand.Intn(256))
+			case 1:
+				corrupted = corrupted[:rand.Intn(len(corrupted))]
+			default:
+				corrupted = append(corrupted, byte(rand.Intn(256)))
+			}
+			corrupted = binary.LittleEndian.AppendUint16(corrupted, dumpVersion)
+			corrupted = binary.LittleEndian.AppendUint64(corrupted, crc64.Checksum(corrupted, crcTable))
+			// any outcome but a panic is fine
+			decodeValue(corrupted)
+		}
+	}
+}
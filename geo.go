@@ -0,0 +1,592 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// `geoShape` is the area GEOSEARCH looks for members in, either a
+// circle of radius or a box of width and height around a center
+type geoShape struct {
+	longitude, latitude float64
+	circular            bool
+	radius              float64
+	width, height       float64
+	// conversion is the number of meters in the unit the
+	// shape was given in
+	conversion float64
+}
+
+// `boundingBox` returns the minimum longitude, minimum latitude,
+// maximum longitude and maximum latitude of the shape
+func (shape geoShape) boundingBox() (float64, float64, float64, float64) {
+	height, width := shape.radius, shape.radius
+	if !shape.circular {
+		height, width = shape.height/2, shape.width/2
+	}
+	height *= shape.conversion
+	width *= shape.conversion
+	latDelta := radiansToDegrees(height / earthRadiusInMeters)
+	longDeltaTop := radiansToDegrees(width / earthRadiusInMeters / math.Cos(degreesToRadians(shape.latitude+latDelta)))
+	longDeltaBottom := radiansToDegrees(width / earthRadiusInMeters / math.Cos(degreesToRadians(shape.latitude-latDelta)))
+	// the widest edge is the one closest to the equator
+	longDelta := longDeltaTop
+	if shape.latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return shape.longitude - longDelta, shape.latitude - latDelta,
+		shape.longitude + longDelta, shape.latitude + latDelta
+}
+
+// `contains` reports whether a position is within the shape, along
+// with its distance in meters from the center
+func (shape geoShape) contains(longitude, latitude float64) (float64, bool) {
+	if shape.circular {
+		distance := geoDistance(shape.longitude, shape.latitude, longitude, latitude)
+		return distance, distance <= shape.radius*shape.conversion
+	}
+	// the latitude distance is cheaper to compute, check it first
+	if geoLatDistance(latitude, shape.latitude) > shape.height*shape.conversion/2 {
+		return 0, false
+	}
+	if geoDistance(longitude, latitude, shape.longitude, latitude) > shape.width*shape.conversion/2 {
+		return 0, false
+	}
+	return geoDistance(shape.longitude, shape.latitude, longitude, latitude), true
+}
+
+// `areas` returns the geohashes covering the shape: the one of its
+// center followed by its neighbors, zero for the neighbors that
+// don't overlap the shape
+func (shape geoShape) areas() [9]geohashBits {
+	minLong, minLat, maxLong, maxLat := shape.boundingBox()
+	radius := shape.radius
+	if !shape.circular {
+		// the distance from the center to a corner
+		radius = math.Sqrt((shape.width/2)*(shape.width/2) + (shape.height/2)*(shape.height/2))
+	}
+	radius *= shape.conversion
+	steps := geohashStepsForRadius(radius, shape.latitude)
+	hash, _ := geohashEncode(geoLongRange, geoLatRange, shape.longitude, shape.latitude, steps)
+	neighbors := neighborsOf(hash)
+	area := geohashDecode(geoLongRange, geoLatRange, hash)
+	// the estimated step may be too large when the shape is close
+	// to the edge of the center's area, so that the neighbors don't
+	// cover all of it
+	north := geohashDecode(geoLongRange, geoLatRange, neighbors.north)
+	south := geohashDecode(geoLongRange, geoLatRange, neighbors.south)
+	east := geohashDecode(geoLongRange, geoLatRange, neighbors.east)
+	west := geohashDecode(geoLongRange, geoLatRange, neighbors.west)
+	if steps > 1 && (north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLong || west.longitude.min > minLong) {
+		steps--
+		hash, _ = geohashEncode(geoLongRange, geoLatRange, shape.longitude, shape.latitude, steps)
+		neighbors = neighborsOf(hash)
+		area = geohashDecode(geoLongRange, geoLatRange, hash)
+	}
+	// exclude the neighbors that can't hold members of the shape
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			neighbors.south, neighbors.southWest, neighbors.southEast = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+		if area.latitude.max > maxLat {
+			neighbors.north, neighbors.northEast, neighbors.northWest = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+		if area.longitude.min < minLong {
+			neighbors.west, neighbors.southWest, neighbors.northWest = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+		if area.longitude.max > maxLong {
+			neighbors.east, neighbors.southEast, neighbors.northEast = geohashBits{}, geohashBits{}, geohashBits{}
+		}
+	}
+	return [9]geohashBits{hash, neighbors.north, neighbors.south, neighbors.east, neighbors.west,
+		neighbors.northEast, neighbors.northWest, neighbors.southEast, neighbors.southWest}
+}
+
+// `geoPoint` is a member found by a search
+type geoPoint struct {
+	member              string
+	score               float64
+	longitude, latitude float64
+	// distance in meters from the center of the search
+	distance float64
+}
+
+// `geoSearch` returns the members of z within shape. The search
+// stops once limit members were found, 0 meaning no limit
+func geoSearch(z *zset, shape geoShape, limit int) []geoPoint {
+	var points []geoPoint
+	areas := shape.areas()
+	// index of the last neighbor searched, 0 until one was
+	last := 0
+	for i, hash := range areas {
+		if hash.isZero() {
+			continue
+		}
+		// with a large radius, adjacent neighbors may be the same area
+		if last != 0 && hash == areas[last] {
+			continue
+		}
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		last = i
+		minScore := float64(hash.align52Bits())
+		hash.bits++
+		r := scoreRange{
+			min:          minScore,
+			max:          float64(hash.align52Bits()),
+			maxExclusive: true,
+		}
+		for x := z.zsl.firstInRange(r); x != nil && r.belowMax(x); x = x.level[0].forward {
+			longitude, latitude := geohashPosition(x.score)
+			distance, ok := shape.contains(longitude, latitude)
+			if !ok {
+				continue
+			}
+			points = append(points, geoPoint{x.member, x.score, longitude, latitude, distance})
+			if limit > 0 && len(points) >= limit {
+				break
+			}
+		}
+	}
+	return points
+}
+
+// `parseGeoUnit` returns the number of meters in a unit
+func parseGeoUnit(arg []byte) (float64, bool) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+const geoUnitError = "unsupported unit provided. please use M, KM, FT, MI"
+
+// `parseLongLat` parses a longitude and latitude pair. A non empty
+// string is the error to reply with
+func parseLongLat(longArg, latArg []byte) (float64, float64, string) {
+	longitude, longOk := parseFloat(longArg)
+	latitude, latOk := parseFloat(latArg)
+	if !longOk || !latOk {
+		return 0, 0, "value is not a valid float"
+	}
+	if longitude < geoLongMin || longitude > geoLongMax || latitude < geoLatMin || latitude > geoLatMax {
+		return 0, 0, fmt.Sprintf("invalid longitude,latitude pair %f,%f", longitude, latitude)
+	}
+	return longitude, latitude, ""
+}
+
+// `formatCoordinate` formats a coordinate with up to 17 decimals
+// and no trailing zeros, the way redis does
+func formatCoordinate(coordinate float64) string {
+	formatted := strconv.FormatFloat(coordinate, 'f', 17, 64)
+	formatted = strings.TrimRight(formatted, "0")
+	formatted = strings.TrimSuffix(formatted, ".")
+	if formatted == "-0" {
+		return "0"
+	}
+	return formatted
+}
+
+// `formatDistance` formats a distance with 4 decimals
+func formatDistance(distance float64) *resp.BulkString {
+	formatted := strconv.FormatFloat(distance, 'f', 4, 64)
+	return &resp.BulkString{Data: []byte(formatted), Size: len(formatted)}
+}
+
+// `coordinatesArray` returns a position as an array of its
+// longitude and latitude
+func coordinatesArray(longitude, latitude float64) *resp.Array {
+	long, lat := formatCoordinate(longitude), formatCoordinate(latitude)
+	return &resp.Array{
+		Size: 2,
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte(long), Size: len(long)},
+			&resp.BulkString{Data: []byte(lat), Size: len(lat)},
+		},
+	}
+}
+
+// GEOADD command adds members at the given positions to the sorted
+// set stored at key, scoring each member by its geohash
+func geoadd(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("geoadd")
+	}
+	var flags zaddFlags
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "CH":
+			flags.ch = true
+		default:
+			break options
+		}
+	}
+	triplets := args[i:]
+	if len(triplets) == 0 || len(triplets)%3 != 0 || (flags.nx && flags.xx) {
+		return errorReply("invalid syntax")
+	}
+	elements := make([][]byte, 0, len(triplets)/3*2)
+	for j := 0; j < len(triplets); j += 3 {
+		longitude, latitude, message := parseLongLat(triplets[j], triplets[j+1])
+		if message != "" {
+			return errorReply(message)
+		}
+		score := formatScore(geohashScore(longitude, latitude))
+		elements = append(elements, []byte(score), triplets[j+2])
+	}
+	return zaddGeneric(string(args[0]), elements, flags, s)
+}
+
+// GEOPOS command returns the positions of members of a sorted set
+func geopos(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("geopos")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	var response resp.Array
+	for _, member := range args[1:] {
+		score, ok := 0.0, false
+		if exists {
+			score, ok = z.scores[string(member)]
+		}
+		if !ok {
+			response.Elements = append(response.Elements, &resp.Array{Size: -1})
+			continue
+		}
+		response.Elements = append(response.Elements, coordinatesArray(geohashPosition(score)))
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// GEODIST command returns the distance between two members
+// of a sorted set
+func geodist(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("geodist")
+	}
+	conversion := 1.0
+	if len(args) == 4 {
+		var ok bool
+		conversion, ok = parseGeoUnit(args[3])
+		if !ok {
+			return errorReply(geoUnitError)
+		}
+	} else if len(args) > 4 {
+		return errorReply("invalid syntax")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	if !exists {
+		return nilBulkString()
+	}
+	score1, ok1 := z.scores[string(args[1])]
+	score2, ok2 := z.scores[string(args[2])]
+	if !ok1 || !ok2 {
+		return nilBulkString()
+	}
+	long1, lat1 := geohashPosition(score1)
+	long2, lat2 := geohashPosition(score2)
+	return formatDistance(geoDistance(long1, lat1, long2, lat2) / conversion).Serialise()
+}
+
+// the alphabet of standard geohash strings
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GEOHASH command returns the standard geohash strings of
+// members of a sorted set
+func geohashCommand(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("geohash")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	var response resp.Array
+	for _, member := range args[1:] {
+		score, ok := 0.0, false
+		if exists {
+			score, ok = z.scores[string(member)]
+		}
+		if !ok {
+			response.Elements = append(response.Elements, &resp.BulkString{Size: -1})
+			continue
+		}
+		// scores are encoded with latitudes limited to +/-85.05,
+		// standard geohashes use +/-90 so the position is re-encoded
+		longitude, latitude := geohashPosition(score)
+		hash, _ := geohashEncode(geohashRange{-180, 180}, geohashRange{-90, 90}, longitude, latitude, geoStepMax)
+		encoded := make([]byte, 11)
+		for i := range encoded {
+			index := uint64(0)
+			// 52 bits only fill 10 characters, the 11th is
+			// always the first letter of the alphabet
+			if i < 10 {
+				index = (hash.bits >> (52 - (i+1)*5)) & 0x1f
+			}
+			encoded[i] = geoAlphabet[index]
+		}
+		response.Elements = append(response.Elements, &resp.BulkString{Data: encoded, Size: len(encoded)})
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `geoSearchOptions` holds the parsed arguments of GEOSEARCH and
+// GEOSEARCHSTORE
+type geoSearchOptions struct {
+	shape geoShape
+	// fromMember is set when the center is a member's position
+	fromMember []byte
+	// sort is 1 for ascending distances, -1 for descending
+	// ones and 0 to leave results unsorted
+	sort                          int
+	count                         int64
+	any                           bool
+	withDist, withHash, withCoord bool
+	storeDist                     bool
+}
+
+// `parseGeoSearch` parses the options of GEOSEARCH, along with
+// STOREDIST for GEOSEARCHSTORE. A non empty string is the error
+// to reply with
+func parseGeoSearch(args [][]byte, command string, store bool) (geoSearchOptions, string) {
+	var options geoSearchOptions
+	var fromLongLat, byRadius, byBox bool
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch option := strings.ToUpper(string(args[i])); {
+		case option == "WITHDIST":
+			options.withDist = true
+		case option == "WITHHASH":
+			options.withHash = true
+		case option == "WITHCOORD":
+			options.withCoord = true
+		case option == "ANY":
+			options.any = true
+		case option == "ASC":
+			options.sort = 1
+		case option == "DESC":
+			options.sort = -1
+		case option == "STOREDIST" && store:
+			options.storeDist = true
+		case option == "COUNT" && remaining >= 1:
+			count, ok := parseInteger(args[i+1])
+			if !ok {
+				return options, "value is not an integer or out of range"
+			}
+			if count <= 0 {
+				return options, "COUNT must be > 0"
+			}
+			options.count = count
+			i++
+		case option == "FROMMEMBER" && remaining >= 1:
+			options.fromMember = args[i+1]
+			i++
+		case option == "FROMLONLAT" && remaining >= 2:
+			longitude, latitude, message := parseLongLat(args[i+1], args[i+2])
+			if message != "" {
+				return options, message
+			}
+			options.shape.longitude, options.shape.latitude = longitude, latitude
+			fromLongLat = true
+			i += 2
+		case option == "BYRADIUS" && remaining >= 2:
+			radius, ok := parseFloat(args[i+1])
+			if !ok {
+				return options, "need numeric radius"
+			}
+			if radius < 0 {
+				return options, "radius cannot be negative"
+			}
+			conversion, ok := parseGeoUnit(args[i+2])
+			if !ok {
+				return options, geoUnitError
+			}
+			options.shape.circular = true
+			options.shape.radius = radius
+			options.shape.conversion = conversion
+			byRadius = true
+			i += 2
+		case option == "BYBOX" && remaining >= 3:
+			width, ok := parseFloat(args[i+1])
+			if !ok {
+				return options, "need numeric width"
+			}
+			height, ok := parseFloat(args[i+2])
+			if !ok {
+				return options, "need numeric height"
+			}
+			if width < 0 || height < 0 {
+				return options, "height or width cannot be negative"
+			}
+			conversion, ok := parseGeoUnit(args[i+3])
+			if !ok {
+				return options, geoUnitError
+			}
+			options.shape.circular = false
+			options.shape.width, options.shape.height = width, height
+			options.shape.conversion = conversion
+			byBox = true
+			i += 3
+		default:
+			return options, "invalid syntax"
+		}
+	}
+	if store && (options.withDist || options.withHash || options.withCoord) {
+		return options, "GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options"
+	}
+	if (options.fromMember != nil) == fromLongLat {
+		return options, "exactly one of FROMMEMBER or FROMLONLAT can be specified for " + command
+	}
+	if byRadius == byBox {
+		return options, "exactly one of BYRADIUS and BYBOX can be specified for " + command
+	}
+	if options.any && options.count == 0 {
+		return options, "the ANY argument requires COUNT argument"
+	}
+	return options, ""
+}
+
+// `geoSearchGeneric` runs the search described by options against
+// the sorted set at key. A non empty string is the error to reply with
+func (s *store) geoSearchGeneric(key string, options geoSearchOptions) ([]geoPoint, bool, string) {
+	z, exists, isZset := s.getZset(key)
+	if !isZset {
+		return nil, false, ""
+	}
+	if !exists {
+		return nil, true, ""
+	}
+	if options.fromMember != nil {
+		score, ok := z.scores[string(options.fromMember)]
+		if !ok {
+			return nil, true, "could not decode requested zset member"
+		}
+		options.shape.longitude, options.shape.latitude = geohashPosition(score)
+	}
+	limit := 0
+	if options.any {
+		limit = int(options.count)
+	}
+	points := geoSearch(z, options.shape, limit)
+	// a COUNT without ANY returns the closest members
+	if options.sort == 0 && options.count > 0 && !options.any {
+		options.sort = 1
+	}
+	if options.sort != 0 {
+		slices.SortStableFunc(points, func(a, b geoPoint) int {
+			if a.distance == b.distance {
+				return 0
+			}
+			if (a.distance < b.distance) == (options.sort == 1) {
+				return -1
+			}
+			return 1
+		})
+	}
+	if options.count > 0 && int64(len(points)) > options.count {
+		points = points[:options.count]
+	}
+	return points, true, ""
+}
+
+// GEOSEARCH command returns the members of a sorted set within
+// a radius or a box around a member or a position
+func geosearch(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 6 {
+		return wrongNumberOfArgs("geosearch")
+	}
+	options, message := parseGeoSearch(args[1:], "geosearch", false)
+	if message != "" {
+		return errorReply(message)
+	}
+	points, isZset, message := s.geoSearchGeneric(string(args[0]), options)
+	if !isZset {
+		return wrongType()
+	}
+	if message != "" {
+		return errorReply(message)
+	}
+	var response resp.Array
+	for _, point := range points {
+		member := &resp.BulkString{Data: []byte(point.member), Size: len(point.member)}
+		if !options.withDist && !options.withHash && !options.withCoord {
+			response.Elements = append(response.Elements, member)
+			continue
+		}
+		item := &resp.Array{Elements: []resp.RESPDatatype{member}}
+		if options.withDist {
+			item.Elements = append(item.Elements, formatDistance(point.distance/options.shape.conversion))
+		}
+		if options.withHash {
+			item.Elements = append(item.Elements, &resp.Integer{Data: int64(point.score)})
+		}
+		if options.withCoord {
+			item.Elements = append(item.Elements, coordinatesArray(point.longitude, point.latitude))
+		}
+		item.Size = len(item.Elements)
+		response.Elements = append(response.Elements, item)
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// GEOSEARCHSTORE command stores the members GEOSEARCH finds in the
+// sorted set at destination, scored by their geohash or, with
+// STOREDIST, their distance
+func geosearchstore(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 7 {
+		return wrongNumberOfArgs("geosearchstore")
+	}
+	options, message := parseGeoSearch(args[2:], "geosearchstore", true)
+	if message != "" {
+		return errorReply(message)
+	}
+	points, isZset, message := s.geoSearchGeneric(string(args[1]), options)
+	if !isZset {
+		return wrongType()
+	}
+	if message != "" {
+		return errorReply(message)
+	}
+	result := newZset()
+	for _, point := range points {
+		score := point.score
+		if options.storeDist {
+			score = point.distance / options.shape.conversion
+		}
+		result.add(point.member, score)
+	}
+	s.storeZset(string(args[0]), result, "geosearchstore")
+	response := resp.Integer{
+		Data: int64(result.length()),
+	}
+	return response.Serialise()
+}
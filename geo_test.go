@@ -0,0 +1,188 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestInterleave(t *testing.T) {
+	if got := interleave64(1, 0); got != 1 {
+		t.Errorf("interleave64(1, 0) = %b", got)
+	}
+	if got := interleave64(0, 1); got != 2 {
+		t.Errorf("interleave64(0, 1) = %b", got)
+	}
+	if got := interleave64(0xFFFFFFFF, 0); got != 0x5555555555555555 {
+		t.Errorf("interleave64(0xFFFFFFFF, 0) = %x", got)
+	}
+	for i := 0; i < 1000; i++ {
+		x, y := rand.Uint32(), rand.Uint32()
+		separated := deinterleave64(interleave64(x, y))
+		if uint32(separated) != x || uint32(separated>>32) != y {
+			t.Fatalf("deinterleave64(interleave64(%x, %x)) = %x", x, y, separated)
+		}
+	}
+}
+
+func TestGeohashEncodeDecode(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		longitude := geoLongMin + rand.Float64()*(geoLongMax-geoLongMin)
+		latitude := geoLatMin + rand.Float64()*(geoLatMax-geoLatMin)
+		for _, step := range []uint{1, 10, geoStepMax} {
+			hash, ok := geohashEncode(geoLongRange, geoLatRange, longitude, latitude, step)
+			if !ok {
+				t.Fatalf("%f,%f wasn't encoded", longitude, latitude)
+			}
+			area := geohashDecode(geoLongRange, geoLatRange, hash)
+			if longitude < area.longitude.min || longitude > area.longitude.max ||
+				latitude < area.latitude.min || latitude > area.latitude.max {
+				t.Fatalf("%f,%f at step %d decoded to %+v", longitude, latitude, step, area)
+			}
+		}
+		// a score decodes to the position within half a cell
+		decodedLong, decodedLat := geohashPosition(geohashScore(longitude, latitude))
+		if d := geoDistance(longitude, latitude, decodedLong, decodedLat); d > 1 {
+			t.Fatalf("%f,%f decoded %fm away", longitude, latitude, d)
+		}
+	}
+	for _, position := range [][2]float64{{180.1, 0}, {-180.1, 0}, {0, 85.06}, {0, -85.06}} {
+		if _, ok := geohashEncode(geoLongRange, geoLatRange, position[0], position[1], geoStepMax); ok {
+			t.Errorf("%v was encoded, it's out of range", position)
+		}
+	}
+	// the scores redis gives to the positions of its documentation
+	if score := geohashScore(13.361389, 38.115556); score != 3479099956230698 {
+		t.Errorf("score of Palermo = %.0f", score)
+	}
+	if score := geohashScore(15.087269, 37.502669); score != 3479447370796909 {
+		t.Errorf("score of Catania = %.0f", score)
+	}
+}
+
+func TestGeohashNeighbors(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		longitude := geoLongMin + rand.Float64()*(geoLongMax-geoLongMin)
+		latitude := geoLatMin + rand.Float64()*(geoLatMax-geoLatMin)
+		step := uint(4 + rand.Intn(geoStepMax-4))
+		hash, _ := geohashEncode(geoLongRange, geoLatRange, longitude, latitude, step)
+		area := geohashDecode(geoLongRange, geoLatRange, hash)
+		neighbors := neighborsOf(hash)
+		adjacent := []struct {
+			name        string
+			neighbor    geohashBits
+			dLong, dLat int
+		}{
+			{"north", neighbors.north, 0, 1},
+			{"south", neighbors.south, 0, -1},
+			{"east", neighbors.east, 1, 0},
+			{"west", neighbors.west, -1, 0},
+			{"north east", neighbors.northEast, 1, 1},
+			{"north west", neighbors.northWest, -1, 1},
+			{"south east", neighbors.southEast, 1, -1},
+			{"south west", neighbors.southWest, -1, -1},
+		}
+		width := area.longitude.max - area.longitude.min
+		height := area.latitude.max - area.latitude.min
+		for _, a := range adjacent {
+			got := geohashDecode(geoLongRange, geoLatRange, a.neighbor)
+			wantLong := area.longitude.min + float64(a.dLong)*width
+			wantLat := area.latitude.min + float64(a.dLat)*height
+			// neighbors beyond the edges wrap around
+			if wantLong < geoLongMin-1e-9 || wantLong+width > geoLongMax+1e-9 ||
+				wantLat < geoLatMin-1e-9 || wantLat+height > geoLatMax+1e-9 {
+				continue
+			}
+			if a.neighbor.step != step || !approxEqual(got.longitude.min, wantLong) || !approxEqual(got.latitude.min, wantLat) {
+				t.Fatalf("%s neighbor of %+v is %+v", a.name, area, got)
+			}
+		}
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+// TestGeoSearch compares searches with checking every member
+func TestGeoSearch(t *testing.T) {
+	s := newStore()
+	args := []string{"GEOADD", "points"}
+	for i := 0; i < 2000; i++ {
+		// points clustered around Europe, and some anywhere
+		longitude := -10 + rand.Float64()*40
+		latitude := 35 + rand.Float64()*30
+		if i%10 == 0 {
+			longitude = geoLongMin + rand.Float64()*(geoLongMax-geoLongMin)
+			latitude = geoLatMin + rand.Float64()*(geoLatMax-geoLatMin)
+		}
+		args = append(args, strconv.FormatFloat(longitude, 'f', -1, 64),
+			strconv.FormatFloat(latitude, 'f', -1, 64), "p"+strconv.Itoa(i))
+	}
+	run(t, s, args...)
+	z, _, _ := s.getZset("points")
+	for i := 0; i < 200; i++ {
+		shape := geoShape{
+			longitude:  -10 + rand.Float64()*40,
+			latitude:   35 + rand.Float64()*30,
+			circular:   i%2 == 0,
+			radius:     rand.Float64() * 2000,
+			width:      rand.Float64() * 3000,
+			height:     rand.Float64() * 3000,
+			conversion: 1000,
+		}
+		// some searches anywhere, near the poles and the antimeridian
+		if i%4 == 3 {
+			shape.longitude = geoLongMin + rand.Float64()*(geoLongMax-geoLongMin)
+			shape.latitude = geoLatMin + rand.Float64()*(geoLatMax-geoLatMin)
+		}
+		var want []string
+		for member, score := range z.scores {
+			longitude, latitude := geohashPosition(score)
+			if _, ok := shape.contains(longitude, latitude); ok {
+				want = append(want, member)
+			}
+		}
+		var got []string
+		for _, point := range geoSearch(z, shape, 0) {
+			got = append(got, point.member)
+		}
+		slices.Sort(want)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Fatalf("search of %+v found %d members, want %d", shape, len(got), len(want))
+		}
+	}
+}
+
+func TestGeoCommands(t *testing.T) {
+	s := newStore()
+	run(t, s, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	// the replies redis documents for the same positions
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania"}, bulkReply("166274.1516")},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "km"}, bulkReply("166.2742")},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "mi"}, bulkReply("103.3182")},
+		{[]string{"GEODIST", "Sicily", "Palermo", "missing"}, "$-1\r\n"},
+		{[]string{"GEOHASH", "Sicily", "Palermo", "Catania", "missing"},
+			"*3\r\n" + bulkReply("sqc8b49rny0") + bulkReply("sqdtr74hyu0") + "$-1\r\n"},
+		{[]string{"GEOPOS", "Sicily", "Palermo"},
+			"*1\r\n*2\r\n" + bulkReply("13.36138933897018433") + bulkReply("38.11555639549629859")},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST"},
+			"*2\r\n*2\r\n" + bulkReply("Catania") + bulkReply("56.4413") + "*2\r\n" + bulkReply("Palermo") + bulkReply("190.4424")},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"}, "*1\r\n" + bulkReply("Catania")},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYBOX", "400", "400", "km", "DESC", "COUNT", "1"},
+			"*1\r\n" + bulkReply("Catania")},
+		{[]string{"GEOADD", "Sicily", "181", "0", "outside"}, "-invalid longitude,latitude pair 181.000000,0.000000\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "parsecs"}, "-" + geoUnitError + "\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q replied %q, want %q", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"math"
+)
+
+// Geo members are stored in sorted sets, scored by a 52 bit geohash
+// of their position: 26 bits of latitude interleaved with 26 bits of
+// longitude. Latitudes are limited to the range of the web mercator
+// projection, as redis does, so scores are compatible with redis
+const (
+	geoLongMin = -180
+	geoLongMax = 180
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoStepMax = 26
+	// earth's quadratic mean radius for WGS-84
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+)
+
+type geohashRange struct {
+	min, max float64
+}
+
+var (
+	geoLongRange = geohashRange{geoLongMin, geoLongMax}
+	geoLatRange  = geohashRange{geoLatMin, geoLatMax}
+)
+
+// `geohashBits` is a geohash of step bits per coordinate
+type geohashBits struct {
+	bits uint64
+	step uint
+}
+
+func (hash geohashBits) isZero() bool {
+	return hash.bits == 0 && hash.step == 0
+}
+
+// `align52Bits` returns the score of the geohash, left aligned
+// to 52 bits
+func (hash geohashBits) align52Bits() uint64 {
+	return hash.bits << (52 - hash.step*2)
+}
+
+// `geohashArea` is the area covered by a geohash
+type geohashArea struct {
+	longitude, latitude geohashRange
+}
+
+// `interleave64` interleaves the bits of x and y, x taking the even
+// bits and y the odd ones
+func interleave64(x32, y32 uint32) uint64 {
+	masks := [...]uint64{0x5555555555555555, 0x3333333333333333,
+		0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	shifts := [...]uint{1, 2, 4, 8, 16}
+	x, y := uint64(x32), uint64(y32)
+	for i := len(masks) - 1; i >= 0; i-- {
+		x = (x | (x << shifts[i])) & masks[i]
+		y = (y | (y << shifts[i])) & masks[i]
+	}
+	return x | (y << 1)
+}
+
+// `deinterleave64` reverses `interleave64`, returning the even bits
+// in the low 32 bits and the odd ones in the high 32 bits
+func deinterleave64(interleaved uint64) uint64 {
+	masks := [...]uint64{0x5555555555555555, 0x3333333333333333,
+		0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF,
+		0x00000000FFFFFFFF}
+	shifts := [...]uint{0, 1, 2, 4, 8, 16}
+	x, y := interleaved, interleaved>>1
+	for i := range masks {
+		x = (x | (x >> shifts[i])) & masks[i]
+		y = (y | (y >> shifts[i])) & masks[i]
+	}
+	return x | (y << 32)
+}
+
+// `geohashEncode` encodes a position into a geohash of step bits per
+// coordinate, it reports false if the position is out of range
+func geohashEncode(longRange, latRange geohashRange, longitude, latitude float64, step uint) (geohashBits, bool) {
+	if longitude > geoLongMax || longitude < geoLongMin ||
+		latitude > geoLatMax || latitude < geoLatMin {
+		return geohashBits{}, false
+	}
+	if latitude < latRange.min || latitude > latRange.max ||
+		longitude < longRange.min || longitude > longRange.max {
+		return geohashBits{}, false
+	}
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+	// convert to fixed point based on the step
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return geohashBits{
+		bits: interleave64(uint32(latOffset), uint32(longOffset)),
+		step: step,
+	}, true
+}
+
+// `geohashDecode` returns the area covered by a geohash
+func geohashDecode(longRange, latRange geohashRange, hash geohashBits) geohashArea {
+	separated := deinterleave64(hash.bits)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	latBits := uint32(separated)
+	longBits := uint32(separated >> 32)
+	cells := float64(uint64(1) << hash.step)
+	return geohashArea{
+		latitude: geohashRange{
+			min: latRange.min + (float64(latBits)/cells)*latScale,
+			max: latRange.min + (float64(latBits+1)/cells)*latScale,
+		},
+		longitude: geohashRange{
+			min: longRange.min + (float64(longBits)/cells)*longScale,
+			max: longRange.min + (float64(longBits+1)/cells)*longScale,
+		},
+	}
+}
+
+// `center` returns the longitude and latitude of the center of the area
+func (area geohashArea) center() (float64, float64) {
+	longitude := (area.longitude.min + area.longitude.max) / 2
+	latitude := (area.latitude.min + area.latitude.max) / 2
+	return min(max(longitude, geoLongMin), geoLongMax), min(max(latitude, geoLatMin), geoLatMax)
+}
+
+// `geohashScore` returns the sorted set score of a position
+func geohashScore(longitude, latitude float64) float64 {
+	hash, _ := geohashEncode(geoLongRange, geoLatRange, longitude, latitude, geoStepMax)
+	return float64(hash.align52Bits())
+}
+
+// `geohashPosition` returns the longitude and latitude a sorted
+// set score encodes
+func geohashPosition(score float64) (float64, float64) {
+	hash := geohashBits{
+		bits: uint64(score),
+		step: geoStepMax,
+	}
+	return geohashDecode(geoLongRange, geoLatRange, hash).center()
+}
+
+func degreesToRadians(degrees float64) float64 {
+	return degrees * (math.Pi / 180)
+}
+
+func radiansToDegrees(radians float64) float64 {
+	return radians / (math.Pi / 180)
+}
+
+// `geoLatDistance` returns the distance in meters between two latitudes
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadiusInMeters * math.Abs(degreesToRadians(lat2)-degreesToRadians(lat1))
+}
+
+// `geoDistance` returns the distance in meters between two positions
+// using the haversine formula
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r := degreesToRadians(lat1)
+	long1r := degreesToRadians(long1)
+	lat2r := degreesToRadians(lat2)
+	long2r := degreesToRadians(long2)
+	v := math.Sin((long2r - long1r) / 2)
+	// positions sharing a longitude only differ by latitude
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusInMeters * math.Asin(math.Sqrt(a))
+}
+
+// `geohashMove` moves a geohash by d cells, along the longitude
+// bits when moveLongitude is set and the latitude bits otherwise
+func geohashMove(hash geohashBits, d int, moveLongitude bool) geohashBits {
+	if d == 0 {
+		return hash
+	}
+	// longitude bits are the odd ones, latitude bits the even ones
+	var coordinateBits, otherBits uint64 = 0xaaaaaaaaaaaaaaaa, 0x5555555555555555
+	if !moveLongitude {
+		coordinateBits, otherBits = otherBits, coordinateBits
+	}
+	coordinate := hash.bits & coordinateBits
+	other := hash.bits & otherBits
+	// set the bits of the other coordinate, so that carries
+	// and borrows propagate through them
+	filler := otherBits >> (64 - hash.step*2)
+	if d > 0 {
+		coordinate += filler + 1
+	} else {
+		coordinate |= filler
+		coordinate -= filler + 1
+	}
+	coordinate &= coordinateBits >> (64 - hash.step*2)
+	hash.bits = coordinate | other
+	return hash
+}
+
+// `geohashNeighbors` holds the eight geohashes surrounding a geohash
+type geohashNeighbors struct {
+	north, south, east, west                   geohashBits
+	northEast, northWest, southEast, southWest geohashBits
+}
+
+func neighborsOf(hash geohashBits) geohashNeighbors {
+	move := func(dLong, dLat int) geohashBits {
+		return geohashMove(geohashMove(hash, dLong, true), dLat, false)
+	}
+	return geohashNeighbors{
+		east:      move(1, 0),
+		west:      move(-1, 0),
+		south:     move(0, -1),
+		north:     move(0, 1),
+		northWest: move(-1, 1),
+		southWest: move(-1, -1),
+		northEast: move(1, 1),
+		southEast: move(1, -1),
+	}
+}
+
+// `geohashStepsForRadius` estimates the number of bits per coordinate
+// of the geohashes covering a search of radius meters
+func geohashStepsForRadius(radius, latitude float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// make sure the range is included in most of the base cases
+	step -= 2
+	// cells are narrower towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"fmt"
+	"math"
+	"math/big"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,25 +22,32 @@ type redisValue struct {
 	value     any
 }
 
-// store is a concurrent safe map
+// store is a concurrent safe map. The lock must be held
+// for the duration of a command, see `execute`
 type store struct {
 	lock sync.Mutex
 	db   map[string]redisValue
+	// clients blocked on each key, in the order they blocked
+	blocked map[string][]*blockedClient
+	// keys that received data while clients were blocked on them
+	readyKeys []string
+	// keys holding hashes with fields that have a TTL
+	volatileHashes map[string]struct{}
 }
 
 // `newStore` returns an instance of `store`
 func newStore() *store {
 	s := store{
-		db: make(map[string]redisValue),
+		db:             make(map[string]redisValue),
+		blocked:        make(map[string][]*blockedClient),
+		volatileHashes: make(map[string]struct{}),
 	}
 	return &s
 }
 
-// `get` is used to retrieve the value of a key
-// in a concurrency safe manner
+// `get` is used to retrieve the value of a key.
+// The caller must hold the store lock
 func (s *store) get(key string) (*redisValue, bool) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
 	value, ok := s.db[key]
 	if !ok {
 		return nil, ok
@@ -46,18 +57,45 @@ func (s *store) get(key string) (*redisValue, bool) {
 		if expired == -1 {
 			// delete the key - This is a passive delete strategy
 			delete(s.db, key)
+			s.notifyKeyspaceEvent(notifyExpired, "expired", key)
 			return nil, false
 		}
 	}
 	return &value, ok
 }
 
-// `set` is used to set the value of a key in a
-// concurrency safe manner
+// `set` is used to set the value of a key.
+// The caller must hold the store lock
 func (s *store) set(key string, value *redisValue) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	_, exists := s.db[key]
 	s.db[key] = *value
+	if !exists {
+		s.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	if h, ok := value.value.(*hash); ok && len(h.expires) > 0 {
+		s.trackFieldExpiry(key)
+	}
+	s.signalKeyAsReady(key)
+}
+
+// `errorReply` serialises an error reply sent back to the client
+func errorReply(message string) ([]byte, error) {
+	response := resp.SimpleError{
+		Data: message,
+	}
+	return response.Serialise()
+}
+
+// `wrongType` serialises the error reply for a command run
+// against a key holding a value of another type
+func wrongType() ([]byte, error) {
+	return errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+// `wrongNumberOfArgs` serialises the error reply for a command
+// called with the wrong number of arguments
+func wrongNumberOfArgs(command string) ([]byte, error) {
+	return errorReply("wrong number of arguments for '" + command + "' command")
 }
 
 // PING command returns PONG
@@ -112,121 +150,655 @@ func get(args [][]byte, s *store) ([]byte, error) {
 	return response.Serialise()
 }
 
+// `parseExpiry` converts the argument of an EX, PX, EXAT or PXAT
+// option into an expiration timestamp. It returns false if the
+// time isn't a positive integer or overflows
+func parseExpiry(option string, arg []byte) (time.Time, bool) {
+	parsedTime, ok := parseInteger(arg)
+	if !ok || parsedTime <= 0 {
+		return time.Time{}, false
+	}
+	var milliseconds int64
+	switch option {
+	case "EX", "EXAT":
+		if parsedTime > math.MaxInt64/1000 {
+			return time.Time{}, false
+		}
+		milliseconds = parsedTime * 1000
+	case "PX", "PXAT":
+		milliseconds = parsedTime
+	}
+	// relative times are converted into unix timestamps
+	if option == "EX" || option == "PX" {
+		now := time.Now().UnixMilli()
+		if milliseconds > math.MaxInt64-now {
+			return time.Time{}, false
+		}
+		milliseconds += now
+	}
+	return time.UnixMilli(milliseconds), true
+}
+
 // SET command is used to set the value of a key
 func set(args [][]byte, s *store) ([]byte, error) {
-	var nx, xx, ex, px, exat, pxat, get bool
+	if len(args) < 2 {
+		return wrongNumberOfArgs("set")
+	}
+	var nx, xx, get, keepTTL bool
+	var expiration time.Time
+	// comparison is one of IFEQ, IFNE, IFDEQ and IFDNE
+	var comparison string
+	var comparisonValue []byte
 	setCounter := 0
 	expiryOptionCounter := 0
-	timeArgs := 0
 	key := string(args[0])
 	value := args[1]
-	currentValue, keyExists := s.get(key)
 	for i := 2; i < len(args); i++ {
-		switch string(args[i]) {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
 		case "NX":
 			setCounter++
 			nx = true
 		case "XX":
 			setCounter++
 			xx = true
-		case "EX":
-			expiryOptionCounter++
-			timeArgs = i + 1
-			ex = true
-		case "PX":
-			expiryOptionCounter++
-			timeArgs = i + 1
-			px = true
-		case "EXAT":
+		case "IFEQ", "IFNE", "IFDEQ", "IFDNE":
+			setCounter++
+			if i+1 >= len(args) {
+				return errorReply("invalid syntax")
+			}
+			comparison = option
+			comparisonValue = args[i+1]
+			i++
+		case "GET":
+			get = true
+		case "KEEPTTL":
 			expiryOptionCounter++
-			timeArgs = i + 1
-			exat = true
-		case "PXAT":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
 			expiryOptionCounter++
-			timeArgs = i + 1
-			pxat = true
+			if i+1 >= len(args) {
+				return errorReply("invalid syntax")
+			}
+			var ok bool
+			expiration, ok = parseExpiry(option, args[i+1])
+			if !ok {
+				return errorReply("invalid expire time in 'set' command")
+			}
+			i++
+		default:
+			return errorReply("invalid syntax")
 		}
 	}
-	// error cases
-	if (setCounter > 1) || (expiryOptionCounter > 1) || (timeArgs >= len(args)) {
-		response := resp.SimpleError{
-			Data: "invalid syntax",
+	// only one of NX, XX and the comparisons, and only one of
+	// EX, PX, EXAT, PXAT and KEEPTTL may be passed
+	if setCounter > 1 || expiryOptionCounter > 1 {
+		return errorReply("invalid syntax")
+	}
+	currentValue, keyExists := s.get(key)
+	if comparison != "" && keyExists && currentValue.valueType != "string" {
+		return errorReply("value is not of string type")
+	}
+	// GET replies with the old value, which must be a string
+	var response resp.RESPDatatype = &resp.SimpleString{
+		Data: "OK",
+	}
+	if get {
+		if keyExists && currentValue.valueType != "string" {
+			return errorReply("value is not of string type")
 		}
-		serialised, err := response.Serialise()
-		if err != nil {
-			return nil, err
+		response = &resp.BulkString{
+			Size: -1,
+		}
+		if keyExists {
+			old := currentValue.value.([]byte)
+			response = &resp.BulkString{
+				Data: old,
+				Size: len(old),
+			}
 		}
-		return serialised, nil
 	}
 	// cases where key shouldn't be set
-	if (nx && keyExists) || (xx && !keyExists) {
-		var response resp.RESPDatatype
-		// if the key doesn't exist, nil must be returned in either
-		// of the above cases
-		if get && keyExists {
-			value, _ := s.get(key)
-			response = &resp.SimpleString{
-				Data: string(value.value.([]byte)),
-			}
-		} else {
+	if (nx && keyExists) || (xx && !keyExists) ||
+		(comparison != "" && !compareString(comparison, currentValue, comparisonValue)) {
+		if !get {
 			response = &resp.BulkString{
 				Size: -1,
 			}
 		}
-		serialised, err := response.Serialise()
-		if err != nil {
-			return nil, err
+		return response.Serialise()
+	}
+	if currentValue == nil {
+		currentValue = &redisValue{}
+	}
+	if !keepTTL {
+		currentValue.expire = expiration
+	}
+	currentValue.value = value
+	currentValue.valueType = "string"
+	s.set(key, currentValue)
+	s.notifyKeyspaceEvent(notifyString, "set", key)
+	if !expiration.IsZero() {
+		s.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	}
+	return response.Serialise()
+}
+
+// `digest` returns the hex encoded XXH3 64 bit hash of data,
+// matching the digests of redis
+func digest(data []byte) string {
+	return fmt.Sprintf("%016x", xxh3Hash64(data))
+}
+
+// `compareString` evaluates an IFEQ, IFNE, IFDEQ or IFDNE condition
+// against the string value of a key, which is nil if the key doesn't
+// exist. IFEQ and IFDEQ fail for keys that don't exist, while IFNE
+// and IFDNE succeed
+func compareString(comparison string, current *redisValue, comparisonValue []byte) bool {
+	if current == nil {
+		return comparison == "IFNE" || comparison == "IFDNE"
+	}
+	str := current.value.([]byte)
+	switch comparison {
+	case "IFEQ":
+		return bytes.Equal(str, comparisonValue)
+	case "IFNE":
+		return !bytes.Equal(str, comparisonValue)
+	case "IFDEQ":
+		return strings.EqualFold(digest(str), string(comparisonValue))
+	case "IFDNE":
+		return !strings.EqualFold(digest(str), string(comparisonValue))
+	}
+	return false
+}
+
+// DIGEST command returns the hex encoded hash of the string stored
+// at key, to be used with the IFDEQ and IFDNE conditions
+func digestCommand(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("digest")
+	}
+	str, exists, isString := s.getString(string(args[0]))
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	if !exists {
+		response := resp.BulkString{
+			Size: -1,
 		}
-		return serialised, err
+		return response.Serialise()
+	}
+	hash := digest(str)
+	response := resp.BulkString{
+		Data: []byte(hash),
+		Size: len(hash),
+	}
+	return response.Serialise()
+}
+
+// GETSET command sets the value of a key and returns its old value
+func getset(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("getset")
 	}
+	return set([][]byte{args[0], args[1], []byte("GET")}, s)
+}
 
+// GETDEL command returns the value of a key and deletes it
+func getdel(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("getdel")
+	}
+	key := string(args[0])
+	str, exists, isString := s.getString(key)
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	if !exists {
+		response := resp.BulkString{
+			Size: -1,
+		}
+		return response.Serialise()
+	}
+	delete(s.db, key)
+	s.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	response := resp.BulkString{
+		Data: str,
+		Size: len(str),
+	}
+	return response.Serialise()
+}
+
+// GETEX command returns the value of a key and optionally
+// sets or removes its expiry time
+func getex(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("getex")
+	}
+	key := string(args[0])
+	var persist bool
 	var expiration time.Time
-	var parsedTime int
-	var err error
-	if expiryOptionCounter > 0 {
-		parsedTime, err = strconv.Atoi(string(args[timeArgs]))
-		if err != nil {
-			return nil, err
+	expiryOptionCounter := 0
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "PERSIST":
+			expiryOptionCounter++
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			expiryOptionCounter++
+			if i+1 >= len(args) {
+				return errorReply("invalid syntax")
+			}
+			var ok bool
+			expiration, ok = parseExpiry(option, args[i+1])
+			if !ok {
+				return errorReply("invalid expire time in 'getex' command")
+			}
+			i++
+		default:
+			return errorReply("invalid syntax")
 		}
-		currentTime := time.Now()
-		if ex {
-			expiration = currentTime.Add(time.Second * time.Duration(parsedTime))
-		} else if px {
-			expiration = currentTime.Add(time.Millisecond * time.Duration(parsedTime))
-		} else if exat {
-			expiration = time.Unix(int64(parsedTime), 0)
-		} else if pxat {
-			expiration = time.UnixMilli(int64(parsedTime))
+	}
+	if expiryOptionCounter > 1 {
+		return errorReply("invalid syntax")
+	}
+	value, ok := s.get(key)
+	if !ok {
+		response := resp.BulkString{
+			Size: -1,
 		}
+		return response.Serialise()
 	}
-	if currentValue == nil {
-		currentValue = &redisValue{}
+	if value.valueType != "string" {
+		return errorReply("value is not of string type")
 	}
-	currentValue.value = value
-	currentValue.expire = expiration
-	currentValue.valueType = "string"
-	s.set(key, currentValue)
-	var response resp.RESPDatatype
-	if get && keyExists {
-		if keyExists {
-			response = &resp.SimpleString{
-				Data: string(currentValue.value.([]byte)),
+	str := value.value.([]byte)
+	response := resp.BulkString{
+		Data: str,
+		Size: len(str),
+	}
+	if !expiration.IsZero() {
+		// an expiry time in the past deletes the key right away
+		if expiration.Before(time.Now()) {
+			delete(s.db, key)
+			s.notifyKeyspaceEvent(notifyGeneric, "del", key)
+			return response.Serialise()
+		}
+		value.expire = expiration
+		s.set(key, value)
+		s.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	} else if persist && !value.expire.IsZero() {
+		value.expire = time.Time{}
+		s.set(key, value)
+		s.notifyKeyspaceEvent(notifyGeneric, "persist", key)
+	}
+	return response.Serialise()
+}
+
+// MGET command returns the values of all the keys passed.
+// "nil" is returned for keys that don't exist or don't hold a string
+func mget(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("mget")
+	}
+	response := resp.Array{
+		Size: len(args),
+	}
+	for _, key := range args {
+		str, exists, isString := s.getString(string(key))
+		if !exists || !isString {
+			response.Elements = append(response.Elements, &resp.BulkString{Size: -1})
+			continue
+		}
+		response.Elements = append(response.Elements, &resp.BulkString{Data: str, Size: len(str)})
+	}
+	return response.Serialise()
+}
+
+// `msetGeneric` sets all the key value pairs passed. The store
+// lock is held by the caller, so no reader observes a partial write
+func msetGeneric(args [][]byte, s *store) {
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		s.set(key, &redisValue{
+			value:     args[i+1],
+			valueType: "string",
+		})
+		s.notifyKeyspaceEvent(notifyString, "set", key)
+	}
+}
+
+// MSET command sets multiple keys to multiple values
+func mset(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return wrongNumberOfArgs("mset")
+	}
+	msetGeneric(args, s)
+	response := resp.SimpleString{
+		Data: "OK",
+	}
+	return response.Serialise()
+}
+
+// MSETNX command sets multiple keys to multiple values, only
+// if none of the keys exist
+func msetnx(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return wrongNumberOfArgs("msetnx")
+	}
+	response := resp.Integer{}
+	for i := 0; i < len(args); i += 2 {
+		if _, ok := s.get(string(args[i])); ok {
+			return response.Serialise()
+		}
+	}
+	msetGeneric(args, s)
+	response.Data = 1
+	return response.Serialise()
+}
+
+// maxStringSize is the largest size a string value can grow to
+const maxStringSize = 512 * 1024 * 1024
+
+// `getString` retrieves the string stored at key. It also reports
+// whether the key exists and whether it holds a string value
+func (s *store) getString(key string) ([]byte, bool, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, false, true
+	}
+	if value.valueType != "string" {
+		return nil, true, false
+	}
+	return value.value.([]byte), true, true
+}
+
+// APPEND command appends value to the string stored at key
+func appendCommand(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("append")
+	}
+	key := string(args[0])
+	value, ok := s.get(key)
+	if !ok {
+		value = &redisValue{
+			value:     []byte{},
+			valueType: "string",
+		}
+	} else if value.valueType != "string" {
+		return errorReply("value is not of string type")
+	}
+	current := value.value.([]byte)
+	if len(current)+len(args[1]) > maxStringSize {
+		return errorReply("string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	value.value = append(current, args[1]...)
+	s.set(key, value)
+	s.notifyKeyspaceEvent(notifyString, "append", key)
+	response := resp.Integer{
+		Data: int64(len(value.value.([]byte))),
+	}
+	return response.Serialise()
+}
+
+// STRLEN command returns the length of the string stored at key
+func strlen(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("strlen")
+	}
+	str, _, isString := s.getString(string(args[0]))
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	response := resp.Integer{
+		Data: int64(len(str)),
+	}
+	return response.Serialise()
+}
+
+// GETRANGE command returns the substring of the string stored
+// at key between the offsets start and end (both inclusive).
+// Negative offsets are counted from the end of the string
+func getrange(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("getrange")
+	}
+	start, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	end, ok := parseInteger(args[2])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	str, _, isString := s.getString(string(args[0]))
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	response := resp.BulkString{
+		Size: 0,
+	}
+	length := int64(len(str))
+	if start < 0 && end < 0 && start > end {
+		return response.Serialise()
+	}
+	if start < 0 {
+		start = length + start
+	}
+	if end < 0 {
+		end = length + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if length == 0 || start > end {
+		return response.Serialise()
+	}
+	response.Data = str[start : end+1]
+	response.Size = len(response.Data)
+	return response.Serialise()
+}
+
+// SETRANGE command overwrites part of the string stored at key,
+// starting at offset. The string is padded with zero bytes if
+// offset is past its end
+func setrange(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("setrange")
+	}
+	key := string(args[0])
+	offset, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return errorReply("offset is out of range")
+	}
+	patch := args[2]
+	str, exists, isString := s.getString(key)
+	if !isString {
+		return errorReply("value is not of string type")
+	}
+	response := resp.Integer{
+		Data: int64(len(str)),
+	}
+	// nothing to write, the key isn't created or modified
+	if len(patch) == 0 {
+		return response.Serialise()
+	}
+	// compared without adding the two, which could overflow
+	if offset > maxStringSize-int64(len(patch)) {
+		return errorReply("string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	if newLength := int(offset) + len(patch); newLength > len(str) {
+		grown := make([]byte, newLength)
+		copy(grown, str)
+		str = grown
+	}
+	copy(str[offset:], patch)
+	value := &redisValue{
+		value:     str,
+		valueType: "string",
+	}
+	if exists {
+		value, _ = s.get(key)
+		value.value = str
+	}
+	s.set(key, value)
+	s.notifyKeyspaceEvent(notifyString, "setrange", key)
+	response.Data = int64(len(str))
+	return response.Serialise()
+}
+
+// LCS command finds the longest common subsequence of the strings
+// stored at key1 and key2. LEN responds back with its length, IDX
+// with the matching ranges of both strings
+func lcs(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("lcs")
+	}
+	var getLength, getIndexes, withMatchLength bool
+	var minMatchLength int64
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "LEN":
+			getLength = true
+		case "IDX":
+			getIndexes = true
+		case "WITHMATCHLEN":
+			withMatchLength = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return errorReply("invalid syntax")
+			}
+			var ok bool
+			minMatchLength, ok = parseInteger(args[i+1])
+			if !ok {
+				return errorReply("value is not an integer or out of range")
 			}
+			if minMatchLength < 0 {
+				minMatchLength = 0
+			}
+			i++
+		default:
+			return errorReply("invalid syntax")
+		}
+	}
+	if getLength && getIndexes {
+		return errorReply("If you want both the length and indexes, please just use IDX.")
+	}
+	// keys that don't exist are empty strings
+	a, _, aIsString := s.getString(string(args[0]))
+	b, _, bIsString := s.getString(string(args[1]))
+	if !aIsString || !bIsString {
+		return errorReply("The specified keys must contain string values")
+	}
+	if uint64(len(a)+1)*uint64(len(b)+1) >= math.MaxUint32/4 {
+		return errorReply("insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+	// table[i][j] holds the length of the LCS of a[:i] and b[:j]
+	columns := len(b) + 1
+	table := make([]uint32, (len(a)+1)*columns)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				table[i*columns+j] = table[(i-1)*columns+j-1] + 1
+			} else {
+				table[i*columns+j] = max(table[(i-1)*columns+j], table[i*columns+j-1])
+			}
+		}
+	}
+	length := table[len(a)*columns+len(b)]
+	if getLength {
+		response := resp.Integer{
+			Data: int64(length),
+		}
+		return response.Serialise()
+	}
+	// walk the table backwards, collecting the subsequence and
+	// the ranges of contiguous matches
+	result := make([]byte, length)
+	var matches []resp.RESPDatatype
+	index := length
+	i, j := len(a), len(b)
+	// aStart == len(a) signals that no range is being tracked
+	aStart, aEnd, bStart, bEnd := len(a), 0, 0, 0
+	for i > 0 && j > 0 {
+		emitRange := false
+		if a[i-1] == b[j-1] {
+			result[index-1] = a[i-1]
+			if aStart == len(a) {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				// extend the range backwards since it is contiguous
+				aStart--
+				bStart--
+			} else {
+				emitRange = true
+			}
+			// the range can't be extended past the start of a string
+			if aStart == 0 || bStart == 0 {
+				emitRange = true
+			}
+			index--
+			i--
+			j--
 		} else {
-			response = &resp.BulkString{
-				Size: -1,
+			if table[(i-1)*columns+j] > table[i*columns+j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != len(a) {
+				emitRange = true
 			}
 		}
-	} else {
-		response = &resp.SimpleString{
-			Data: "OK",
+		if emitRange {
+			matchLength := aEnd - aStart + 1
+			if getIndexes && int64(matchLength) >= minMatchLength {
+				match := resp.Array{
+					Elements: []resp.RESPDatatype{
+						&resp.Array{Size: 2, Elements: []resp.RESPDatatype{
+							&resp.Integer{Data: int64(aStart)}, &resp.Integer{Data: int64(aEnd)},
+						}},
+						&resp.Array{Size: 2, Elements: []resp.RESPDatatype{
+							&resp.Integer{Data: int64(bStart)}, &resp.Integer{Data: int64(bEnd)},
+						}},
+					},
+				}
+				if withMatchLength {
+					match.Elements = append(match.Elements, &resp.Integer{Data: int64(matchLength)})
+				}
+				match.Size = len(match.Elements)
+				matches = append(matches, &match)
+			}
+			aStart = len(a)
 		}
 	}
-	serialised, err := response.Serialise()
-	if err != nil {
-		return nil, err
+	if getIndexes {
+		response := resp.Array{
+			Size: 4,
+			Elements: []resp.RESPDatatype{
+				&resp.BulkString{Data: []byte("matches"), Size: len("matches")},
+				&resp.Array{Size: len(matches), Elements: matches},
+				&resp.BulkString{Data: []byte("len"), Size: len("len")},
+				&resp.Integer{Data: int64(length)},
+			},
+		}
+		return response.Serialise()
 	}
-	return serialised, nil
+	response := resp.BulkString{
+		Data: result,
+		Size: len(result),
+	}
+	return response.Serialise()
 }
 
 // EXISTS command checks if a key(s) exists
@@ -255,92 +827,256 @@ func del(args [][]byte, s *store) ([]byte, error) {
 		_, ok := s.get(string(args[i]))
 		if ok {
 			deleteCounter++
-			s.lock.Lock()
 			delete(s.db, string(args[i]))
-			s.lock.Unlock()
+			s.notifyKeyspaceEvent(notifyGeneric, "del", string(args[i]))
+		}
+	}
+	resp := resp.Integer{
+		Data: int64(deleteCounter),
+	}
+	serialised, err := resp.Serialise()
+	if err != nil {
+		return nil, err
+	}
+	return serialised, nil
+}
+
+// DELEX command deletes a key. With a IFEQ, IFNE, IFDEQ or IFDNE
+// condition, the key must hold a string and is only deleted if the
+// condition holds
+func delex(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 && len(args) != 3 {
+		return wrongNumberOfArgs("delex")
+	}
+	if len(args) == 1 {
+		return del(args, s)
+	}
+	comparison := strings.ToUpper(string(args[1]))
+	switch comparison {
+	case "IFEQ", "IFNE", "IFDEQ", "IFDNE":
+	default:
+		return errorReply("invalid syntax")
+	}
+	value, ok := s.get(string(args[0]))
+	if ok && value.valueType != "string" {
+		return errorReply("value is not of string type")
+	}
+	if !ok || !compareString(comparison, value, args[2]) {
+		response := resp.Integer{}
+		return response.Serialise()
+	}
+	return del(args[:1], s)
+}
+
+// RENAME command renames a key, overwriting the
+// destination key if it already exists
+func rename(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("rename")
+	}
+	source, destination := string(args[0]), string(args[1])
+	value, ok := s.get(source)
+	if !ok {
+		return errorReply("no such key")
+	}
+	delete(s.db, source)
+	s.set(destination, value)
+	s.notifyKeyspaceEvent(notifyGeneric, "rename_from", source)
+	s.notifyKeyspaceEvent(notifyGeneric, "rename_to", destination)
+	response := resp.SimpleString{
+		Data: "OK",
+	}
+	return response.Serialise()
+}
+
+// `parseInteger` parses data as a 64 bit integer following
+// redis' strict rules: no surrounding spaces, no leading '+'
+// and no leading zeros
+func parseInteger(data []byte) (int64, bool) {
+	if len(data) == 0 || len(data) > 20 {
+		return 0, false
+	}
+	digits := data
+	if digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || (digits[0] == '0' && len(data) > 1) {
+		return 0, false
+	}
+	for _, digit := range digits {
+		if digit < '0' || digit > '9' {
+			return 0, false
+		}
+	}
+	integer, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return integer, true
+}
+
+// `parseFloat` parses data as a float, rejecting NaN.
+// Values with surrounding spaces are rejected by ParseFloat
+func parseFloat(data []byte) (float64, bool) {
+	float, err := strconv.ParseFloat(string(data), 64)
+	if err != nil || math.IsNaN(float) {
+		return 0, false
+	}
+	return float, true
+}
+
+// `addFloats` adds the floats in a and b, both validated by
+// parseFloat, the way redis does for INCRBYFLOAT: in a long double,
+// whose 64 bit mantissa makes 0.1 + 0.2 add up to 0.3 rather than
+// the 0.30000000000000004 of a float64, formatted with 17 decimals
+// less the trailing zeros. It reports false when the sum would be
+// NaN or Infinity
+func addFloats(a, b []byte) (string, bool) {
+	sum := new(big.Float).SetPrec(64)
+	for _, data := range [][]byte{a, b} {
+		float, _ := parseFloat(data)
+		if math.IsInf(float, 0) {
+			return "", false
+		}
+		term, _, err := big.ParseFloat(string(data), 0, 64, big.ToNearestEven)
+		if err != nil {
+			return "", false
 		}
+		sum.Add(sum, term)
 	}
-	resp := resp.Integer{
-		Data: int64(deleteCounter),
+	// the sum has to parse back as a float64
+	if float, _ := sum.Float64(); math.IsInf(float, 0) {
+		return "", false
 	}
-	serialised, err := resp.Serialise()
-	if err != nil {
-		return nil, err
+	text := strings.TrimRight(sum.Text('f', 17), "0")
+	text = strings.TrimSuffix(text, ".")
+	if text == "-0" {
+		text = "0"
 	}
-	return serialised, nil
+	return text, true
 }
 
-// INCR command increments the number stored at key by one
-func incr(args [][]byte, s *store) ([]byte, error) {
-	key := string(args[0])
-	response := resp.Integer{}
+// `incrementBy` adds delta to the integer stored at key,
+// creating the key if it doesn't exist
+func incrementBy(key string, delta int64, s *store) ([]byte, error) {
 	value, ok := s.get(key)
+	var integer int64
 	if !ok {
 		value = &redisValue{
-			value:     []byte("1"),
 			valueType: "string",
 		}
-		s.set(key, value)
-		response.Data = 1
 	} else {
 		if value.valueType != "string" {
-			response := resp.SimpleError{
-				Data: "value is not of numeric type",
-			}
-			return response.Serialise()
+			return errorReply("value is not of numeric type")
 		}
-		integer, err := strconv.Atoi(string(value.value.([]byte)))
-		if err != nil {
-			return nil, err
+		integer, ok = parseInteger(value.value.([]byte))
+		if !ok {
+			return errorReply("value is not an integer or out of range")
 		}
-		value.value = []byte(strconv.Itoa(integer + 1))
-		s.set(key, value)
-		response.Data = int64(integer + 1)
 	}
-	serialised, err := response.Serialise()
-	return serialised, err
+	if (delta < 0 && integer < 0 && delta < math.MinInt64-integer) ||
+		(delta > 0 && integer > 0 && delta > math.MaxInt64-integer) {
+		return errorReply("increment or decrement would overflow")
+	}
+	integer += delta
+	value.value = []byte(strconv.FormatInt(integer, 10))
+	s.set(key, value)
+	s.notifyKeyspaceEvent(notifyString, "incrby", key)
+	response := resp.Integer{
+		Data: integer,
+	}
+	return response.Serialise()
+}
+
+// INCR command increments the number stored at key by one
+func incr(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("incr")
+	}
+	return incrementBy(string(args[0]), 1, s)
 }
 
 // DECR command decrements the number stored at key by one
 func decr(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("decr")
+	}
+	return incrementBy(string(args[0]), -1, s)
+}
+
+// INCRBY command increments the number stored at key by increment
+func incrby(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("incrby")
+	}
+	increment, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	return incrementBy(string(args[0]), increment, s)
+}
+
+// DECRBY command decrements the number stored at key by decrement
+func decrby(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("decrby")
+	}
+	decrement, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	// negating the smallest integer overflows
+	if decrement == math.MinInt64 {
+		return errorReply("decrement would overflow")
+	}
+	return incrementBy(string(args[0]), -decrement, s)
+}
+
+// INCRBYFLOAT command increments the floating point number
+// stored at key by increment
+func incrbyfloat(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("incrbyfloat")
+	}
 	key := string(args[0])
-	response := resp.Integer{}
+	if _, ok := parseFloat(args[1]); !ok {
+		return errorReply("value is not a valid float")
+	}
 	value, ok := s.get(key)
+	current := []byte("0")
 	if !ok {
 		value = &redisValue{
-			value:     []byte("-1"),
 			valueType: "string",
 		}
-		s.set(key, value)
-		response.Data = -1
 	} else {
 		if value.valueType != "string" {
-			response := resp.SimpleError{
-				Data: "value is not of numeric type",
-			}
-			return response.Serialise()
+			return errorReply("value is not of numeric type")
 		}
-		integer, err := strconv.Atoi(string(value.value.([]byte)))
-		if err != nil {
-			return nil, err
+		current = value.value.([]byte)
+		if _, ok = parseFloat(current); !ok {
+			return errorReply("value is not a valid float")
 		}
-		value.value = []byte(strconv.Itoa(integer - 1))
-		s.set(key, value)
-		response.Data = int64(integer - 1)
 	}
-	serialised, err := response.Serialise()
-	return serialised, err
+	sum, ok := addFloats(current, args[1])
+	if !ok {
+		return errorReply("increment would produce NaN or Infinity")
+	}
+	value.value = []byte(sum)
+	s.set(key, value)
+	s.notifyKeyspaceEvent(notifyString, "incrbyfloat", key)
+	response := resp.BulkString{
+		Data: value.value.([]byte),
+		Size: len(value.value.([]byte)),
+	}
+	return response.Serialise()
 }
 
 // LPUSH command inserts value at the head of a list
 func lpush(args [][]byte, s *store) ([]byte, error) {
-	key := string(args[0])
-	nodes := make([]*node, 0)
-	for i := 1; i < len(args); i++ {
-		nodes = append(nodes, &node{
-			data: args[i],
-		})
+	if len(args) < 2 {
+		return wrongNumberOfArgs("lpush")
 	}
+	key := string(args[0])
 	value, ok := s.get(key)
 	var l *list
 	if !ok {
@@ -359,7 +1095,8 @@ func lpush(args [][]byte, s *store) ([]byte, error) {
 		}
 		l = value.value.(*list)
 	}
-	l.hpush(nodes)
+	l.hpush(args[1:])
+	s.notifyKeyspaceEvent(notifyList, "lpush", key)
 	response := resp.Integer{
 		Data: int64(l.length),
 	}
@@ -368,13 +1105,10 @@ func lpush(args [][]byte, s *store) ([]byte, error) {
 
 // RPUSH command inserts value at the tail of a list
 func rpush(args [][]byte, s *store) ([]byte, error) {
-	key := string(args[0])
-	nodes := make([]*node, 0)
-	for i := 1; i < len(args); i++ {
-		nodes = append(nodes, &node{
-			data: args[i],
-		})
+	if len(args) < 2 {
+		return wrongNumberOfArgs("rpush")
 	}
+	key := string(args[0])
 	value, ok := s.get(key)
 	var l *list
 	if !ok {
@@ -393,34 +1127,38 @@ func rpush(args [][]byte, s *store) ([]byte, error) {
 		}
 		l = value.value.(*list)
 	}
-	l.tpush(nodes)
+	l.tpush(args[1:])
+	s.notifyKeyspaceEvent(notifyList, "rpush", key)
 	response := resp.Integer{
 		Data: int64(l.length),
 	}
 	return response.Serialise()
 }
 
-// convert a list to a RESP array of bulk strings
+// convert a list to a RESP array of bulk strings. start and end
+// are zero based indices, both inclusive. The first element is
+// reached from whichever end of the list is closer
 func (l *list) toRESPArray(start, end int) ([]byte, error) {
 	var response resp.Array
-	listPointer := l.head
-	for i := 0; i < l.length && i < start; i++ {
-		listPointer = listPointer.next
-	}
-	for i := start; i < l.length && i <= end; i++ {
+	it := l.iterator(start, true)
+	for i := start; i <= end; i++ {
+		data, ok := it.next()
+		if !ok {
+			break
+		}
 		elem := resp.BulkString{
-			Data: listPointer.data,
-			Size: len(listPointer.data),
+			Data: data,
+			Size: len(data),
 		}
 		response.Elements = append(response.Elements, &elem)
-		listPointer = listPointer.next
 	}
 	response.Size = len(response.Elements)
 	return response.Serialise()
 }
 
 // LRANGE command returns specified elements of the list
-// stored at key
+// stored at key. Negative offsets are counted from the tail
+// of the list, -1 being the last element
 func lrange(args [][]byte, s *store) ([]byte, error) {
 	if len(args) < 3 {
 		return nil, resp.ErrInvalidClientData
@@ -438,26 +1176,529 @@ func lrange(args [][]byte, s *store) ([]byte, error) {
 		}
 		return response.Serialise()
 	}
-	start, err := strconv.Atoi(string(args[1]))
-	if err != nil {
-		return nil, resp.ErrIntegerConversion
+	start, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
 	}
-	end, err := strconv.Atoi(string(args[2]))
-	if err != nil {
-		return nil, resp.ErrInvalidClientData
+	end, ok := parseInteger(args[2])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	length := l.value.(*list).length
+	start = normaliseIndex(start, length)
+	end = normaliseIndex(end, length)
+	if start < 0 {
+		start = 0
+	}
+	// out of range offsets result in an empty list
+	if start > end || start >= int64(length) {
+		return response.Serialise()
+	}
+	if end >= int64(length) {
+		end = int64(length) - 1
+	}
+	return l.value.(*list).toRESPArray(int(start), int(end))
+}
+
+// `getList` retrieves the list stored at key. It also reports
+// whether the key exists and whether it holds a list
+func (s *store) getList(key string) (*list, bool, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, false, true
+	}
+	if value.valueType != "list" {
+		return nil, true, false
+	}
+	return value.value.(*list), true, true
+}
+
+// `deleteIfEmpty` deletes key once the list, hash or other
+// collection it holds runs out of elements
+func (s *store) deleteIfEmpty(key string, length int) {
+	if length == 0 {
+		delete(s.db, key)
+		s.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+}
+
+// `popGeneric` implements LPOP and RPOP
+func popGeneric(args [][]byte, s *store, head bool) ([]byte, error) {
+	command := "rpop"
+	if head {
+		command = "lpop"
+	}
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs(command)
+	}
+	key := string(args[0])
+	count := int64(-1)
+	if len(args) == 2 {
+		var ok bool
+		count, ok = parseInteger(args[1])
+		if !ok || count < 0 {
+			return errorReply("value is out of range, must be positive")
+		}
+	}
+	l, exists, isList := s.getList(key)
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	if !exists {
+		// a missing key is a nil bulk string, or a nil array with a count
+		if count == -1 {
+			response := resp.BulkString{
+				Size: -1,
+			}
+			return response.Serialise()
+		}
+		response := resp.Array{
+			Size: -1,
+		}
+		return response.Serialise()
+	}
+	if count == -1 {
+		return s.listPop(key, l, head, 1)[0].Serialise()
+	}
+	var response resp.Array
+	response.Elements = s.listPop(key, l, head, count)
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `listPop` pops up to count elements from the head or tail of
+// the list l stored at key, deleting the key once the list is empty
+func (s *store) listPop(key string, l *list, head bool, count int64) []resp.RESPDatatype {
+	event := "rpop"
+	if head {
+		event = "lpop"
+	}
+	var elements []resp.RESPDatatype
+	for int64(len(elements)) < count {
+		var data []byte
+		var ok bool
+		if head {
+			data, ok = l.hpop()
+		} else {
+			data, ok = l.tpop()
+		}
+		if !ok {
+			break
+		}
+		elements = append(elements, &resp.BulkString{
+			Data: data,
+			Size: len(data),
+		})
+	}
+	if len(elements) > 0 {
+		s.notifyKeyspaceEvent(notifyList, event, key)
+		s.deleteIfEmpty(key, l.length)
+	}
+	return elements
+}
+
+// `listMove` pops an element from the head or tail of the list at
+// source and pushes it onto the head or tail of the list at
+// destination, in a single step. It reports false when there is
+// no list at source to move an element from
+func listMove(source, destination string, fromHead, toHead bool, s *store) ([]byte, bool, error) {
+	l, exists, isList := s.getList(source)
+	if !isList {
+		response, err := errorReply("value not of list type")
+		return response, true, err
+	}
+	if !exists {
+		return nil, false, nil
+	}
+	dst, exists, isList := s.getList(destination)
+	if !isList {
+		response, err := errorReply("value not of list type")
+		return response, true, err
+	}
+	var data []byte
+	if fromHead {
+		data, _ = l.hpop()
+		s.notifyKeyspaceEvent(notifyList, "lpop", source)
+	} else {
+		data, _ = l.tpop()
+		s.notifyKeyspaceEvent(notifyList, "rpop", source)
+	}
+	if source == destination {
+		dst = l
+	} else if !exists {
+		dst = newList()
+		s.set(destination, &redisValue{
+			value:     dst,
+			valueType: "list",
+		})
+	}
+	if toHead {
+		dst.hpush([][]byte{data})
+		s.notifyKeyspaceEvent(notifyList, "lpush", destination)
+	} else {
+		dst.tpush([][]byte{data})
+		s.notifyKeyspaceEvent(notifyList, "rpush", destination)
+	}
+	s.deleteIfEmpty(source, l.length)
+	response := resp.BulkString{
+		Data: data,
+		Size: len(data),
+	}
+	serialisedData, err := response.Serialise()
+	return serialisedData, true, err
+}
+
+// LPOP command removes and returns the first elements of a list
+func lpop(args [][]byte, s *store) ([]byte, error) {
+	return popGeneric(args, s, true)
+}
+
+// RPOP command removes and returns the last elements of a list
+func rpop(args [][]byte, s *store) ([]byte, error) {
+	return popGeneric(args, s, false)
+}
+
+// LLEN command returns the length of a list
+func llen(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("llen")
+	}
+	l, exists, isList := s.getList(string(args[0]))
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	response := resp.Integer{}
+	if exists {
+		response.Data = int64(l.length)
+	}
+	return response.Serialise()
+}
+
+// `normaliseIndex` converts a negative index, counted from the
+// tail of the list, into a zero based index from the head
+func normaliseIndex(index int64, length int) int64 {
+	if index < 0 {
+		index += int64(length)
+	}
+	return index
+}
+
+// LINDEX command returns the element at index in a list.
+// Negative indices are counted from the tail, -1 being the last element
+func lindex(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("lindex")
+	}
+	index, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	l, exists, isList := s.getList(string(args[0]))
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	response := resp.BulkString{
+		Size: -1,
+	}
+	if !exists {
+		return response.Serialise()
+	}
+	index = normaliseIndex(index, l.length)
+	if index < 0 || index >= int64(l.length) {
+		return response.Serialise()
+	}
+	response.Data, _ = l.index(int(index))
+	response.Size = len(response.Data)
+	return response.Serialise()
+}
+
+// LSET command sets the element at index in a list
+func lset(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("lset")
+	}
+	key := string(args[0])
+	index, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	l, exists, isList := s.getList(key)
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	if !exists {
+		return errorReply("no such key")
+	}
+	index = normaliseIndex(index, l.length)
+	if index < 0 || index >= int64(l.length) {
+		return errorReply("index out of range")
+	}
+	l.set(int(index), args[2])
+	s.notifyKeyspaceEvent(notifyList, "lset", key)
+	response := resp.SimpleString{
+		Data: "OK",
+	}
+	return response.Serialise()
+}
+
+// LREM command removes the first count occurrences of element from
+// a list. A negative count removes occurrences starting from the
+// tail, and 0 removes all of them
+func lrem(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("lrem")
+	}
+	key := string(args[0])
+	count, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	l, exists, isList := s.getList(key)
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	fromTail := count < 0
+	if fromTail {
+		count = -count
+	}
+	removed := l.removeMatching(args[2], count, fromTail)
+	if removed > 0 {
+		s.notifyKeyspaceEvent(notifyList, "lrem", key)
+		s.deleteIfEmpty(key, l.length)
+	}
+	response.Data = removed
+	return response.Serialise()
+}
+
+// LTRIM command trims a list so that it only contains
+// the elements between start and stop, both inclusive
+func ltrim(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("ltrim")
+	}
+	key := string(args[0])
+	start, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	stop, ok := parseInteger(args[2])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	l, exists, isList := s.getList(key)
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	response := resp.SimpleString{
+		Data: "OK",
+	}
+	if !exists {
+		return response.Serialise()
+	}
+	length := int64(l.length)
+	start = normaliseIndex(start, l.length)
+	stop = normaliseIndex(stop, l.length)
+	if start < 0 {
+		start = 0
 	}
-	if start > l.value.(*list).length {
+	var left, right int64
+	if start > stop || start >= length {
+		// the range is empty, every element is removed
+		left, right = length, 0
+	} else {
+		if stop >= length {
+			stop = length - 1
+		}
+		left, right = start, length-stop-1
+	}
+	l.trim(int(left), int(right))
+	s.notifyKeyspaceEvent(notifyList, "ltrim", key)
+	s.deleteIfEmpty(key, l.length)
+	return response.Serialise()
+}
+
+// LINSERT command inserts element before or after the
+// first occurrence of pivot in a list
+func linsert(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 4 {
+		return wrongNumberOfArgs("linsert")
+	}
+	key := string(args[0])
+	var before bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+	default:
+		return errorReply("invalid syntax")
+	}
+	l, exists, isList := s.getList(key)
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	it := l.iterator(0, true)
+	index := 0
+	for data, ok := it.next(); ok; data, ok = it.next() {
+		if !bytes.Equal(data, args[2]) {
+			index++
+			continue
+		}
+		if !before {
+			index++
+		}
+		l.insert(index, args[3])
+		s.notifyKeyspaceEvent(notifyList, "linsert", key)
+		response.Data = int64(l.length)
 		return response.Serialise()
 	}
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	return l.value.(*list).toRESPArray(start, end)
+	// pivot wasn't found
+	response.Data = -1
+	return response.Serialise()
+}
+
+// LPOS command returns the index of matching elements in a list
+func lpos(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("lpos")
+	}
+	rank, count, maxLength := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errorReply("invalid syntax")
+		}
+		value, ok := parseInteger(args[i+1])
+		if !ok {
+			return errorReply("value is not an integer or out of range")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if value == 0 || value == math.MinInt64 {
+				return errorReply("RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = value
+		case "COUNT":
+			if value < 0 {
+				return errorReply("COUNT can't be negative")
+			}
+			count = value
+		case "MAXLEN":
+			if value < 0 {
+				return errorReply("MAXLEN can't be negative")
+			}
+			maxLength = value
+		default:
+			return errorReply("invalid syntax")
+		}
+	}
+	l, exists, isList := s.getList(string(args[0]))
+	if !isList {
+		return errorReply("value not of list type")
+	}
+	var matches []resp.RESPDatatype
+	if exists {
+		// a negative rank searches from the tail
+		fromTail := rank < 0
+		if fromTail {
+			rank = -rank
+		}
+		it, index, step := l.iterator(0, true), int64(0), int64(1)
+		if fromTail {
+			it, index, step = l.iterator(l.length-1, false), int64(l.length-1), -1
+		}
+		data, ok := it.next()
+		for compared := int64(0); ok && (maxLength == 0 || compared < maxLength); compared++ {
+			if bytes.Equal(data, args[1]) {
+				if rank > 1 {
+					rank--
+				} else {
+					matches = append(matches, &resp.Integer{Data: index})
+					if count != 0 && int64(len(matches)) >= max(count, 1) {
+						break
+					}
+				}
+			}
+			index += step
+			data, ok = it.next()
+		}
+	}
+	// without COUNT a single index, or nil, is returned
+	if count == -1 {
+		if len(matches) == 0 {
+			response := resp.BulkString{
+				Size: -1,
+			}
+			return response.Serialise()
+		}
+		return matches[0].Serialise()
+	}
+	response := resp.Array{
+		Size:     len(matches),
+		Elements: matches,
+	}
+	return response.Serialise()
+}
+
+// LMOVE command atomically moves an element from one end of the
+// list at source to one end of the list at destination
+func lmove(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 4 {
+		return wrongNumberOfArgs("lmove")
+	}
+	fromHead, fromOk := parseDirection(args[2])
+	toHead, toOk := parseDirection(args[3])
+	if !fromOk || !toOk {
+		return errorReply("invalid syntax")
+	}
+	data, ok, err := listMove(string(args[0]), string(args[1]), fromHead, toHead, s)
+	if !ok {
+		return nilBulkString()
+	}
+	return data, err
+}
+
+// RPOPLPUSH command moves the last element of the list at source
+// to the head of the list at destination, it's LMOVE RIGHT LEFT
+func rpoplpush(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("rpoplpush")
+	}
+	data, ok, err := listMove(string(args[0]), string(args[1]), false, true, s)
+	if !ok {
+		return nilBulkString()
+	}
+	return data, err
+}
+
+// LMPOP command pops elements from the first non empty list
+// among the given keys
+func lmpop(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("lmpop")
+	}
+	keys, head, count, message := parseMpop(args, parseDirection)
+	if message != "" {
+		return errorReply(message)
+	}
+	for _, key := range keys {
+		data, ok, err := serveMpop(key, head, count, s)
+		if ok {
+			return data, err
+		}
+	}
+	return nilArray()
 }
 
 // SAVE command is used to save the database to disk
 func save(args [][]byte, s *store) ([]byte, error) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
 	// serialise the database
 	db, err := os.Create("db.dump")
 	if err != nil {
@@ -488,21 +1729,29 @@ func save(args [][]byte, s *store) ([]byte, error) {
 			return nil, err
 		}
 		var serialisedValue []byte
-		// a list will be stored as an array of bulk string
-		if value.valueType == "list" {
+		// lists, hashes, sets, sorted sets, streams and JSON documents are stored as arrays of bulk strings
+		switch value.valueType {
+		case "list":
 			serialisedValue, err = value.value.(*list).toRESPArray(0, value.value.(*list).length)
-			if err != nil {
-				return nil, err
-			}
-		} else {
+		case "hash":
+			serialisedValue, err = value.value.(*hash).toRESPArray()
+		case "set":
+			serialisedValue, err = membersToRESPArray(value.value.(*redisSet).list())
+		case "zset":
+			serialisedValue, err = value.value.(*zset).toRESPArray()
+		case "stream":
+			serialisedValue, err = value.value.(*stream).toRESPArray()
+		case "json":
+			serialisedValue, err = value.value.(*jsonDocument).toRESPArray()
+		default:
 			dataBulk := resp.BulkString{
 				Data: value.value.([]byte),
 				Size: len(value.value.([]byte)),
 			}
 			serialisedValue, err = dataBulk.Serialise()
-			if err != nil {
-				return nil, err
-			}
+		}
+		if err != nil {
+			return nil, err
 		}
 		serialisedData := bytes.Join([][]byte{serialisedKey, serialisedExpire, serialisedValueType, serialisedValue}, []byte(""))
 		written, err := writer.Write(serialisedData)
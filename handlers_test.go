@@ -0,0 +1,368 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetrange(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SETRANGE", "key", "6", "Redis"}, integerReply(11)},
+		{[]string{"GET", "key"}, bulkReply("\x00\x00\x00\x00\x00\x00Redis")},
+		{[]string{"SETRANGE", "key", "0", "Hello "}, integerReply(11)},
+		{[]string{"GET", "key"}, bulkReply("Hello Redis")},
+		{[]string{"SETRANGE", "key", "100", ""}, integerReply(11)},
+		{[]string{"SETRANGE", "key", "-1", "x"}, "-offset is out of range\r\n"},
+		{[]string{"SETRANGE", "key", "536870912", "x"}, "-string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+		// offset + length overflows an int64
+		{[]string{"SETRANGE", "key", "9223372036854775807", "xy"}, "-string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+		{[]string{"GET", "key"}, bulkReply("Hello Redis")},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestStringCommands(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"APPEND", "key", "Hello"}, integerReply(5)},
+		{[]string{"APPEND", "key", " World"}, integerReply(11)},
+		{[]string{"STRLEN", "key"}, integerReply(11)},
+		{[]string{"STRLEN", "missing"}, integerReply(0)},
+		{[]string{"GETRANGE", "key", "0", "4"}, bulkReply("Hello")},
+		{[]string{"GETRANGE", "key", "-5", "-1"}, bulkReply("World")},
+		{[]string{"GETRANGE", "key", "0", "-1"}, bulkReply("Hello World")},
+		{[]string{"GETRANGE", "key", "10", "100"}, bulkReply("d")},
+		{[]string{"GETRANGE", "key", "11", "100"}, bulkReply("")},
+		{[]string{"GETRANGE", "key", "-1", "-5"}, bulkReply("")},
+		{[]string{"GETRANGE", "key", "-100", "-100"}, bulkReply("H")},
+		{[]string{"GETRANGE", "key", "5", "3"}, bulkReply("")},
+		{[]string{"GETRANGE", "missing", "0", "-1"}, bulkReply("")},
+		{[]string{"GETRANGE", "key", "x", "1"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"SET", "ttl", "a", "EX", "100"}, "+OK\r\n"},
+		// APPEND keeps the TTL of the key
+		{[]string{"APPEND", "ttl", "b"}, integerReply(2)},
+		{[]string{"RPUSH", "list", "a"}, integerReply(1)},
+		{[]string{"APPEND", "list", "a"}, "-value is not of string type\r\n"},
+		{[]string{"STRLEN", "list"}, "-value is not of string type\r\n"},
+		{[]string{"GETRANGE", "list", "0", "1"}, "-value is not of string type\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+	if s.db["ttl"].expire.IsZero() {
+		t.Error("APPEND removed the TTL of the key")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SET", "lock", "a", "IFEQ", "a"}, "$-1\r\n"},
+		{[]string{"SET", "lock", "a", "IFNE", "a"}, "+OK\r\n"},
+		{[]string{"SET", "lock", "b", "IFNE", "a"}, "$-1\r\n"},
+		{[]string{"SET", "lock", "b", "IFEQ", "a"}, "+OK\r\n"},
+		{[]string{"DELEX", "lock", "IFEQ", "a"}, integerReply(0)},
+		{[]string{"DELEX", "lock", "IFNE", "a"}, integerReply(1)},
+		{[]string{"DELEX", "lock"}, integerReply(0)},
+		{[]string{"DIGEST", "lease"}, "$-1\r\n"},
+		{[]string{"SET", "lease", "node1"}, "+OK\r\n"},
+		{[]string{"DIGEST", "lease"}, bulkReply("52039e369c1b4eb7")},
+		{[]string{"SET", "lease", "node2", "IFDEQ", "0000000000000000"}, "$-1\r\n"},
+		{[]string{"SET", "lease", "node2", "IFDEQ", "52039E369C1B4EB7"}, "+OK\r\n"},
+		{[]string{"DELEX", "lease", "IFDNE", "52039e369c1b4eb7"}, integerReply(1)},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestCounters(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"INCR", "n"}, integerReply(1)},
+		{[]string{"INCRBY", "n", "9"}, integerReply(10)},
+		{[]string{"DECR", "n"}, integerReply(9)},
+		{[]string{"DECRBY", "n", "-1"}, integerReply(10)},
+		{[]string{"SET", "n", "9223372036854775806"}, "+OK\r\n"},
+		{[]string{"INCR", "n"}, integerReply(9223372036854775807)},
+		{[]string{"INCR", "n"}, "-increment or decrement would overflow\r\n"},
+		{[]string{"GET", "n"}, bulkReply("9223372036854775807")},
+		{[]string{"SET", "n", "-9223372036854775808"}, "+OK\r\n"},
+		{[]string{"DECR", "n"}, "-increment or decrement would overflow\r\n"},
+		{[]string{"INCRBY", "n", "-1"}, "-increment or decrement would overflow\r\n"},
+		{[]string{"DECRBY", "zero", "-9223372036854775808"}, "-decrement would overflow\r\n"},
+		{[]string{"INCRBY", "n", "9223372036854775808"}, "-value is not an integer or out of range\r\n"},
+		// integers with spaces, signs or leading zeros aren't counters
+		{[]string{"SET", "n", " 1"}, "+OK\r\n"},
+		{[]string{"INCR", "n"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"SET", "n", "+1"}, "+OK\r\n"},
+		{[]string{"INCR", "n"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"SET", "n", "01"}, "+OK\r\n"},
+		{[]string{"INCR", "n"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"SET", "n", "-0"}, "+OK\r\n"},
+		{[]string{"INCR", "n"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"SET", "n", "0"}, "+OK\r\n"},
+		{[]string{"INCR", "n"}, integerReply(1)},
+		{[]string{"RPUSH", "list", "1"}, integerReply(1)},
+		{[]string{"INCR", "list"}, "-value is not of numeric type\r\n"},
+		{[]string{"INCRBYFLOAT", "f", "10.5"}, bulkReply("10.5")},
+		{[]string{"INCRBYFLOAT", "f", "0.1"}, bulkReply("10.6")},
+		{[]string{"INCRBYFLOAT", "f", "-5"}, bulkReply("5.6")},
+		{[]string{"INCRBYFLOAT", "g", "0.1"}, bulkReply("0.1")},
+		{[]string{"INCRBYFLOAT", "g", "0.2"}, bulkReply("0.3")},
+		{[]string{"INCRBYFLOAT", "g", "-0.3"}, bulkReply("0")},
+		{[]string{"SET", "g", "5.0e3"}, "+OK\r\n"},
+		{[]string{"INCRBYFLOAT", "g", "2.0e2"}, bulkReply("5200")},
+		{[]string{"INCRBYFLOAT", "e", "5.0e3"}, bulkReply("5000")},
+		{[]string{"INCRBYFLOAT", "f", "nan"}, "-value is not a valid float\r\n"},
+		{[]string{"INCRBYFLOAT", "f", "inf"}, "-increment would produce NaN or Infinity\r\n"},
+		{[]string{"SET", "f", "1.7976931348623157e308"}, "+OK\r\n"},
+		{[]string{"INCRBYFLOAT", "f", "1.7976931348623157e308"}, "-increment would produce NaN or Infinity\r\n"},
+		{[]string{"SET", "f", "3"}, "+OK\r\n"},
+		{[]string{"INCRBYFLOAT", "f", "1"}, bulkReply("4")},
+		{[]string{"INCR", "f"}, integerReply(5)},
+		{[]string{"SET", "f", "abc"}, "+OK\r\n"},
+		{[]string{"INCRBYFLOAT", "f", "1"}, "-value is not a valid float\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestMultiKeyStrings(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"MSET", "a", "1", "b", "2", "a", "3"}, "+OK\r\n"},
+		{[]string{"MGET", "a", "b", "missing"}, "*3\r\n" + bulkReply("3") + bulkReply("2") + "$-1\r\n"},
+		{[]string{"MSET", "a"}, "-wrong number of arguments for 'mset' command\r\n"},
+		{[]string{"MSET", "a", "1", "b"}, "-wrong number of arguments for 'mset' command\r\n"},
+		{[]string{"RPUSH", "list", "x"}, integerReply(1)},
+		// keys that don't hold a string are nil, not an error
+		{[]string{"MGET", "list", "a"}, "*2\r\n$-1\r\n" + bulkReply("3")},
+		{[]string{"MSETNX", "c", "1", "a", "4"}, integerReply(0)},
+		{[]string{"EXISTS", "c"}, integerReply(0)},
+		{[]string{"GET", "a"}, bulkReply("3")},
+		{[]string{"MSETNX", "c", "1", "d", "2"}, integerReply(1)},
+		{[]string{"MGET", "c", "d"}, "*2\r\n" + bulkReply("1") + bulkReply("2")},
+		{[]string{"SET", "ttl", "x", "EX", "100"}, "+OK\r\n"},
+		// MSET replaces the value of a key of any type, and its TTL
+		{[]string{"MSET", "list", "y", "ttl", "z"}, "+OK\r\n"},
+		{[]string{"MGET", "list", "ttl"}, "*2\r\n" + bulkReply("y") + bulkReply("z")},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+	if !s.db["ttl"].expire.IsZero() {
+		t.Error("MSET kept the TTL of the key it replaced")
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SET", "k", "a", "XX"}, "$-1\r\n"},
+		{[]string{"SET", "k", "a", "NX"}, "+OK\r\n"},
+		{[]string{"SET", "k", "b", "NX"}, "$-1\r\n"},
+		{[]string{"SET", "k", "b", "XX", "GET"}, bulkReply("a")},
+		// GET replies with the old value even when the key isn't set
+		{[]string{"SET", "k", "c", "NX", "GET"}, bulkReply("b")},
+		{[]string{"SET", "new", "x", "GET"}, "$-1\r\n"},
+		{[]string{"SET", "k", "c", "nx", "xx"}, "-invalid syntax\r\n"},
+		{[]string{"SET", "k", "c", "EX", "10", "PX", "10"}, "-invalid syntax\r\n"},
+		{[]string{"SET", "k", "c", "EX", "10", "KEEPTTL"}, "-invalid syntax\r\n"},
+		{[]string{"SET", "k", "c", "EX"}, "-invalid syntax\r\n"},
+		{[]string{"SET", "k", "c", "EX", "0"}, "-invalid expire time in 'set' command\r\n"},
+		{[]string{"SET", "k", "c", "EX", "9223372036854775807"}, "-invalid expire time in 'set' command\r\n"},
+		{[]string{"SET", "k", "c", "PX", "9223372036854775807"}, "-invalid expire time in 'set' command\r\n"},
+		{[]string{"SET", "k", "c", "UNKNOWN"}, "-invalid syntax\r\n"},
+		{[]string{"GET", "k"}, bulkReply("b")},
+		{[]string{"RPUSH", "list", "x"}, integerReply(1)},
+		{[]string{"SET", "list", "y", "GET"}, "-value is not of string type\r\n"},
+		{[]string{"SET", "list", "y"}, "+OK\r\n"},
+		{[]string{"SET", "past", "x", "PXAT", "1"}, "+OK\r\n"},
+		{[]string{"GET", "past"}, "$-1\r\n"},
+		{[]string{"GETSET", "k", "d"}, bulkReply("b")},
+		{[]string{"GETSET", "missing", "d"}, "$-1\r\n"},
+		{[]string{"GETDEL", "k"}, bulkReply("d")},
+		{[]string{"GETDEL", "k"}, "$-1\r\n"},
+		{[]string{"RPUSH", "other", "x"}, integerReply(1)},
+		{[]string{"GETDEL", "other"}, "-value is not of string type\r\n"},
+		{[]string{"GETEX", "absent", "EX", "10"}, "$-1\r\n"},
+		{[]string{"GETEX", "new", "EX", "10", "PERSIST"}, "-invalid syntax\r\n"},
+		{[]string{"GETEX", "new", "EX", "-1"}, "-invalid expire time in 'getex' command\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	// `ttl` returns how long before key expires, 0 if it doesn't
+	ttl := func(key string) time.Duration {
+		value, ok := s.db[key]
+		if !ok {
+			t.Fatalf("%s doesn't exist", key)
+		}
+		if value.expire.IsZero() {
+			return 0
+		}
+		return time.Until(value.expire)
+	}
+	run(t, s, "SET", "k", "a", "EX", "100")
+	if d := ttl("k"); d <= 99*time.Second || d > 100*time.Second {
+		t.Errorf("SET EX 100 expires in %v", d)
+	}
+	run(t, s, "SET", "k", "b", "KEEPTTL")
+	if d := ttl("k"); d <= 99*time.Second || d > 100*time.Second {
+		t.Errorf("SET KEEPTTL changed the TTL to %v", d)
+	}
+	run(t, s, "SET", "k", "c")
+	if d := ttl("k"); d != 0 {
+		t.Errorf("SET without an expiry option kept a TTL of %v", d)
+	}
+	run(t, s, "SET", "k", "d", "PXAT", "9999999999999")
+	if expire := s.db["k"].expire.UnixMilli(); expire != 9999999999999 {
+		t.Errorf("SET PXAT 9999999999999 expires at %d", expire)
+	}
+	if got := run(t, s, "GETEX", "k", "PX", "5000"); got != bulkReply("d") {
+		t.Errorf("GETEX PX replied %q", got)
+	}
+	if d := ttl("k"); d <= 4*time.Second || d > 5*time.Second {
+		t.Errorf("GETEX PX 5000 expires in %v", d)
+	}
+	run(t, s, "GETEX", "k")
+	if d := ttl("k"); d == 0 {
+		t.Error("GETEX without options removed the TTL")
+	}
+	run(t, s, "GETEX", "k", "PERSIST")
+	if d := ttl("k"); d != 0 {
+		t.Errorf("GETEX PERSIST kept a TTL of %v", d)
+	}
+	if got := run(t, s, "GETEX", "k", "EXAT", "1"); got != bulkReply("d") {
+		t.Errorf("GETEX EXAT in the past replied %q", got)
+	}
+	if _, ok := s.db["k"]; ok {
+		t.Error("GETEX with an expiry time in the past kept the key")
+	}
+}
+
+// `lcsMatch` serialises a match of an LCS IDX reply
+func lcsMatch(aStart, aEnd, bStart, bEnd int64, length ...int64) string {
+	match := "*2\r\n" + integerReply(aStart) + integerReply(aEnd) + "*2\r\n" + integerReply(bStart) + integerReply(bEnd)
+	if len(length) > 0 {
+		return "*3\r\n" + match + integerReply(length[0])
+	}
+	return "*2\r\n" + match
+}
+
+func TestLCS(t *testing.T) {
+	s := newStore()
+	run(t, s, "MSET", "key1", "ohmytext", "key2", "mynewtext")
+	// the replies redis documents for the same strings
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"LCS", "key1", "key2"}, bulkReply("mytext")},
+		{[]string{"LCS", "key1", "key2", "LEN"}, integerReply(6)},
+		{[]string{"LCS", "key1", "key2", "IDX"},
+			"*4\r\n" + bulkReply("matches") + "*2\r\n" + lcsMatch(4, 7, 5, 8) + lcsMatch(2, 3, 0, 1) + bulkReply("len") + integerReply(6)},
+		{[]string{"LCS", "key1", "key2", "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN"},
+			"*4\r\n" + bulkReply("matches") + "*1\r\n" + lcsMatch(4, 7, 5, 8, 4) + bulkReply("len") + integerReply(6)},
+		{[]string{"LCS", "key1", "key2", "LEN", "IDX"}, "-If you want both the length and indexes, please just use IDX.\r\n"},
+		{[]string{"LCS", "key1", "key2", "MINMATCHLEN"}, "-invalid syntax\r\n"},
+		{[]string{"LCS", "key1", "missing"}, bulkReply("")},
+		{[]string{"LCS", "key1", "missing", "IDX"}, "*4\r\n" + bulkReply("matches") + "*0\r\n" + bulkReply("len") + integerReply(0)},
+		{[]string{"RPUSH", "list", "x"}, integerReply(1)},
+		{[]string{"LCS", "key1", "list"}, "-The specified keys must contain string values\r\n"},
+		// a match at the start of both strings
+		{[]string{"MSET", "a", "abcx", "b", "abcy"}, "+OK\r\n"},
+		{[]string{"LCS", "a", "b", "IDX"}, "*4\r\n" + bulkReply("matches") + "*1\r\n" + lcsMatch(0, 2, 0, 2) + bulkReply("len") + integerReply(3)},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	// the subsequence of random strings is common to both, and as
+	// long as the longest found by recursion
+	isSubsequence := func(sub, str string) bool {
+		for i := 0; i < len(str) && len(sub) > 0; i++ {
+			if str[i] == sub[0] {
+				sub = sub[1:]
+			}
+		}
+		return len(sub) == 0
+	}
+	random := func() string {
+		str := make([]byte, rand.Intn(30))
+		for i := range str {
+			str[i] = "abc"[rand.Intn(3)]
+		}
+		return string(str)
+	}
+	var longest func(a, b string, memo map[[2]int]int) int
+	longest = func(a, b string, memo map[[2]int]int) int {
+		if a == "" || b == "" {
+			return 0
+		}
+		if length, ok := memo[[2]int{len(a), len(b)}]; ok {
+			return length
+		}
+		length := max(longest(a[1:], b, memo), longest(a, b[1:], memo))
+		if a[0] == b[0] {
+			length = max(length, 1+longest(a[1:], b[1:], memo))
+		}
+		memo[[2]int{len(a), len(b)}] = length
+		return length
+	}
+	for i := 0; i < 200; i++ {
+		a, b := random(), random()
+		run(t, s, "MSET", "a", a, "b", b)
+		reply := run(t, s, "LCS", "a", "b")
+		sub := reply[strings.Index(reply, "\r\n")+2 : len(reply)-2]
+		if !isSubsequence(sub, a) || !isSubsequence(sub, b) {
+			t.Fatalf("LCS of %q and %q replied %q", a, b, sub)
+		}
+		if want := longest(a, b, map[[2]int]int{}); len(sub) != want {
+			t.Fatalf("LCS of %q and %q replied %q, want %d bytes", a, b, sub, want)
+		}
+		if got := run(t, s, "LCS", "a", "b", "LEN"); got != integerReply(int64(len(sub))) {
+			t.Fatalf("LCS LEN of %q and %q replied %q, want %d", a, b, got, len(sub))
+		}
+	}
+}
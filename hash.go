@@ -0,0 +1,1049 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// hash maps fields to their values
+type hash struct {
+	fields map[string][]byte
+	// expiration timestamps of the fields with a TTL, also kept
+	// in a min-heap so that the expired fields are found without
+	// going over every TTL
+	expires     map[string]*fieldExpiry
+	expiryQueue fieldExpiries
+	// the fields, in the order HSCAN visits them
+	index scanIndex
+}
+
+func newHash() *hash {
+	return &hash{
+		fields: make(map[string][]byte),
+	}
+}
+
+// fieldExpiry is the expiration time of a field of a hash
+type fieldExpiry struct {
+	field  string
+	expire time.Time
+	// position in the hash's expiry queue
+	index int
+}
+
+// fieldExpiries is a min-heap of field expiration times, it
+// implements heap.Interface
+type fieldExpiries []*fieldExpiry
+
+func (q fieldExpiries) Len() int {
+	return len(q)
+}
+
+func (q fieldExpiries) Less(i, j int) bool {
+	return q[i].expire.Before(q[j].expire)
+}
+
+func (q fieldExpiries) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *fieldExpiries) Push(x any) {
+	expiry := x.(*fieldExpiry)
+	expiry.index = len(*q)
+	*q = append(*q, expiry)
+}
+
+func (q *fieldExpiries) Pop() any {
+	old := *q
+	expiry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return expiry
+}
+
+// `expiry` returns the expiration time of field, and whether
+// it has a TTL
+func (h *hash) expiry(field string) (time.Time, bool) {
+	expiry, ok := h.expires[field]
+	if !ok {
+		return time.Time{}, false
+	}
+	return expiry.expire, true
+}
+
+// `setExpiry` sets the expiration time of field
+func (h *hash) setExpiry(field string, expire time.Time) {
+	if expiry, ok := h.expires[field]; ok {
+		expiry.expire = expire
+		heap.Fix(&h.expiryQueue, expiry.index)
+		return
+	}
+	if h.expires == nil {
+		h.expires = make(map[string]*fieldExpiry)
+	}
+	expiry := &fieldExpiry{field: field, expire: expire}
+	h.expires[field] = expiry
+	heap.Push(&h.expiryQueue, expiry)
+}
+
+// `persist` removes the TTL of field, it reports false if
+// the field had none
+func (h *hash) persist(field string) bool {
+	expiry, ok := h.expires[field]
+	if !ok {
+		return false
+	}
+	heap.Remove(&h.expiryQueue, expiry.index)
+	delete(h.expires, field)
+	return true
+}
+
+// `expireFields` deletes up to limit fields that expired by now,
+// the earliest first, and returns how many it deleted
+func (h *hash) expireFields(now time.Time, limit int) int {
+	expired := 0
+	for expired < limit && len(h.expiryQueue) > 0 && !h.expiryQueue[0].expire.After(now) {
+		h.deleteField(h.expiryQueue[0].field)
+		expired++
+	}
+	return expired
+}
+
+// `setField` sets field to value, leaving its TTL as is. It
+// reports whether the field is new
+func (h *hash) setField(field string, value []byte) bool {
+	_, exists := h.fields[field]
+	h.fields[field] = value
+	if !exists {
+		h.index.add(field)
+	}
+	return !exists
+}
+
+// `deleteField` deletes field along with its TTL
+func (h *hash) deleteField(field string) {
+	if _, exists := h.fields[field]; !exists {
+		return
+	}
+	delete(h.fields, field)
+	h.persist(field)
+	h.index.remove(field)
+}
+
+// `getHash` retrieves the hash stored at key, once its expired
+// fields are deleted. It also reports whether the key exists and
+// whether it holds a hash
+func (s *store) getHash(key string) (*hash, bool, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, false, true
+	}
+	if value.valueType != "hash" {
+		return nil, true, false
+	}
+	h := value.value.(*hash)
+	// a passive delete of the expired fields
+	if s.expireHashFields(key, h, time.Now()) {
+		return nil, false, true
+	}
+	return h, true, true
+}
+
+// `hashForWrite` retrieves the hash stored at key, creating an
+// empty one if the key doesn't exist. It reports false when the
+// key holds a value of another type
+func (s *store) hashForWrite(key string) (*hash, bool) {
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return nil, false
+	}
+	if !exists {
+		h = newHash()
+		s.set(key, &redisValue{
+			value:     h,
+			valueType: "hash",
+		})
+	}
+	return h, true
+}
+
+// `toRESPArray` converts a hash to a RESP array of fields, each
+// followed by its value and its expiration time in unix
+// milliseconds, 0 if the field doesn't expire
+func (h *hash) toRESPArray() ([]byte, error) {
+	var response resp.Array
+	for field, value := range h.fields {
+		expire := "0"
+		if t, ok := h.expiry(field); ok {
+			expire = strconv.FormatInt(t.UnixMilli(), 10)
+		}
+		response.Elements = append(response.Elements,
+			&resp.BulkString{Data: []byte(field), Size: len(field)},
+			&resp.BulkString{Data: value, Size: len(value)},
+			&resp.BulkString{Data: []byte(expire), Size: len(expire)},
+		)
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `hashFromElements` builds a hash out of the array
+// produced by `toRESPArray`
+func hashFromElements(elements [][]byte) (*hash, error) {
+	if len(elements)%3 != 0 {
+		return nil, resp.ErrInvalidClientData
+	}
+	h := newHash()
+	for i := 0; i < len(elements); i += 3 {
+		field := string(elements[i])
+		h.setField(field, elements[i+1])
+		expire, ok := parseInteger(elements[i+2])
+		if !ok {
+			return nil, resp.ErrInvalidClientData
+		}
+		if expire != 0 {
+			h.setExpiry(field, time.UnixMilli(expire))
+		}
+	}
+	return h, nil
+}
+
+// HSET command sets fields of the hash stored at key
+func hset(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return wrongNumberOfArgs("hset")
+	}
+	key := string(args[0])
+	h, ok := s.hashForWrite(key)
+	if !ok {
+		return wrongType()
+	}
+	added := int64(0)
+	for i := 1; i < len(args); i += 2 {
+		if h.setField(string(args[i]), args[i+1]) {
+			added++
+		}
+		// overwriting a field clears its TTL
+		h.persist(string(args[i]))
+	}
+	s.notifyKeyspaceEvent(notifyHash, "hset", key)
+	response := resp.Integer{
+		Data: added,
+	}
+	return response.Serialise()
+}
+
+// HSETNX command sets a field of a hash, only if
+// the field doesn't exist yet
+func hsetnx(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("hsetnx")
+	}
+	key := string(args[0])
+	h, ok := s.hashForWrite(key)
+	if !ok {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if _, exists := h.fields[string(args[1])]; !exists {
+		h.setField(string(args[1]), args[2])
+		s.notifyKeyspaceEvent(notifyHash, "hset", key)
+		response.Data = 1
+	}
+	return response.Serialise()
+}
+
+// HGET command returns the value of a field of a hash
+func hget(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("hget")
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	response := resp.BulkString{
+		Size: -1,
+	}
+	if exists {
+		if value, ok := h.fields[string(args[1])]; ok {
+			response.Data = value
+			response.Size = len(value)
+		}
+	}
+	return response.Serialise()
+}
+
+// HMGET command returns the values of several fields of a hash
+func hmget(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("hmget")
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	var response resp.Array
+	for _, field := range args[1:] {
+		elem := &resp.BulkString{
+			Size: -1,
+		}
+		if exists {
+			if value, ok := h.fields[string(field)]; ok {
+				elem.Data = value
+				elem.Size = len(value)
+			}
+		}
+		response.Elements = append(response.Elements, elem)
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// HDEL command removes fields from a hash
+func hdel(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("hdel")
+	}
+	key := string(args[0])
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	for _, field := range args[1:] {
+		if _, ok := h.fields[string(field)]; ok {
+			h.deleteField(string(field))
+			response.Data++
+		}
+	}
+	if response.Data > 0 {
+		s.notifyKeyspaceEvent(notifyHash, "hdel", key)
+		s.deleteIfEmpty(key, len(h.fields))
+	}
+	return response.Serialise()
+}
+
+// HLEN command returns the number of fields of a hash
+func hlen(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("hlen")
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists {
+		response.Data = int64(len(h.fields))
+	}
+	return response.Serialise()
+}
+
+// HEXISTS command reports whether a field exists in a hash
+func hexists(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("hexists")
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists {
+		if _, ok := h.fields[string(args[1])]; ok {
+			response.Data = 1
+		}
+	}
+	return response.Serialise()
+}
+
+// HSTRLEN command returns the length of the value of a field
+func hstrlen(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("hstrlen")
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists {
+		response.Data = int64(len(h.fields[string(args[1])]))
+	}
+	return response.Serialise()
+}
+
+// `hashContents` implements HGETALL, HKEYS and HVALS
+func hashContents(args [][]byte, s *store, command string, withFields, withValues bool) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs(command)
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	var response resp.Array
+	if exists {
+		for field, value := range h.fields {
+			if withFields {
+				response.Elements = append(response.Elements, &resp.BulkString{Data: []byte(field), Size: len(field)})
+			}
+			if withValues {
+				response.Elements = append(response.Elements, &resp.BulkString{Data: value, Size: len(value)})
+			}
+		}
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// HGETALL command returns the fields and values of a hash
+func hgetall(args [][]byte, s *store) ([]byte, error) {
+	return hashContents(args, s, "hgetall", true, true)
+}
+
+// HKEYS command returns the fields of a hash
+func hkeys(args [][]byte, s *store) ([]byte, error) {
+	return hashContents(args, s, "hkeys", true, false)
+}
+
+// HVALS command returns the values of a hash
+func hvals(args [][]byte, s *store) ([]byte, error) {
+	return hashContents(args, s, "hvals", false, true)
+}
+
+// HINCRBY command increments the integer stored at a field of a hash
+func hincrby(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("hincrby")
+	}
+	key := string(args[0])
+	delta, ok := parseInteger(args[2])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return wrongType()
+	}
+	var integer int64
+	if exists {
+		if value, ok := h.fields[string(args[1])]; ok {
+			integer, ok = parseInteger(value)
+			if !ok {
+				return errorReply("hash value is not an integer")
+			}
+		}
+	}
+	if (delta < 0 && integer < 0 && delta < math.MinInt64-integer) ||
+		(delta > 0 && integer > 0 && delta > math.MaxInt64-integer) {
+		return errorReply("increment or decrement would overflow")
+	}
+	integer += delta
+	h, _ = s.hashForWrite(key)
+	h.setField(string(args[1]), []byte(strconv.FormatInt(integer, 10)))
+	s.notifyKeyspaceEvent(notifyHash, "hincrby", key)
+	response := resp.Integer{
+		Data: integer,
+	}
+	return response.Serialise()
+}
+
+// HINCRBYFLOAT command increments the float stored at a field of a hash
+func hincrbyfloat(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("hincrbyfloat")
+	}
+	key := string(args[0])
+	if _, ok := parseFloat(args[2]); !ok {
+		return errorReply("value is not a valid float")
+	}
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return wrongType()
+	}
+	current := []byte("0")
+	if exists {
+		if value, ok := h.fields[string(args[1])]; ok {
+			if _, ok = parseFloat(value); !ok {
+				return errorReply("hash value is not a float")
+			}
+			current = value
+		}
+	}
+	sum, ok := addFloats(current, args[2])
+	if !ok {
+		return errorReply("increment would produce NaN or Infinity")
+	}
+	value := []byte(sum)
+	h, _ = s.hashForWrite(key)
+	h.setField(string(args[1]), value)
+	s.notifyKeyspaceEvent(notifyHash, "hincrbyfloat", key)
+	response := resp.BulkString{
+		Data: value,
+		Size: len(value),
+	}
+	return response.Serialise()
+}
+
+// HRANDFIELD command returns random fields of a hash. A positive
+// count returns distinct fields, a negative one may repeat them
+func hrandfield(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return wrongNumberOfArgs("hrandfield")
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	if len(args) == 1 {
+		response := resp.BulkString{
+			Size: -1,
+		}
+		// map iteration order is unspecified but not random
+		// enough, so pick the field by its position. A hash
+		// without fields, which shouldn't be stored, has none
+		if exists && len(h.fields) > 0 {
+			target := rand.Intn(len(h.fields))
+			for field := range h.fields {
+				if target == 0 {
+					response.Data = []byte(field)
+					response.Size = len(field)
+					break
+				}
+				target--
+			}
+		}
+		return response.Serialise()
+	}
+	count, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHVALUES" {
+			return errorReply("invalid syntax")
+		}
+		withValues = true
+	}
+	var response resp.Array
+	if exists && len(h.fields) > 0 && count != 0 {
+		fields := make([]string, 0, len(h.fields))
+		for field := range h.fields {
+			fields = append(fields, field)
+		}
+		var picked []string
+		if count > 0 {
+			rand.Shuffle(len(fields), func(i, j int) {
+				fields[i], fields[j] = fields[j], fields[i]
+			})
+			picked = fields[:min(int64(len(fields)), count)]
+		} else {
+			if count < -maxRandomCount {
+				return errorReply("value is out of range")
+			}
+			for i := int64(0); i < -count; i++ {
+				picked = append(picked, fields[rand.Intn(len(fields))])
+			}
+		}
+		for _, field := range picked {
+			response.Elements = append(response.Elements, &resp.BulkString{Data: []byte(field), Size: len(field)})
+			if withValues {
+				value := h.fields[field]
+				response.Elements = append(response.Elements, &resp.BulkString{Data: value, Size: len(value)})
+			}
+		}
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// upper bound on the number of elements returned when picking
+// random elements with repetitions
+const maxRandomCount = math.MaxInt32
+
+// HSCAN command incrementally iterates over the fields of a hash
+func hscan(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("hscan")
+	}
+	options, message := parseScanOptions(args[1:], true)
+	if message != "" {
+		return errorReply(message)
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	if !exists {
+		return scanReply(0, nil)
+	}
+	page, cursor := h.index.scan(options)
+	var elements []resp.RESPDatatype
+	for _, field := range page {
+		elements = append(elements, &resp.BulkString{Data: []byte(field), Size: len(field)})
+		if !options.noValues {
+			value := h.fields[field]
+			elements = append(elements, &resp.BulkString{Data: value, Size: len(value)})
+		}
+	}
+	return scanReply(cursor, elements)
+}
+
+// `trackFieldExpiry` records that the hash stored at key has fields
+// with a TTL, so that the active expiry cycle visits it
+func (s *store) trackFieldExpiry(key string) {
+	s.volatileHashes[key] = struct{}{}
+}
+
+// `expireHashFields` deletes the fields of h, stored at key, that
+// expired by now. It reports whether the key was deleted as a
+// result of the hash running out of fields
+func (s *store) expireHashFields(key string, h *hash, now time.Time) bool {
+	return s.expireHashFieldsUpTo(key, h, now, math.MaxInt) && len(h.fields) == 0
+}
+
+// `expireHashFieldsUpTo` deletes up to limit fields of h, stored at
+// key, that expired by now. It reports whether any field expired
+func (s *store) expireHashFieldsUpTo(key string, h *hash, now time.Time, limit int) bool {
+	if h.expireFields(now, limit) == 0 {
+		return false
+	}
+	s.notifyKeyspaceEvent(notifyHash, "hexpired", key)
+	s.deleteIfEmpty(key, len(h.fields))
+	return true
+}
+
+const (
+	// hashes sampled by a round of the active expire cycle
+	activeExpireSamples = 20
+	// fields a round deletes from a single hash at most
+	activeExpireFieldsPerHash = 100
+	// time an active expire cycle may run for, a quarter of the
+	// 100ms between two cycles, like redis' ACTIVE_EXPIRE_CYCLE_SLOW
+	activeExpireBudget = 25 * time.Millisecond
+)
+
+// `activeExpireCycle` deletes the expired fields of hashes with
+// fields that have a TTL, so that fields that are never accessed
+// again don't linger in memory. Like redis' activeExpireCycle, it
+// samples a few of those hashes per round, and runs another round
+// while more than a quarter of the sampled hashes had expired
+// fields and it's within its time budget
+func (s *store) activeExpireCycle() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	start := time.Now()
+	for {
+		now := time.Now()
+		sampled, expired := 0, 0
+		// map iteration starts at a random position, so the
+		// first hashes visited are a sample
+		for key := range s.volatileHashes {
+			if sampled == activeExpireSamples {
+				break
+			}
+			sampled++
+			value, ok := s.db[key]
+			if !ok || value.valueType != "hash" || len(value.value.(*hash).expires) == 0 {
+				delete(s.volatileHashes, key)
+				continue
+			}
+			if s.expireHashFieldsUpTo(key, value.value.(*hash), now, activeExpireFieldsPerHash) {
+				expired++
+			}
+		}
+		if 4*expired <= sampled || time.Since(start) >= activeExpireBudget {
+			return
+		}
+	}
+}
+
+// largest expiration timestamp a field may have, in unix milliseconds
+const maxFieldExpire = 0x3FFFFFFFFFFF
+
+// `parseFields` parses the FIELDS numfields field [field ...] block
+// that ends the field expiration commands. With pairs set, every
+// field is followed by a value. A non empty string is the error
+// to reply with
+func parseFields(args [][]byte, pairs bool) ([][]byte, string) {
+	if len(args) < 2 || strings.ToUpper(string(args[0])) != "FIELDS" {
+		return nil, "mandatory argument FIELDS is missing or not at the right position"
+	}
+	numFields, ok := parseInteger(args[1])
+	if !ok || numFields <= 0 {
+		return nil, "parameter `numFields` should be greater than 0"
+	}
+	perField := int64(1)
+	if pairs {
+		perField = 2
+	}
+	if numFields > int64(len(args)-2)/perField || int64(len(args)-2) != numFields*perField {
+		return nil, "the `numfields` parameter must match the number of arguments"
+	}
+	return args[2:], ""
+}
+
+// `integerArray` serialises an array of integers
+func integerArray(integers []int64) ([]byte, error) {
+	var response resp.Array
+	for _, integer := range integers {
+		response.Elements = append(response.Elements, &resp.Integer{Data: integer})
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `hexpireGeneric` implements HEXPIRE, HPEXPIRE, HEXPIREAT and
+// HPEXPIREAT. unit converts the time argument to milliseconds
+func hexpireGeneric(args [][]byte, s *store, command string, unit int64, absolute bool) ([]byte, error) {
+	if len(args) < 4 {
+		return wrongNumberOfArgs(command)
+	}
+	key := string(args[0])
+	expireTime, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	if expireTime < 0 {
+		return errorReply("invalid expire time, must be >= 0")
+	}
+	if expireTime > maxFieldExpire/unit {
+		return errorReply("invalid expire time in '" + command + "' command")
+	}
+	milliseconds := expireTime * unit
+	if !absolute {
+		milliseconds += time.Now().UnixMilli()
+	}
+	if milliseconds > maxFieldExpire {
+		return errorReply("invalid expire time in '" + command + "' command")
+	}
+	expire := time.UnixMilli(milliseconds)
+	rest := args[2:]
+	condition := ""
+	switch option := strings.ToUpper(string(rest[0])); option {
+	case "NX", "XX", "GT", "LT":
+		condition = option
+		rest = rest[1:]
+	}
+	fields, message := parseFields(rest, false)
+	if message != "" {
+		return errorReply(message)
+	}
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return wrongType()
+	}
+	results := make([]int64, len(fields))
+	now := time.Now()
+	set, deleted := false, false
+	for i, field := range fields {
+		name := string(field)
+		if !exists {
+			results[i] = -2
+			continue
+		}
+		if _, ok := h.fields[name]; !ok {
+			results[i] = -2
+			continue
+		}
+		current, hasTTL := h.expiry(name)
+		// a field without a TTL never expires, so it is greater than
+		// any expiration time
+		if (condition == "NX" && hasTTL) || (condition == "XX" && !hasTTL) ||
+			(condition == "GT" && (!hasTTL || !expire.After(current))) ||
+			(condition == "LT" && hasTTL && !expire.Before(current)) {
+			results[i] = 0
+			continue
+		}
+		// a time in the past deletes the field
+		if !expire.After(now) {
+			h.deleteField(name)
+			deleted = true
+			results[i] = 2
+			continue
+		}
+		h.setExpiry(name, expire)
+		set = true
+		results[i] = 1
+	}
+	if set {
+		s.trackFieldExpiry(key)
+		s.notifyKeyspaceEvent(notifyHash, "hexpire", key)
+	}
+	if deleted {
+		s.notifyKeyspaceEvent(notifyHash, "hdel", key)
+		s.deleteIfEmpty(key, len(h.fields))
+	}
+	return integerArray(results)
+}
+
+// HEXPIRE command sets a TTL, in seconds, on fields of a hash
+func hexpire(args [][]byte, s *store) ([]byte, error) {
+	return hexpireGeneric(args, s, "hexpire", 1000, false)
+}
+
+// HPEXPIRE command sets a TTL, in milliseconds, on fields of a hash
+func hpexpire(args [][]byte, s *store) ([]byte, error) {
+	return hexpireGeneric(args, s, "hpexpire", 1, false)
+}
+
+// HEXPIREAT command sets the expiration unix time, in seconds,
+// of fields of a hash
+func hexpireat(args [][]byte, s *store) ([]byte, error) {
+	return hexpireGeneric(args, s, "hexpireat", 1000, true)
+}
+
+// HPEXPIREAT command sets the expiration unix time, in
+// milliseconds, of fields of a hash
+func hpexpireat(args [][]byte, s *store) ([]byte, error) {
+	return hexpireGeneric(args, s, "hpexpireat", 1, true)
+}
+
+// `httlGeneric` implements HTTL and HPTTL, unit is the number
+// of milliseconds the TTL is expressed in
+func httlGeneric(args [][]byte, s *store, command string, unit int64) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs(command)
+	}
+	fields, message := parseFields(args[1:], false)
+	if message != "" {
+		return errorReply(message)
+	}
+	h, exists, isHash := s.getHash(string(args[0]))
+	if !isHash {
+		return wrongType()
+	}
+	results := make([]int64, len(fields))
+	now := time.Now()
+	for i, field := range fields {
+		results[i] = -2
+		if !exists {
+			continue
+		}
+		if _, ok := h.fields[string(field)]; !ok {
+			continue
+		}
+		expire, hasTTL := h.expiry(string(field))
+		if !hasTTL {
+			results[i] = -1
+			continue
+		}
+		// round to the closest unit, like TTL does
+		results[i] = (expire.Sub(now).Milliseconds() + unit/2) / unit
+	}
+	return integerArray(results)
+}
+
+// HTTL command returns the remaining TTL, in seconds, of fields of a hash
+func httl(args [][]byte, s *store) ([]byte, error) {
+	return httlGeneric(args, s, "httl", 1000)
+}
+
+// HPTTL command returns the remaining TTL, in milliseconds, of fields
+// of a hash
+func hpttl(args [][]byte, s *store) ([]byte, error) {
+	return httlGeneric(args, s, "hpttl", 1)
+}
+
+// HPERSIST command removes the TTL of fields of a hash
+func hpersist(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("hpersist")
+	}
+	key := string(args[0])
+	fields, message := parseFields(args[1:], false)
+	if message != "" {
+		return errorReply(message)
+	}
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return wrongType()
+	}
+	results := make([]int64, len(fields))
+	persisted := false
+	for i, field := range fields {
+		results[i] = -2
+		if !exists {
+			continue
+		}
+		if _, ok := h.fields[string(field)]; !ok {
+			continue
+		}
+		if !h.persist(string(field)) {
+			results[i] = -1
+			continue
+		}
+		persisted = true
+		results[i] = 1
+	}
+	if persisted {
+		s.notifyKeyspaceEvent(notifyHash, "hpersist", key)
+	}
+	return integerArray(results)
+}
+
+// `parseFieldExpiry` parses the optional EX, PX, EXAT, PXAT or
+// PERSIST option of HGETEX, or KEEPTTL in place of PERSIST for
+// HSETEX, followed by the FIELDS block. It returns the expiration
+// time, the option given and the fields
+func parseFieldExpiry(args [][]byte, command string, keepOption string, pairs bool) (time.Time, string, [][]byte, string) {
+	var expire time.Time
+	option := ""
+	switch upper := strings.ToUpper(string(args[0])); upper {
+	case "EX", "PX", "EXAT", "PXAT":
+		if len(args) < 2 {
+			return expire, "", nil, "invalid syntax"
+		}
+		var ok bool
+		expire, ok = parseExpiry(upper, args[1])
+		if !ok || expire.UnixMilli() > maxFieldExpire {
+			return expire, "", nil, "invalid expire time in '" + command + "' command"
+		}
+		option = upper
+		args = args[2:]
+	case keepOption:
+		option = upper
+		args = args[1:]
+	}
+	fields, message := parseFields(args, pairs)
+	return expire, option, fields, message
+}
+
+// HGETEX command returns the values of fields of a hash, and
+// optionally sets or removes their TTL
+func hgetex(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("hgetex")
+	}
+	key := string(args[0])
+	expire, option, fields, message := parseFieldExpiry(args[1:], "hgetex", "PERSIST", false)
+	if message != "" {
+		return errorReply(message)
+	}
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return wrongType()
+	}
+	var response resp.Array
+	now := time.Now()
+	changed, deleted := false, false
+	for _, field := range fields {
+		elem := &resp.BulkString{
+			Size: -1,
+		}
+		response.Elements = append(response.Elements, elem)
+		if !exists {
+			continue
+		}
+		value, ok := h.fields[string(field)]
+		if !ok {
+			continue
+		}
+		elem.Data = value
+		elem.Size = len(value)
+		switch option {
+		case "":
+		case "PERSIST":
+			if h.persist(string(field)) {
+				changed = true
+			}
+		default:
+			if !expire.After(now) {
+				h.deleteField(string(field))
+				deleted = true
+				continue
+			}
+			h.setExpiry(string(field), expire)
+			changed = true
+		}
+	}
+	if changed {
+		if option == "PERSIST" {
+			s.notifyKeyspaceEvent(notifyHash, "hpersist", key)
+		} else {
+			s.trackFieldExpiry(key)
+			s.notifyKeyspaceEvent(notifyHash, "hexpire", key)
+		}
+	}
+	if deleted {
+		s.notifyKeyspaceEvent(notifyHash, "hdel", key)
+		s.deleteIfEmpty(key, len(h.fields))
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// HSETEX command sets fields of a hash along with their TTL
+func hsetex(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("hsetex")
+	}
+	key := string(args[0])
+	rest := args[1:]
+	condition := ""
+	switch option := strings.ToUpper(string(rest[0])); option {
+	case "FNX", "FXX":
+		condition = option
+		rest = rest[1:]
+	}
+	expire, option, fields, message := parseFieldExpiry(rest, "hsetex", "KEEPTTL", true)
+	if message != "" {
+		return errorReply(message)
+	}
+	h, exists, isHash := s.getHash(key)
+	if !isHash {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	// FNX requires none of the fields to exist, FXX all of them
+	for i := 0; i < len(fields); i += 2 {
+		fieldExists := false
+		if exists {
+			_, fieldExists = h.fields[string(fields[i])]
+		}
+		if (condition == "FNX" && fieldExists) || (condition == "FXX" && !fieldExists) {
+			return response.Serialise()
+		}
+	}
+	h, _ = s.hashForWrite(key)
+	expired := !expire.IsZero() && !expire.After(time.Now())
+	for i := 0; i < len(fields); i += 2 {
+		field := string(fields[i])
+		h.setField(field, fields[i+1])
+		switch {
+		case option == "KEEPTTL":
+		case expire.IsZero():
+			h.persist(field)
+		case expired:
+			h.deleteField(field)
+		default:
+			h.setExpiry(field, expire)
+		}
+	}
+	s.notifyKeyspaceEvent(notifyHash, "hset", key)
+	if !expire.IsZero() {
+		if expired {
+			s.notifyKeyspaceEvent(notifyHash, "hdel", key)
+			s.deleteIfEmpty(key, len(h.fields))
+		} else {
+			s.trackFieldExpiry(key)
+			s.notifyKeyspaceEvent(notifyHash, "hexpire", key)
+		}
+	}
+	response.Data = 1
+	return response.Serialise()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHashCommands(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HSET", "h", "a", "1", "b", "2"}, integerReply(2)},
+		{[]string{"HSET", "h", "a", "3", "c", "4"}, integerReply(1)},
+		{[]string{"HSET", "h", "a"}, "-wrong number of arguments for 'hset' command\r\n"},
+		{[]string{"HSETNX", "h", "a", "5"}, integerReply(0)},
+		{[]string{"HSETNX", "h", "d", "5"}, integerReply(1)},
+		{[]string{"HGET", "h", "a"}, bulkReply("3")},
+		{[]string{"HGET", "h", "missing"}, "$-1\r\n"},
+		{[]string{"HGET", "missing", "a"}, "$-1\r\n"},
+		{[]string{"HMGET", "h", "a", "missing", "b"}, "*3\r\n" + bulkReply("3") + "$-1\r\n" + bulkReply("2")},
+		{[]string{"HLEN", "h"}, integerReply(4)},
+		{[]string{"HLEN", "missing"}, integerReply(0)},
+		{[]string{"HEXISTS", "h", "b"}, integerReply(1)},
+		{[]string{"HEXISTS", "h", "missing"}, integerReply(0)},
+		{[]string{"HSTRLEN", "h", "a"}, integerReply(1)},
+		{[]string{"HSTRLEN", "h", "missing"}, integerReply(0)},
+		{[]string{"HDEL", "h", "c", "d", "missing"}, integerReply(2)},
+		{[]string{"HINCRBY", "h", "a", "-5"}, integerReply(-2)},
+		{[]string{"HINCRBY", "h", "new", "7"}, integerReply(7)},
+		{[]string{"HINCRBY", "h", "a", "x"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"HINCRBY", "h", "big", "9223372036854775807"}, integerReply(9223372036854775807)},
+		{[]string{"HINCRBY", "h", "big", "1"}, "-increment or decrement would overflow\r\n"},
+		{[]string{"HINCRBYFLOAT", "h", "b", "1.5"}, bulkReply("3.5")},
+		{[]string{"HINCRBYFLOAT", "h", "b", "x"}, "-value is not a valid float\r\n"},
+		{[]string{"HINCRBYFLOAT", "h", "sum", "0.1"}, bulkReply("0.1")},
+		{[]string{"HINCRBYFLOAT", "h", "sum", "0.2"}, bulkReply("0.3")},
+		{[]string{"HINCRBYFLOAT", "h", "sum", "10.2"}, bulkReply("10.5")},
+		{[]string{"HINCRBYFLOAT", "h", "sum", "0.1"}, bulkReply("10.6")},
+		{[]string{"HINCRBYFLOAT", "h", "exp", "5.0e3"}, bulkReply("5000")},
+		{[]string{"HSET", "h", "text", "abc"}, integerReply(1)},
+		{[]string{"HINCRBY", "h", "text", "1"}, "-hash value is not an integer\r\n"},
+		{[]string{"HINCRBYFLOAT", "h", "text", "1"}, "-hash value is not a float\r\n"},
+		{[]string{"HDEL", "h", "a", "b", "new", "big", "text", "sum", "exp"}, integerReply(7)},
+		// deleting the last field deletes the key
+		{[]string{"EXISTS", "h"}, integerReply(0)},
+		{[]string{"HSET", "single", "f", "v"}, integerReply(1)},
+		{[]string{"HGETALL", "single"}, bulkArray("f", "v")},
+		{[]string{"HKEYS", "single"}, bulkArray("f")},
+		{[]string{"HVALS", "single"}, bulkArray("v")},
+		{[]string{"HGETALL", "missing"}, "*0\r\n"},
+		{[]string{"HRANDFIELD", "single"}, bulkReply("f")},
+		{[]string{"HRANDFIELD", "single", "-3", "WITHVALUES"}, bulkArray("f", "v", "f", "v", "f", "v")},
+		{[]string{"HRANDFIELD", "single", "5"}, bulkArray("f")},
+		{[]string{"HRANDFIELD", "missing"}, "$-1\r\n"},
+		{[]string{"HRANDFIELD", "missing", "2"}, "*0\r\n"},
+		{[]string{"SET", "str", "v"}, "+OK\r\n"},
+		{[]string{"HGET", "str", "f"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"HSET", "str", "f", "v"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// TestHrandfieldEmptyHash checks that a hash without fields, which
+// commands never leave behind, replies like a missing one
+func TestHrandfieldEmptyHash(t *testing.T) {
+	s := newStore()
+	s.db["empty"] = redisValue{valueType: "hash", value: newHash()}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HRANDFIELD", "empty"}, "$-1\r\n"},
+		{[]string{"HRANDFIELD", "empty", "2"}, "*0\r\n"},
+		{[]string{"HRANDFIELD", "empty", "-2", "WITHVALUES"}, "*0\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestHashExpireCommands(t *testing.T) {
+	s := newStore()
+	run(t, s, "HSET", "h", "a", "1", "b", "2", "c", "3")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HEXPIRE", "h", "100", "FIELDS", "2", "a", "missing"}, "*2\r\n" + integerReply(1) + integerReply(-2)},
+		{[]string{"HEXPIRE", "missing", "100", "FIELDS", "1", "a"}, "*1\r\n" + integerReply(-2)},
+		{[]string{"HEXPIRE", "h", "200", "NX", "FIELDS", "2", "a", "b"}, "*2\r\n" + integerReply(0) + integerReply(1)},
+		{[]string{"HEXPIRE", "h", "50", "GT", "FIELDS", "2", "a", "c"}, "*2\r\n" + integerReply(0) + integerReply(0)},
+		{[]string{"HEXPIRE", "h", "50", "LT", "FIELDS", "2", "a", "c"}, "*2\r\n" + integerReply(1) + integerReply(1)},
+		{[]string{"HEXPIRE", "h", "300", "XX", "FIELDS", "1", "b"}, "*1\r\n" + integerReply(1)},
+		{[]string{"HTTL", "h", "FIELDS", "4", "a", "b", "c", "missing"}, "*4\r\n" + integerReply(50) + integerReply(300) + integerReply(50) + integerReply(-2)},
+		{[]string{"HPERSIST", "h", "FIELDS", "2", "c", "c"}, "*2\r\n" + integerReply(1) + integerReply(-1)},
+		{[]string{"HTTL", "h", "FIELDS", "1", "c"}, "*1\r\n" + integerReply(-1)},
+		{[]string{"HEXPIRE", "h", "100", "FIELDS", "2", "a"}, "-the `numfields` parameter must match the number of arguments\r\n"},
+		{[]string{"HEXPIRE", "h", "-1", "FIELDS", "1", "a"}, "-invalid expire time, must be >= 0\r\n"},
+		// a TTL in the past deletes the field
+		{[]string{"HPEXPIREAT", "h", "1", "FIELDS", "1", "a"}, "*1\r\n" + integerReply(2)},
+		{[]string{"HEXISTS", "h", "a"}, integerReply(0)},
+		{[]string{"HSETEX", "h", "FNX", "EX", "100", "FIELDS", "1", "a", "4"}, integerReply(1)},
+		{[]string{"HSETEX", "h", "FNX", "FIELDS", "1", "a", "5"}, integerReply(0)},
+		{[]string{"HTTL", "h", "FIELDS", "1", "a"}, "*1\r\n" + integerReply(100)},
+		{[]string{"HSETEX", "h", "FXX", "KEEPTTL", "FIELDS", "1", "a", "5"}, integerReply(1)},
+		{[]string{"HTTL", "h", "FIELDS", "1", "a"}, "*1\r\n" + integerReply(100)},
+		{[]string{"HGETEX", "h", "PERSIST", "FIELDS", "1", "a"}, bulkArray("5")},
+		{[]string{"HTTL", "h", "FIELDS", "1", "a"}, "*1\r\n" + integerReply(-1)},
+		{[]string{"HGETEX", "h", "EX", "100", "FIELDS", "2", "b", "missing"}, "*2\r\n" + bulkReply("2") + "$-1\r\n"},
+		{[]string{"HTTL", "h", "FIELDS", "1", "b"}, "*1\r\n" + integerReply(100)},
+		// HSET keeps no TTL
+		{[]string{"HSET", "h", "b", "6"}, integerReply(0)},
+		{[]string{"HTTL", "h", "FIELDS", "1", "b"}, "*1\r\n" + integerReply(-1)},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// `checkExpiryQueue` checks that the expiry queue of h is a min-heap
+// holding exactly the TTLs of h
+func checkExpiryQueue(t *testing.T, h *hash) {
+	t.Helper()
+	if len(h.expiryQueue) != len(h.expires) {
+		t.Fatalf("queue holds %d TTLs, the hash %d", len(h.expiryQueue), len(h.expires))
+	}
+	for i, expiry := range h.expiryQueue {
+		if expiry.index != i || h.expires[expiry.field] != expiry {
+			t.Fatalf("TTL of %s is misplaced in the queue", expiry.field)
+		}
+		if i > 0 && expiry.expire.Before(h.expiryQueue[(i-1)/2].expire) {
+			t.Fatalf("TTL of %s expires before its parent's", expiry.field)
+		}
+	}
+}
+
+func TestFieldExpiryQueue(t *testing.T) {
+	h := newHash()
+	base := time.UnixMilli(1_000_000)
+	for i := 0; i < 200; i++ {
+		field := strconv.Itoa(i)
+		h.setField(field, []byte("value"))
+		h.setExpiry(field, base.Add(time.Duration(rand.Intn(1000))*time.Millisecond))
+	}
+	checkExpiryQueue(t, h)
+	for i := 0; i < 200; i += 3 {
+		h.setExpiry(strconv.Itoa(i), base.Add(time.Duration(rand.Intn(1000))*time.Millisecond))
+	}
+	for i := 1; i < 200; i += 7 {
+		h.persist(strconv.Itoa(i))
+	}
+	for i := 2; i < 200; i += 11 {
+		h.deleteField(strconv.Itoa(i))
+	}
+	checkExpiryQueue(t, h)
+
+	now := base.Add(500 * time.Millisecond)
+	want := 0
+	for _, expiry := range h.expires {
+		if !expiry.expire.After(now) {
+			want++
+		}
+	}
+	if got := h.expireFields(now, 10); got != min(10, want) {
+		t.Fatalf("expireFields deleted %d fields with a limit of 10, %d expired", got, want)
+	}
+	h.expireFields(now, want)
+	checkExpiryQueue(t, h)
+	for field := range h.fields {
+		if expire, ok := h.expiry(field); ok && !expire.After(now) {
+			t.Fatalf("field %s expired but wasn't deleted", field)
+		}
+	}
+	for _, expiry := range h.expires {
+		if _, ok := h.fields[expiry.field]; !ok {
+			t.Fatalf("TTL of the deleted field %s was kept", expiry.field)
+		}
+	}
+}
+
+func TestHashFieldExpiry(t *testing.T) {
+	s := newStore()
+	run(t, s, "HSET", "h", "a", "1", "b", "2", "c", "3")
+	if got := run(t, s, "HPEXPIRE", "h", "100000", "FIELDS", "2", "a", "b"); got != "*2\r\n"+integerReply(1)+integerReply(1) {
+		t.Fatalf("HPEXPIRE replied %q", got)
+	}
+	// expire a by moving its TTL to the past
+	h := s.db["h"].value.(*hash)
+	h.setExpiry("a", time.Now().Add(-time.Millisecond))
+	if got := run(t, s, "HGET", "h", "a"); got != "$-1\r\n" {
+		t.Errorf("HGET of an expired field replied %q", got)
+	}
+	if got := run(t, s, "HLEN", "h"); got != integerReply(2) {
+		t.Errorf("HLEN replied %q", got)
+	}
+	// HSET clears the TTL of b
+	run(t, s, "HSET", "h", "b", "4")
+	if got := run(t, s, "HTTL", "h", "FIELDS", "1", "b"); got != "*1\r\n"+integerReply(-1) {
+		t.Errorf("HTTL of an overwritten field replied %q", got)
+	}
+	h.setExpiry("b", time.Now().Add(-time.Millisecond))
+	h.setExpiry("c", time.Now().Add(-time.Millisecond))
+	if got := run(t, s, "HLEN", "h"); got != integerReply(0) {
+		t.Errorf("HLEN of a hash whose fields expired replied %q", got)
+	}
+	if _, ok := s.db["h"]; ok {
+		t.Error("the hash whose fields expired wasn't deleted")
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	s := newStore()
+	const hashes = 500
+	for i := 0; i < hashes; i++ {
+		key := "h" + strconv.Itoa(i)
+		run(t, s, "HSET", key, "expiring", "1", "kept", "2")
+		run(t, s, "HPEXPIRE", key, "100000", "FIELDS", "1", "expiring")
+		s.db[key].value.(*hash).setExpiry("expiring", time.Now().Add(-time.Millisecond))
+	}
+	// a hash whose fields don't expire yet
+	run(t, s, "HSET", "later", "field", "1")
+	run(t, s, "HPEXPIRE", "later", "100000", "FIELDS", "1", "field")
+
+	for cycles := 0; ; cycles++ {
+		start := time.Now()
+		s.activeExpireCycle()
+		if elapsed := time.Since(start); elapsed > activeExpireBudget+100*time.Millisecond {
+			t.Errorf("cycle took %v, beyond its budget of %v", elapsed, activeExpireBudget)
+		}
+		expired := 0
+		for i := 0; i < hashes; i++ {
+			if _, ok := s.db["h"+strconv.Itoa(i)].value.(*hash).fields["expiring"]; !ok {
+				expired++
+			}
+		}
+		if expired == hashes {
+			break
+		}
+		if cycles == 100 {
+			t.Fatalf("only %d of %d hashes had their fields expired after %d cycles", expired, hashes, cycles)
+		}
+	}
+	if got := run(t, s, "HLEN", "later"); got != integerReply(1) {
+		t.Errorf("a field that didn't expire yet was deleted, HLEN replied %q", got)
+	}
+	for i := 0; i < hashes; i++ {
+		if got := run(t, s, "HGET", "h"+strconv.Itoa(i), "kept"); got != bulkReply("2") {
+			t.Fatalf("HGET of a field without a TTL replied %q", got)
+		}
+	}
+}
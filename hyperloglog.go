@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// HyperLogLogs are stored as string values using the same layout
+// as redis, so they can be read with GET, restored with SET and
+// persisted like any other string. The layout is a 16 byte header
+// followed by the registers:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// "E" is the encoding, dense or sparse, and "Cardin." is the cached
+// cardinality as a little endian 64 bit integer. The most significant
+// bit of its last byte is set when the cache is invalid.
+//
+// The dense encoding packs 16384 registers of 6 bits each. The sparse
+// encoding is a sequence of opcodes:
+//
+//	ZERO   00xxxxxx           - xxxxxx+1 registers set to 0
+//	XZERO  01xxxxxx yyyyyyyy  - xxxxxxyyyyyyyy+1 registers set to 0
+//	VAL    1vvvvvxx           - xx+1 registers set to vvvvv+1
+const (
+	hllP              = 14
+	hllQ              = 64 - hllP
+	hllRegisters      = 1 << hllP
+	hllBits           = 6
+	hllRegisterMax    = 1<<hllBits - 1
+	hllHeaderSize     = 16
+	hllDenseSize      = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense          = 0
+	hllSparse         = 1
+	hllSparseValMax   = 32
+	hllSparseMaxBytes = 3000
+	hllAlphaInf       = 0.721347520444481703680
+	hllWrongType      = "WRONGTYPE Key is not a valid HyperLogLog string value."
+	hllCorrupted      = "INVALIDOBJ Corrupted HLL object detected"
+)
+
+var errCorruptedHLL = errors.New("corrupted HLL object")
+
+// `murmurHash64A` is the 64 bit MurmurHash2 used by redis
+// to hash the elements added to a HyperLogLog
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(data)) * m)
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// `hllPatternLength` returns the register an element hashes to and
+// the length of the 000..1 pattern of the rest of its hash
+func hllPatternLength(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	// make sure the count is at most Q+1
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// `newHLL` returns an empty HyperLogLog, sparse encoded
+func newHLL() []byte {
+	hll := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(hll, "HYLL")
+	hll[4] = hllSparse
+	// a single XZERO opcode covers every register
+	return append(hll, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// `isHLL` reports whether a string holds a HyperLogLog
+func isHLL(str []byte) bool {
+	if len(str) < hllHeaderSize || string(str[:4]) != "HYLL" {
+		return false
+	}
+	switch str[4] {
+	case hllDense:
+		return len(str) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+// `hllDecode` unpacks the registers of a HyperLogLog
+func hllDecode(hll []byte) ([]uint8, error) {
+	registers := make([]uint8, hllRegisters)
+	data := hll[hllHeaderSize:]
+	if hll[4] == hllDense {
+		for i := 0; i < hllRegisters; i++ {
+			byteIndex := i * hllBits / 8
+			firstBit := uint(i * hllBits & 7)
+			b0 := uint(data[byteIndex])
+			var b1 uint
+			if byteIndex+1 < len(data) {
+				b1 = uint(data[byteIndex+1])
+			}
+			registers[i] = uint8((b0>>firstBit | b1<<(8-firstBit)) & hllRegisterMax)
+		}
+		return registers, nil
+	}
+	index := 0
+	for i := 0; i < len(data); {
+		opcode := data[i]
+		switch {
+		case opcode&0xc0 == 0x00: // ZERO
+			index += int(opcode&0x3f) + 1
+			i++
+		case opcode&0xc0 == 0x40: // XZERO
+			if i+1 >= len(data) {
+				return nil, errCorruptedHLL
+			}
+			index += (int(opcode&0x3f)<<8 | int(data[i+1])) + 1
+			i += 2
+		default: // VAL
+			value := (opcode>>2)&0x1f + 1
+			run := int(opcode&0x3) + 1
+			if index+run > hllRegisters {
+				return nil, errCorruptedHLL
+			}
+			for j := 0; j < run; j++ {
+				registers[index+j] = value
+			}
+			index += run
+			i++
+		}
+		if index > hllRegisters {
+			return nil, errCorruptedHLL
+		}
+	}
+	if index != hllRegisters {
+		return nil, errCorruptedHLL
+	}
+	return registers, nil
+}
+
+// `hllEncodeSparse` packs the registers using the sparse encoding.
+// It returns false if a register is too large for the sparse
+// encoding or the result would exceed hllSparseMaxBytes
+func hllEncodeSparse(registers []uint8) ([]byte, bool) {
+	hll := make([]byte, hllHeaderSize)
+	for i := 0; i < hllRegisters; {
+		value := registers[i]
+		run := 1
+		for i+run < hllRegisters && registers[i+run] == value {
+			run++
+		}
+		i += run
+		if value > hllSparseValMax {
+			return nil, false
+		}
+		for run > 0 {
+			switch {
+			case value == 0 && run > 64:
+				chunk := min(run, hllRegisters)
+				hll = append(hll, 0x40|byte((chunk-1)>>8), byte((chunk-1)&0xff))
+				run -= chunk
+			case value == 0:
+				hll = append(hll, byte(run-1))
+				run = 0
+			default:
+				chunk := min(run, 4)
+				hll = append(hll, 0x80|(value-1)<<2|byte(chunk-1))
+				run -= chunk
+			}
+		}
+		if len(hll) > hllHeaderSize+hllSparseMaxBytes {
+			return nil, false
+		}
+	}
+	copy(hll, "HYLL")
+	hll[4] = hllSparse
+	return hll, true
+}
+
+// `hllEncodeDense` packs the registers using the dense encoding
+func hllEncodeDense(registers []uint8) []byte {
+	hll := make([]byte, hllDenseSize)
+	copy(hll, "HYLL")
+	hll[4] = hllDense
+	data := hll[hllHeaderSize:]
+	for i, value := range registers {
+		byteIndex := i * hllBits / 8
+		firstBit := uint(i * hllBits & 7)
+		data[byteIndex] |= value << firstBit
+		if byteIndex+1 < len(data) {
+			data[byteIndex+1] |= value >> (8 - firstBit)
+		}
+	}
+	return hll
+}
+
+// `hllEncode` packs the registers, using the sparse encoding if
+// allowed and possible. The cached cardinality is left invalid
+func hllEncode(registers []uint8, sparse bool) []byte {
+	var hll []byte
+	ok := false
+	if sparse {
+		hll, ok = hllEncodeSparse(registers)
+	}
+	if !ok {
+		hll = hllEncodeDense(registers)
+	}
+	hll[15] |= 0x80
+	return hll
+}
+
+// `hllTau` and `hllSigma` are used by the cardinality estimator
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		previous := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if previous == z {
+			return z / 3
+		}
+	}
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		previous := z
+		z += x * y
+		y += y
+		if previous == z {
+			return z
+		}
+	}
+}
+
+// `hllCount` estimates the cardinality from the registers using
+// the improved estimator of "New cardinality estimation algorithms
+// for HyperLogLog sketches" by Otmar Ertl, like redis does
+func hllCount(registers []uint8) uint64 {
+	m := float64(hllRegisters)
+	var histogram [64]int
+	for _, value := range registers {
+		histogram[value]++
+	}
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// `getHLL` retrieves the HyperLogLog stored at key. It returns
+// an error reply if the key holds another value
+func (s *store) getHLL(key string) (*redisValue, []byte, error) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, nil, nil
+	}
+	if value.valueType != "string" || !isHLL(value.value.([]byte)) {
+		reply, err := errorReply(hllWrongType)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, reply, nil
+	}
+	return value, nil, nil
+}
+
+// PFADD command adds elements to the HyperLogLog stored at key
+func pfadd(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("pfadd")
+	}
+	key := string(args[0])
+	value, reply, err := s.getHLL(key)
+	if reply != nil || err != nil {
+		return reply, err
+	}
+	updated := false
+	if value == nil {
+		value = &redisValue{
+			value:     newHLL(),
+			valueType: "string",
+		}
+		updated = true
+	}
+	hll := value.value.([]byte)
+	registers, err := hllDecode(hll)
+	if err != nil {
+		return errorReply(hllCorrupted)
+	}
+	changed := false
+	for _, element := range args[1:] {
+		index, count := hllPatternLength(element)
+		if count > registers[index] {
+			registers[index] = count
+			changed = true
+		}
+	}
+	if changed {
+		value.value = hllEncode(registers, hll[4] == hllSparse)
+		updated = true
+	}
+	response := resp.Integer{}
+	if updated {
+		s.set(key, value)
+		s.notifyKeyspaceEvent(notifyString, "pfadd", key)
+		response.Data = 1
+	}
+	return response.Serialise()
+}
+
+// PFCOUNT command returns the approximate cardinality of the
+// HyperLogLog stored at key, or of the union of multiple ones
+func pfcount(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("pfcount")
+	}
+	response := resp.Integer{}
+	if len(args) == 1 {
+		value, reply, err := s.getHLL(string(args[0]))
+		if reply != nil || err != nil {
+			return reply, err
+		}
+		if value == nil {
+			return response.Serialise()
+		}
+		hll := value.value.([]byte)
+		// use the cached cardinality if it's valid
+		if hll[15]&0x80 == 0 {
+			response.Data = int64(binary.LittleEndian.Uint64(hll[8:16]))
+			return response.Serialise()
+		}
+		registers, err := hllDecode(hll)
+		if err != nil {
+			return errorReply(hllCorrupted)
+		}
+		cardinality := hllCount(registers)
+		binary.LittleEndian.PutUint64(hll[8:16], cardinality)
+		response.Data = int64(cardinality)
+		return response.Serialise()
+	}
+	union := make([]uint8, hllRegisters)
+	for _, key := range args {
+		value, reply, err := s.getHLL(string(key))
+		if reply != nil || err != nil {
+			return reply, err
+		}
+		if value == nil {
+			continue
+		}
+		registers, err := hllDecode(value.value.([]byte))
+		if err != nil {
+			return errorReply(hllCorrupted)
+		}
+		for i, register := range registers {
+			union[i] = max(union[i], register)
+		}
+	}
+	response.Data = int64(hllCount(union))
+	return response.Serialise()
+}
+
+// PFMERGE command merges HyperLogLogs into destkey, which
+// is included in the union if it already exists
+func pfmerge(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("pfmerge")
+	}
+	destination := string(args[0])
+	union := make([]uint8, hllRegisters)
+	sparse := true
+	var destinationValue *redisValue
+	for i, key := range args {
+		value, reply, err := s.getHLL(string(key))
+		if reply != nil || err != nil {
+			return reply, err
+		}
+		if value == nil {
+			continue
+		}
+		if i == 0 {
+			destinationValue = value
+		}
+		hll := value.value.([]byte)
+		// the result is dense if any of the inputs is dense
+		if hll[4] == hllDense {
+			sparse = false
+		}
+		registers, err := hllDecode(hll)
+		if err != nil {
+			return errorReply(hllCorrupted)
+		}
+		for j, register := range registers {
+			union[j] = max(union[j], register)
+		}
+	}
+	if destinationValue == nil {
+		destinationValue = &redisValue{
+			valueType: "string",
+		}
+	}
+	destinationValue.value = hllEncode(union, sparse)
+	s.set(destination, destinationValue)
+	s.notifyKeyspaceEvent(notifyString, "pfadd", destination)
+	response := resp.SimpleString{
+		Data: "OK",
+	}
+	return response.Serialise()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestMurmurHash64A(t *testing.T) {
+	// computed with the reference implementation of MurmurHash64A,
+	// seeded the way redis seeds it for HyperLogLogs
+	tests := []struct {
+		data string
+		want uint64
+	}{
+		{"", 0xd8dfea6585bc9732},
+		{"a", 0x53d2470a9b43b1a7},
+		{"foo", 0xe64609b8b0141cb4},
+		{"hello world", 0xa919bc3051f624b7},
+		{"0123456789abcdef", 0x9f8565428eaa573d},
+		{"The quick brown fox jumps over the lazy dog", 0x51606c5c5b561ace},
+	}
+	for _, test := range tests {
+		if got := murmurHash64A([]byte(test.data), 0xadc83b19); got != test.want {
+			t.Errorf("murmurHash64A(%q) = %#x, want %#x", test.data, got, test.want)
+		}
+	}
+}
+
+func TestHLLEncoding(t *testing.T) {
+	empty, err := hllDecode(newHLL())
+	if err != nil || slices.ContainsFunc(empty, func(r uint8) bool { return r != 0 }) {
+		t.Fatalf("a new HyperLogLog decoded to non zero registers, %v", err)
+	}
+	for _, filled := range []int{0, 1, 10, 100, 1000, hllRegisters} {
+		registers := make([]uint8, hllRegisters)
+		for i := 0; i < filled; i++ {
+			registers[rand.Intn(hllRegisters)] = uint8(1 + rand.Intn(hllSparseValMax))
+		}
+		// runs of equal registers longer than a VAL opcode holds
+		for i := 0; i < 10 && filled > 0; i++ {
+			registers[100+i] = 3
+		}
+		dense := hllEncodeDense(registers)
+		if !isHLL(dense) || dense[4] != hllDense {
+			t.Fatalf("the dense encoding of %d registers isn't a dense HyperLogLog", filled)
+		}
+		if decoded, err := hllDecode(dense); err != nil || !slices.Equal(decoded, registers) {
+			t.Fatalf("the dense encoding of %d registers decoded differently, %v", filled, err)
+		}
+		sparse, ok := hllEncodeSparse(registers)
+		if filled > 1000 {
+			// the registers don't fit the sparse encoding's limit
+			if ok {
+				t.Errorf("%d registers were sparse encoded in %d bytes", filled, len(sparse))
+			}
+			continue
+		}
+		if !ok || !isHLL(sparse) || sparse[4] != hllSparse {
+			t.Fatalf("%d registers weren't sparse encoded", filled)
+		}
+		if decoded, err := hllDecode(sparse); err != nil || !slices.Equal(decoded, registers) {
+			t.Fatalf("the sparse encoding of %d registers decoded differently, %v", filled, err)
+		}
+	}
+	// registers beyond the largest VAL value need the dense encoding
+	registers := make([]uint8, hllRegisters)
+	registers[7] = hllSparseValMax + 1
+	if _, ok := hllEncodeSparse(registers); ok {
+		t.Error("a register larger than a VAL opcode holds was sparse encoded")
+	}
+	if hll := hllEncode(registers, true); hll[4] != hllDense || hll[15]&0x80 == 0 {
+		t.Error("hllEncode didn't fall back to the dense encoding with an invalid cached cardinality")
+	}
+
+	corrupted := [][]byte{
+		// a truncated XZERO
+		append(newHLL()[:hllHeaderSize], 0x40),
+		// too few registers
+		append(newHLL()[:hllHeaderSize], 0x00),
+		// too many registers
+		append(newHLL(), 0x00),
+		// a VAL opcode past the last register
+		append(append(newHLL()[:hllHeaderSize], 0x7f, 0xfe), 0x83),
+	}
+	for _, hll := range corrupted {
+		if _, err := hllDecode(hll); err == nil {
+			t.Errorf("the corrupted HyperLogLog %x was decoded", hll[hllHeaderSize:])
+		}
+	}
+}
+
+func TestHLLCount(t *testing.T) {
+	registers := make([]uint8, hllRegisters)
+	added := 0
+	for _, cardinality := range []int{1, 10, 100, 1000, 10000, 100000, 1000000} {
+		for ; added < cardinality; added++ {
+			index, count := hllPatternLength([]byte(strconv.Itoa(added)))
+			registers[index] = max(registers[index], count)
+		}
+		estimate := float64(hllCount(registers))
+		// three times the standard error of 0.81%, small
+		// cardinalities are exact
+		if cardinality <= 100 && estimate != float64(cardinality) ||
+			math.Abs(estimate-float64(cardinality)) > 0.0243*float64(cardinality) {
+			t.Errorf("estimated %.0f elements, want %d", estimate, cardinality)
+		}
+	}
+}
+
+func TestHLLCommands(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PFADD", "hll"}, integerReply(1)},
+		{[]string{"PFADD", "hll"}, integerReply(0)},
+		{[]string{"PFCOUNT", "hll"}, integerReply(0)},
+		{[]string{"PFADD", "hll", "a", "b", "c", "d", "e", "f", "g"}, integerReply(1)},
+		{[]string{"PFADD", "hll", "a", "b"}, integerReply(0)},
+		{[]string{"PFCOUNT", "hll"}, integerReply(7)},
+		{[]string{"PFADD", "other", "g", "h", "i"}, integerReply(1)},
+		{[]string{"PFCOUNT", "hll", "other", "missing"}, integerReply(9)},
+		{[]string{"PFMERGE", "merged", "hll", "other"}, "+OK\r\n"},
+		{[]string{"PFCOUNT", "merged"}, integerReply(9)},
+		{[]string{"SET", "str", "value"}, "+OK\r\n"},
+		{[]string{"PFADD", "str", "a"}, "-" + hllWrongType + "\r\n"},
+		{[]string{"PFCOUNT", "str"}, "-" + hllWrongType + "\r\n"},
+		{[]string{"SET", "bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x40"}, "+OK\r\n"},
+		{[]string{"PFADD", "bad", "a"}, "-" + hllCorrupted + "\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q replied %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	// the value is a string that can be copied with GET and SET
+	copied := run(t, s, "GET", "merged")
+	run(t, s, "SET", "copy", copied[len("$00\r\n"):len(copied)-2])
+	if got := run(t, s, "PFCOUNT", "copy"); got != integerReply(9) {
+		t.Errorf("PFCOUNT of a copied HyperLogLog replied %q", got)
+	}
+
+	// enough elements switch the encoding to dense
+	args := []string{"PFADD", "large"}
+	for i := 0; i < 5000; i++ {
+		args = append(args, "element"+strconv.Itoa(i))
+	}
+	run(t, s, args...)
+	if hll := s.db["large"].value.([]byte); hll[4] != hllDense {
+		t.Error("5000 elements are still sparse encoded")
+	}
+	got := run(t, s, "PFCOUNT", "large")
+	// the cached cardinality is returned the next time
+	if again := run(t, s, "PFCOUNT", "large"); again != got {
+		t.Errorf("PFCOUNT replied %q then %q", got, again)
+	}
+	if hll := s.db["large"].value.([]byte); hll[15]&0x80 != 0 {
+		t.Error("PFCOUNT didn't cache the cardinality")
+	}
+	run(t, s, "PFADD", "large", "one more element")
+	if hll := s.db["large"].value.([]byte); hll[15]&0x80 == 0 {
+		t.Error("PFADD didn't invalidate the cached cardinality")
+	}
+}
@@ -0,0 +1,1255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// JSON documents are trees of the following values: nil, bool,
+// int64, float64, string, *jsonObject and *jsonArray. Integers and
+// floats are kept apart so that integers stay exact, and objects
+// keep their keys in insertion order
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+type jsonArray struct {
+	elements []any
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{
+		values: make(map[string]any),
+	}
+}
+
+// `set` sets the value of key, appending it when it's new
+func (object *jsonObject) set(key string, value any) {
+	if _, ok := object.values[key]; !ok {
+		object.keys = append(object.keys, key)
+	}
+	object.values[key] = value
+}
+
+// `delete` removes key from the object
+func (object *jsonObject) delete(key string) {
+	delete(object.values, key)
+	object.keys = slices.DeleteFunc(object.keys, func(k string) bool {
+		return k == key
+	})
+}
+
+// `jsonDocument` holds the root of a document, which paths
+// may replace
+type jsonDocument struct {
+	root any
+}
+
+var (
+	errInvalidJSON = errors.New("invalid JSON")
+	errJSONTooDeep = errors.New("JSON nesting exceeds the recursion limit")
+)
+
+// `maxJSONDepth` is the deepest nesting of objects and arrays a
+// JSON text may have, the limit RedisJSON parses with, which keeps
+// the recursive parsing from overflowing the stack
+const maxJSONDepth = 128
+
+// `parseJSON` parses a JSON text into a value
+func parseJSON(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeJSONValue(decoder, 0)
+	if err == errJSONTooDeep {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errInvalidJSON
+	}
+	// the text must hold a single value
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errInvalidJSON
+	}
+	return value, nil
+}
+
+// `decodeJSONValue` decodes the next value, depth being the number
+// of objects and arrays it's nested in
+func decodeJSONValue(decoder *json.Decoder, depth int) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if depth == maxJSONDepth {
+			return nil, errJSONTooDeep
+		}
+		switch t {
+		case '{':
+			object := newJSONObject()
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSONValue(decoder, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				object.set(key.(string), value)
+			}
+			// consume the closing delimiter
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return object, nil
+		case '[':
+			array := &jsonArray{}
+			for decoder.More() {
+				value, err := decodeJSONValue(decoder, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				array.elements = append(array.elements, value)
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return array, nil
+		}
+		return nil, errInvalidJSON
+	case json.Number:
+		return parseJSONNumber(string(t))
+	case string, bool, nil:
+		return t, nil
+	}
+	return nil, errInvalidJSON
+}
+
+// `parseJSONNumber` parses a number as an int64 when it's an integer
+// that fits one, and as a float64 otherwise
+func parseJSONNumber(number string) (any, error) {
+	if !strings.ContainsAny(number, ".eE") {
+		if integer, err := strconv.ParseInt(number, 10, 64); err == nil {
+			return integer, nil
+		}
+	}
+	float, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return nil, errInvalidJSON
+	}
+	return float, nil
+}
+
+// `cloneJSON` returns a deep copy of a value
+func cloneJSON(value any) any {
+	switch v := value.(type) {
+	case *jsonObject:
+		object := newJSONObject()
+		for _, key := range v.keys {
+			object.set(key, cloneJSON(v.values[key]))
+		}
+		return object
+	case *jsonArray:
+		array := &jsonArray{elements: make([]any, len(v.elements))}
+		for i, element := range v.elements {
+			array.elements[i] = cloneJSON(element)
+		}
+		return array
+	}
+	return value
+}
+
+// `jsonTypeName` returns the type of a value as JSON.TYPE names it
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case *jsonObject:
+		return "object"
+	case *jsonArray:
+		return "array"
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// `jsonFormat` holds the INDENT, NEWLINE and SPACE options of JSON.GET
+type jsonFormat struct {
+	indent, newline, space string
+}
+
+// `formatJSONFloat` formats a float the shortest way that parses back
+// to the same float, keeping a fractional part so it reads back as
+// a float
+func formatJSONFloat(float float64) string {
+	formatted := strconv.FormatFloat(float, 'g', -1, 64)
+	if abs := math.Abs(float); abs == 0 || (abs >= 1e-5 && abs < 1e16) {
+		formatted = strconv.FormatFloat(float, 'f', -1, 64)
+	}
+	if !strings.ContainsAny(formatted, ".e") {
+		formatted += ".0"
+	}
+	return formatted
+}
+
+// `appendJSONString` appends a string quoted and escaped
+func appendJSONString(buffer []byte, str string) []byte {
+	const hex = "0123456789abcdef"
+	buffer = append(buffer, '"')
+	for i := 0; i < len(str); {
+		c := str[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(str[i:])
+			if r == utf8.RuneError && size == 1 {
+				buffer = append(buffer, `�`...)
+			} else {
+				buffer = append(buffer, str[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			buffer = append(buffer, '\\', c)
+		case '\n':
+			buffer = append(buffer, '\\', 'n')
+		case '\r':
+			buffer = append(buffer, '\\', 'r')
+		case '\t':
+			buffer = append(buffer, '\\', 't')
+		case '\b':
+			buffer = append(buffer, '\\', 'b')
+		case '\f':
+			buffer = append(buffer, '\\', 'f')
+		default:
+			if c < 0x20 {
+				buffer = append(buffer, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			} else {
+				buffer = append(buffer, c)
+			}
+		}
+		i++
+	}
+	return append(buffer, '"')
+}
+
+// `appendJSON` appends a value serialised with the given format,
+// depth being the nesting level of the value
+func appendJSON(buffer []byte, value any, format jsonFormat, depth int) []byte {
+	// `appendIndent` starts a new line at the given depth
+	appendIndent := func(depth int) {
+		buffer = append(buffer, format.newline...)
+		for i := 0; i < depth; i++ {
+			buffer = append(buffer, format.indent...)
+		}
+	}
+	switch v := value.(type) {
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			return append(buffer, "{}"...)
+		}
+		buffer = append(buffer, '{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buffer = append(buffer, ',')
+			}
+			appendIndent(depth + 1)
+			buffer = appendJSONString(buffer, key)
+			buffer = append(buffer, ':')
+			buffer = append(buffer, format.space...)
+			buffer = appendJSON(buffer, v.values[key], format, depth+1)
+		}
+		appendIndent(depth)
+		return append(buffer, '}')
+	case *jsonArray:
+		if len(v.elements) == 0 {
+			return append(buffer, "[]"...)
+		}
+		buffer = append(buffer, '[')
+		for i, element := range v.elements {
+			if i > 0 {
+				buffer = append(buffer, ',')
+			}
+			appendIndent(depth + 1)
+			buffer = appendJSON(buffer, element, format, depth+1)
+		}
+		appendIndent(depth)
+		return append(buffer, ']')
+	case string:
+		return appendJSONString(buffer, v)
+	case int64:
+		return strconv.AppendInt(buffer, v, 10)
+	case float64:
+		return append(buffer, formatJSONFloat(v)...)
+	case bool:
+		return strconv.AppendBool(buffer, v)
+	}
+	return append(buffer, "null"...)
+}
+
+// `serialiseJSON` serialises a value compactly
+func serialiseJSON(value any) []byte {
+	return appendJSON(nil, value, jsonFormat{}, 0)
+}
+
+// Paths are either JSONPaths, which start with "$" and match any
+// number of values, or legacy paths such as ".a.b" or "a[0]", which
+// address a single value. The JSONPath subset supported is made up of
+// the root "$", children by name ".name" or ['name'], array indices
+// [n] counting from the end when negative, slices [start:end], the
+// wildcards .* and [*], and the recursive descent "..", such as
+// "$..name"
+const (
+	jsonStepKey = iota
+	jsonStepIndex
+	jsonStepWildcard
+	jsonStepSlice
+)
+
+type jsonStep struct {
+	kind  int
+	key   string
+	index int
+	// bounds of a slice, which default to the whole array
+	start, end       int
+	hasStart, hasEnd bool
+	// recursive applies the step to every value nested below
+	recursive bool
+}
+
+type jsonPath struct {
+	text   string
+	steps  []jsonStep
+	legacy bool
+}
+
+// `isRoot` reports whether the path addresses the root
+func (path jsonPath) isRoot() bool {
+	return len(path.steps) == 0
+}
+
+// `parseJSONBracket` parses a bracketed step, rest starting with '['.
+// It returns the step and what follows it
+func parseJSONBracket(rest string) (jsonStep, string, bool) {
+	var step jsonStep
+	if len(rest) > 2 && (rest[1] == '\'' || rest[1] == '"') {
+		quote := rest[1]
+		var key strings.Builder
+		for i := 2; i < len(rest); i++ {
+			switch rest[i] {
+			case '\\':
+				if i+1 == len(rest) {
+					return step, "", false
+				}
+				i++
+				key.WriteByte(rest[i])
+			case quote:
+				if i+1 == len(rest) || rest[i+1] != ']' {
+					return step, "", false
+				}
+				step.kind = jsonStepKey
+				step.key = key.String()
+				return step, rest[i+2:], true
+			default:
+				key.WriteByte(rest[i])
+			}
+		}
+		return step, "", false
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return step, "", false
+	}
+	content := strings.TrimSpace(rest[1:end])
+	rest = rest[end+1:]
+	if content == "*" {
+		step.kind = jsonStepWildcard
+		return step, rest, true
+	}
+	if startText, endText, isSlice := strings.Cut(content, ":"); isSlice {
+		step.kind = jsonStepSlice
+		if startText = strings.TrimSpace(startText); startText != "" {
+			start, err := strconv.Atoi(startText)
+			if err != nil {
+				return step, "", false
+			}
+			step.start, step.hasStart = start, true
+		}
+		if endText = strings.TrimSpace(endText); endText != "" {
+			end, err := strconv.Atoi(endText)
+			if err != nil {
+				return step, "", false
+			}
+			step.end, step.hasEnd = end, true
+		}
+		return step, rest, true
+	}
+	index, err := strconv.Atoi(content)
+	if err != nil {
+		return step, "", false
+	}
+	step.kind = jsonStepIndex
+	step.index = index
+	return step, rest, true
+}
+
+// `parseJSONPath` parses a JSONPath or a legacy path
+func parseJSONPath(text string) (jsonPath, bool) {
+	path := jsonPath{text: text}
+	rest, isJSONPath := strings.CutPrefix(text, "$")
+	if !isJSONPath {
+		path.legacy = true
+		switch {
+		case text == ".":
+			rest = ""
+		case text == "":
+			return path, false
+		case text[0] != '.' && text[0] != '[':
+			rest = "." + text
+		}
+	}
+	for len(rest) > 0 {
+		var step jsonStep
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] != '[':
+			return path, false
+		}
+		if strings.HasPrefix(rest, "[") {
+			bracket, remaining, ok := parseJSONBracket(rest)
+			if !ok {
+				return path, false
+			}
+			bracket.recursive = step.recursive
+			step, rest = bracket, remaining
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return path, false
+			case "*":
+				step.kind = jsonStepWildcard
+			default:
+				step.kind = jsonStepKey
+				step.key = name
+			}
+		}
+		path.steps = append(path.steps, step)
+	}
+	return path, true
+}
+
+// `jsonNode` is a value matched by a path, along with the object
+// or array holding it, nil for the root
+type jsonNode struct {
+	value  any
+	parent any
+	key    string
+	index  int
+}
+
+// `jsonDescendants` returns node followed by every value nested
+// below it, in document order
+func jsonDescendants(node jsonNode, nodes []jsonNode) []jsonNode {
+	nodes = append(nodes, node)
+	switch v := node.value.(type) {
+	case *jsonObject:
+		for _, key := range v.keys {
+			nodes = jsonDescendants(jsonNode{value: v.values[key], parent: v, key: key}, nodes)
+		}
+	case *jsonArray:
+		for i, element := range v.elements {
+			nodes = jsonDescendants(jsonNode{value: element, parent: v, index: i}, nodes)
+		}
+	}
+	return nodes
+}
+
+// `children` returns the values the step selects below node
+func (step jsonStep) children(node jsonNode, nodes []jsonNode) []jsonNode {
+	switch v := node.value.(type) {
+	case *jsonObject:
+		switch step.kind {
+		case jsonStepKey:
+			if value, ok := v.values[step.key]; ok {
+				nodes = append(nodes, jsonNode{value: value, parent: v, key: step.key})
+			}
+		case jsonStepWildcard:
+			for _, key := range v.keys {
+				nodes = append(nodes, jsonNode{value: v.values[key], parent: v, key: key})
+			}
+		}
+	case *jsonArray:
+		length := len(v.elements)
+		start, end := 0, 0
+		switch step.kind {
+		case jsonStepIndex:
+			start = step.index
+			if start < 0 {
+				start += length
+			}
+			end = start + 1
+		case jsonStepWildcard:
+			start, end = 0, length
+		case jsonStepSlice:
+			start, end = 0, length
+			if step.hasStart {
+				start = step.start
+				if start < 0 {
+					start += length
+				}
+			}
+			if step.hasEnd {
+				end = step.end
+				if end < 0 {
+					end += length
+				}
+			}
+		}
+		for i := max(start, 0); i < min(end, length); i++ {
+			nodes = append(nodes, jsonNode{value: v.elements[i], parent: v, index: i})
+		}
+	}
+	return nodes
+}
+
+// `evaluate` returns the values of the document matched by path
+func (doc *jsonDocument) evaluate(path jsonPath) []jsonNode {
+	nodes := []jsonNode{{value: doc.root}}
+	for _, step := range path.steps {
+		var next []jsonNode
+		for _, node := range nodes {
+			candidates := []jsonNode{node}
+			if step.recursive {
+				candidates = jsonDescendants(node, nil)
+			}
+			for _, candidate := range candidates {
+				next = step.children(candidate, next)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// `replace` replaces the value at node
+func (doc *jsonDocument) replace(node jsonNode, value any) {
+	switch parent := node.parent.(type) {
+	case *jsonObject:
+		parent.values[node.key] = value
+	case *jsonArray:
+		parent.elements[node.index] = value
+	default:
+		doc.root = value
+	}
+}
+
+// `getJSON` retrieves the document stored at key. It also reports
+// whether the key exists and whether it holds a document
+func (s *store) getJSON(key string) (*jsonDocument, bool, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, false, true
+	}
+	if value.valueType != "json" {
+		return nil, true, false
+	}
+	return value.value.(*jsonDocument), true, true
+}
+
+// `jsonPathError` serialises the error reply for a path that
+// can't be parsed
+func jsonPathError(path string) ([]byte, error) {
+	return errorReply("invalid JSONPath '" + path + "'")
+}
+
+// `jsonMissingPath` serialises the error reply for a legacy
+// path matching no value
+func jsonMissingPath(path jsonPath) ([]byte, error) {
+	return errorReply("Path '" + path.text + "' does not exist")
+}
+
+// `jsonWrongType` serialises the error reply for a legacy path
+// matching a value of the wrong type
+func jsonWrongType(expected string, value any) ([]byte, error) {
+	return errorReply("WRONGTYPE wrong type of path value - expected " + expected + " but found " + jsonTypeName(value))
+}
+
+// `jsonTarget` looks up the document at key and the values of the
+// path matches in it. The document is nil when the key doesn't exist,
+// a non nil reply is the error to reply with
+func (s *store) jsonTarget(key string, pathArg []byte) (*jsonDocument, jsonPath, []jsonNode, []byte, error) {
+	path, ok := parseJSONPath(string(pathArg))
+	if !ok {
+		response, err := jsonPathError(string(pathArg))
+		return nil, path, nil, response, err
+	}
+	doc, exists, isJSON := s.getJSON(key)
+	if !isJSON {
+		response, err := wrongType()
+		return nil, path, nil, response, err
+	}
+	if !exists {
+		return nil, path, nil, nil, nil
+	}
+	return doc, path, doc.evaluate(path), nil, nil
+}
+
+// `jsonApply` runs apply on the values matched by path that are of
+// the expected type. A JSONPath is replied with the result for each
+// value matched, nil for values of another type, and a legacy path
+// with the result for the last value, or with an error if a value
+// is of another type
+func jsonApply(path jsonPath, nodes []jsonNode, expected string, apply func(node jsonNode) resp.RESPDatatype) ([]byte, error) {
+	isExpected := func(value any) bool {
+		typeName := jsonTypeName(value)
+		return typeName == expected || (expected == "number" && typeName == "integer")
+	}
+	if path.legacy {
+		if len(nodes) == 0 {
+			return jsonMissingPath(path)
+		}
+		for _, node := range nodes {
+			if !isExpected(node.value) {
+				return jsonWrongType(expected, node.value)
+			}
+		}
+		var result resp.RESPDatatype
+		for _, node := range nodes {
+			result = apply(node)
+		}
+		return result.Serialise()
+	}
+	response := resp.Array{Size: len(nodes)}
+	for _, node := range nodes {
+		if isExpected(node.value) {
+			response.Elements = append(response.Elements, apply(node))
+		} else {
+			response.Elements = append(response.Elements, &resp.BulkString{Size: -1})
+		}
+	}
+	return response.Serialise()
+}
+
+// `jsonMissingKey` serialises the error reply for a command
+// modifying a document at a key that doesn't exist
+func jsonMissingKey() ([]byte, error) {
+	return errorReply("could not perform this operation on a key that doesn't exist")
+}
+
+// JSON.SET command sets the values matched by path to a JSON value
+func jsonSet(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return wrongNumberOfArgs("json.set")
+	}
+	var nx, xx bool
+	if len(args) == 4 {
+		switch strings.ToUpper(string(args[3])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errorReply("invalid syntax")
+		}
+	}
+	key := string(args[0])
+	value, err := parseJSON(args[2])
+	if err == errJSONTooDeep {
+		return errorReply(err.Error())
+	}
+	if err != nil {
+		return errorReply("invalid JSON value")
+	}
+	doc, path, nodes, response, err := s.jsonTarget(key, args[1])
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		if !path.isRoot() {
+			return errorReply("new objects must be created at the root")
+		}
+		if xx {
+			return nilBulkString()
+		}
+		s.set(key, &redisValue{
+			value:     &jsonDocument{root: value},
+			valueType: "json",
+		})
+		s.notifyKeyspaceEvent(notifyModule, "json.set", key)
+		ok := resp.SimpleString{Data: "OK"}
+		return ok.Serialise()
+	}
+	if len(nodes) > 0 {
+		if nx {
+			return nilBulkString()
+		}
+		for i, node := range nodes {
+			if i > 0 {
+				value = cloneJSON(value)
+			}
+			doc.replace(node, value)
+		}
+	} else {
+		// a missing object member is added when its object exists
+		last := path.steps[len(path.steps)-1]
+		if xx || last.kind != jsonStepKey || last.recursive {
+			return nilBulkString()
+		}
+		parentPath := jsonPath{steps: path.steps[:len(path.steps)-1]}
+		added := false
+		for _, parent := range doc.evaluate(parentPath) {
+			if object, ok := parent.value.(*jsonObject); ok {
+				if added {
+					value = cloneJSON(value)
+				}
+				object.set(last.key, value)
+				added = true
+			}
+		}
+		if !added {
+			if path.legacy {
+				return jsonMissingPath(path)
+			}
+			return nilBulkString()
+		}
+	}
+	s.notifyKeyspaceEvent(notifyModule, "json.set", key)
+	ok := resp.SimpleString{Data: "OK"}
+	return ok.Serialise()
+}
+
+// JSON.GET command returns the values matched by paths, serialised
+func jsonGet(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("json.get")
+	}
+	var format jsonFormat
+	i := 1
+	// the formatting options come before the paths
+	for options := true; options && i+1 < len(args); {
+		switch strings.ToUpper(string(args[i])) {
+		case "INDENT":
+			format.indent = string(args[i+1])
+		case "NEWLINE":
+			format.newline = string(args[i+1])
+		case "SPACE":
+			format.space = string(args[i+1])
+		default:
+			options = false
+			continue
+		}
+		i += 2
+	}
+	pathArgs := args[i:]
+	if len(pathArgs) == 0 {
+		pathArgs = [][]byte{[]byte(".")}
+	}
+	paths := make([]jsonPath, len(pathArgs))
+	legacy := true
+	for j, arg := range pathArgs {
+		path, ok := parseJSONPath(string(arg))
+		if !ok {
+			return jsonPathError(string(arg))
+		}
+		paths[j] = path
+		legacy = legacy && path.legacy
+	}
+	doc, exists, isJSON := s.getJSON(string(args[0]))
+	if !isJSON {
+		return wrongType()
+	}
+	if !exists {
+		return nilBulkString()
+	}
+	// `result` returns the values matched by path, as an array
+	// unless all paths are legacy ones
+	result := func(path jsonPath) (any, bool) {
+		nodes := doc.evaluate(path)
+		if legacy {
+			if len(nodes) == 0 {
+				return nil, false
+			}
+			return nodes[0].value, true
+		}
+		matches := &jsonArray{elements: make([]any, 0, len(nodes))}
+		for _, node := range nodes {
+			matches.elements = append(matches.elements, node.value)
+		}
+		return matches, true
+	}
+	var value any
+	if len(paths) == 1 {
+		var ok bool
+		if value, ok = result(paths[0]); !ok {
+			return jsonMissingPath(paths[0])
+		}
+	} else {
+		// several paths are replied with an object of each
+		// path's values
+		object := newJSONObject()
+		for _, path := range paths {
+			matches, ok := result(path)
+			if !ok {
+				return jsonMissingPath(path)
+			}
+			object.set(path.text, matches)
+		}
+		value = object
+	}
+	data := appendJSON(nil, value, format, 0)
+	response := resp.BulkString{Data: data, Size: len(data)}
+	return response.Serialise()
+}
+
+// JSON.DEL command deletes the values matched by path
+func jsonDel(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs("json.del")
+	}
+	pathArg := []byte("$")
+	if len(args) == 2 {
+		pathArg = args[1]
+	}
+	key := string(args[0])
+	doc, path, nodes, response, err := s.jsonTarget(key, pathArg)
+	if response != nil {
+		return response, err
+	}
+	deleted := resp.Integer{}
+	if doc == nil {
+		return deleted.Serialise()
+	}
+	if path.isRoot() {
+		delete(s.db, key)
+		s.notifyKeyspaceEvent(notifyModule, "json.del", key)
+		deleted.Data = 1
+		return deleted.Serialise()
+	}
+	// array elements are removed once all of them are known, so
+	// that the indices of the nodes stay valid
+	removed := make(map[*jsonArray][]int)
+	for _, node := range nodes {
+		switch parent := node.parent.(type) {
+		case *jsonObject:
+			if _, ok := parent.values[node.key]; ok {
+				parent.delete(node.key)
+				deleted.Data++
+			}
+		case *jsonArray:
+			if !slices.Contains(removed[parent], node.index) {
+				removed[parent] = append(removed[parent], node.index)
+				deleted.Data++
+			}
+		}
+	}
+	for array, indices := range removed {
+		slices.Sort(indices)
+		for j := len(indices) - 1; j >= 0; j-- {
+			array.elements = slices.Delete(array.elements, indices[j], indices[j]+1)
+		}
+	}
+	if deleted.Data > 0 {
+		s.notifyKeyspaceEvent(notifyModule, "json.del", key)
+	}
+	return deleted.Serialise()
+}
+
+// JSON.TYPE command returns the types of the values matched by path
+func jsonType(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs("json.type")
+	}
+	pathArg := []byte(".")
+	if len(args) == 2 {
+		pathArg = args[1]
+	}
+	doc, path, nodes, response, err := s.jsonTarget(string(args[0]), pathArg)
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return nilBulkString()
+	}
+	if path.legacy {
+		if len(nodes) == 0 {
+			return nilBulkString()
+		}
+		return bulkStringOf(jsonTypeName(nodes[0].value)).Serialise()
+	}
+	types := make([]string, len(nodes))
+	for i, node := range nodes {
+		types[i] = jsonTypeName(node.value)
+	}
+	return membersToRESPArray(types)
+}
+
+// JSON.NUMINCRBY command increments the numbers matched by path
+func jsonNumIncrBy(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("json.numincrby")
+	}
+	increment, err := parseJSON(args[2])
+	if err != nil || (jsonTypeName(increment) != "integer" && jsonTypeName(increment) != "number") {
+		return errorReply("the increment must be a number")
+	}
+	key := string(args[0])
+	doc, path, nodes, response, err := s.jsonTarget(key, args[1])
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return jsonMissingKey()
+	}
+	// work out every result before changing the document
+	results := make([]any, len(nodes))
+	for i, node := range nodes {
+		switch jsonTypeName(node.value) {
+		case "integer", "number":
+		default:
+			if path.legacy {
+				return jsonWrongType("number", node.value)
+			}
+			continue
+		}
+		sum, ok := addJSONNumbers(node.value, increment)
+		if !ok {
+			return errorReply("increment would produce NaN or Infinity")
+		}
+		results[i] = sum
+	}
+	if path.legacy && len(nodes) == 0 {
+		return jsonMissingPath(path)
+	}
+	changed := false
+	for i, node := range nodes {
+		if results[i] != nil {
+			doc.replace(node, results[i])
+			changed = true
+		}
+	}
+	if changed {
+		s.notifyKeyspaceEvent(notifyModule, "json.numincrby", key)
+	}
+	var data []byte
+	if path.legacy {
+		data = serialiseJSON(results[len(results)-1])
+	} else {
+		data = serialiseJSON(&jsonArray{elements: results})
+	}
+	reply := resp.BulkString{Data: data, Size: len(data)}
+	return reply.Serialise()
+}
+
+// `addJSONNumbers` adds two numbers, the sum of two integers is an
+// integer unless it overflows. It reports false when the sum isn't
+// a finite number
+func addJSONNumbers(a, b any) (any, bool) {
+	x, xIsInt := a.(int64)
+	y, yIsInt := b.(int64)
+	if xIsInt && yIsInt {
+		sum := x + y
+		// the sum overflows when both operands share a sign
+		// the sum doesn't have
+		if (x >= 0) == (y >= 0) && (sum >= 0) != (x >= 0) {
+			return float64(x) + float64(y), true
+		}
+		return sum, true
+	}
+	toFloat := func(number any) float64 {
+		if integer, ok := number.(int64); ok {
+			return float64(integer)
+		}
+		return number.(float64)
+	}
+	sum := toFloat(a) + toFloat(b)
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return nil, false
+	}
+	return sum, true
+}
+
+// JSON.STRAPPEND command appends a string to the strings matched
+// by path
+func jsonStrAppend(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return wrongNumberOfArgs("json.strappend")
+	}
+	pathArg, valueArg := []byte("."), args[1]
+	if len(args) == 3 {
+		pathArg, valueArg = args[1], args[2]
+	}
+	value, err := parseJSON(valueArg)
+	suffix, isString := value.(string)
+	if err != nil || !isString {
+		return errorReply("the value to append must be a JSON string")
+	}
+	key := string(args[0])
+	doc, path, nodes, response, err := s.jsonTarget(key, pathArg)
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return jsonMissingKey()
+	}
+	changed := false
+	response, err = jsonApply(path, nodes, "string", func(node jsonNode) resp.RESPDatatype {
+		appended := node.value.(string) + suffix
+		doc.replace(node, appended)
+		changed = true
+		return &resp.Integer{Data: int64(len(appended))}
+	})
+	if changed {
+		s.notifyKeyspaceEvent(notifyModule, "json.strappend", key)
+	}
+	return response, err
+}
+
+// `parseJSONValues` parses each argument as a JSON value
+func parseJSONValues(args [][]byte) ([]any, bool) {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		value, err := parseJSON(arg)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = value
+	}
+	return values, true
+}
+
+// `cloneJSONValues` returns deep copies of values
+func cloneJSONValues(values []any) []any {
+	clones := make([]any, len(values))
+	for i, value := range values {
+		clones[i] = cloneJSON(value)
+	}
+	return clones
+}
+
+// JSON.ARRAPPEND command appends values to the arrays matched by path
+func jsonArrAppend(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("json.arrappend")
+	}
+	values, ok := parseJSONValues(args[2:])
+	if !ok {
+		return errorReply("invalid JSON value")
+	}
+	key := string(args[0])
+	doc, path, nodes, response, err := s.jsonTarget(key, args[1])
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return jsonMissingKey()
+	}
+	changed := false
+	response, err = jsonApply(path, nodes, "array", func(node jsonNode) resp.RESPDatatype {
+		array := node.value.(*jsonArray)
+		array.elements = append(array.elements, cloneJSONValues(values)...)
+		changed = true
+		return &resp.Integer{Data: int64(len(array.elements))}
+	})
+	if changed {
+		s.notifyKeyspaceEvent(notifyModule, "json.arrappend", key)
+	}
+	return response, err
+}
+
+// JSON.ARRINSERT command inserts values into the arrays matched by
+// path before index, which counts from the end when negative
+func jsonArrInsert(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("json.arrinsert")
+	}
+	index, ok := parseInteger(args[2])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	values, ok := parseJSONValues(args[3:])
+	if !ok {
+		return errorReply("invalid JSON value")
+	}
+	key := string(args[0])
+	doc, path, nodes, response, err := s.jsonTarget(key, args[1])
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return jsonMissingKey()
+	}
+	// `position` returns the index to insert at in array
+	position := func(array *jsonArray) (int, bool) {
+		length := int64(len(array.elements))
+		position := index
+		if position < 0 {
+			position += length
+		}
+		return int(position), position >= 0 && position <= length
+	}
+	// check every array before changing any of them
+	for _, node := range nodes {
+		if array, isArray := node.value.(*jsonArray); isArray {
+			if _, ok := position(array); !ok {
+				return errorReply("index out of bounds")
+			}
+		}
+	}
+	changed := false
+	response, err = jsonApply(path, nodes, "array", func(node jsonNode) resp.RESPDatatype {
+		array := node.value.(*jsonArray)
+		at, _ := position(array)
+		array.elements = slices.Insert(array.elements, at, cloneJSONValues(values)...)
+		changed = true
+		return &resp.Integer{Data: int64(len(array.elements))}
+	})
+	if changed {
+		s.notifyKeyspaceEvent(notifyModule, "json.arrinsert", key)
+	}
+	return response, err
+}
+
+// JSON.ARRPOP command removes and returns the element at index,
+// the last one by default, of the arrays matched by path
+func jsonArrPop(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return wrongNumberOfArgs("json.arrpop")
+	}
+	pathArg := []byte(".")
+	if len(args) > 1 {
+		pathArg = args[1]
+	}
+	index := int64(-1)
+	if len(args) == 3 {
+		var ok bool
+		if index, ok = parseInteger(args[2]); !ok {
+			return errorReply("value is not an integer or out of range")
+		}
+	}
+	key := string(args[0])
+	doc, path, nodes, response, err := s.jsonTarget(key, pathArg)
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return jsonMissingKey()
+	}
+	changed := false
+	response, err = jsonApply(path, nodes, "array", func(node jsonNode) resp.RESPDatatype {
+		array := node.value.(*jsonArray)
+		length := int64(len(array.elements))
+		if length == 0 {
+			return &resp.BulkString{Size: -1}
+		}
+		// out of range indices pop the first or last element
+		at := index
+		if at < 0 {
+			at += length
+		}
+		at = min(max(at, 0), length-1)
+		popped := array.elements[at]
+		array.elements = slices.Delete(array.elements, int(at), int(at)+1)
+		changed = true
+		data := serialiseJSON(popped)
+		return &resp.BulkString{Data: data, Size: len(data)}
+	})
+	if changed {
+		s.notifyKeyspaceEvent(notifyModule, "json.arrpop", key)
+	}
+	return response, err
+}
+
+// JSON.ARRLEN command returns the lengths of the arrays matched
+// by path
+func jsonArrLen(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs("json.arrlen")
+	}
+	pathArg := []byte(".")
+	if len(args) == 2 {
+		pathArg = args[1]
+	}
+	doc, path, nodes, response, err := s.jsonTarget(string(args[0]), pathArg)
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return nilBulkString()
+	}
+	return jsonApply(path, nodes, "array", func(node jsonNode) resp.RESPDatatype {
+		return &resp.Integer{Data: int64(len(node.value.(*jsonArray).elements))}
+	})
+}
+
+// JSON.OBJKEYS command returns the keys of the objects matched
+// by path
+func jsonObjKeys(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs("json.objkeys")
+	}
+	pathArg := []byte(".")
+	if len(args) == 2 {
+		pathArg = args[1]
+	}
+	doc, path, nodes, response, err := s.jsonTarget(string(args[0]), pathArg)
+	if response != nil {
+		return response, err
+	}
+	if doc == nil {
+		return nilBulkString()
+	}
+	return jsonApply(path, nodes, "object", func(node jsonNode) resp.RESPDatatype {
+		keys := &resp.Array{}
+		for _, key := range node.value.(*jsonObject).keys {
+			keys.Elements = append(keys.Elements, bulkStringOf(key))
+		}
+		keys.Size = len(keys.Elements)
+		return keys
+	})
+}
+
+// `toRESPArray` serialises the document into an array holding
+// the document's JSON text, the way it's saved
+func (doc *jsonDocument) toRESPArray() ([]byte, error) {
+	return membersToRESPArray([]string{string(serialiseJSON(doc.root))})
+}
+
+// `jsonFromElements` rebuilds a document from the elements
+// it's saved as
+func jsonFromElements(elements [][]byte) (*jsonDocument, error) {
+	if len(elements) != 1 {
+		return nil, resp.ErrInvalidClientData
+	}
+	root, err := parseJSON(elements[0])
+	if err != nil {
+		return nil, resp.ErrInvalidClientData
+	}
+	return &jsonDocument{root: root}, nil
+}
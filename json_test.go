@@ -0,0 +1,188 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		text string
+		// the value serialised back, empty when the text is invalid
+		want string
+	}{
+		{`{"a":1,"b":[true,null,"x"],"c":1.5}`, `{"a":1,"b":[true,null,"x"],"c":1.5}`},
+		{` { "b" : 1 , "a" : 2 } `, `{"b":1,"a":2}`},
+		// a repeated key keeps its first position and its last value
+		{`{"a":1,"b":2,"a":3}`, `{"a":3,"b":2}`},
+		{`[]`, `[]`},
+		{`{}`, `{}`},
+		{`-7`, `-7`},
+		{`1e2`, `100.0`},
+		{`2.50`, `2.5`},
+		{`9223372036854775807`, `9223372036854775807`},
+		{`"é\n\"\\"`, `"é\n\"\\"`},
+		{`"\u0001"`, `"\u0001"`},
+		{`null`, `null`},
+		{``, ``},
+		{`{`, ``},
+		{`[1,]`, ``},
+		{`{"a" 1}`, ``},
+		{`{"a":1}x`, ``},
+		{`1 2`, ``},
+		{`'a'`, ``},
+		{`{1:2}`, ``},
+		{`01`, ``},
+	}
+	for _, test := range tests {
+		value, err := parseJSON([]byte(test.text))
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("parseJSON(%q) = %s, want an error", test.text, serialiseJSON(value))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseJSON(%q): %v", test.text, err)
+			continue
+		}
+		if got := string(serialiseJSON(value)); got != test.want {
+			t.Errorf("parseJSON(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+	// integers stay exact, other numbers are floats
+	if value, _ := parseJSON([]byte(`9007199254740993`)); value != int64(9007199254740993) {
+		t.Errorf("9007199254740993 parsed as %#v", value)
+	}
+	if value, _ := parseJSON([]byte(`9223372036854775808`)); value != float64(9223372036854775808) {
+		t.Errorf("9223372036854775808 parsed as %#v", value)
+	}
+	// nesting is capped rather than overflowing the stack
+	deepest := strings.Repeat("[", maxJSONDepth) + strings.Repeat("]", maxJSONDepth)
+	if _, err := parseJSON([]byte(deepest)); err != nil {
+		t.Errorf("parsing %d nested arrays: %v", maxJSONDepth, err)
+	}
+	for _, text := range []string{"[" + deepest + "]", strings.Repeat(`{"a":`, 1000000)} {
+		if _, err := parseJSON([]byte(text)); err != errJSONTooDeep {
+			t.Errorf("parsing %.10s... nested too deep returned %v", text, err)
+		}
+	}
+	s := newStore()
+	tooDeep := strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1)
+	if got := run(t, s, "JSON.SET", "doc", "$", tooDeep); got != "-"+errJSONTooDeep.Error()+"\r\n" {
+		t.Errorf("JSON.SET of a value nested too deep replied %q", got)
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	valid := []struct {
+		text   string
+		steps  int
+		legacy bool
+	}{
+		{"$", 0, false},
+		{".", 0, true},
+		{"a", 1, true},
+		{".a.b", 2, true},
+		{"a[0]", 2, true},
+		{"$.a[*]", 2, false},
+		{"$..name", 1, false},
+		{"$..[0]", 1, false},
+		{"$['a b']", 1, false},
+		{`$["q\"x"]`, 1, false},
+		{"$[1:3]", 1, false},
+		{"$[:-1]", 1, false},
+		{"$[ -1 ]", 1, false},
+		{"$.*", 1, false},
+	}
+	for _, test := range valid {
+		path, ok := parseJSONPath(test.text)
+		if !ok {
+			t.Errorf("parseJSONPath(%q) failed", test.text)
+			continue
+		}
+		if len(path.steps) != test.steps || path.legacy != test.legacy {
+			t.Errorf("parseJSONPath(%q) has %d steps and legacy %v, want %d and %v",
+				test.text, len(path.steps), path.legacy, test.steps, test.legacy)
+		}
+	}
+	for _, text := range []string{"", "$a", "$.", "$..", "$.a..", "$[", "$[x]", "$['a'", "$['a'x]", "$[1:x]", "$[0"} {
+		if _, ok := parseJSONPath(text); ok {
+			t.Errorf("parseJSONPath(%q) succeeded, want a failure", text)
+		}
+	}
+	path, _ := parseJSONPath(`$["q\"x"]`)
+	if path.steps[0].kind != jsonStepKey || path.steps[0].key != `q"x` {
+		t.Errorf(`$["q\"x"] selects %+v`, path.steps[0])
+	}
+}
+
+func TestJSONPathEvaluate(t *testing.T) {
+	root, err := parseJSON([]byte(`{"a":{"name":"x","b":[1,2,3,4]},"name":"y","c":[{"name":"z"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &jsonDocument{root: root}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$.name", `["y"]`},
+		{"$..name", `["y","x","z"]`},
+		{"$.a.*", `["x",[1,2,3,4]]`},
+		{"$.a.b[*]", `[1,2,3,4]`},
+		{"$.a.b[1:3]", `[2,3]`},
+		{"$.a.b[:-2]", `[1,2]`},
+		{"$.a.b[2:]", `[3,4]`},
+		{"$.a.b[-1]", `[4]`},
+		{"$.a.b[4]", `[]`},
+		{"$.a.b[-5]", `[]`},
+		{"$.c[0].name", `["z"]`},
+		{"$['a']['name']", `["x"]`},
+		{"$.missing", `[]`},
+		{"$.name.length", `[]`},
+		{"$..b[0]", `[1]`},
+	}
+	for _, test := range tests {
+		path, ok := parseJSONPath(test.path)
+		if !ok {
+			t.Errorf("parseJSONPath(%q) failed", test.path)
+			continue
+		}
+		matches := &jsonArray{elements: []any{}}
+		for _, node := range doc.evaluate(path) {
+			matches.elements = append(matches.elements, node.value)
+		}
+		if got := string(serialiseJSON(matches)); got != test.want {
+			t.Errorf("%s matched %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestJSONCommands(t *testing.T) {
+	s := newStore()
+	if got := run(t, s, "JSON.SET", "doc", "$", `{"a":{"b":[1,2]},"n":1}`); got != "+OK\r\n" {
+		t.Fatalf("JSON.SET replied %q", got)
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"JSON.GET", "doc", "a.b[1]"}, bulkReply("2")},
+		{[]string{"JSON.GET", "doc", "$.a.b[*]"}, bulkReply("[1,2]")},
+		{[]string{"JSON.GET", "doc", "$.missing"}, bulkReply("[]")},
+		{[]string{"JSON.SET", "doc", "$.a.c", `"new"`}, "+OK\r\n"},
+		{[]string{"JSON.SET", "doc", "$.x.y", "1"}, "$-1\r\n"},
+		{[]string{"JSON.SET", "doc", "$.n", "2", "NX"}, "$-1\r\n"},
+		{[]string{"JSON.SET", "doc", "$.n", "{"}, "-invalid JSON value\r\n"},
+		{[]string{"JSON.NUMINCRBY", "doc", "$.n", "1.5"}, bulkReply("[2.5]")},
+		{[]string{"JSON.GET", "doc"}, bulkReply(`{"a":{"b":[1,2],"c":"new"},"n":2.5}`)},
+		{[]string{"JSON.GET", "doc", "INDENT", "  ", "NEWLINE", "\n", "SPACE", " ", "$.a.b"}, bulkReply("[\n  [\n    1,\n    2\n  ]\n]")},
+		{[]string{"JSON.SET", "missing", "$.a", "1"}, "-new objects must be created at the root\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q replied %q, want %q", test.args, got, test.want)
+		}
+	}
+}
@@ -1,50 +1,572 @@
 package main
 
-type node struct {
-	data []byte
-	next *node
-}
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"sync"
+)
 
+// list is a quicklist: a doubly linked list of chunks, each packing
+// several elements into a single byte slice. Chunks more than
+// `compress` chunks away from both ends are kept deflated
+// (list-compress-depth), since lists are mostly accessed at the ends
 type list struct {
-	head   *node
-	tail   *node
+	head   *listChunk
+	tail   *listChunk
 	length int
+	// number of chunks kept uncompressed at each end, 0 disables
+	// compression
+	compress int
+}
+
+// listChunk holds up to `maxChunkSize` bytes of packed entries. Each
+// entry is encoded as
+//
+//	uvarint(len(data)) data backlen
+//
+// where backlen is the size of the first two fields as a uvarint
+// stored back to front, so that the chunk can be walked from the end
+type listChunk struct {
+	prev *listChunk
+	next *listChunk
+	// entries holds the deflated entries when the chunk is compressed
+	entries    []byte
+	count      int
+	compressed bool
+	// compressTried is set once the chunk was deflated as an interior
+	// chunk, even if compression didn't make it any smaller, and
+	// cleared once it's decompressed
+	compressTried bool
+}
+
+// maximum size of the entries packed in a chunk, the same as the
+// default list-max-listpack-size of redis. An element larger than
+// that gets a chunk of its own
+const maxChunkSize = 8 * 1024
+
+// chunks smaller than this aren't worth compressing
+const minCompressSize = 48
+
+// flate writers are reused across chunks, since each one allocates
+// hundreds of kilobytes of state
+var chunkWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
 }
 
 func newList() *list {
-	return &list{}
+	return &list{
+		compress: serverConfig.listCompressDepth,
+	}
+}
+
+// `appendEntry` packs data at the end of entries
+func appendEntry(entries []byte, data []byte) []byte {
+	start := len(entries)
+	entries = binary.AppendUvarint(entries, uint64(len(data)))
+	entries = append(entries, data...)
+	var backlen [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(backlen[:], uint64(len(entries)-start))
+	for i := n - 1; i >= 0; i-- {
+		entries = append(entries, backlen[i])
+	}
+	return entries
 }
 
-func (l *list) hpush(n []*node) {
-	if len(n) < 1 {
+// `entrySize` returns the size of data once packed
+func entrySize(data []byte) int {
+	size := uvarintSize(uint64(len(data))) + len(data)
+	return size + uvarintSize(uint64(size))
+}
+
+// `nextEntry` decodes the entry starting at offset, it returns
+// the entry's data and the offset of the entry after it
+func nextEntry(entries []byte, offset int) ([]byte, int) {
+	length, n := binary.Uvarint(entries[offset:])
+	start := offset + n
+	end := start + int(length)
+	return entries[start:end], end + uvarintSize(uint64(end-offset))
+}
+
+// `uvarintSize` returns the number of bytes x takes as a uvarint
+func uvarintSize(x uint64) int {
+	size := 1
+	for ; x >= 0x80; x >>= 7 {
+		size++
+	}
+	return size
+}
+
+// `prevEntry` returns the offset of the entry ending at end
+func prevEntry(entries []byte, end int) int {
+	var size uint64
+	var shift uint
+	i := end - 1
+	for ; ; i-- {
+		b := entries[i]
+		size |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return i - int(size)
+}
+
+// `raw` returns the uncompressed entries of the chunk without
+// decompressing the chunk itself
+func (c *listChunk) raw() []byte {
+	if !c.compressed {
+		return c.entries
+	}
+	entries, err := io.ReadAll(flate.NewReader(bytes.NewReader(c.entries)))
+	if err != nil {
+		panic("corrupted list chunk: " + err.Error())
+	}
+	return entries
+}
+
+// `decompress` leaves the chunk uncompressed
+func (c *listChunk) decompress() {
+	c.compressTried = false
+	if c.compressed {
+		c.entries = c.raw()
+		c.compressed = false
+	}
+}
+
+// `deflate` compresses the chunk, unless compression
+// wouldn't make it any smaller
+func (c *listChunk) deflate() {
+	if c.compressTried {
 		return
 	}
-	// empty list
-	if l.head == nil {
-		l.tail = n[0]
+	c.compressTried = true
+	if c.compressed || len(c.entries) < minCompressSize {
+		return
 	}
-	for i := 0; i < len(n); i++ {
-		n[i].next = l.head
-		l.head = n[i]
-		l.length++
+	var buf bytes.Buffer
+	w := chunkWriters.Get().(*flate.Writer)
+	defer chunkWriters.Put(w)
+	w.Reset(&buf)
+	w.Write(c.entries)
+	w.Close()
+	if buf.Len() >= len(c.entries) {
+		return
 	}
+	c.entries = bytes.Clone(buf.Bytes())
+	c.compressed = true
 }
 
-func (l *list) tpush(n []*node) {
-	if len(n) < 1 {
+// `interior` reports whether c lies more than `compress` chunks
+// away from both ends of the list
+func (l *list) interior(c *listChunk) bool {
+	if l.compress == 0 {
+		return false
+	}
+	head, tail := l.head, l.tail
+	for i := 0; i < l.compress && head != nil; i++ {
+		if head == c || tail == c {
+			return false
+		}
+		head, tail = head.next, tail.prev
+	}
+	return true
+}
+
+// `recompress` compresses c if it's an interior chunk
+func (l *list) recompress(c *listChunk) {
+	if l.interior(c) {
+		c.deflate()
+	} else {
+		c.decompress()
+	}
+}
+
+// `compressEnds` restores the compression invariant after chunks
+// were added or removed at the ends of the list: the `compress`
+// chunks at each end are uncompressed and every chunk inwards is
+// compressed. A bulk push may move several chunks into the interior
+// at once, so interior chunks are deflated walking inwards from each
+// end until one that was already deflated
+func (l *list) compressEnds() {
+	if l.compress == 0 {
 		return
 	}
-	start := 0
-	// empty list
-	if l.head == nil {
-		l.head = n[0]
-		l.tail = n[0]
-		start++
+	head, tail := l.head, l.tail
+	for i := 0; i < l.compress && head != nil; i++ {
+		head.decompress()
+		tail.decompress()
+		head, tail = head.next, tail.prev
+	}
+	for c := head; c != nil && !c.compressTried && l.interior(c); c = c.next {
+		c.deflate()
+	}
+	for c := tail; c != nil && !c.compressTried && l.interior(c); c = c.prev {
+		c.deflate()
+	}
+}
+
+// `link` inserts chunk c after prev, or at the head if prev is nil
+func (l *list) link(prev, c *listChunk) {
+	c.prev = prev
+	if prev == nil {
+		c.next = l.head
+		l.head = c
+	} else {
+		c.next = prev.next
+		prev.next = c
+	}
+	if c.next != nil {
+		c.next.prev = c
+	} else {
+		l.tail = c
+	}
+}
+
+// `unlink` removes chunk c from the list
+func (l *list) unlink(c *listChunk) {
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else {
+		l.head = c.next
+	}
+	if c.next != nil {
+		c.next.prev = c.prev
+	} else {
+		l.tail = c.prev
+	}
+	c.prev = nil
+	c.next = nil
+}
+
+// `hpush` pushes elements one by one to the head of the list,
+// so they end up in reverse order
+func (l *list) hpush(elements [][]byte) {
+	for _, data := range elements {
+		size := entrySize(data)
+		if l.head == nil || len(l.head.entries)+size > maxChunkSize {
+			l.link(nil, &listChunk{})
+		}
+		c := l.head
+		entries := make([]byte, 0, len(c.entries)+size)
+		entries = appendEntry(entries, data)
+		c.entries = append(entries, c.entries...)
+		c.count++
 		l.length++
 	}
-	for i := start; i < len(n); i++ {
-		l.tail.next = n[i]
-		l.tail = n[i]
+	l.compressEnds()
+}
+
+// `tpush` pushes elements to the tail of the list
+func (l *list) tpush(elements [][]byte) {
+	for _, data := range elements {
+		if l.tail == nil || len(l.tail.entries)+entrySize(data) > maxChunkSize {
+			l.link(l.tail, &listChunk{})
+		}
+		c := l.tail
+		c.entries = appendEntry(c.entries, data)
+		c.count++
 		l.length++
 	}
+	l.compressEnds()
+}
+
+// `hpop` removes and returns the head of the list
+func (l *list) hpop() ([]byte, bool) {
+	if l.head == nil {
+		return nil, false
+	}
+	c := l.head
+	data, next := nextEntry(c.entries, 0)
+	data = bytes.Clone(data)
+	c.entries = c.entries[next:]
+	c.count--
+	l.length--
+	if c.count == 0 {
+		l.unlink(c)
+		l.compressEnds()
+	}
+	return data, true
+}
+
+// `tpop` removes and returns the tail of the list
+func (l *list) tpop() ([]byte, bool) {
+	if l.tail == nil {
+		return nil, false
+	}
+	c := l.tail
+	start := prevEntry(c.entries, len(c.entries))
+	data, _ := nextEntry(c.entries, start)
+	data = bytes.Clone(data)
+	c.entries = c.entries[:start]
+	c.count--
+	l.length--
+	if c.count == 0 {
+		l.unlink(c)
+		l.compressEnds()
+	}
+	return data, true
+}
+
+// `locate` returns the chunk holding the element at a zero based
+// index and the element's position within the chunk. The chunks
+// are walked from whichever end is closer to the index
+func (l *list) locate(i int) (*listChunk, int) {
+	if i > l.length/2 {
+		c := l.tail
+		last := l.length - c.count
+		for i < last {
+			c = c.prev
+			last -= c.count
+		}
+		return c, i - last
+	}
+	c := l.head
+	for i >= c.count {
+		i -= c.count
+		c = c.next
+	}
+	return c, i
+}
+
+// `entryOffset` returns the offset of the i-th entry in entries
+func entryOffset(entries []byte, i int) int {
+	offset := 0
+	for ; i > 0; i-- {
+		_, offset = nextEntry(entries, offset)
+	}
+	return offset
+}
+
+// `index` returns the element at a zero based index, or false
+// if the index is out of range
+func (l *list) index(i int) ([]byte, bool) {
+	if i < 0 || i >= l.length {
+		return nil, false
+	}
+	c, i := l.locate(i)
+	entries := c.raw()
+	data, _ := nextEntry(entries, entryOffset(entries, i))
+	return data, true
+}
+
+// `split` halves c if it grew beyond `maxChunkSize`
+func (l *list) split(c *listChunk) {
+	if len(c.entries) <= maxChunkSize || c.count < 2 {
+		return
+	}
+	offset := entryOffset(c.entries, c.count/2)
+	second := &listChunk{
+		entries: bytes.Clone(c.entries[offset:]),
+		count:   c.count - c.count/2,
+	}
+	c.entries = c.entries[:offset:offset]
+	c.count /= 2
+	l.link(c, second)
+	l.recompress(second)
+	l.compressEnds()
+}
+
+// `set` replaces the element at a zero based index, which must
+// be in range
+func (l *list) set(i int, data []byte) {
+	c, i := l.locate(i)
+	c.decompress()
+	start := entryOffset(c.entries, i)
+	_, end := nextEntry(c.entries, start)
+	entries := make([]byte, 0, len(c.entries)-(end-start)+entrySize(data))
+	entries = append(entries, c.entries[:start]...)
+	entries = appendEntry(entries, data)
+	c.entries = append(entries, c.entries[end:]...)
+	l.split(c)
+	l.recompress(c)
+}
+
+// `insert` inserts data so that it ends up at a zero based
+// index, which must be in the range [0, length]
+func (l *list) insert(i int, data []byte) {
+	if i == 0 {
+		l.hpush([][]byte{data})
+		return
+	}
+	if i == l.length {
+		l.tpush([][]byte{data})
+		return
+	}
+	c, i := l.locate(i)
+	c.decompress()
+	offset := entryOffset(c.entries, i)
+	entries := make([]byte, 0, len(c.entries)+entrySize(data))
+	entries = append(entries, c.entries[:offset]...)
+	entries = appendEntry(entries, data)
+	c.entries = append(entries, c.entries[offset:]...)
+	c.count++
+	l.length++
+	l.split(c)
+	l.recompress(c)
+}
+
+// `removeMatching` removes up to count elements equal to data,
+// walking from the tail when fromTail is set. A count of 0
+// removes all of them. It returns the number of removed elements
+func (l *list) removeMatching(data []byte, count int64, fromTail bool) int64 {
+	removed := int64(0)
+	c := l.head
+	if fromTail {
+		c = l.tail
+	}
+	for c != nil && (count == 0 || removed < count) {
+		next := c.next
+		if fromTail {
+			next = c.prev
+		}
+		entries := c.raw()
+		// indices of the matching entries, in the walking order
+		var matches []int
+		offset := 0
+		for i := 0; i < c.count; i++ {
+			element, end := nextEntry(entries, offset)
+			if bytes.Equal(element, data) {
+				matches = append(matches, i)
+			}
+			offset = end
+		}
+		if fromTail {
+			for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+				matches[i], matches[j] = matches[j], matches[i]
+			}
+		}
+		if count != 0 && int64(len(matches)) > count-removed {
+			matches = matches[:count-removed]
+		}
+		if len(matches) > 0 {
+			remove := make(map[int]bool, len(matches))
+			for _, i := range matches {
+				remove[i] = true
+			}
+			kept := make([]byte, 0, len(entries))
+			offset = 0
+			for i := 0; i < c.count; i++ {
+				_, end := nextEntry(entries, offset)
+				if !remove[i] {
+					kept = append(kept, entries[offset:end]...)
+				}
+				offset = end
+			}
+			c.entries = kept
+			c.compressed = false
+			c.compressTried = false
+			c.count -= len(matches)
+			l.length -= len(matches)
+			removed += int64(len(matches))
+			if c.count == 0 {
+				l.unlink(c)
+			} else {
+				l.recompress(c)
+			}
+		}
+		c = next
+	}
+	l.compressEnds()
+	return removed
+}
+
+// `trim` removes left elements from the head and
+// right elements from the tail of the list
+func (l *list) trim(left, right int) {
+	for left > 0 && l.head != nil {
+		c := l.head
+		if c.count <= left {
+			left -= c.count
+			l.length -= c.count
+			l.unlink(c)
+			continue
+		}
+		c.decompress()
+		c.entries = bytes.Clone(c.entries[entryOffset(c.entries, left):])
+		c.count -= left
+		l.length -= left
+		left = 0
+	}
+	for right > 0 && l.tail != nil {
+		c := l.tail
+		if c.count <= right {
+			right -= c.count
+			l.length -= c.count
+			l.unlink(c)
+			continue
+		}
+		c.decompress()
+		c.entries = c.entries[:entryOffset(c.entries, c.count-right)]
+		c.count -= right
+		l.length -= right
+		right = 0
+	}
+	l.compressEnds()
+}
+
+// `listIterator` walks the elements of a list in either direction
+type listIterator struct {
+	chunk *listChunk
+	// entries are the uncompressed entries of chunk
+	entries []byte
+	// offset is where the next entry starts when walking forward,
+	// and where it ends when walking backward
+	offset  int
+	forward bool
+}
+
+// `iterator` returns an iterator starting at the element at a
+// zero based index, walking towards the tail when forward is set
+func (l *list) iterator(i int, forward bool) *listIterator {
+	it := &listIterator{
+		forward: forward,
+	}
+	if i < 0 || i >= l.length {
+		return it
+	}
+	c, i := l.locate(i)
+	it.chunk = c
+	it.entries = c.raw()
+	it.offset = entryOffset(it.entries, i)
+	if !forward {
+		_, it.offset = nextEntry(it.entries, it.offset)
+	}
+	return it
+}
+
+// `next` returns the next element, or false once the
+// iterator ran past the end of the list
+func (it *listIterator) next() ([]byte, bool) {
+	for it.chunk != nil {
+		if it.forward && it.offset < len(it.entries) {
+			data, next := nextEntry(it.entries, it.offset)
+			it.offset = next
+			return data, true
+		}
+		if !it.forward && it.offset > 0 {
+			start := prevEntry(it.entries, it.offset)
+			data, _ := nextEntry(it.entries, start)
+			it.offset = start
+			return data, true
+		}
+		if it.forward {
+			it.chunk = it.chunk.next
+		} else {
+			it.chunk = it.chunk.prev
+		}
+		if it.chunk != nil {
+			it.entries = it.chunk.raw()
+			it.offset = 0
+			if !it.forward {
+				it.offset = len(it.entries)
+			}
+		}
+	}
+	return nil, false
 }
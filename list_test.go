@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// `listElements` returns the elements of l, walking from the head
+func listElements(l *list) [][]byte {
+	var elements [][]byte
+	it := l.iterator(0, true)
+	for data, ok := it.next(); ok; data, ok = it.next() {
+		elements = append(elements, bytes.Clone(data))
+	}
+	return elements
+}
+
+// `checkList` checks that l holds want and that only the chunks
+// within `compress` chunks of either end are left uncompressed
+func checkList(t *testing.T, l *list, want [][]byte) {
+	t.Helper()
+	if l.length != len(want) {
+		t.Fatalf("length = %d, want %d", l.length, len(want))
+	}
+	if got := listElements(l); !slices.EqualFunc(got, want, bytes.Equal) {
+		t.Fatalf("list holds %d elements that differ from the %d expected", len(got), len(want))
+	}
+	var chunks []*listChunk
+	for c := l.head; c != nil; c = c.next {
+		chunks = append(chunks, c)
+	}
+	for i, c := range chunks {
+		end := i < l.compress || i >= len(chunks)-l.compress
+		if end && c.compressed {
+			t.Errorf("chunk %d of %d is compressed, it's within %d chunks of an end", i, len(chunks), l.compress)
+		}
+		if !end && !c.compressed {
+			t.Errorf("interior chunk %d of %d isn't compressed", i, len(chunks))
+		}
+	}
+}
+
+// `element` returns a compressible element of the given size
+func element(i int, size int) []byte {
+	return []byte(fmt.Sprintf("%0*d", size, i))
+}
+
+func TestListCompression(t *testing.T) {
+	l := &list{compress: 2}
+	var want [][]byte
+	// a bulk push adds many chunks at once
+	var bulk [][]byte
+	for i := 0; i < 20000; i++ {
+		bulk = append(bulk, element(i, 16))
+	}
+	l.tpush(bulk)
+	want = append(want, bulk...)
+	checkList(t, l, want)
+
+	bulk = bulk[:0]
+	for i := 0; i < 5000; i++ {
+		bulk = append(bulk, element(-i, 16))
+	}
+	l.hpush(bulk)
+	for _, data := range bulk {
+		want = slices.Insert(want, 0, data)
+	}
+	checkList(t, l, want)
+
+	for i := 0; i < 3000; i++ {
+		l.hpop()
+		l.tpop()
+	}
+	want = want[3000 : len(want)-3000]
+	checkList(t, l, want)
+
+	l.insert(9000, []byte("inserted"))
+	want = slices.Insert(want, 9000, []byte("inserted"))
+	l.set(100, []byte("set"))
+	want[100] = []byte("set")
+	checkList(t, l, want)
+
+	l.removeMatching(want[5000], 0, false)
+	want = slices.Delete(want, 5000, 5001)
+	l.trim(1000, 2000)
+	want = want[1000 : len(want)-2000]
+	checkList(t, l, want)
+}
+
+// linkedList is the list that preceded the quicklist, with a node
+// and a separate slice per element, kept to benchmark against
+type linkedList struct {
+	head   *linkedListNode
+	tail   *linkedListNode
+	length int
+}
+
+type linkedListNode struct {
+	data []byte
+	prev *linkedListNode
+	next *linkedListNode
+}
+
+func (l *linkedList) tpush(elements [][]byte) {
+	for _, data := range elements {
+		n := &linkedListNode{data: bytes.Clone(data), prev: l.tail}
+		if l.tail == nil {
+			l.head = n
+		} else {
+			l.tail.next = n
+		}
+		l.tail = n
+		l.length++
+	}
+}
+
+func (l *linkedList) hpop() ([]byte, bool) {
+	n := l.head
+	if n == nil {
+		return nil, false
+	}
+	l.head = n.next
+	if l.head == nil {
+		l.tail = nil
+	} else {
+		l.head.prev = nil
+	}
+	l.length--
+	return n.data, true
+}
+
+// `heapInUse` returns the bytes allocated on the heap after
+// a garbage collection. It collects twice so that the flate
+// writers pooled for compression aren't counted
+func heapInUse() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// BenchmarkListMemory builds lists of 100,000 elements and reports
+// the heap used per element. The elements are zero padded counters,
+// so they compress about as well as typical keys or IDs do
+func BenchmarkListMemory(b *testing.B) {
+	const length = 100000
+	for _, size := range []int{8, 64} {
+		elements := make([][]byte, length)
+		for i := range elements {
+			elements[i] = element(i, size)
+		}
+		lists := []struct {
+			name  string
+			build func() any
+		}{
+			{"linked", func() any {
+				l := &linkedList{}
+				for _, data := range elements {
+					l.tpush([][]byte{data})
+				}
+				return l
+			}},
+			{"quicklist", func() any {
+				l := &list{}
+				for _, data := range elements {
+					l.tpush([][]byte{data})
+				}
+				return l
+			}},
+			{"quicklist-compress-depth-1", func() any {
+				l := &list{compress: 1}
+				for _, data := range elements {
+					l.tpush([][]byte{data})
+				}
+				return l
+			}},
+		}
+		for _, list := range lists {
+			b.Run(fmt.Sprintf("%s/%dB", list.name, size), func(b *testing.B) {
+				b.ReportAllocs()
+				var used uint64
+				for i := 0; i < b.N; i++ {
+					before := heapInUse()
+					l := list.build()
+					used += heapInUse() - before
+					runtime.KeepAlive(l)
+				}
+				b.ReportMetric(float64(used)/float64(b.N)/length, "heap-B/element")
+			})
+		}
+	}
+}
+
+// BenchmarkListPushPop pushes an element to the tail of a list of
+// 1,000 elements and pops one from its head
+func BenchmarkListPushPop(b *testing.B) {
+	data := element(0, 16)
+	elements := make([][]byte, 1000)
+	for i := range elements {
+		elements[i] = data
+	}
+	b.Run("linked", func(b *testing.B) {
+		b.ReportAllocs()
+		l := &linkedList{}
+		l.tpush(elements)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.tpush([][]byte{data})
+			l.hpop()
+		}
+	})
+	b.Run("quicklist", func(b *testing.B) {
+		b.ReportAllocs()
+		l := &list{}
+		l.tpush(elements)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.tpush([][]byte{data})
+			l.hpop()
+		}
+	})
+}
+
+func TestListCommands(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"RPUSH", "l", "a", "b", "c", "b", "a", "b"}, integerReply(6)},
+		{[]string{"LLEN", "l"}, integerReply(6)},
+		{[]string{"LLEN", "missing"}, integerReply(0)},
+		{[]string{"LINDEX", "l", "0"}, bulkReply("a")},
+		{[]string{"LINDEX", "l", "-1"}, bulkReply("b")},
+		{[]string{"LINDEX", "l", "6"}, "$-1\r\n"},
+		{[]string{"LINDEX", "l", "-7"}, "$-1\r\n"},
+		{[]string{"LPOS", "l", "b"}, integerReply(1)},
+		{[]string{"LPOS", "l", "b", "RANK", "2"}, integerReply(3)},
+		{[]string{"LPOS", "l", "b", "RANK", "-1"}, integerReply(5)},
+		{[]string{"LPOS", "l", "b", "COUNT", "0"}, "*3\r\n" + integerReply(1) + integerReply(3) + integerReply(5)},
+		{[]string{"LPOS", "l", "b", "RANK", "-2", "COUNT", "2"}, "*2\r\n" + integerReply(3) + integerReply(1)},
+		{[]string{"LPOS", "l", "b", "MAXLEN", "1"}, "$-1\r\n"},
+		{[]string{"LPOS", "l", "x", "COUNT", "1"}, "*0\r\n"},
+		{[]string{"LPOS", "l", "b", "RANK", "0"}, "-RANK can't be zero: use 1 to start from the first match, " +
+			"2 from the second ... or use negative to start from the end of the list\r\n"},
+		{[]string{"LPOS", "l", "b", "COUNT", "-1"}, "-COUNT can't be negative\r\n"},
+		{[]string{"LPOS", "l", "b", "RANK"}, "-invalid syntax\r\n"},
+		{[]string{"LSET", "l", "-2", "x"}, "+OK\r\n"},
+		{[]string{"LSET", "l", "6", "x"}, "-index out of range\r\n"},
+		{[]string{"LSET", "missing", "0", "x"}, "-no such key\r\n"},
+		{[]string{"LRANGE", "l", "0", "-1"}, bulkArray("a", "b", "c", "b", "x", "b")},
+		{[]string{"LREM", "l", "-2", "b"}, integerReply(2)},
+		{[]string{"LRANGE", "l", "0", "-1"}, bulkArray("a", "b", "c", "x")},
+		{[]string{"LINSERT", "l", "BEFORE", "c", "y"}, integerReply(5)},
+		{[]string{"LINSERT", "l", "after", "x", "z"}, integerReply(6)},
+		{[]string{"LINSERT", "l", "AFTER", "missing", "z"}, integerReply(-1)},
+		{[]string{"LINSERT", "missing", "AFTER", "a", "z"}, integerReply(0)},
+		{[]string{"LINSERT", "l", "AROUND", "a", "z"}, "-invalid syntax\r\n"},
+		{[]string{"LRANGE", "l", "0", "-1"}, bulkArray("a", "b", "y", "c", "x", "z")},
+		{[]string{"LTRIM", "l", "1", "-2"}, "+OK\r\n"},
+		{[]string{"LRANGE", "l", "0", "-1"}, bulkArray("b", "y", "c", "x")},
+		{[]string{"LPOP", "l"}, bulkReply("b")},
+		{[]string{"RPOP", "l", "2"}, bulkArray("x", "c")},
+		{[]string{"LPOP", "l", "0"}, "*0\r\n"},
+		{[]string{"LPOP", "l", "-1"}, "-value is out of range, must be positive\r\n"},
+		{[]string{"LPOP", "missing"}, "$-1\r\n"},
+		{[]string{"LPOP", "missing", "1"}, "*-1\r\n"},
+		// popping the last element deletes the key
+		{[]string{"RPOP", "l", "5"}, bulkArray("y")},
+		{[]string{"EXISTS", "l"}, integerReply(0)},
+		{[]string{"RPUSH", "l", "a"}, integerReply(1)},
+		{[]string{"LTRIM", "l", "1", "0"}, "+OK\r\n"},
+		{[]string{"EXISTS", "l"}, integerReply(0)},
+		{[]string{"SET", "str", "a"}, "+OK\r\n"},
+		{[]string{"LLEN", "str"}, "-value not of list type\r\n"},
+		{[]string{"LPOP", "str"}, "-value not of list type\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// TestListCommandsModel runs random list commands against a
+// compressed list long enough to span many chunks, and the same
+// operations against a slice
+func TestListCommandsModel(t *testing.T) {
+	defer func(depth int) { serverConfig.listCompressDepth = depth }(serverConfig.listCompressDepth)
+	serverConfig.listCompressDepth = 1
+	s := newStore()
+	var model []string
+	random := func() string {
+		return string(element(rand.Intn(50), 100))
+	}
+	abs := func(i int) int {
+		return max(i, -i)
+	}
+	// `normalise` clamps a start or stop index the way LRANGE does
+	normalise := func(i int) int {
+		if i < 0 {
+			i += len(model)
+		}
+		return max(i, 0)
+	}
+	for i := 0; i < 5000; i++ {
+		switch op := rand.Intn(10); {
+		case op < 4 || len(model) == 0:
+			data := random()
+			if rand.Intn(2) == 0 {
+				run(t, s, "LPUSH", "l", data)
+				model = slices.Insert(model, 0, data)
+			} else {
+				run(t, s, "RPUSH", "l", data)
+				model = append(model, data)
+			}
+		case op == 3:
+			count := rand.Intn(3)
+			popped := min(count, len(model))
+			if rand.Intn(2) == 0 {
+				want := bulkArray(model[:popped]...)
+				model = model[popped:]
+				if got := run(t, s, "LPOP", "l", strconv.Itoa(count)); got != want {
+					t.Fatalf("LPOP %d replied %q, want %q", count, got, want)
+				}
+			} else {
+				tail := slices.Clone(model[len(model)-popped:])
+				slices.Reverse(tail)
+				model = model[:len(model)-popped]
+				if got := run(t, s, "RPOP", "l", strconv.Itoa(count)); got != bulkArray(tail...) {
+					t.Fatalf("RPOP %d replied %q, want %q", count, got, bulkArray(tail...))
+				}
+			}
+		case op == 4:
+			i, data := rand.Intn(len(model)), random()
+			run(t, s, "LSET", "l", strconv.Itoa(i-len(model)*rand.Intn(2)), data)
+			model[i] = data
+		case op == 5:
+			data, count := random(), rand.Intn(5)-2
+			removed := 0
+			if count >= 0 {
+				model = slices.DeleteFunc(model, func(e string) bool {
+					if e == data && (count == 0 || removed < count) {
+						removed++
+						return true
+					}
+					return false
+				})
+			} else {
+				for j := len(model) - 1; j >= 0 && removed < -count; j-- {
+					if model[j] == data {
+						model = slices.Delete(model, j, j+1)
+						removed++
+					}
+				}
+			}
+			if got := run(t, s, "LREM", "l", strconv.Itoa(count), data); got != integerReply(int64(removed)) {
+				t.Fatalf("LREM %d replied %q, want %d", count, got, removed)
+			}
+		case op == 6:
+			start, stop := rand.Intn(3), -1-rand.Intn(3)
+			run(t, s, "LTRIM", "l", strconv.Itoa(start), strconv.Itoa(stop))
+			// a stop before the head empties the list, it isn't clamped
+			if stop += len(model); start > stop || start >= len(model) {
+				model = nil
+			} else {
+				model = model[start : min(stop, len(model)-1)+1]
+			}
+		case op == 7:
+			pivot, data := random(), random()
+			position := slices.Index(model, pivot)
+			before := rand.Intn(2) == 0
+			where := "AFTER"
+			if before {
+				where = "BEFORE"
+			}
+			want := integerReply(-1)
+			if position >= 0 {
+				if !before {
+					position++
+				}
+				model = slices.Insert(model, position, data)
+				want = integerReply(int64(len(model)))
+			}
+			if got := run(t, s, "LINSERT", "l", where, pivot, data); got != want {
+				t.Fatalf("LINSERT replied %q, want %q", got, want)
+			}
+		case op == 8:
+			i := rand.Intn(len(model)+10) - len(model) - 5
+			want := "$-1\r\n"
+			if j := normalise(i); i >= -len(model) && j < len(model) {
+				want = bulkReply(model[j])
+			}
+			if got := run(t, s, "LINDEX", "l", strconv.Itoa(i)); got != want {
+				t.Fatalf("LINDEX %d replied %q, want %q", i, got, want)
+			}
+		default:
+			data, rank := random(), 1+rand.Intn(2)
+			var matches []int
+			for j := range model {
+				if model[j] == data {
+					matches = append(matches, j)
+				}
+			}
+			// a negative rank searches from the tail
+			if rand.Intn(2) == 0 {
+				slices.Reverse(matches)
+				rank = -rank
+			}
+			var want []int
+			if len(matches) >= abs(rank) {
+				want = matches[abs(rank)-1:]
+			}
+			reply := fmt.Sprintf("*%d\r\n", len(want))
+			for _, j := range want {
+				reply += integerReply(int64(j))
+			}
+			if got := run(t, s, "LPOS", "l", data, "RANK", strconv.Itoa(rank), "COUNT", "0"); got != reply {
+				t.Fatalf("LPOS RANK %d replied %q, want %q", rank, got, reply)
+			}
+		}
+		if len(model) == 0 {
+			if got := run(t, s, "EXISTS", "l"); got != integerReply(0) {
+				t.Fatal("an empty list wasn't deleted")
+			}
+			continue
+		}
+		if i%100 == 0 {
+			l, _, _ := s.getList("l")
+			want := make([][]byte, len(model))
+			for j, data := range model {
+				want[j] = []byte(data)
+			}
+			checkList(t, l, want)
+		}
+	}
+}
+
+func TestLrange(t *testing.T) {
+	s := newStore()
+	run(t, s, "RPUSH", "l", "a", "b", "c", "d", "e")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"LRANGE", "l", "0", "-1"}, bulkArray("a", "b", "c", "d", "e")},
+		{[]string{"LRANGE", "l", "-3", "-2"}, bulkArray("c", "d")},
+		{[]string{"LRANGE", "l", "-100", "1"}, bulkArray("a", "b")},
+		{[]string{"LRANGE", "l", "3", "100"}, bulkArray("d", "e")},
+		{[]string{"LRANGE", "l", "-1", "-1"}, bulkArray("e")},
+		{[]string{"LRANGE", "l", "-1", "-2"}, "*0\r\n"},
+		{[]string{"LRANGE", "l", "5", "10"}, "*0\r\n"},
+		{[]string{"LRANGE", "l", "0", "-6"}, "*0\r\n"},
+		{[]string{"LRANGE", "l", "-9223372036854775808", "9223372036854775807"}, bulkArray("a", "b", "c", "d", "e")},
+		{[]string{"LRANGE", "l", "0", "x"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"LRANGE", "missing", "0", "-1"}, "*0\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	// ranges of a list spanning many chunks, walked from either end
+	var model []string
+	args := []string{"RPUSH", "long"}
+	for i := 0; i < 5000; i++ {
+		model = append(model, string(element(i, 20)))
+		args = append(args, model[i])
+	}
+	run(t, s, args...)
+	for i := 0; i < 500; i++ {
+		start, stop := rand.Intn(6000)-5500, rand.Intn(6000)-5500
+		args := []string{"LRANGE", "long", strconv.Itoa(start), strconv.Itoa(stop)}
+		got := run(t, s, args...)
+		if start < 0 {
+			start = max(start+len(model), 0)
+		}
+		if stop < 0 {
+			stop += len(model)
+		}
+		want := "*0\r\n"
+		if start <= stop && start < len(model) {
+			want = bulkArray(model[start : min(stop, len(model)-1)+1]...)
+		}
+		if got != want {
+			t.Fatalf("%q differs from the expected elements", args)
+		}
+	}
+	l, _, _ := s.getList("long")
+	for _, i := range []int{0, 1, 2499, 2500, 2501, 4998, 4999} {
+		it := l.iterator(i, false)
+		for j := i; j >= 0; j-- {
+			data, ok := it.next()
+			if !ok || string(data) != model[j] {
+				t.Fatalf("walking back from %d returned %q at %d", i, data, j)
+			}
+		}
+		if _, ok := it.next(); ok {
+			t.Fatalf("walking back from %d ran past the head", i)
+		}
+	}
+}
+
+func TestListMove(t *testing.T) {
+	s := newStore()
+	run(t, s, "RPUSH", "src", "a", "b", "c")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"LMOVE", "src", "dst", "LEFT", "RIGHT"}, bulkReply("a")},
+		{[]string{"LMOVE", "src", "dst", "right", "left"}, bulkReply("c")},
+		{[]string{"LRANGE", "dst", "0", "-1"}, bulkArray("c", "a")},
+		{[]string{"LMOVE", "src", "dst", "UP", "LEFT"}, "-invalid syntax\r\n"},
+		{[]string{"LMOVE", "missing", "dst", "LEFT", "LEFT"}, "$-1\r\n"},
+		{[]string{"EXISTS", "missing"}, integerReply(0)},
+		// rotating a list onto itself
+		{[]string{"RPOPLPUSH", "dst", "dst"}, bulkReply("a")},
+		{[]string{"LRANGE", "dst", "0", "-1"}, bulkArray("a", "c")},
+		{[]string{"LMOVE", "dst", "dst", "LEFT", "RIGHT"}, bulkReply("a")},
+		{[]string{"LRANGE", "dst", "0", "-1"}, bulkArray("c", "a")},
+		// moving the last element deletes the source
+		{[]string{"RPOPLPUSH", "src", "dst"}, bulkReply("b")},
+		{[]string{"EXISTS", "src"}, integerReply(0)},
+		{[]string{"LRANGE", "dst", "0", "-1"}, bulkArray("b", "c", "a")},
+		{[]string{"SET", "str", "x"}, "+OK\r\n"},
+		// a destination of the wrong type leaves the source untouched
+		{[]string{"LMOVE", "dst", "str", "LEFT", "LEFT"}, "-value not of list type\r\n"},
+		{[]string{"RPOPLPUSH", "str", "dst"}, "-value not of list type\r\n"},
+		{[]string{"LLEN", "dst"}, integerReply(3)},
+		{[]string{"RPUSH", "other", "x", "y"}, integerReply(2)},
+		{[]string{"LMPOP", "3", "missing", "dst", "other", "RIGHT", "COUNT", "2"}, "*2\r\n" + bulkReply("dst") + bulkArray("a", "c")},
+		{[]string{"LMPOP", "2", "dst", "other", "LEFT", "COUNT", "5"}, "*2\r\n" + bulkReply("dst") + bulkArray("b")},
+		{[]string{"EXISTS", "dst"}, integerReply(0)},
+		{[]string{"LMPOP", "2", "dst", "other", "LEFT"}, "*2\r\n" + bulkReply("other") + bulkArray("x")},
+		{[]string{"LMPOP", "1", "missing", "LEFT"}, "*-1\r\n"},
+		{[]string{"LMPOP", "2", "str", "other", "LEFT"}, "-value not of list type\r\n"},
+		{[]string{"LMPOP", "0", "other", "LEFT"}, "-numkeys should be greater than 0\r\n"},
+		{[]string{"LMPOP", "x", "other", "LEFT"}, "-value is not an integer or out of range\r\n"},
+		{[]string{"LMPOP", "2", "other", "LEFT"}, "-invalid syntax\r\n"},
+		{[]string{"LMPOP", "1", "other", "MIDDLE"}, "-invalid syntax\r\n"},
+		{[]string{"LMPOP", "1", "other", "LEFT", "COUNT", "0"}, "-count should be greater than 0\r\n"},
+		{[]string{"LMPOP", "1", "other", "LEFT", "COUNT"}, "-invalid syntax\r\n"},
+		{[]string{"LLEN", "other"}, integerReply(1)},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// TestConcurrentListMove moves elements back and forth between
+// lists from many goroutines and checks that every element ends up
+// in exactly one of them
+func TestConcurrentListMove(t *testing.T) {
+	s := newStore()
+	const length = 100
+	args := []string{"RPUSH", "a"}
+	for i := 0; i < length; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	run(t, s, args...)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			source, destination := "a", "b"
+			if i%2 == 1 {
+				source, destination = "b", "a"
+			}
+			for j := 0; j < 200; j++ {
+				switch j % 3 {
+				case 0:
+					run(t, s, "LMOVE", source, destination, "LEFT", "RIGHT")
+				case 1:
+					run(t, s, "RPOPLPUSH", source, destination)
+				default:
+					run(t, s, "LMOVE", source, source, "RIGHT", "LEFT")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	reply := run(t, s, "LRANGE", "a", "0", "-1") + run(t, s, "LRANGE", "b", "0", "-1")
+	for i := 0; i < length; i++ {
+		if n := strings.Count(reply, "\r\n"+strconv.Itoa(i)+"\r\n"); n != 1 {
+			t.Errorf("%d is held %d times", i, n)
+		}
+	}
+}
@@ -19,20 +19,63 @@ var keyValueStore = newStore()
 
 func dispatch(c net.Conn) {
 	var respError resp.SimpleError
-	err := dispatchHelper(c)
+	cl := newClient(c)
+	// release the client's subscriptions once it disconnects
+	defer pubSubHub.unsubscribeAll(cl)
+	defer cl.close()
+	err := dispatchHelper(cl)
 	if err != nil {
 		respError.Data = err.Error()
 		serialisedError, serialisationError := respError.Serialise()
 		if serialisationError != nil {
-			c.Close()
+			return
 		}
-		c.Write(serialisedError)
-		c.Close()
+		cl.write(serialisedError)
 	}
 }
 
-func dispatchHelper(c net.Conn) error {
-	reader := bufio.NewReader(c)
+func dispatchHelper(c *client) error {
+	reader := bufio.NewReader(c.conn)
+	// commands are read on their own goroutine, so that a client
+	// waiting on a blocking command notices when it disconnects
+	commands := make(chan [][]byte)
+	disconnected := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	var readErr error
+	go func() {
+		readErr = readCommands(reader, commands, quit)
+		close(disconnected)
+	}()
+	for {
+		var command [][]byte
+		select {
+		case command = <-commands:
+		case <-disconnected:
+			return readErr
+		}
+		var serialisedData []byte
+		var err error
+		if isPubSubCommand(string(command[0])) {
+			serialisedData, err = executePubSub(command, c)
+		} else if pubSubHub.subscribed(c) {
+			serialisedData, err = subscribedModeCommand(command)
+		} else if isBlockingCommand(command) {
+			serialisedData, err = executeBlocking(command, keyValueStore, disconnected)
+		} else {
+			serialisedData, err = execute(command, keyValueStore)
+		}
+		if err != nil {
+			return err
+		} else {
+			c.write(serialisedData)
+		}
+	}
+}
+
+// `readCommands` reads commands off the connection and sends them
+// on commands until the connection is closed or quit is closed
+func readCommands(reader *bufio.Reader, commands chan<- [][]byte, quit <-chan struct{}) error {
 	// read from the TCP connection until its closed
 	for {
 		// command stores the command and arguments passed
@@ -81,43 +124,335 @@ func dispatchHelper(c net.Conn) error {
 			// add command/arg to the data without the TERMINATOR
 			command = append(command, bulkStringData)
 		}
-		var serialisedData []byte
-		switch string(command[0]) {
-		case "PING":
-			serialisedData, err = ping(command[1:])
-		case "ECHO":
-			serialisedData, err = echo(command[1:])
-		case "GET":
-			serialisedData, err = get(command[1:], keyValueStore)
-		case "SET":
-			serialisedData, err = set(command[1:], keyValueStore)
-		case "EXISTS":
-			serialisedData, err = exists(command[1:], keyValueStore)
-		case "DEL":
-			serialisedData, err = del(command[1:], keyValueStore)
-		case "INCR":
-			serialisedData, err = incr(command[1:], keyValueStore)
-		case "DECR":
-			serialisedData, err = decr(command[1:], keyValueStore)
-		case "LPUSH":
-			serialisedData, err = lpush(command[1:], keyValueStore)
-		case "RPUSH":
-			serialisedData, err = rpush(command[1:], keyValueStore)
-		case "LRANGE":
-			serialisedData, err = lrange(command[1:], keyValueStore)
-		case "SAVE":
-			serialisedData, err = save(command[1:], keyValueStore)
-		default:
-			return resp.ErrInvalidCommand
+		if len(command) == 0 {
+			continue
 		}
-		if err != nil {
-			return err
-		} else {
-			c.Write(serialisedData)
+		select {
+		case commands <- command:
+		case <-quit:
+			return nil
 		}
 	}
 }
 
+// `execute` runs a single command against the store. The store lock
+// is held for the whole command so that every command, including the
+// read-modify-write ones, is atomic with respect to the keyspace
+func execute(command [][]byte, s *store) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var serialisedData []byte
+	var err error
+	switch string(command[0]) {
+	case "PING":
+		serialisedData, err = ping(command[1:])
+	case "ECHO":
+		serialisedData, err = echo(command[1:])
+	case "GET":
+		serialisedData, err = get(command[1:], s)
+	case "SET":
+		serialisedData, err = set(command[1:], s)
+	case "GETSET":
+		serialisedData, err = getset(command[1:], s)
+	case "GETDEL":
+		serialisedData, err = getdel(command[1:], s)
+	case "GETEX":
+		serialisedData, err = getex(command[1:], s)
+	case "LCS":
+		serialisedData, err = lcs(command[1:], s)
+	case "MGET":
+		serialisedData, err = mget(command[1:], s)
+	case "MSET":
+		serialisedData, err = mset(command[1:], s)
+	case "MSETNX":
+		serialisedData, err = msetnx(command[1:], s)
+	case "EXISTS":
+		serialisedData, err = exists(command[1:], s)
+	case "DEL":
+		serialisedData, err = del(command[1:], s)
+	case "DELEX":
+		serialisedData, err = delex(command[1:], s)
+	case "DIGEST":
+		serialisedData, err = digestCommand(command[1:], s)
+	case "RENAME":
+		serialisedData, err = rename(command[1:], s)
+	case "INCR":
+		serialisedData, err = incr(command[1:], s)
+	case "DECR":
+		serialisedData, err = decr(command[1:], s)
+	case "INCRBY":
+		serialisedData, err = incrby(command[1:], s)
+	case "DECRBY":
+		serialisedData, err = decrby(command[1:], s)
+	case "INCRBYFLOAT":
+		serialisedData, err = incrbyfloat(command[1:], s)
+	case "APPEND":
+		serialisedData, err = appendCommand(command[1:], s)
+	case "STRLEN":
+		serialisedData, err = strlen(command[1:], s)
+	case "GETRANGE":
+		serialisedData, err = getrange(command[1:], s)
+	case "SETRANGE":
+		serialisedData, err = setrange(command[1:], s)
+	case "SETBIT":
+		serialisedData, err = setbit(command[1:], s)
+	case "GETBIT":
+		serialisedData, err = getbit(command[1:], s)
+	case "BITCOUNT":
+		serialisedData, err = bitcount(command[1:], s)
+	case "BITPOS":
+		serialisedData, err = bitpos(command[1:], s)
+	case "BITOP":
+		serialisedData, err = bitop(command[1:], s)
+	case "BITFIELD":
+		serialisedData, err = bitfield(command[1:], s)
+	case "BITFIELD_RO":
+		serialisedData, err = bitfieldRO(command[1:], s)
+	case "PFADD":
+		serialisedData, err = pfadd(command[1:], s)
+	case "PFCOUNT":
+		serialisedData, err = pfcount(command[1:], s)
+	case "PFMERGE":
+		serialisedData, err = pfmerge(command[1:], s)
+	case "LPUSH":
+		serialisedData, err = lpush(command[1:], s)
+	case "RPUSH":
+		serialisedData, err = rpush(command[1:], s)
+	case "LRANGE":
+		serialisedData, err = lrange(command[1:], s)
+	case "DUMP":
+		serialisedData, err = dump(command[1:], s)
+	case "RESTORE":
+		serialisedData, err = restore(command[1:], s)
+	case "LPOP":
+		serialisedData, err = lpop(command[1:], s)
+	case "RPOP":
+		serialisedData, err = rpop(command[1:], s)
+	case "LLEN":
+		serialisedData, err = llen(command[1:], s)
+	case "LINDEX":
+		serialisedData, err = lindex(command[1:], s)
+	case "LSET":
+		serialisedData, err = lset(command[1:], s)
+	case "LREM":
+		serialisedData, err = lrem(command[1:], s)
+	case "LTRIM":
+		serialisedData, err = ltrim(command[1:], s)
+	case "LINSERT":
+		serialisedData, err = linsert(command[1:], s)
+	case "LPOS":
+		serialisedData, err = lpos(command[1:], s)
+	case "LMOVE":
+		serialisedData, err = lmove(command[1:], s)
+	case "RPOPLPUSH":
+		serialisedData, err = rpoplpush(command[1:], s)
+	case "LMPOP":
+		serialisedData, err = lmpop(command[1:], s)
+	case "BLPOP", "BRPOP", "BLMOVE", "BLMPOP", "BZPOPMIN", "BZPOPMAX", "BZMPOP", "XREAD", "XREADGROUP":
+		// blocking commands only get here when they mustn't block,
+		// XREAD and XREADGROUP without the BLOCK option, see
+		// `isBlockingCommand`
+		serialisedData, err = executeNoWait(command, s)
+	case "HSET":
+		serialisedData, err = hset(command[1:], s)
+	case "HSETNX":
+		serialisedData, err = hsetnx(command[1:], s)
+	case "HGET":
+		serialisedData, err = hget(command[1:], s)
+	case "HMGET":
+		serialisedData, err = hmget(command[1:], s)
+	case "HDEL":
+		serialisedData, err = hdel(command[1:], s)
+	case "HLEN":
+		serialisedData, err = hlen(command[1:], s)
+	case "HEXISTS":
+		serialisedData, err = hexists(command[1:], s)
+	case "HSTRLEN":
+		serialisedData, err = hstrlen(command[1:], s)
+	case "HGETALL":
+		serialisedData, err = hgetall(command[1:], s)
+	case "HKEYS":
+		serialisedData, err = hkeys(command[1:], s)
+	case "HVALS":
+		serialisedData, err = hvals(command[1:], s)
+	case "HINCRBY":
+		serialisedData, err = hincrby(command[1:], s)
+	case "HINCRBYFLOAT":
+		serialisedData, err = hincrbyfloat(command[1:], s)
+	case "HRANDFIELD":
+		serialisedData, err = hrandfield(command[1:], s)
+	case "HSCAN":
+		serialisedData, err = hscan(command[1:], s)
+	case "SADD":
+		serialisedData, err = sadd(command[1:], s)
+	case "SREM":
+		serialisedData, err = srem(command[1:], s)
+	case "SISMEMBER":
+		serialisedData, err = sismember(command[1:], s)
+	case "SMISMEMBER":
+		serialisedData, err = smismember(command[1:], s)
+	case "SMEMBERS":
+		serialisedData, err = smembers(command[1:], s)
+	case "SCARD":
+		serialisedData, err = scard(command[1:], s)
+	case "SPOP":
+		serialisedData, err = spop(command[1:], s)
+	case "SRANDMEMBER":
+		serialisedData, err = srandmember(command[1:], s)
+	case "SMOVE":
+		serialisedData, err = smove(command[1:], s)
+	case "SINTER":
+		serialisedData, err = sinter(command[1:], s)
+	case "SUNION":
+		serialisedData, err = sunion(command[1:], s)
+	case "SDIFF":
+		serialisedData, err = sdiff(command[1:], s)
+	case "SINTERSTORE":
+		serialisedData, err = sinterstore(command[1:], s)
+	case "SUNIONSTORE":
+		serialisedData, err = sunionstore(command[1:], s)
+	case "SDIFFSTORE":
+		serialisedData, err = sdiffstore(command[1:], s)
+	case "SINTERCARD":
+		serialisedData, err = sintercard(command[1:], s)
+	case "SSCAN":
+		serialisedData, err = sscan(command[1:], s)
+	case "ZADD":
+		serialisedData, err = zadd(command[1:], s)
+	case "ZREM":
+		serialisedData, err = zrem(command[1:], s)
+	case "ZSCORE":
+		serialisedData, err = zscore(command[1:], s)
+	case "ZMSCORE":
+		serialisedData, err = zmscore(command[1:], s)
+	case "ZINCRBY":
+		serialisedData, err = zincrby(command[1:], s)
+	case "ZCARD":
+		serialisedData, err = zcard(command[1:], s)
+	case "ZCOUNT":
+		serialisedData, err = zcount(command[1:], s)
+	case "ZRANK":
+		serialisedData, err = zrank(command[1:], s)
+	case "ZREVRANK":
+		serialisedData, err = zrevrank(command[1:], s)
+	case "ZRANGE":
+		serialisedData, err = zrange(command[1:], s)
+	case "ZRANGESTORE":
+		serialisedData, err = zrangestore(command[1:], s)
+	case "ZPOPMIN":
+		serialisedData, err = zpopmin(command[1:], s)
+	case "ZPOPMAX":
+		serialisedData, err = zpopmax(command[1:], s)
+	case "ZMPOP":
+		serialisedData, err = zmpop(command[1:], s)
+	case "ZUNION":
+		serialisedData, err = zunion(command[1:], s)
+	case "ZINTER":
+		serialisedData, err = zinter(command[1:], s)
+	case "ZDIFF":
+		serialisedData, err = zdiff(command[1:], s)
+	case "ZUNIONSTORE":
+		serialisedData, err = zunionstore(command[1:], s)
+	case "ZINTERSTORE":
+		serialisedData, err = zinterstore(command[1:], s)
+	case "ZDIFFSTORE":
+		serialisedData, err = zdiffstore(command[1:], s)
+	case "ZREMRANGEBYSCORE":
+		serialisedData, err = zremrangebyscore(command[1:], s)
+	case "ZREMRANGEBYRANK":
+		serialisedData, err = zremrangebyrank(command[1:], s)
+	case "ZREMRANGEBYLEX":
+		serialisedData, err = zremrangebylex(command[1:], s)
+	case "GEOADD":
+		serialisedData, err = geoadd(command[1:], s)
+	case "GEOPOS":
+		serialisedData, err = geopos(command[1:], s)
+	case "GEODIST":
+		serialisedData, err = geodist(command[1:], s)
+	case "GEOHASH":
+		serialisedData, err = geohashCommand(command[1:], s)
+	case "GEOSEARCH":
+		serialisedData, err = geosearch(command[1:], s)
+	case "GEOSEARCHSTORE":
+		serialisedData, err = geosearchstore(command[1:], s)
+	case "XADD":
+		serialisedData, err = xadd(command[1:], s)
+	case "XLEN":
+		serialisedData, err = xlen(command[1:], s)
+	case "XRANGE":
+		serialisedData, err = xrange(command[1:], s)
+	case "XREVRANGE":
+		serialisedData, err = xrevrange(command[1:], s)
+	case "XDEL":
+		serialisedData, err = xdel(command[1:], s)
+	case "XTRIM":
+		serialisedData, err = xtrim(command[1:], s)
+	case "XGROUP":
+		serialisedData, err = xgroup(command[1:], s)
+	case "XACK":
+		serialisedData, err = xack(command[1:], s)
+	case "XPENDING":
+		serialisedData, err = xpending(command[1:], s)
+	case "XCLAIM":
+		serialisedData, err = xclaim(command[1:], s)
+	case "XAUTOCLAIM":
+		serialisedData, err = xautoclaim(command[1:], s)
+	case "XINFO":
+		serialisedData, err = xinfo(command[1:], s)
+	case "JSON.SET":
+		serialisedData, err = jsonSet(command[1:], s)
+	case "JSON.GET":
+		serialisedData, err = jsonGet(command[1:], s)
+	case "JSON.DEL", "JSON.FORGET":
+		serialisedData, err = jsonDel(command[1:], s)
+	case "JSON.TYPE":
+		serialisedData, err = jsonType(command[1:], s)
+	case "JSON.NUMINCRBY":
+		serialisedData, err = jsonNumIncrBy(command[1:], s)
+	case "JSON.STRAPPEND":
+		serialisedData, err = jsonStrAppend(command[1:], s)
+	case "JSON.ARRAPPEND":
+		serialisedData, err = jsonArrAppend(command[1:], s)
+	case "JSON.ARRINSERT":
+		serialisedData, err = jsonArrInsert(command[1:], s)
+	case "JSON.ARRPOP":
+		serialisedData, err = jsonArrPop(command[1:], s)
+	case "JSON.ARRLEN":
+		serialisedData, err = jsonArrLen(command[1:], s)
+	case "JSON.OBJKEYS":
+		serialisedData, err = jsonObjKeys(command[1:], s)
+	case "HEXPIRE":
+		serialisedData, err = hexpire(command[1:], s)
+	case "HPEXPIRE":
+		serialisedData, err = hpexpire(command[1:], s)
+	case "HEXPIREAT":
+		serialisedData, err = hexpireat(command[1:], s)
+	case "HPEXPIREAT":
+		serialisedData, err = hpexpireat(command[1:], s)
+	case "HTTL":
+		serialisedData, err = httl(command[1:], s)
+	case "HPTTL":
+		serialisedData, err = hpttl(command[1:], s)
+	case "HPERSIST":
+		serialisedData, err = hpersist(command[1:], s)
+	case "HGETEX":
+		serialisedData, err = hgetex(command[1:], s)
+	case "HSETEX":
+		serialisedData, err = hsetex(command[1:], s)
+	case "SAVE":
+		serialisedData, err = save(command[1:], s)
+	case "PUBLISH":
+		serialisedData, err = publish(command[1:])
+	case "CONFIG":
+		serialisedData, err = configCommand(command[1:])
+	default:
+		return nil, resp.ErrInvalidCommand
+	}
+	// serve the clients blocked on keys the command fed
+	s.serveBlockedClients()
+	return serialisedData, err
+}
+
 // `extractKeyValuePair` extracts a key value pair
 func extractKeyValuePair(reader *bufio.Reader) (string, redisValue, error) {
 	var value redisValue
@@ -168,57 +503,99 @@ func extractKeyValuePair(reader *bufio.Reader) (string, redisValue, error) {
 		value.value = bulkStringData
 		value.expire = expireTime
 		value.valueType = "string"
-	} else { // list is stored as an array of bulk strings
-		// arrayToken is made up of ARRAY_IDENTIFIER<size>TERMINATOR
-		arrayToken, err := reader.ReadBytes('\n')
+	} else { // lists, hashes, sets, sorted sets, streams and JSON documents are stored as arrays of bulk strings
+		elements, err := readBulkStringArray(reader)
 		if err != nil {
-			return "", value, resp.ErrTerminatorNotFound
+			return "", value, err
 		}
-		if string(arrayToken[0]) != resp.ARRAY_IDENTIFIER {
+		value.expire = expireTime
+		value.valueType = valueType
+		// the key of an empty collection is deleted, never saved
+		if len(elements) == 0 && valueType != "stream" && valueType != "json" {
 			return "", value, resp.ErrInvalidClientData
 		}
-		arraySize, err := strconv.Atoi(string(arrayToken[1 : len(arrayToken)-resp.TERMINATOR_SIZE]))
-		if err != nil {
-			return "", value, resp.ErrLengthExtraction
-		}
-		nodes := make([]*node, arraySize)
-		// read one bulk string at a time
-		for i := 0; i < arraySize; i++ {
-			bulkString, err := reader.ReadBytes('\n')
+		switch valueType {
+		case "list":
+			list := newList()
+			list.tpush(elements)
+			value.value = list
+		case "hash":
+			value.value, err = hashFromElements(elements)
 			if err != nil {
-				return "", value, resp.ErrTerminatorNotFound
+				return "", value, err
 			}
-			// extract length
-			length, err := strconv.Atoi(string(bulkString[1 : len(bulkString)-resp.TERMINATOR_SIZE]))
-			if err != nil {
-				return "", value, resp.ErrLengthExtraction
+		case "set":
+			st := newRedisSet()
+			for _, member := range elements {
+				st.add(string(member))
 			}
-			bulkStringData := make([]byte, length)
-			copied, err := io.ReadFull(reader, bulkStringData)
+			value.value = st
+		case "zset":
+			value.value, err = zsetFromElements(elements)
 			if err != nil {
 				return "", value, err
 			}
-			if copied != len(bulkStringData) {
-				return "", value, resp.ErrBulkStringDataSize
-			}
-			nodes[i] = &node{
-				data: bulkStringData,
+		case "stream":
+			value.value, err = streamFromElements(elements)
+			if err != nil {
+				return "", value, err
 			}
-			// read the terminator
-			_, err = reader.ReadBytes('\n')
+		case "json":
+			value.value, err = jsonFromElements(elements)
 			if err != nil {
-				return "", value, resp.ErrTerminatorNotFound
+				return "", value, err
 			}
+		default:
+			return "", value, resp.ErrInvalidClientData
 		}
-		// add the values to a list
-		list := newList()
-		value.value = list
-		value.valueType = "list"
-		list.tpush(nodes)
 	}
 	return key, value, nil
 }
 
+// `readBulkStringArray` reads an array of bulk strings
+func readBulkStringArray(reader *bufio.Reader) ([][]byte, error) {
+	// arrayToken is made up of ARRAY_IDENTIFIER<size>TERMINATOR
+	arrayToken, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, resp.ErrTerminatorNotFound
+	}
+	if string(arrayToken[0]) != resp.ARRAY_IDENTIFIER {
+		return nil, resp.ErrInvalidClientData
+	}
+	arraySize, err := strconv.Atoi(string(arrayToken[1 : len(arrayToken)-resp.TERMINATOR_SIZE]))
+	if err != nil {
+		return nil, resp.ErrLengthExtraction
+	}
+	elements := make([][]byte, arraySize)
+	// read one bulk string at a time
+	for i := 0; i < arraySize; i++ {
+		bulkString, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, resp.ErrTerminatorNotFound
+		}
+		// extract length
+		length, err := strconv.Atoi(string(bulkString[1 : len(bulkString)-resp.TERMINATOR_SIZE]))
+		if err != nil {
+			return nil, resp.ErrLengthExtraction
+		}
+		bulkStringData := make([]byte, length)
+		copied, err := io.ReadFull(reader, bulkStringData)
+		if err != nil {
+			return nil, err
+		}
+		if copied != len(bulkStringData) {
+			return nil, resp.ErrBulkStringDataSize
+		}
+		elements[i] = bulkStringData
+		// read the terminator
+		_, err = reader.ReadBytes('\n')
+		if err != nil {
+			return nil, resp.ErrTerminatorNotFound
+		}
+	}
+	return elements, nil
+}
+
 func loadFromDB(file *os.File) error {
 	reader := bufio.NewReader(file)
 	for {
@@ -228,9 +605,10 @@ func loadFromDB(file *os.File) error {
 			if errors.Is(err, io.EOF) {
 				break
 			}
+			return err
 		}
 		// store the key value pair in the database
-		keyValueStore.db[key] = value
+		keyValueStore.set(key, &value)
 	}
 	return nil
 }
@@ -250,6 +628,12 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	// actively expire hash fields
+	go func() {
+		for range time.Tick(100 * time.Millisecond) {
+			keyValueStore.activeExpireCycle()
+		}
+	}()
 	listener, err := net.Listen("tcp", ":6379")
 	if err != nil {
 		log.Fatalln(err)
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// `run` executes a command against s and returns the serialised
+// reply. It's safe to call from several goroutines
+func run(t testing.TB, s *store, args ...string) string {
+	t.Helper()
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	reply, err := execute(command, s)
+	if err != nil {
+		t.Errorf("%q: %v", args, err)
+	}
+	return string(reply)
+}
+
+// `integerReply` and `bulkReply` serialise the replies tests expect
+func integerReply(n int64) string {
+	return ":" + strconv.FormatInt(n, 10) + "\r\n"
+}
+
+func bulkReply(data string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(data), data)
+}
+
+// `bulkArray` serialises an array of bulk strings
+func bulkArray(elements ...string) string {
+	var reply strings.Builder
+	fmt.Fprintf(&reply, "*%d\r\n", len(elements))
+	for _, data := range elements {
+		fmt.Fprintf(&reply, "$%d\r\n%s\r\n", len(data), data)
+	}
+	return reply.String()
+}
+
+// TestConcurrentReadModifyWrite runs read-modify-write commands
+// against the same keys from many goroutines and checks that no
+// update was lost. Run it with `go test -race`, which also catches
+// data races between writers and readers of the same list
+func TestConcurrentReadModifyWrite(t *testing.T) {
+	const goroutines = 50
+	const iterations = 500
+	s := newStore()
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := "value" + strconv.Itoa(i)
+			for j := 0; j < iterations; j++ {
+				run(t, s, "INCR", "counter")
+				run(t, s, "DECR", "negative")
+				run(t, s, "INCRBY", "byfive", "5")
+				run(t, s, "RPUSH", "list", value)
+				run(t, s, "LPUSH", "list", value)
+				run(t, s, "APPEND", "log", "x")
+				run(t, s, "LRANGE", "list", "0", "9")
+				run(t, s, "GET", "counter")
+			}
+		}(i)
+	}
+	wg.Wait()
+	total := int64(goroutines * iterations)
+	checks := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "counter"}, bulkReply(strconv.FormatInt(total, 10))},
+		{[]string{"GET", "negative"}, bulkReply(strconv.FormatInt(-total, 10))},
+		{[]string{"GET", "byfive"}, bulkReply(strconv.FormatInt(5*total, 10))},
+		{[]string{"LLEN", "list"}, integerReply(2 * total)},
+		{[]string{"STRLEN", "log"}, integerReply(total)},
+	}
+	for _, check := range checks {
+		if got := run(t, s, check.args...); got != check.want {
+			t.Errorf("%q = %q, want %q", check.args, got, check.want)
+		}
+	}
+}
+
+// TestConcurrentClients drives the same counter from many
+// connections, through the code path real clients take
+func TestConcurrentClients(t *testing.T) {
+	const clients = 20
+	const iterations = 200
+	key := "TestConcurrentClients"
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		serverConn, clientConn := net.Pipe()
+		go dispatch(serverConn)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer clientConn.Close()
+			reader := bufio.NewReader(clientConn)
+			command := fmt.Sprintf("*2\r\n$4\r\nINCR\r\n$%d\r\n%s\r\n", len(key), key)
+			for j := 0; j < iterations; j++ {
+				if _, err := clientConn.Write([]byte(command)); err != nil {
+					t.Error(err)
+					return
+				}
+				reply, err := reader.ReadString('\n')
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if reply[0] != ':' {
+					t.Errorf("INCR replied %q", reply)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	want := bulkReply(strconv.Itoa(clients * iterations))
+	if got := run(t, keyValueStore, "GET", key); got != want {
+		t.Errorf("GET %s = %q, want %q", key, got, want)
+	}
+}
+
+// TestLoadEmptyCollections checks that a snapshot holding an empty
+// list, set, sorted set or hash is rejected
+func TestLoadEmptyCollections(t *testing.T) {
+	expire := time.Time{}.Format(time.UnixDate)
+	for _, valueType := range []string{"list", "set", "zset", "hash"} {
+		snapshot := "+key\r\n+" + expire + "\r\n+" + valueType + "\r\n*0\r\n"
+		if _, _, err := extractKeyValuePair(bufio.NewReader(strings.NewReader(snapshot))); err == nil {
+			t.Errorf("an empty %s was loaded", valueType)
+		}
+	}
+	snapshot := "+key\r\n+" + expire + "\r\n+set\r\n*1\r\n$1\r\na\r\n"
+	if _, value, err := extractKeyValuePair(bufio.NewReader(strings.NewReader(snapshot))); err != nil || value.value.(*redisSet).size() != 1 {
+		t.Errorf("a set of one member wasn't loaded: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// keyspace event classes, as configured by notify-keyspace-events
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyModule               // d
+	notifyNew                  // n
+	// A is an alias for "g$lshzxetd"
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZset | notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+var errInvalidKeyspaceEvents = errors.New("invalid event class character")
+
+// `keyspaceEventsFromString` parses the notify-keyspace-events
+// configuration into a set of flags
+func keyspaceEventsFromString(classes string) (int, error) {
+	flags := 0
+	for _, class := range classes {
+		switch class {
+		case 'A':
+			flags |= notifyAll
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZset
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 't':
+			flags |= notifyStream
+		case 'd':
+			flags |= notifyModule
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'm':
+			flags |= notifyKeyMiss
+		case 'n':
+			flags |= notifyNew
+		default:
+			return 0, errInvalidKeyspaceEvents
+		}
+	}
+	return flags, nil
+}
+
+// `keyspaceEventsToString` converts a set of flags back into
+// the notify-keyspace-events configuration string
+func keyspaceEventsToString(flags int) string {
+	var classes strings.Builder
+	if flags&notifyAll == notifyAll {
+		classes.WriteByte('A')
+	} else {
+		for _, class := range []struct {
+			flag int
+			char byte
+		}{
+			{notifyGeneric, 'g'}, {notifyString, '$'}, {notifyList, 'l'},
+			{notifySet, 's'}, {notifyHash, 'h'}, {notifyZset, 'z'},
+			{notifyExpired, 'x'}, {notifyEvicted, 'e'}, {notifyStream, 't'},
+			{notifyModule, 'd'},
+		} {
+			if flags&class.flag != 0 {
+				classes.WriteByte(class.char)
+			}
+		}
+	}
+	if flags&notifyKeyspace != 0 {
+		classes.WriteByte('K')
+	}
+	if flags&notifyKeyevent != 0 {
+		classes.WriteByte('E')
+	}
+	if flags&notifyKeyMiss != 0 {
+		classes.WriteByte('m')
+	}
+	if flags&notifyNew != 0 {
+		classes.WriteByte('n')
+	}
+	return classes.String()
+}
+
+// `notifyKeyspaceEvent` publishes a keyspace event to
+// __keyspace@0__:<key> and __keyevent@0__:<event>, provided
+// the event's class is enabled. The caller must hold the store lock
+func (s *store) notifyKeyspaceEvent(class int, event string, key string) {
+	flags := serverConfig.notifyKeyspaceEvents
+	if flags&class == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		pubSubHub.publish("__keyspace@0__:"+key, []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		pubSubHub.publish("__keyevent@0__:"+event, []byte(key))
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// `pubSub` keeps track of the clients subscribed to
+// channels and patterns
+type pubSub struct {
+	lock     sync.Mutex
+	channels map[string]map[*client]struct{}
+	patterns map[string]map[*client]struct{}
+}
+
+var pubSubHub = newPubSub()
+
+// `newPubSub` returns an instance of `pubSub`
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*client]struct{}),
+		patterns: make(map[string]map[*client]struct{}),
+	}
+}
+
+// `subscriptionReply` serialises the reply sent for each
+// channel or pattern a client (un)subscribes from
+func subscriptionReply(kind string, channel *string, count int) ([]byte, error) {
+	response := resp.Array{
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte(kind), Size: len(kind)},
+			&resp.BulkString{Size: -1},
+			&resp.Integer{Data: int64(count)},
+		},
+	}
+	if channel != nil {
+		response.Elements[1] = &resp.BulkString{Data: []byte(*channel), Size: len(*channel)}
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `subscriptionCount` returns the number of channels and patterns
+// a client is subscribed to. The caller must hold the pubSub lock
+func (c *client) subscriptionCount() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// `subscribed` reports whether the client is in subscribed mode
+func (p *pubSub) subscribed(c *client) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return c.subscriptionCount() > 0
+}
+
+// `subscribe` subscribes a client to channels (or patterns)
+func (p *pubSub) subscribe(c *client, names [][]byte, pattern bool) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	registry, subscriptions, kind := p.channels, c.channels, "subscribe"
+	if pattern {
+		registry, subscriptions, kind = p.patterns, c.patterns, "psubscribe"
+	}
+	var serialised []byte
+	for _, name := range names {
+		channel := string(name)
+		if _, ok := registry[channel]; !ok {
+			registry[channel] = make(map[*client]struct{})
+		}
+		registry[channel][c] = struct{}{}
+		subscriptions[channel] = struct{}{}
+		reply, err := subscriptionReply(kind, &channel, c.subscriptionCount())
+		if err != nil {
+			return nil, err
+		}
+		serialised = append(serialised, reply...)
+	}
+	return serialised, nil
+}
+
+// `unsubscribe` unsubscribes a client from channels (or patterns).
+// The client is unsubscribed from all of them if none are passed
+func (p *pubSub) unsubscribe(c *client, names [][]byte, pattern bool) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	registry, subscriptions, kind := p.channels, c.channels, "unsubscribe"
+	if pattern {
+		registry, subscriptions, kind = p.patterns, c.patterns, "punsubscribe"
+	}
+	if len(names) == 0 {
+		for channel := range subscriptions {
+			names = append(names, []byte(channel))
+		}
+		// nothing to unsubscribe from
+		if len(names) == 0 {
+			return subscriptionReply(kind, nil, c.subscriptionCount())
+		}
+	}
+	var serialised []byte
+	for _, name := range names {
+		channel := string(name)
+		delete(subscriptions, channel)
+		if clients, ok := registry[channel]; ok {
+			delete(clients, c)
+			if len(clients) == 0 {
+				delete(registry, channel)
+			}
+		}
+		reply, err := subscriptionReply(kind, &channel, c.subscriptionCount())
+		if err != nil {
+			return nil, err
+		}
+		serialised = append(serialised, reply...)
+	}
+	return serialised, nil
+}
+
+// `unsubscribeAll` removes every subscription of a client,
+// it is called when the client disconnects
+func (p *pubSub) unsubscribeAll(c *client) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for channel := range c.channels {
+		delete(p.channels[channel], c)
+		if len(p.channels[channel]) == 0 {
+			delete(p.channels, channel)
+		}
+	}
+	for pattern := range c.patterns {
+		delete(p.patterns[pattern], c)
+		if len(p.patterns[pattern]) == 0 {
+			delete(p.patterns, pattern)
+		}
+	}
+	c.channels = make(map[string]struct{})
+	c.patterns = make(map[string]struct{})
+}
+
+// `publish` queues a message for every client subscribed to the
+// channel or to a pattern matching it, without waiting on any of
+// them. It returns the number of clients the message was queued for
+func (p *pubSub) publish(channel string, message []byte) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	receivers := 0
+	if clients, ok := p.channels[channel]; ok {
+		response := resp.Array{
+			Size: 3,
+			Elements: []resp.RESPDatatype{
+				&resp.BulkString{Data: []byte("message"), Size: len("message")},
+				&resp.BulkString{Data: []byte(channel), Size: len(channel)},
+				&resp.BulkString{Data: message, Size: len(message)},
+			},
+		}
+		serialised, err := response.Serialise()
+		if err == nil {
+			for c := range clients {
+				if c.push(serialised) {
+					receivers++
+				}
+			}
+		}
+	}
+	for pattern, clients := range p.patterns {
+		if !globMatch([]byte(pattern), []byte(channel)) {
+			continue
+		}
+		response := resp.Array{
+			Size: 4,
+			Elements: []resp.RESPDatatype{
+				&resp.BulkString{Data: []byte("pmessage"), Size: len("pmessage")},
+				&resp.BulkString{Data: []byte(pattern), Size: len(pattern)},
+				&resp.BulkString{Data: []byte(channel), Size: len(channel)},
+				&resp.BulkString{Data: message, Size: len(message)},
+			},
+		}
+		serialised, err := response.Serialise()
+		if err != nil {
+			continue
+		}
+		for c := range clients {
+			if c.push(serialised) {
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// `isPubSubCommand` reports whether a command manages
+// the subscriptions of a client
+func isPubSubCommand(command string) bool {
+	switch command {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+		return true
+	}
+	return false
+}
+
+// `executePubSub` runs the commands that (un)subscribe a client.
+// These commands don't touch the keyspace so they don't take
+// the store lock
+func executePubSub(command [][]byte, c *client) ([]byte, error) {
+	switch string(command[0]) {
+	case "SUBSCRIBE":
+		if len(command) < 2 {
+			return wrongNumberOfArgs("subscribe")
+		}
+		return pubSubHub.subscribe(c, command[1:], false)
+	case "PSUBSCRIBE":
+		if len(command) < 2 {
+			return wrongNumberOfArgs("psubscribe")
+		}
+		return pubSubHub.subscribe(c, command[1:], true)
+	case "UNSUBSCRIBE":
+		return pubSubHub.unsubscribe(c, command[1:], false)
+	case "PUNSUBSCRIBE":
+		return pubSubHub.unsubscribe(c, command[1:], true)
+	}
+	return nil, resp.ErrInvalidCommand
+}
+
+// `subscribedModeCommand` handles the commands a client in subscribed
+// mode may run besides the (un)subscribe commands
+func subscribedModeCommand(command [][]byte) ([]byte, error) {
+	if string(command[0]) != "PING" {
+		return errorReply("can't execute '" + strings.ToLower(string(command[0])) +
+			"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+	}
+	// PING replies with an array in subscribed mode
+	var payload []byte
+	if len(command) > 1 {
+		payload = command[1]
+	}
+	response := resp.Array{
+		Size: 2,
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte("pong"), Size: len("pong")},
+			&resp.BulkString{Data: payload, Size: len(payload)},
+		},
+	}
+	return response.Serialise()
+}
+
+// PUBLISH command posts a message to a channel
+func publish(args [][]byte) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("publish")
+	}
+	response := resp.Integer{
+		Data: int64(pubSubHub.publish(string(args[0]), args[1])),
+	}
+	return response.Serialise()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPublishDoesNotWaitOnStalledSubscriber checks that a subscriber
+// that stops reading doesn't hold up publishing, and that it's
+// disconnected once its queue overflows
+func TestPublishDoesNotWaitOnStalledSubscriber(t *testing.T) {
+	p := newPubSub()
+	stalledConn, stalledPeer := net.Pipe()
+	defer stalledPeer.Close()
+	stalled := newClient(stalledConn)
+	p.subscribe(stalled, [][]byte{[]byte("news")}, false)
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*clientQueueSize; i++ {
+			p.publish("news", []byte("message"))
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing waited on the stalled subscriber")
+	}
+	select {
+	case <-stalled.done:
+	default:
+		t.Fatal("the stalled subscriber wasn't disconnected")
+	}
+	if _, err := stalledPeer.Read(make([]byte, 1)); err == nil {
+		// the connection may hand over what was being written
+		// when it was closed, it must be closed after that
+		stalledPeer.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := bufio.NewReader(stalledPeer).ReadString(0); err == nil {
+			t.Error("the stalled subscriber's connection wasn't closed")
+		}
+	}
+
+	// subscribers that keep up still receive messages
+	readingConn, readingPeer := net.Pipe()
+	defer readingPeer.Close()
+	reading := newClient(readingConn)
+	defer reading.close()
+	p.subscribe(reading, [][]byte{[]byte("news")}, false)
+	if receivers := p.publish("news", []byte("message")); receivers != 1 {
+		t.Errorf("publish reached %d clients, want 1", receivers)
+	}
+	readingPeer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(readingPeer)
+	want := []string{"*3\r\n", "$7\r\n", "message\r\n", "$4\r\n", "news\r\n", "$7\r\n", "message\r\n"}
+	for _, line := range want {
+		got, err := reader.ReadString('\n')
+		if err != nil || got != line {
+			t.Fatalf("read %q, %v, want %q", got, err, line)
+		}
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	defer func(flags int) { serverConfig.notifyKeyspaceEvents = flags }(serverConfig.notifyKeyspaceEvents)
+	s := newStore()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newClient(conn)
+	defer c.close()
+	pubSubHub.subscribe(c, [][]byte{[]byte("__key*__:*")}, true)
+	defer pubSubHub.unsubscribeAll(c)
+	reader := bufio.NewReader(peer)
+	// `next` reads the next notification, as its channel and message
+	next := func() (string, string) {
+		t.Helper()
+		peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var fields []string
+		for i := 0; i < 9; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading a notification: %v", err)
+			}
+			// skip the array and bulk string headers
+			if i == 0 || i%2 == 1 {
+				continue
+			}
+			fields = append(fields, strings.TrimSuffix(line, "\r\n"))
+		}
+		if fields[0] != "pmessage" {
+			t.Fatalf("received a %s, want a pmessage", fields[0])
+		}
+		return fields[2], fields[3]
+	}
+	// `expect` checks the notifications of a command, keyspace
+	// ones first, then keyevent ones
+	expect := func(command []string, events ...string) {
+		t.Helper()
+		run(t, s, command...)
+		for _, event := range events {
+			event, key, _ := strings.Cut(event, " ")
+			for _, want := range [][2]string{{"__keyspace@0__:" + key, event}, {"__keyevent@0__:" + event, key}} {
+				if channel, message := next(); channel != want[0] || message != want[1] {
+					t.Fatalf("%q notified %s %s, want %s %s", command, channel, message, want[0], want[1])
+				}
+			}
+		}
+	}
+
+	if got := run(t, s, "CONFIG", "SET", "notify-keyspace-events", "KEA"); got != "+OK\r\n" {
+		t.Fatalf("CONFIG SET replied %q", got)
+	}
+	if got := run(t, s, "CONFIG", "SET", "notify-keyspace-events", "KEQ"); got == "+OK\r\n" {
+		t.Error("CONFIG SET accepted an unknown event class")
+	}
+	expect([]string{"SET", "k", "v", "EX", "100"}, "set k", "expire k")
+	expect([]string{"INCR", "n"}, "incrby n")
+	expect([]string{"RPUSH", "l", "a", "b"}, "rpush l")
+	expect([]string{"LPOP", "l", "2"}, "lpop l", "del l")
+	expect([]string{"HSET", "h", "f", "v"}, "hset h")
+	expect([]string{"DEL", "k", "n", "h"}, "del k", "del n", "del h")
+	expect([]string{"SET", "volatile", "v", "PX", "1"}, "set volatile", "expire volatile")
+	time.Sleep(5 * time.Millisecond)
+	expect([]string{"GET", "volatile"}, "expired volatile")
+
+	// only the enabled classes are notified, on the enabled channels
+	run(t, s, "CONFIG", "SET", "notify-keyspace-events", "El")
+	expect([]string{"SET", "k", "v"})
+	expect([]string{"SADD", "set", "a"})
+	run(t, s, "RPUSH", "l", "a")
+	if channel, message := next(); channel != "__keyevent@0__:rpush" || message != "l" {
+		t.Errorf("RPUSH notified %s %s with only keyevent list events enabled", channel, message)
+	}
+	run(t, s, "CONFIG", "SET", "notify-keyspace-events", "Kn")
+	run(t, s, "SET", "k", "w")
+	run(t, s, "SET", "brand new", "v")
+	if channel, message := next(); channel != "__keyspace@0__:brand new" || message != "new" {
+		t.Errorf("creating a key notified %s %s, want a new key event", channel, message)
+	}
+	run(t, s, "CONFIG", "SET", "notify-keyspace-events", "")
+	run(t, s, "SET", "other", "v")
+	if receivers := pubSubHub.publish("__keyspace@0__:done", []byte("done")); receivers != 1 {
+		t.Fatalf("publish reached %d clients, want 1", receivers)
+	}
+	if channel, message := next(); channel != "__keyspace@0__:done" {
+		t.Errorf("received %s %s with notifications disabled", channel, message)
+	}
+}
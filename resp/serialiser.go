@@ -103,7 +103,7 @@ type Integer struct {
 
 // Serialise serialises an Integer into the RESP format
 func (i *Integer) Serialise() ([]byte, error) {
-	return []byte(INTEGER_IDENTIFIER + strconv.Itoa(int(i.Data)) + TERMINATOR), nil
+	return []byte(INTEGER_IDENTIFIER + strconv.FormatInt(i.Data, 10) + TERMINATOR), nil
 }
 
 // Deserialise converts data into an Integer
@@ -116,11 +116,11 @@ func (i *Integer) Deserialise(data []byte) (int, error) {
 	if err != nil {
 		return position, err
 	}
-	num, err := strconv.Atoi(string(data))
+	num, err := strconv.ParseInt(string(data), 10, 64)
 	if err != nil {
 		return position, ErrIntegerConversion
 	}
-	i.Data = int64(num)
+	i.Data = num
 	return position, nil
 }
 
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// `scanOptions` holds the arguments of the SCAN family of commands
+type scanOptions struct {
+	cursor uint64
+	// pattern is nil when every name matches
+	pattern  []byte
+	count    int
+	noValues bool
+}
+
+// `parseScanOptions` parses cursor [MATCH pattern] [COUNT count], along
+// with NOVALUES when allowNoValues is set. A non empty string is the
+// error to reply with
+func parseScanOptions(args [][]byte, allowNoValues bool) (scanOptions, string) {
+	options := scanOptions{
+		count: 10,
+	}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return options, "invalid cursor"
+	}
+	options.cursor = cursor
+	for i := 1; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); {
+		case option == "MATCH" && i+1 < len(args):
+			i++
+			options.pattern = args[i]
+		case option == "COUNT" && i+1 < len(args):
+			i++
+			count, ok := parseInteger(args[i])
+			if !ok {
+				return options, "value is not an integer or out of range"
+			}
+			if count < 1 {
+				return options, "invalid syntax"
+			}
+			options.count = int(min(count, int64(maxScanCount)))
+		case option == "NOVALUES" && allowNoValues:
+			options.noValues = true
+		default:
+			return options, "invalid syntax"
+		}
+	}
+	return options, ""
+}
+
+// upper bound on the page size, so that COUNT doesn't overflow
+const maxScanCount = 1 << 30
+
+// smallest number of buckets of a `scanIndex`
+const minScanBuckets = 4
+
+// scanIndex buckets names by a hash of the name, the way the hash
+// tables of redis do, so that SCAN resumes from the bucket it
+// stopped at instead of going over every name for each page. The
+// number of buckets is a power of two that follows the number of
+// names
+type scanIndex struct {
+	buckets [][]string
+	size    int
+}
+
+// `scanHash` returns the hash a name is bucketed by
+func scanHash(name string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return hash.Sum64()
+}
+
+// `bucket` returns the bucket name belongs to
+func (x *scanIndex) bucket(name string) *[]string {
+	return &x.buckets[scanHash(name)&uint64(len(x.buckets)-1)]
+}
+
+// `add` adds name, which mustn't be in the index yet
+func (x *scanIndex) add(name string) {
+	if x.size >= len(x.buckets) {
+		x.resize(max(minScanBuckets, 2*len(x.buckets)))
+	}
+	bucket := x.bucket(name)
+	*bucket = append(*bucket, name)
+	x.size++
+}
+
+// `remove` removes name from the index, if it's there
+func (x *scanIndex) remove(name string) {
+	if x.size == 0 {
+		return
+	}
+	bucket := x.bucket(name)
+	i := slices.Index(*bucket, name)
+	if i < 0 {
+		return
+	}
+	last := len(*bucket) - 1
+	(*bucket)[i] = (*bucket)[last]
+	(*bucket)[last] = ""
+	*bucket = (*bucket)[:last]
+	x.size--
+	if len(x.buckets) > minScanBuckets && x.size < len(x.buckets)/8 {
+		x.resize(len(x.buckets) / 2)
+	}
+}
+
+// `resize` moves the names to n buckets
+func (x *scanIndex) resize(n int) {
+	buckets := x.buckets
+	x.buckets = make([][]string, n)
+	for _, bucket := range buckets {
+		for _, name := range bucket {
+			moved := x.bucket(name)
+			*moved = append(*moved, name)
+		}
+	}
+}
+
+// `scan` returns the page of names starting at the cursor, and the
+// cursor the next page starts at, 0 once every name was visited.
+// Buckets are visited in the order of their reversed bits, as
+// redis' dictScan does, so that the buckets already visited stay
+// visited when the number of buckets doubles or halves. A full
+// iteration therefore returns every name present from its start to
+// its end, possibly more than once if the index shrank meanwhile.
+// A page visits buckets until it went over count names, or ten
+// times count empty buckets
+func (x *scanIndex) scan(options scanOptions) ([]string, uint64) {
+	if x.size == 0 {
+		return nil, 0
+	}
+	mask := uint64(len(x.buckets) - 1)
+	cursor := options.cursor
+	var page []string
+	visited, empty := 0, 0
+	for visited < options.count && empty < 10*options.count {
+		bucket := x.buckets[cursor&mask]
+		if len(bucket) == 0 {
+			empty++
+		}
+		for _, name := range bucket {
+			if options.pattern == nil || globMatch(options.pattern, []byte(name)) {
+				page = append(page, name)
+			}
+		}
+		visited += len(bucket)
+		// increment the bits of the cursor covered by the mask,
+		// starting from the most significant one
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 {
+			break
+		}
+	}
+	return page, cursor
+}
+
+// `scanReply` serialises a page of the SCAN family: the next cursor
+// followed by the page's elements
+func scanReply(cursor uint64, elements []resp.RESPDatatype) ([]byte, error) {
+	next := strconv.FormatUint(cursor, 10)
+	response := resp.Array{
+		Size: 2,
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte(next), Size: len(next)},
+			&resp.Array{Size: len(elements), Elements: elements},
+		},
+	}
+	return response.Serialise()
+}
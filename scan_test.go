@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// `scanAll` runs a full iteration over x, calling between with the
+// page number after each page, and returns how many times each
+// name was returned
+func scanAll(t *testing.T, x *scanIndex, count int, between func(page int)) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	options := scanOptions{count: count}
+	for page := 0; ; page++ {
+		names, cursor := x.scan(options)
+		if len(names) > 2*count+8 {
+			t.Fatalf("page %d holds %d names, for a count of %d", page, len(names), count)
+		}
+		for _, name := range names {
+			seen[name]++
+		}
+		if cursor == 0 {
+			return seen
+		}
+		between(page)
+		options.cursor = cursor
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	x := &scanIndex{}
+	for i := 0; i < 1000; i++ {
+		x.add(strconv.Itoa(i))
+	}
+	// a stable index returns every name exactly once
+	seen := scanAll(t, x, 10, func(int) {})
+	for i := 0; i < 1000; i++ {
+		if seen[strconv.Itoa(i)] != 1 {
+			t.Fatalf("%d was returned %d times", i, seen[strconv.Itoa(i)])
+		}
+	}
+
+	// the index grows several times during the iteration
+	next := 1000
+	seen = scanAll(t, x, 10, func(int) {
+		for i := 0; i < 20; i++ {
+			x.add(strconv.Itoa(next))
+			next++
+		}
+	})
+	for i := 0; i < 1000; i++ {
+		if seen[strconv.Itoa(i)] != 1 {
+			t.Fatalf("%d was returned %d times while the index grew", i, seen[strconv.Itoa(i)])
+		}
+	}
+
+	// the index shrinks during the iteration, the names that are
+	// kept must be returned at least once
+	seen = scanAll(t, x, 10, func(int) {
+		for i := 0; i < 40 && next > 1000; i++ {
+			next--
+			x.remove(strconv.Itoa(next))
+		}
+	})
+	for i := 0; i < 1000; i++ {
+		if seen[strconv.Itoa(i)] == 0 {
+			t.Fatalf("%d wasn't returned while the index shrank", i)
+		}
+	}
+	if next != 1000 {
+		t.Fatalf("%d names are left to remove", next-1000)
+	}
+	if x.size != 1000 || len(x.buckets) > 8*x.size {
+		t.Errorf("index holds %d names in %d buckets", x.size, len(x.buckets))
+	}
+
+	for i := 0; i < 1000; i++ {
+		x.remove(strconv.Itoa(i))
+	}
+	if names, cursor := x.scan(scanOptions{count: 10}); len(names) != 0 || cursor != 0 {
+		t.Errorf("empty index returned %q and cursor %d", names, cursor)
+	}
+	if len(x.buckets) != minScanBuckets {
+		t.Errorf("empty index kept %d buckets", len(x.buckets))
+	}
+}
+
+func TestScanCommands(t *testing.T) {
+	s := newStore()
+	for i := 0; i < 100; i++ {
+		run(t, s, "HSET", "h", "f"+strconv.Itoa(i), "v")
+		run(t, s, "SADD", "s", "m"+strconv.Itoa(i))
+	}
+	run(t, s, "HDEL", "h", "f0")
+	run(t, s, "SREM", "s", "m0")
+	for _, command := range []string{"HSCAN", "SSCAN"} {
+		got := run(t, s, command, "h", "0", "COUNT", "1000", "NOVALUES")
+		if command == "SSCAN" {
+			got = run(t, s, command, "s", "0", "COUNT", "1000")
+		}
+		// the cursor followed by 99 names
+		want := "*2\r\n$1\r\n0\r\n*99\r\n"
+		if len(got) < len(want) || got[:len(want)] != want {
+			t.Errorf("%s replied %q", command, got)
+		}
+	}
+	// a small intset is returned whole
+	run(t, s, "SADD", "ints", "1", "2", "3", "12")
+	want := "*2\r\n$1\r\n0\r\n*2\r\n" + bulkReply("1") + bulkReply("12")
+	if got := run(t, s, "SSCAN", "ints", "0", "MATCH", "1*", "COUNT", "1"); got != want {
+		t.Errorf("SSCAN of an intset replied %q, want %q", got, want)
+	}
+}
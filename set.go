@@ -0,0 +1,687 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// redisSet is an unordered collection of unique members. While every
+// member is an integer and there are at most `maxIntsetEntries` of
+// them, the set is encoded as an intset: a sorted slice of integers.
+// It's converted to a map once either condition stops holding
+type redisSet struct {
+	intset []int64
+	// members maps each member to its position in dense, it's nil
+	// while the set is an intset
+	members map[string]int
+	// the members, packed so that a random one is picked in O(1)
+	dense []string
+	// the members of a map, in the order SSCAN visits them
+	index scanIndex
+}
+
+// the same as the default set-max-intset-entries of redis
+const maxIntsetEntries = 512
+
+func newRedisSet() *redisSet {
+	return &redisSet{}
+}
+
+// `parseIntsetMember` returns the integer a member encodes, the
+// member must be its canonical representation to be stored in
+// an intset
+func parseIntsetMember(member string) (int64, bool) {
+	return parseInteger([]byte(member))
+}
+
+// `convert` turns an intset into a map
+func (st *redisSet) convert() {
+	st.members = make(map[string]int, len(st.intset))
+	st.dense = make([]string, 0, len(st.intset))
+	for _, integer := range st.intset {
+		member := strconv.FormatInt(integer, 10)
+		st.members[member] = len(st.dense)
+		st.dense = append(st.dense, member)
+		st.index.add(member)
+	}
+	st.intset = nil
+}
+
+// `add` adds member to the set, it reports false if it was
+// already a member
+func (st *redisSet) add(member string) bool {
+	if st.members == nil {
+		integer, ok := parseIntsetMember(member)
+		if ok {
+			i, found := slices.BinarySearch(st.intset, integer)
+			if found {
+				return false
+			}
+			if len(st.intset) < maxIntsetEntries {
+				st.intset = slices.Insert(st.intset, i, integer)
+				return true
+			}
+		}
+		st.convert()
+	}
+	if _, ok := st.members[member]; ok {
+		return false
+	}
+	st.members[member] = len(st.dense)
+	st.dense = append(st.dense, member)
+	st.index.add(member)
+	return true
+}
+
+// `remove` removes member from the set, it reports false if
+// it wasn't a member
+func (st *redisSet) remove(member string) bool {
+	if st.members == nil {
+		integer, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		i, found := slices.BinarySearch(st.intset, integer)
+		if found {
+			st.intset = slices.Delete(st.intset, i, i+1)
+		}
+		return found
+	}
+	i, ok := st.members[member]
+	if !ok {
+		return false
+	}
+	// the last member takes the place of the removed one
+	last := st.dense[len(st.dense)-1]
+	st.dense[i] = last
+	st.members[last] = i
+	st.dense[len(st.dense)-1] = ""
+	st.dense = st.dense[:len(st.dense)-1]
+	delete(st.members, member)
+	st.index.remove(member)
+	return true
+}
+
+// `contains` reports whether member is in the set
+func (st *redisSet) contains(member string) bool {
+	if st.members == nil {
+		integer, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(st.intset, integer)
+		return found
+	}
+	_, ok := st.members[member]
+	return ok
+}
+
+// `size` returns the number of members of the set
+func (st *redisSet) size() int {
+	if st.members == nil {
+		return len(st.intset)
+	}
+	return len(st.members)
+}
+
+// `list` returns the members of the set. An intset's
+// members are returned in ascending order
+func (st *redisSet) list() []string {
+	members := make([]string, 0, st.size())
+	if st.members == nil {
+		for _, integer := range st.intset {
+			members = append(members, strconv.FormatInt(integer, 10))
+		}
+		return members
+	}
+	return append(members, st.dense...)
+}
+
+// `memberAt` returns the member at position i of the set's
+// encoding, in [0, size)
+func (st *redisSet) memberAt(i int) string {
+	if st.members == nil {
+		return strconv.FormatInt(st.intset[i], 10)
+	}
+	return st.dense[i]
+}
+
+// `randomMember` returns a random member of a non empty set
+func (st *redisSet) randomMember() string {
+	return st.memberAt(rand.Intn(st.size()))
+}
+
+// `randomMembers` returns count distinct random members of the set,
+// or all of its members if it has fewer. It takes O(count) time
+func (st *redisSet) randomMembers(count int) []string {
+	size := st.size()
+	if count >= size {
+		members := st.list()
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		return members
+	}
+	members := make([]string, 0, count)
+	if 3*count > size {
+		// most members are picked, so shuffle the start of the
+		// positions rather than retrying positions picked already
+		positions := make([]int, size)
+		for i := range positions {
+			positions[i] = i
+		}
+		for i := 0; i < count; i++ {
+			j := i + rand.Intn(size-i)
+			positions[i], positions[j] = positions[j], positions[i]
+			members = append(members, st.memberAt(positions[i]))
+		}
+		return members
+	}
+	picked := make(map[int]struct{}, count)
+	for len(members) < count {
+		i := rand.Intn(size)
+		if _, ok := picked[i]; ok {
+			continue
+		}
+		picked[i] = struct{}{}
+		members = append(members, st.memberAt(i))
+	}
+	return members
+}
+
+// `getSet` retrieves the set stored at key. It also reports
+// whether the key exists and whether it holds a set
+func (s *store) getSet(key string) (*redisSet, bool, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, false, true
+	}
+	if value.valueType != "set" {
+		return nil, true, false
+	}
+	return value.value.(*redisSet), true, true
+}
+
+// `membersToRESPArray` converts members to a RESP array of bulk strings
+func membersToRESPArray(members []string) ([]byte, error) {
+	var response resp.Array
+	for _, member := range members {
+		response.Elements = append(response.Elements, &resp.BulkString{Data: []byte(member), Size: len(member)})
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// SADD command adds members to the set stored at key
+func sadd(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("sadd")
+	}
+	key := string(args[0])
+	st, exists, isSet := s.getSet(key)
+	if !isSet {
+		return wrongType()
+	}
+	if !exists {
+		st = newRedisSet()
+		s.set(key, &redisValue{
+			value:     st,
+			valueType: "set",
+		})
+	}
+	response := resp.Integer{}
+	for _, member := range args[1:] {
+		if st.add(string(member)) {
+			response.Data++
+		}
+	}
+	if response.Data > 0 {
+		s.notifyKeyspaceEvent(notifySet, "sadd", key)
+	}
+	return response.Serialise()
+}
+
+// SREM command removes members from a set
+func srem(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("srem")
+	}
+	key := string(args[0])
+	st, exists, isSet := s.getSet(key)
+	if !isSet {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	for _, member := range args[1:] {
+		if st.remove(string(member)) {
+			response.Data++
+		}
+	}
+	if response.Data > 0 {
+		s.notifyKeyspaceEvent(notifySet, "srem", key)
+		s.deleteIfEmpty(key, st.size())
+	}
+	return response.Serialise()
+}
+
+// SISMEMBER command reports whether member belongs to a set
+func sismember(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("sismember")
+	}
+	st, exists, isSet := s.getSet(string(args[0]))
+	if !isSet {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists && st.contains(string(args[1])) {
+		response.Data = 1
+	}
+	return response.Serialise()
+}
+
+// SMISMEMBER command reports whether each of the members belongs
+// to a set
+func smismember(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("smismember")
+	}
+	st, exists, isSet := s.getSet(string(args[0]))
+	if !isSet {
+		return wrongType()
+	}
+	results := make([]int64, len(args)-1)
+	for i, member := range args[1:] {
+		if exists && st.contains(string(member)) {
+			results[i] = 1
+		}
+	}
+	return integerArray(results)
+}
+
+// SMEMBERS command returns the members of a set
+func smembers(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("smembers")
+	}
+	st, exists, isSet := s.getSet(string(args[0]))
+	if !isSet {
+		return wrongType()
+	}
+	if !exists {
+		return membersToRESPArray(nil)
+	}
+	return membersToRESPArray(st.list())
+}
+
+// SCARD command returns the number of members of a set
+func scard(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("scard")
+	}
+	st, exists, isSet := s.getSet(string(args[0]))
+	if !isSet {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists {
+		response.Data = int64(st.size())
+	}
+	return response.Serialise()
+}
+
+// SPOP command removes and returns random members of a set
+func spop(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs("spop")
+	}
+	key := string(args[0])
+	count := int64(-1)
+	if len(args) == 2 {
+		var ok bool
+		count, ok = parseInteger(args[1])
+		if !ok || count < 0 {
+			return errorReply("value is out of range, must be positive")
+		}
+	}
+	st, exists, isSet := s.getSet(key)
+	if !isSet {
+		return wrongType()
+	}
+	// an empty set, which shouldn't be stored, pops like a missing one
+	if !exists || st.size() == 0 {
+		if count == -1 {
+			response := resp.BulkString{
+				Size: -1,
+			}
+			return response.Serialise()
+		}
+		return membersToRESPArray(nil)
+	}
+	var popped []string
+	for i := int64(0); (count == -1 && i < 1) || i < count; i++ {
+		if st.size() == 0 {
+			break
+		}
+		member := st.randomMember()
+		st.remove(member)
+		popped = append(popped, member)
+	}
+	if len(popped) > 0 {
+		s.notifyKeyspaceEvent(notifySet, "spop", key)
+		s.deleteIfEmpty(key, st.size())
+	}
+	if count == -1 {
+		response := resp.BulkString{
+			Data: []byte(popped[0]),
+			Size: len(popped[0]),
+		}
+		return response.Serialise()
+	}
+	return membersToRESPArray(popped)
+}
+
+// SRANDMEMBER command returns random members of a set. A positive
+// count returns distinct members, a negative one may repeat them
+func srandmember(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs("srandmember")
+	}
+	st, exists, isSet := s.getSet(string(args[0]))
+	if !isSet {
+		return wrongType()
+	}
+	if len(args) == 1 {
+		response := resp.BulkString{
+			Size: -1,
+		}
+		if exists && st.size() > 0 {
+			member := st.randomMember()
+			response.Data = []byte(member)
+			response.Size = len(member)
+		}
+		return response.Serialise()
+	}
+	count, ok := parseInteger(args[1])
+	if !ok {
+		return errorReply("value is not an integer or out of range")
+	}
+	if !exists || st.size() == 0 || count == 0 {
+		return membersToRESPArray(nil)
+	}
+	if count > 0 {
+		return membersToRESPArray(st.randomMembers(int(min(count, int64(st.size())))))
+	}
+	if count < -maxRandomCount {
+		return errorReply("value is out of range")
+	}
+	picked := make([]string, -count)
+	for i := range picked {
+		picked[i] = st.randomMember()
+	}
+	return membersToRESPArray(picked)
+}
+
+// SMOVE command moves a member from the set at source to the set
+// at destination
+func smove(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("smove")
+	}
+	source, destination, member := string(args[0]), string(args[1]), string(args[2])
+	src, exists, isSet := s.getSet(source)
+	if !isSet {
+		return wrongType()
+	}
+	dst, dstExists, dstIsSet := s.getSet(destination)
+	if !dstIsSet {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if !exists || !src.contains(member) {
+		return response.Serialise()
+	}
+	response.Data = 1
+	if source == destination {
+		return response.Serialise()
+	}
+	src.remove(member)
+	s.notifyKeyspaceEvent(notifySet, "srem", source)
+	if !dstExists {
+		dst = newRedisSet()
+		s.set(destination, &redisValue{
+			value:     dst,
+			valueType: "set",
+		})
+	}
+	dst.add(member)
+	s.notifyKeyspaceEvent(notifySet, "sadd", destination)
+	s.deleteIfEmpty(source, src.size())
+	return response.Serialise()
+}
+
+// `getSets` retrieves the sets stored at keys, a missing key is
+// an empty set. It reports false if any key holds another type
+func (s *store) getSets(keys [][]byte) ([]*redisSet, bool) {
+	sets := make([]*redisSet, len(keys))
+	for i, key := range keys {
+		st, exists, isSet := s.getSet(string(key))
+		if !isSet {
+			return nil, false
+		}
+		if !exists {
+			st = newRedisSet()
+		}
+		sets[i] = st
+	}
+	return sets, true
+}
+
+// `setAlgebra` computes the intersection, union or difference of
+// sets. The intersection stops once it holds limit members, 0
+// meaning no limit
+func setAlgebra(operation string, sets []*redisSet, limit int) []string {
+	var result []string
+	switch operation {
+	case "inter":
+		// walk the smallest set, checking the others
+		sorted := slices.Clone(sets)
+		slices.SortFunc(sorted, func(a, b *redisSet) int {
+			return a.size() - b.size()
+		})
+		for _, member := range sorted[0].list() {
+			inAll := true
+			for _, other := range sorted[1:] {
+				if !other.contains(member) {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				result = append(result, member)
+				if limit > 0 && len(result) == limit {
+					break
+				}
+			}
+		}
+	case "union":
+		union := newRedisSet()
+		for _, st := range sets {
+			for _, member := range st.list() {
+				union.add(member)
+			}
+		}
+		result = union.list()
+	case "diff":
+		for _, member := range sets[0].list() {
+			inOther := false
+			for _, other := range sets[1:] {
+				if other.contains(member) {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				result = append(result, member)
+			}
+		}
+	}
+	return result
+}
+
+// `setAlgebraGeneric` implements SINTER, SUNION and SDIFF
+func setAlgebraGeneric(args [][]byte, s *store, operation string) ([]byte, error) {
+	if len(args) < 1 {
+		return wrongNumberOfArgs("s" + operation)
+	}
+	sets, ok := s.getSets(args)
+	if !ok {
+		return wrongType()
+	}
+	return membersToRESPArray(setAlgebra(operation, sets, 0))
+}
+
+// `setAlgebraStoreGeneric` implements SINTERSTORE, SUNIONSTORE and
+// SDIFFSTORE, which store the result at the destination key
+func setAlgebraStoreGeneric(args [][]byte, s *store, operation string) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("s" + operation + "store")
+	}
+	destination := string(args[0])
+	sets, ok := s.getSets(args[1:])
+	if !ok {
+		return wrongType()
+	}
+	members := setAlgebra(operation, sets, 0)
+	if len(members) == 0 {
+		if _, exists := s.get(destination); exists {
+			delete(s.db, destination)
+			s.notifyKeyspaceEvent(notifyGeneric, "del", destination)
+		}
+	} else {
+		st := newRedisSet()
+		for _, member := range members {
+			st.add(member)
+		}
+		s.set(destination, &redisValue{
+			value:     st,
+			valueType: "set",
+		})
+		s.notifyKeyspaceEvent(notifySet, "s"+operation+"store", destination)
+	}
+	response := resp.Integer{
+		Data: int64(len(members)),
+	}
+	return response.Serialise()
+}
+
+// SINTER command returns the members common to all the given sets
+func sinter(args [][]byte, s *store) ([]byte, error) {
+	return setAlgebraGeneric(args, s, "inter")
+}
+
+// SUNION command returns the members of any of the given sets
+func sunion(args [][]byte, s *store) ([]byte, error) {
+	return setAlgebraGeneric(args, s, "union")
+}
+
+// SDIFF command returns the members of the first set that
+// aren't in any of the following ones
+func sdiff(args [][]byte, s *store) ([]byte, error) {
+	return setAlgebraGeneric(args, s, "diff")
+}
+
+// SINTERSTORE command stores the intersection of sets at destination
+func sinterstore(args [][]byte, s *store) ([]byte, error) {
+	return setAlgebraStoreGeneric(args, s, "inter")
+}
+
+// SUNIONSTORE command stores the union of sets at destination
+func sunionstore(args [][]byte, s *store) ([]byte, error) {
+	return setAlgebraStoreGeneric(args, s, "union")
+}
+
+// SDIFFSTORE command stores the difference of sets at destination
+func sdiffstore(args [][]byte, s *store) ([]byte, error) {
+	return setAlgebraStoreGeneric(args, s, "diff")
+}
+
+// SINTERCARD command returns the number of members of the
+// intersection of sets, counting up to LIMIT members
+func sintercard(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("sintercard")
+	}
+	numkeys, ok := parseInteger(args[0])
+	if !ok || numkeys <= 0 {
+		return errorReply("numkeys should be greater than 0")
+	}
+	if numkeys > int64(len(args)-1) {
+		return errorReply("Number of keys can't be greater than number of args")
+	}
+	keys := args[1 : 1+numkeys]
+	rest := args[1+numkeys:]
+	limit := int64(0)
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return errorReply("invalid syntax")
+		}
+		limit, ok = parseInteger(rest[1])
+		if !ok {
+			return errorReply("value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return errorReply("LIMIT can't be negative")
+		}
+	}
+	sets, ok := s.getSets(keys)
+	if !ok {
+		return wrongType()
+	}
+	response := resp.Integer{
+		Data: int64(len(setAlgebra("inter", sets, int(limit)))),
+	}
+	return response.Serialise()
+}
+
+// SSCAN command incrementally iterates over the members of a set
+func sscan(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("sscan")
+	}
+	options, message := parseScanOptions(args[1:], false)
+	if message != "" {
+		return errorReply(message)
+	}
+	st, exists, isSet := s.getSet(string(args[0]))
+	if !isSet {
+		return wrongType()
+	}
+	if !exists {
+		return scanReply(0, nil)
+	}
+	var page []string
+	cursor := uint64(0)
+	if st.members == nil {
+		// an intset is small, so it's returned whole, as redis does
+		for _, member := range st.list() {
+			if options.pattern == nil || globMatch(options.pattern, []byte(member)) {
+				page = append(page, member)
+			}
+		}
+	} else {
+		page, cursor = st.index.scan(options)
+	}
+	var elements []resp.RESPDatatype
+	for _, member := range page {
+		elements = append(elements, &resp.BulkString{Data: []byte(member), Size: len(member)})
+	}
+	return scanReply(cursor, elements)
+}
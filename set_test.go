@@ -0,0 +1,187 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SADD", "ints", "3", "1", "2", "1"}, integerReply(3)},
+		{[]string{"SMEMBERS", "ints"}, bulkArray("1", "2", "3")},
+		{[]string{"SADD", "words", "a", "b", "c"}, integerReply(3)},
+		{[]string{"SADD", "words", "a", "d"}, integerReply(1)},
+		{[]string{"SCARD", "words"}, integerReply(4)},
+		{[]string{"SCARD", "missing"}, integerReply(0)},
+		{[]string{"SISMEMBER", "words", "d"}, integerReply(1)},
+		{[]string{"SISMEMBER", "words", "x"}, integerReply(0)},
+		{[]string{"SMISMEMBER", "ints", "1", "4", "3"}, "*3\r\n" + integerReply(1) + integerReply(0) + integerReply(1)},
+		{[]string{"SREM", "words", "d", "x"}, integerReply(1)},
+		{[]string{"SMOVE", "words", "ints", "a"}, integerReply(1)},
+		{[]string{"SMOVE", "words", "ints", "a"}, integerReply(0)},
+		{[]string{"SISMEMBER", "ints", "a"}, integerReply(1)},
+		{[]string{"SREM", "ints", "a"}, integerReply(1)},
+		{[]string{"SADD", "more", "2", "3", "4"}, integerReply(3)},
+		{[]string{"SINTERSTORE", "inter", "ints", "more"}, integerReply(2)},
+		{[]string{"SMEMBERS", "inter"}, bulkArray("2", "3")},
+		{[]string{"SINTER", "ints", "missing"}, "*0\r\n"},
+		{[]string{"SDIFF", "ints", "more"}, bulkArray("1")},
+		{[]string{"SUNIONSTORE", "all", "ints", "more"}, integerReply(4)},
+		{[]string{"SMEMBERS", "all"}, bulkArray("1", "2", "3", "4")},
+		{[]string{"SINTERSTORE", "none", "ints", "missing"}, integerReply(0)},
+		{[]string{"EXISTS", "none"}, integerReply(0)},
+		{[]string{"SDIFFSTORE", "diff", "more", "ints"}, integerReply(1)},
+		{[]string{"SMEMBERS", "diff"}, bulkArray("4")},
+		{[]string{"SINTERCARD", "2", "all", "more"}, integerReply(3)},
+		{[]string{"SINTERCARD", "2", "all", "more", "LIMIT", "2"}, integerReply(2)},
+		{[]string{"SINTERCARD", "0", "all"}, "-numkeys should be greater than 0\r\n"},
+		{[]string{"SINTERCARD", "3", "all"}, "-Number of keys can't be greater than number of args\r\n"},
+		{[]string{"SINTERCARD", "1", "all", "LIMIT", "-1"}, "-LIMIT can't be negative\r\n"},
+		// removing the last member deletes the key
+		{[]string{"SREM", "diff", "4"}, integerReply(1)},
+		{[]string{"EXISTS", "diff"}, integerReply(0)},
+		{[]string{"SPOP", "missing"}, "$-1\r\n"},
+		{[]string{"SPOP", "missing", "2"}, "*0\r\n"},
+		{[]string{"SPOP", "words", "-1"}, "-value is out of range, must be positive\r\n"},
+		{[]string{"SRANDMEMBER", "missing"}, "$-1\r\n"},
+		{[]string{"SRANDMEMBER", "diff", "2"}, "*0\r\n"},
+		{[]string{"SET", "str", "v"}, "+OK\r\n"},
+		{[]string{"SADD", "str", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"SINTER", "ints", "str"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// `checkDense` checks that the dense members of st hold exactly
+// its members, each at the position the map records
+func checkDense(t *testing.T, st *redisSet) {
+	t.Helper()
+	if len(st.dense) != len(st.members) {
+		t.Fatalf("dense holds %d members, the map %d", len(st.dense), len(st.members))
+	}
+	for i, member := range st.dense {
+		if st.members[member] != i {
+			t.Fatalf("%s is at %d, the map records %d", member, i, st.members[member])
+		}
+	}
+}
+
+// `checkRandomMembers` checks that picked holds count distinct
+// members of st
+func checkRandomMembers(t *testing.T, st *redisSet, picked []string, count int) {
+	t.Helper()
+	if len(picked) != count {
+		t.Fatalf("picked %d members, want %d", len(picked), count)
+	}
+	seen := make(map[string]bool)
+	for _, member := range picked {
+		if !st.contains(member) {
+			t.Fatalf("picked %s, which isn't a member", member)
+		}
+		if seen[member] {
+			t.Fatalf("picked %s twice", member)
+		}
+		seen[member] = true
+	}
+}
+
+func TestSetDenseMembers(t *testing.T) {
+	st := newRedisSet()
+	for i := 0; i < 100; i++ {
+		st.add(strconv.Itoa(i))
+	}
+	// a non integer member converts the intset
+	st.add("member")
+	checkDense(t, st)
+	for i := 0; i < 100; i += 3 {
+		st.remove(strconv.Itoa(i))
+	}
+	st.remove("member")
+	checkDense(t, st)
+	if st.size() != 66 {
+		t.Fatalf("set holds %d members, want 66", st.size())
+	}
+
+	for _, encoding := range []*redisSet{st, {intset: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}} {
+		size := encoding.size()
+		// few, most and all of the members
+		for _, count := range []int{1, size / 4, size / 2, size - 1, size} {
+			checkRandomMembers(t, encoding, encoding.randomMembers(count), count)
+		}
+		// every member is eventually picked
+		seen := make(map[string]bool)
+		for i := 0; i < 100*size && len(seen) < size; i++ {
+			seen[encoding.randomMember()] = true
+		}
+		if len(seen) != size {
+			t.Errorf("only %d of %d members were picked", len(seen), size)
+		}
+	}
+}
+
+func TestSpopSrandmember(t *testing.T) {
+	s := newStore()
+	for i := 0; i < 1000; i++ {
+		run(t, s, "SADD", "s", "m"+strconv.Itoa(i))
+	}
+	st := s.db["s"].value.(*redisSet)
+	popped := run(t, s, "SPOP", "s", "300")
+	if got := run(t, s, "SCARD", "s"); got != integerReply(700) {
+		t.Fatalf("SCARD after popping 300 of 1000 members replied %q", got)
+	}
+	checkDense(t, st)
+	for i := 0; i < 1000; i++ {
+		member := "m" + strconv.Itoa(i)
+		if st.contains(member) == strings.Contains(popped, bulkReply(member)) {
+			t.Fatalf("%s is both popped and a member, or neither", member)
+		}
+	}
+
+	for _, count := range []string{"5", "500", "700", "1000"} {
+		got := run(t, s, "SRANDMEMBER", "s", count)
+		n, _ := strconv.Atoi(count)
+		want := "*" + strconv.Itoa(min(n, 700)) + "\r\n"
+		if got[:len(want)] != want {
+			t.Errorf("SRANDMEMBER s %s replied %q", count, got[:min(len(got), 20)])
+		}
+	}
+	if got := run(t, s, "SRANDMEMBER", "s", "-2000"); got[:7] != "*2000\r\n" {
+		t.Errorf("SRANDMEMBER s -2000 replied %q", got[:min(len(got), 20)])
+	}
+
+	run(t, s, "SPOP", "s", "1000")
+	if got := run(t, s, "EXISTS", "s"); got != integerReply(0) {
+		t.Errorf("EXISTS after popping every member replied %q", got)
+	}
+}
+
+// TestSpopSrandmemberEmptySet checks that an empty set, which
+// commands never leave behind, replies like a missing one
+func TestSpopSrandmemberEmptySet(t *testing.T) {
+	s := newStore()
+	s.db["empty"] = redisValue{valueType: "set", value: newRedisSet()}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SPOP", "empty"}, "$-1\r\n"},
+		{[]string{"SPOP", "empty", "2"}, "*0\r\n"},
+		{[]string{"SRANDMEMBER", "empty"}, "$-1\r\n"},
+		{[]string{"SRANDMEMBER", "empty", "2"}, "*0\r\n"},
+		{[]string{"SRANDMEMBER", "empty", "-2"}, "*0\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
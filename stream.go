@@ -0,0 +1,1131 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// `streamID` identifies a stream entry: the unix time in milliseconds
+// the entry was added at, and a sequence number among the entries
+// added within the same millisecond
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms:
+		return -1
+	case id.ms > other.ms:
+		return 1
+	case id.seq < other.seq:
+		return -1
+	case id.seq > other.seq:
+		return 1
+	}
+	return 0
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// `next` returns the smallest ID greater than id, it reports
+// false if id is the largest possible ID
+func (id streamID) next() (streamID, bool) {
+	if id.seq == math.MaxUint64 {
+		if id.ms == math.MaxUint64 {
+			return id, false
+		}
+		return streamID{id.ms + 1, 0}, true
+	}
+	return streamID{id.ms, id.seq + 1}, true
+}
+
+// `prev` returns the largest ID smaller than id, it reports
+// false if id is 0-0
+func (id streamID) prev() (streamID, bool) {
+	if id.seq == 0 {
+		if id.ms == 0 {
+			return id, false
+		}
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return streamID{id.ms, id.seq - 1}, true
+}
+
+// `parseStreamID` parses an ID given as ms-seq, or as ms alone in
+// which case its sequence number is missingSeq
+func parseStreamID(arg []byte, missingSeq uint64) (streamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(string(arg), "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil || msPart[0] == '+' {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil || seqPart[0] == '+' {
+		return streamID{}, false
+	}
+	return streamID{ms, seq}, true
+}
+
+const invalidStreamID = "Invalid stream ID specified as stream command argument"
+
+// `streamIDBulkString` returns an ID as a bulk string
+func streamIDBulkString(id streamID) *resp.BulkString {
+	formatted := id.String()
+	return &resp.BulkString{Data: []byte(formatted), Size: len(formatted)}
+}
+
+// `streamEntry` is an entry of a stream, fields holds each
+// field followed by its value
+type streamEntry struct {
+	id     streamID
+	fields [][]byte
+}
+
+// Entries are packed into chunks of up to `streamChunkMaxEntries`
+// entries or `streamChunkMaxBytes` bytes. Each entry is encoded as
+// <flags><uvarint ms - master ms><uvarint seq><fields>, the master
+// being the first entry added to the chunk. Fields are encoded as
+// <uvarint count> followed by the length prefixed fields and values,
+// or, when the entry has the same fields as the master, only by the
+// length prefixed values. Deleted entries are flagged rather than
+// removed, until they make up most of the chunk
+const (
+	streamChunkMaxEntries = 100
+	streamChunkMaxBytes   = 4096
+
+	streamEntryDeleted    byte = 1
+	streamEntrySameFields byte = 2
+)
+
+type streamChunk struct {
+	master       streamID
+	masterFields [][]byte
+	// last is the ID of the last entry added to the chunk
+	last    streamID
+	data    []byte
+	entries int
+	deleted int
+}
+
+// `live` returns the number of entries of the chunk not deleted
+func (c *streamChunk) live() int {
+	return c.entries - c.deleted
+}
+
+// `append` packs an entry at the end of the chunk
+func (c *streamChunk) append(entry streamEntry) {
+	sameFields := len(entry.fields)/2 == len(c.masterFields)
+	for i := 0; sameFields && i < len(c.masterFields); i++ {
+		sameFields = string(entry.fields[2*i]) == string(c.masterFields[i])
+	}
+	var flags byte
+	if sameFields {
+		flags = streamEntrySameFields
+	}
+	c.data = append(c.data, flags)
+	c.data = binary.AppendUvarint(c.data, entry.id.ms-c.master.ms)
+	c.data = binary.AppendUvarint(c.data, entry.id.seq)
+	if sameFields {
+		for i := 1; i < len(entry.fields); i += 2 {
+			c.data = appendDumpString(c.data, entry.fields[i])
+		}
+	} else {
+		c.data = binary.AppendUvarint(c.data, uint64(len(entry.fields)/2))
+		for _, field := range entry.fields {
+			c.data = appendDumpString(c.data, field)
+		}
+	}
+	c.entries++
+	c.last = entry.id
+}
+
+// `packedEntry` is an entry decoded from a chunk, along with
+// its offset within the chunk's data
+type packedEntry struct {
+	streamEntry
+	offset  int
+	deleted bool
+}
+
+// `decode` returns the entries packed in the chunk, deleted ones
+// included
+func (c *streamChunk) decode() []packedEntry {
+	entries := make([]packedEntry, 0, c.entries)
+	readUvarint := func(offset *int) uint64 {
+		value, n := binary.Uvarint(c.data[*offset:])
+		*offset += n
+		return value
+	}
+	readString := func(offset *int) []byte {
+		length := int(readUvarint(offset))
+		data := c.data[*offset : *offset+length : *offset+length]
+		*offset += length
+		return data
+	}
+	for offset := 0; offset < len(c.data); {
+		entry := packedEntry{offset: offset}
+		flags := c.data[offset]
+		offset++
+		entry.deleted = flags&streamEntryDeleted != 0
+		entry.id.ms = c.master.ms + readUvarint(&offset)
+		entry.id.seq = readUvarint(&offset)
+		if flags&streamEntrySameFields != 0 {
+			entry.fields = make([][]byte, 0, 2*len(c.masterFields))
+			for _, field := range c.masterFields {
+				entry.fields = append(entry.fields, field, readString(&offset))
+			}
+		} else {
+			count := int(readUvarint(&offset))
+			entry.fields = make([][]byte, 2*count)
+			for i := range entry.fields {
+				entry.fields[i] = readString(&offset)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// `markDeleted` flags the entry at offset as deleted
+func (c *streamChunk) markDeleted(offset int) {
+	c.data[offset] |= streamEntryDeleted
+	c.deleted++
+}
+
+// `compact` repacks the chunk once most of its entries are deleted,
+// which moves the entries left
+func (c *streamChunk) compact() {
+	if c.entries > 10 && c.deleted > c.entries/2 {
+		entries := c.decode()
+		c.data = nil
+		c.entries, c.deleted = 0, 0
+		for _, entry := range entries {
+			if !entry.deleted {
+				c.append(entry.streamEntry)
+			}
+		}
+	}
+}
+
+// stream is an append only log of entries ordered by ID
+type stream struct {
+	// chunks are ordered by ID, none of them is empty
+	chunks []*streamChunk
+	length int
+	// lastID is the ID of the last entry ever added, later
+	// entries must have greater IDs
+	lastID       streamID
+	maxDeletedID streamID
+	entriesAdded uint64
+	groups       map[string]*consumerGroup
+}
+
+func newStream() *stream {
+	return &stream{}
+}
+
+// `add` appends an entry, its ID must be greater than lastID
+func (st *stream) add(entry streamEntry) {
+	var tail *streamChunk
+	if len(st.chunks) > 0 {
+		tail = st.chunks[len(st.chunks)-1]
+	}
+	if tail == nil || tail.entries >= streamChunkMaxEntries || len(tail.data) >= streamChunkMaxBytes {
+		tail = &streamChunk{
+			master: entry.id,
+		}
+		for i := 0; i < len(entry.fields); i += 2 {
+			tail.masterFields = append(tail.masterFields, slices.Clone(entry.fields[i]))
+		}
+		st.chunks = append(st.chunks, tail)
+	}
+	tail.append(entry)
+	st.length++
+	st.lastID = entry.id
+	st.entriesAdded++
+}
+
+// `removeChunk` drops the chunk at index i
+func (st *stream) removeChunk(i int) {
+	st.length -= st.chunks[i].live()
+	st.chunks = slices.Delete(st.chunks, i, i+1)
+}
+
+// `remove` deletes the entry with the given ID, it reports
+// false if there is no such entry
+func (st *stream) remove(id streamID) bool {
+	i := sort.Search(len(st.chunks), func(i int) bool {
+		return st.chunks[i].last.compare(id) >= 0
+	})
+	if i == len(st.chunks) {
+		return false
+	}
+	chunk := st.chunks[i]
+	for _, entry := range chunk.decode() {
+		if entry.id == id && !entry.deleted {
+			if chunk.live() == 1 {
+				st.removeChunk(i)
+			} else {
+				chunk.markDeleted(entry.offset)
+				chunk.compact()
+				st.length--
+			}
+			if id.compare(st.maxDeletedID) > 0 {
+				st.maxDeletedID = id
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// `rangeEntries` returns up to count entries with IDs between start
+// and end, all of them when count is 0. Entries are returned from
+// end to start when reverse is set
+func (st *stream) rangeEntries(start, end streamID, count int, reverse bool) []streamEntry {
+	var entries []streamEntry
+	if start.compare(end) > 0 {
+		return entries
+	}
+	inRange := func(entry packedEntry) bool {
+		return !entry.deleted && entry.id.compare(start) >= 0 && entry.id.compare(end) <= 0
+	}
+	if !reverse {
+		first := sort.Search(len(st.chunks), func(i int) bool {
+			return st.chunks[i].last.compare(start) >= 0
+		})
+		for _, chunk := range st.chunks[first:] {
+			if chunk.master.compare(end) > 0 {
+				break
+			}
+			for _, entry := range chunk.decode() {
+				if inRange(entry) {
+					entries = append(entries, entry.streamEntry)
+					if len(entries) == count {
+						return entries
+					}
+				}
+			}
+		}
+		return entries
+	}
+	last := sort.Search(len(st.chunks), func(i int) bool {
+		return st.chunks[i].master.compare(end) > 0
+	}) - 1
+	for i := last; i >= 0; i-- {
+		if st.chunks[i].last.compare(start) < 0 {
+			break
+		}
+		decoded := st.chunks[i].decode()
+		for j := len(decoded) - 1; j >= 0; j-- {
+			if inRange(decoded[j]) {
+				entries = append(entries, decoded[j].streamEntry)
+				if len(entries) == count {
+					return entries
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// `firstEntry` returns the first entry of the stream
+func (st *stream) firstEntry() (streamEntry, bool) {
+	entries := st.rangeEntries(streamID{}, maxStreamID, 1, false)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// `lastEntry` returns the last entry of the stream
+func (st *stream) lastEntry() (streamEntry, bool) {
+	entries := st.rangeEntries(streamID{}, maxStreamID, 1, true)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// `streamTrimOptions` holds the MAXLEN|MINID [=|~] threshold
+// [LIMIT count] options of XADD and XTRIM
+type streamTrimOptions struct {
+	// byMinID is set for MINID, maxLen is used otherwise
+	byMinID bool
+	maxLen  int64
+	minID   streamID
+	approx  bool
+	// limit bounds the number of entries removed, 0 meaning no limit
+	limit int64
+}
+
+// `trim` removes entries from the head of the stream, while it holds
+// more than maxLen entries or entries below minID. An approximate trim
+// only removes whole chunks. It returns the number of entries removed
+func (st *stream) trim(options streamTrimOptions) int64 {
+	var removed int64
+	for len(st.chunks) > 0 {
+		if !options.byMinID && int64(st.length) <= options.maxLen {
+			break
+		}
+		chunk := st.chunks[0]
+		live := int64(chunk.live())
+		if options.limit > 0 && removed+live > options.limit {
+			break
+		}
+		var removeChunk bool
+		if options.byMinID {
+			removeChunk = chunk.last.compare(options.minID) < 0
+		} else {
+			removeChunk = int64(st.length)-live >= options.maxLen
+		}
+		if removeChunk {
+			st.removeChunk(0)
+			removed += live
+			continue
+		}
+		if options.approx {
+			break
+		}
+		// the threshold falls within the chunk, remove its entries
+		// one at a time
+		for _, entry := range chunk.decode() {
+			if entry.deleted {
+				continue
+			}
+			if options.byMinID {
+				if entry.id.compare(options.minID) >= 0 {
+					break
+				}
+			} else if int64(st.length) <= options.maxLen {
+				break
+			}
+			chunk.markDeleted(entry.offset)
+			st.length--
+			removed++
+		}
+		chunk.compact()
+		break
+	}
+	return removed
+}
+
+// `getStream` retrieves the stream stored at key. It also reports
+// whether the key exists and whether it holds a stream
+func (s *store) getStream(key string) (*stream, bool, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, false, true
+	}
+	if value.valueType != "stream" {
+		return nil, true, false
+	}
+	return value.value.(*stream), true, true
+}
+
+// `streamEntryArray` returns an entry as an array of its ID and
+// an array of its fields and values. Entries have at least one field,
+// an entry without fields stands for a deleted entry and its fields
+// are returned as nil
+func streamEntryArray(entry streamEntry) *resp.Array {
+	if entry.fields == nil {
+		return &resp.Array{
+			Size:     2,
+			Elements: []resp.RESPDatatype{streamIDBulkString(entry.id), &resp.Array{Size: -1}},
+		}
+	}
+	fields := &resp.Array{Size: len(entry.fields)}
+	for _, field := range entry.fields {
+		fields.Elements = append(fields.Elements, &resp.BulkString{Data: field, Size: len(field)})
+	}
+	return &resp.Array{
+		Size:     2,
+		Elements: []resp.RESPDatatype{streamIDBulkString(entry.id), fields},
+	}
+}
+
+// `streamEntriesArray` returns entries as an array of entries
+func streamEntriesArray(entries []streamEntry) *resp.Array {
+	response := &resp.Array{Size: len(entries)}
+	for _, entry := range entries {
+		response.Elements = append(response.Elements, streamEntryArray(entry))
+	}
+	return response
+}
+
+// `parseStreamTrim` parses the trimming options of XADD and XTRIM
+// starting at args[i], which is MAXLEN or MINID. It returns the index
+// of the last argument consumed. A non empty string is the error
+// to reply with
+func parseStreamTrim(args [][]byte, i int, options *streamTrimOptions) (int, string) {
+	options.byMinID = strings.ToUpper(string(args[i])) == "MINID"
+	i++
+	if i < len(args) && (string(args[i]) == "~" || string(args[i]) == "=") {
+		options.approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return i, "invalid syntax"
+	}
+	if options.byMinID {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			return i, invalidStreamID
+		}
+		options.minID = id
+	} else {
+		maxLen, ok := parseInteger(args[i])
+		if !ok {
+			return i, "value is not an integer or out of range"
+		}
+		if maxLen < 0 {
+			return i, "The MAXLEN argument must be >= 0."
+		}
+		options.maxLen = maxLen
+	}
+	return i, ""
+}
+
+// `parseStreamTrimLimit` validates the trimming options once parsed,
+// limitGiven is set when LIMIT was given
+func parseStreamTrimLimit(options *streamTrimOptions, limitGiven bool) string {
+	if limitGiven && !options.approx {
+		return "syntax error, LIMIT cannot be used without the special ~ option"
+	}
+	// approximate trims do a bounded amount of work by default
+	if options.approx && !limitGiven {
+		options.limit = 100 * streamChunkMaxEntries
+	}
+	return ""
+}
+
+// XADD command appends an entry to the stream stored at key
+func xadd(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("xadd")
+	}
+	key := string(args[0])
+	var trim *streamTrimOptions
+	var noMkStream, strategyGiven, limitGiven, autoSeq bool
+	id := streamID{}
+	autoID := false
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "*":
+			autoID = true
+		case option == "NOMKSTREAM":
+			noMkStream = true
+			continue
+		case (option == "MAXLEN" || option == "MINID") && i+1 < len(args):
+			if strategyGiven && trim.byMinID != (option == "MINID") {
+				return errorReply("syntax error, MAXLEN and MINID options at the same time are not compatible")
+			}
+			if trim == nil {
+				trim = &streamTrimOptions{}
+			}
+			strategyGiven = true
+			var message string
+			i, message = parseStreamTrim(args, i, trim)
+			if message != "" {
+				return errorReply(message)
+			}
+			continue
+		case option == "LIMIT" && i+1 < len(args):
+			limit, ok := parseInteger(args[i+1])
+			if !ok {
+				return errorReply("value is not an integer or out of range")
+			}
+			if limit < 0 {
+				return errorReply("The LIMIT argument must be >= 0.")
+			}
+			limitGiven = true
+			if trim == nil {
+				trim = &streamTrimOptions{}
+			}
+			trim.limit = limit
+			i++
+			continue
+		default:
+			// an ID with an automatic sequence number, ms-*
+			if ms, found := strings.CutSuffix(string(args[i]), "-*"); found {
+				parsed, ok := parseStreamID([]byte(ms), 0)
+				if !ok {
+					return errorReply(invalidStreamID)
+				}
+				id, autoSeq = parsed, true
+				break
+			}
+			parsed, ok := parseStreamID(args[i], 0)
+			if !ok {
+				return errorReply(invalidStreamID)
+			}
+			id = parsed
+		}
+		break
+	}
+	fields := args[min(i+1, len(args)):]
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		return wrongNumberOfArgs("xadd")
+	}
+	if trim != nil {
+		if !strategyGiven {
+			return errorReply("syntax error, LIMIT cannot be used without specifying a trimming strategy")
+		}
+		if message := parseStreamTrimLimit(trim, limitGiven); message != "" {
+			return errorReply(message)
+		}
+	}
+	if !autoID && !autoSeq && id == (streamID{}) {
+		return errorReply("The ID specified in XADD must be greater than 0-0")
+	}
+	st, exists, isStream := s.getStream(key)
+	if !isStream {
+		return wrongType()
+	}
+	if !exists {
+		if noMkStream {
+			return nilBulkString()
+		}
+		st = newStream()
+	}
+	// work out the ID of the new entry
+	switch {
+	case autoID:
+		now := uint64(time.Now().UnixMilli())
+		if now > st.lastID.ms {
+			id = streamID{now, 0}
+		} else {
+			next, ok := st.lastID.next()
+			if !ok {
+				return errorReply("The stream has exhausted the last possible ID, unable to add more items")
+			}
+			id = next
+		}
+	case autoSeq:
+		if id.ms == st.lastID.ms {
+			if st.lastID.seq == math.MaxUint64 {
+				return errorReply("The ID specified in XADD is equal or smaller than the target stream top item")
+			}
+			id.seq = st.lastID.seq + 1
+		} else if id.ms < st.lastID.ms {
+			return errorReply("The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+	if id.compare(st.lastID) <= 0 {
+		return errorReply("The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	fieldsCopy := make([][]byte, len(fields))
+	for j, field := range fields {
+		fieldsCopy[j] = slices.Clone(field)
+	}
+	st.add(streamEntry{id, fieldsCopy})
+	if exists {
+		s.signalKeyAsReady(key)
+	} else {
+		s.set(key, &redisValue{
+			value:     st,
+			valueType: "stream",
+		})
+	}
+	s.notifyKeyspaceEvent(notifyStream, "xadd", key)
+	if trim != nil && st.trim(*trim) > 0 {
+		s.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+	return streamIDBulkString(id).Serialise()
+}
+
+// XLEN command returns the number of entries of a stream
+func xlen(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("xlen")
+	}
+	st, exists, isStream := s.getStream(string(args[0]))
+	if !isStream {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists {
+		response.Data = int64(st.length)
+	}
+	return response.Serialise()
+}
+
+// `parseRangeID` parses a bound of XRANGE: "-", "+", an ID, or an ID
+// prefixed with '(' to exclude it. A missing sequence number is 0
+// for the start of the range and the largest one for its end
+func parseRangeID(arg []byte, isStart bool) (streamID, string) {
+	switch string(arg) {
+	case "-":
+		return streamID{}, ""
+	case "+":
+		return maxStreamID, ""
+	}
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	missingSeq := uint64(0)
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+	id, ok := parseStreamID(arg, missingSeq)
+	if !ok {
+		return id, invalidStreamID
+	}
+	if !exclusive {
+		return id, ""
+	}
+	if isStart {
+		if id, ok = id.next(); !ok {
+			return id, "invalid start ID for the interval"
+		}
+	} else if id, ok = id.prev(); !ok {
+		return id, "invalid end ID for the interval"
+	}
+	return id, ""
+}
+
+// `xrangeGeneric` implements XRANGE and XREVRANGE
+func xrangeGeneric(args [][]byte, s *store, command string, reverse bool) ([]byte, error) {
+	if len(args) != 3 && len(args) != 5 {
+		return wrongNumberOfArgs(command)
+	}
+	startArg, endArg := args[1], args[2]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, message := parseRangeID(startArg, true)
+	if message != "" {
+		return errorReply(message)
+	}
+	end, message := parseRangeID(endArg, false)
+	if message != "" {
+		return errorReply(message)
+	}
+	count := int64(0)
+	if len(args) == 5 {
+		if strings.ToUpper(string(args[3])) != "COUNT" {
+			return errorReply("invalid syntax")
+		}
+		var ok bool
+		count, ok = parseInteger(args[4])
+		if !ok {
+			return errorReply("value is not an integer or out of range")
+		}
+		if count <= 0 {
+			return streamEntriesArray(nil).Serialise()
+		}
+	}
+	st, exists, isStream := s.getStream(string(args[0]))
+	if !isStream {
+		return wrongType()
+	}
+	if !exists {
+		return streamEntriesArray(nil).Serialise()
+	}
+	return streamEntriesArray(st.rangeEntries(start, end, int(min(count, math.MaxInt32)), reverse)).Serialise()
+}
+
+// XRANGE command returns the entries of a stream with IDs
+// between start and end
+func xrange(args [][]byte, s *store) ([]byte, error) {
+	return xrangeGeneric(args, s, "xrange", false)
+}
+
+// XREVRANGE command returns the entries of a stream with IDs
+// between end and start, from the last one
+func xrevrange(args [][]byte, s *store) ([]byte, error) {
+	return xrangeGeneric(args, s, "xrevrange", true)
+}
+
+// XDEL command deletes entries from a stream
+func xdel(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("xdel")
+	}
+	ids := make([]streamID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return errorReply(invalidStreamID)
+		}
+		ids[i] = id
+	}
+	key := string(args[0])
+	st, exists, isStream := s.getStream(key)
+	if !isStream {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	for _, id := range ids {
+		if st.remove(id) {
+			response.Data++
+		}
+	}
+	if response.Data > 0 {
+		s.notifyKeyspaceEvent(notifyStream, "xdel", key)
+	}
+	return response.Serialise()
+}
+
+// XTRIM command removes entries from the head of a stream
+func xtrim(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("xtrim")
+	}
+	strategy := strings.ToUpper(string(args[1]))
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return errorReply("invalid syntax")
+	}
+	var options streamTrimOptions
+	i, message := parseStreamTrim(args, 1, &options)
+	if message != "" {
+		return errorReply(message)
+	}
+	limitGiven := false
+	rest := args[i+1:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return errorReply("invalid syntax")
+		}
+		limit, ok := parseInteger(rest[1])
+		if !ok {
+			return errorReply("value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return errorReply("The LIMIT argument must be >= 0.")
+		}
+		options.limit = limit
+		limitGiven = true
+	}
+	if message := parseStreamTrimLimit(&options, limitGiven); message != "" {
+		return errorReply(message)
+	}
+	key := string(args[0])
+	st, exists, isStream := s.getStream(key)
+	if !isStream {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	response.Data = st.trim(options)
+	if response.Data > 0 {
+		s.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+	return response.Serialise()
+}
+
+// `xreadRequest` holds the parsed arguments of XREAD and XREADGROUP
+type xreadRequest struct {
+	keys []string
+	// ids holds, for each key, the ID entries must be greater than
+	ids     []streamID
+	count   int
+	block   bool
+	timeout time.Duration
+	// group and consumer are set by XREADGROUP, history is set for
+	// the keys reading the consumer's pending entries rather than
+	// new entries, which are given as ">"
+	group    string
+	consumer string
+	noAck    bool
+	history  []bool
+}
+
+// `parseXread` parses the arguments of XREAD, [COUNT count] [BLOCK
+// milliseconds] STREAMS key [key ...] id [id ...], along with the
+// GROUP group consumer and NOACK options of XREADGROUP. The special
+// IDs are resolved against the current content of the streams: "$"
+// reads entries added after the call and "+" the last entry
+func parseXread(args [][]byte, s *store, command string) (*xreadRequest, []byte, error) {
+	request := &xreadRequest{}
+	isGroup := command == "xreadgroup"
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "STREAMS" {
+			break
+		}
+		if option == "NOACK" {
+			if !isGroup {
+				response, err := errorReply("The NOACK option is only supported by XREADGROUP. You called XREAD instead.")
+				return nil, response, err
+			}
+			request.noAck = true
+			continue
+		}
+		if i+1 >= len(args) {
+			response, err := errorReply("invalid syntax")
+			return nil, response, err
+		}
+		switch option {
+		case "COUNT":
+			count, ok := parseInteger(args[i+1])
+			if !ok {
+				response, err := errorReply("value is not an integer or out of range")
+				return nil, response, err
+			}
+			request.count = int(max(min(count, math.MaxInt32), 0))
+		case "BLOCK":
+			milliseconds, ok := parseInteger(args[i+1])
+			if !ok {
+				response, err := errorReply("timeout is not an integer or out of range")
+				return nil, response, err
+			}
+			if milliseconds < 0 {
+				response, err := errorReply("timeout is negative")
+				return nil, response, err
+			}
+			request.block = true
+			request.timeout = time.Duration(min(milliseconds, math.MaxInt64/int64(time.Millisecond))) * time.Millisecond
+		case "GROUP":
+			if !isGroup {
+				response, err := errorReply("The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+				return nil, response, err
+			}
+			if i+2 >= len(args) {
+				response, err := errorReply("invalid syntax")
+				return nil, response, err
+			}
+			request.group, request.consumer = string(args[i+1]), string(args[i+2])
+			i++
+		default:
+			response, err := errorReply("invalid syntax")
+			return nil, response, err
+		}
+		i++
+	}
+	streams := args[min(i+1, len(args)):]
+	if i == len(args) || len(streams) == 0 {
+		response, err := errorReply("invalid syntax")
+		return nil, response, err
+	}
+	if isGroup && request.group == "" {
+		response, err := errorReply("Missing GROUP option for XREADGROUP")
+		return nil, response, err
+	}
+	if len(streams)%2 != 0 {
+		response, err := errorReply("Unbalanced '" + command + "' list of streams: for each stream key an ID or '$' must be specified.")
+		return nil, response, err
+	}
+	count := len(streams) / 2
+	for j := 0; j < count; j++ {
+		key := string(streams[j])
+		st, exists, isStream := s.getStream(key)
+		if !isStream {
+			response, err := wrongType()
+			return nil, response, err
+		}
+		if isGroup {
+			if !exists || st.groups[request.group] == nil {
+				response, err := errorReply("NOGROUP No such key '" + key + "' or consumer group '" + request.group +
+					"' in XREADGROUP with GROUP option")
+				return nil, response, err
+			}
+		}
+		var id streamID
+		history := false
+		switch arg := string(streams[count+j]); {
+		case arg == "$" && isGroup:
+			response, err := errorReply("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+			return nil, response, err
+		case arg == "$":
+			if exists {
+				id = st.lastID
+			}
+		case arg == "+" && !isGroup:
+			if exists {
+				id = st.lastID
+				// read the last entry, by starting right before it
+				if last, ok := st.lastEntry(); ok {
+					id, _ = last.id.prev()
+				}
+			}
+		case arg == ">":
+			if !isGroup {
+				response, err := errorReply("The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+				return nil, response, err
+			}
+		default:
+			parsed, ok := parseStreamID(streams[count+j], 0)
+			if !ok {
+				response, err := errorReply(invalidStreamID)
+				return nil, response, err
+			}
+			id, history = parsed, isGroup
+		}
+		request.keys = append(request.keys, key)
+		request.ids = append(request.ids, id)
+		request.history = append(request.history, history)
+	}
+	return request, nil, nil
+}
+
+// `serve` returns the entries of the stream at the j-th key of the
+// request that follow the key's ID, as an array of the key and its
+// entries. It reports false when there are none
+func (request *xreadRequest) serve(j int, s *store) (*resp.Array, bool, []byte, error) {
+	if request.group != "" {
+		return request.serveGroup(j, s)
+	}
+	st, exists, isStream := s.getStream(request.keys[j])
+	if !isStream {
+		response, err := wrongType()
+		return nil, false, response, err
+	}
+	if !exists {
+		return nil, false, nil, nil
+	}
+	start, ok := request.ids[j].next()
+	if !ok {
+		return nil, false, nil, nil
+	}
+	entries := st.rangeEntries(start, maxStreamID, request.count, false)
+	if len(entries) == 0 {
+		return nil, false, nil, nil
+	}
+	key := request.keys[j]
+	return &resp.Array{
+		Size: 2,
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte(key), Size: len(key)},
+			streamEntriesArray(entries),
+		},
+	}, true, nil, nil
+}
+
+// `xreadClient` returns the client XREAD and XREADGROUP block with.
+// It's served with the entries of every stream holding new entries,
+// and once blocked, with those of the first stream receiving new
+// entries
+func xreadClient(request *xreadRequest) *blockedClient {
+	b := &blockedClient{
+		keys:    request.keys,
+		timeout: request.timeout,
+		reply:   make(chan blockedReply, 1),
+	}
+	b.serveAll = func(s *store) ([]byte, bool, error) {
+		response := resp.Array{}
+		for j := range request.keys {
+			streamArray, ok, errResponse, err := request.serve(j, s)
+			if errResponse != nil {
+				return errResponse, true, err
+			}
+			if ok {
+				response.Elements = append(response.Elements, streamArray)
+			}
+		}
+		if len(response.Elements) == 0 {
+			return nil, false, nil
+		}
+		response.Size = len(response.Elements)
+		data, err := response.Serialise()
+		return data, true, err
+	}
+	b.serve = func(key string, s *store) ([]byte, bool, error) {
+		j := slices.Index(request.keys, key)
+		streamArray, ok, errResponse, err := request.serve(j, s)
+		if errResponse != nil {
+			return errResponse, true, err
+		}
+		if !ok {
+			return nil, false, nil
+		}
+		response := resp.Array{
+			Size:     1,
+			Elements: []resp.RESPDatatype{streamArray},
+		}
+		data, err := response.Serialise()
+		return data, true, err
+	}
+	b.nilReply = nilArray
+	return b
+}
+
+// `elements` flattens the stream into its last ID, largest deleted
+// ID, number of entries ever added and number of entries, followed
+// by each entry as its ID, its number of fields and its fields and
+// values, and by the consumer groups of the stream.
+// `streamFromElements` reads it back
+func (st *stream) elements() [][]byte {
+	elements := [][]byte{
+		[]byte(st.lastID.String()),
+		[]byte(st.maxDeletedID.String()),
+		[]byte(strconv.FormatUint(st.entriesAdded, 10)),
+		[]byte(strconv.Itoa(st.length)),
+	}
+	for _, entry := range st.rangeEntries(streamID{}, maxStreamID, 0, false) {
+		elements = append(elements, []byte(entry.id.String()), []byte(strconv.Itoa(len(entry.fields)/2)))
+		elements = append(elements, entry.fields...)
+	}
+	return st.appendGroupElements(elements)
+}
+
+// `toRESPArray` serialises the stream as an array of its elements
+func (st *stream) toRESPArray() ([]byte, error) {
+	var response resp.Array
+	for _, element := range st.elements() {
+		response.Elements = append(response.Elements, &resp.BulkString{Data: element, Size: len(element)})
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `streamFromElements` builds a stream out of the elements
+// produced by `elements`
+func streamFromElements(elements [][]byte) (*stream, error) {
+	if len(elements) < 4 {
+		return nil, resp.ErrInvalidClientData
+	}
+	st := newStream()
+	lastID, lastOk := parseStreamID(elements[0], 0)
+	maxDeletedID, deletedOk := parseStreamID(elements[1], 0)
+	entriesAdded, addedErr := strconv.ParseUint(string(elements[2]), 10, 64)
+	length, lengthErr := strconv.Atoi(string(elements[3]))
+	if !lastOk || !deletedOk || addedErr != nil || lengthErr != nil {
+		return nil, resp.ErrInvalidClientData
+	}
+	elements = elements[4:]
+	for ; length > 0; length-- {
+		if len(elements) < 2 {
+			return nil, resp.ErrInvalidClientData
+		}
+		id, ok := parseStreamID(elements[0], 0)
+		fieldCount, err := strconv.Atoi(string(elements[1]))
+		if !ok || err != nil || fieldCount < 1 || fieldCount > (len(elements)-2)/2 ||
+			(st.length > 0 && id.compare(st.lastID) <= 0) {
+			return nil, resp.ErrInvalidClientData
+		}
+		st.add(streamEntry{id, elements[2 : 2+2*fieldCount]})
+		elements = elements[2+2*fieldCount:]
+	}
+	if err := st.readGroupElements(elements); err != nil {
+		return nil, err
+	}
+	st.lastID = lastID
+	st.maxDeletedID = maxDeletedID
+	st.entriesAdded = entriesAdded
+	return st, nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStreamID(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want streamID
+		ok   bool
+	}{
+		{"0-1", streamID{0, 1}, true},
+		{"1526919030474-55", streamID{1526919030474, 55}, true},
+		{"7", streamID{7, 42}, true},
+		{"18446744073709551615-18446744073709551615", maxStreamID, true},
+		{"18446744073709551616-0", streamID{}, false},
+		{"1-18446744073709551616", streamID{}, false},
+		{"", streamID{}, false},
+		{"-", streamID{}, false},
+		{"1-", streamID{}, false},
+		{"-1", streamID{}, false},
+		{"+1-1", streamID{}, false},
+		{"1-+1", streamID{}, false},
+		{"1-2-3", streamID{}, false},
+		{"a-1", streamID{}, false},
+		{" 1-1", streamID{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseStreamID([]byte(test.arg), 42)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("parseStreamID(%q) = %v, %v, want %v, %v", test.arg, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseRangeID(t *testing.T) {
+	tests := []struct {
+		arg     string
+		isStart bool
+		want    streamID
+		message string
+	}{
+		{"-", true, streamID{}, ""},
+		{"+", false, maxStreamID, ""},
+		{"5", true, streamID{5, 0}, ""},
+		{"5", false, streamID{5, math.MaxUint64}, ""},
+		{"(5-3", true, streamID{5, 4}, ""},
+		{"(5-3", false, streamID{5, 2}, ""},
+		{"(5-18446744073709551615", true, streamID{6, 0}, ""},
+		{"(5-0", false, streamID{4, math.MaxUint64}, ""},
+		{"(18446744073709551615-18446744073709551615", true, streamID{}, "invalid start ID for the interval"},
+		{"(0-0", false, streamID{}, "invalid end ID for the interval"},
+		{"(", true, streamID{}, invalidStreamID},
+		{"x", false, streamID{}, invalidStreamID},
+	}
+	for _, test := range tests {
+		got, message := parseRangeID([]byte(test.arg), test.isStart)
+		if message != test.message || (message == "" && got != test.want) {
+			t.Errorf("parseRangeID(%q, %v) = %v, %q, want %v, %q",
+				test.arg, test.isStart, got, message, test.want, test.message)
+		}
+	}
+}
+
+// `checkStream` checks that st holds exactly the entries of want,
+// in order, whichever way it's read
+func checkStream(t *testing.T, st *stream, want []streamEntry) {
+	t.Helper()
+	if st.length != len(want) {
+		t.Fatalf("stream holds %d entries, want %d", st.length, len(want))
+	}
+	equal := func(a, b streamEntry) bool {
+		return a.id == b.id && slices.EqualFunc(a.fields, b.fields, slices.Equal)
+	}
+	if got := st.rangeEntries(streamID{}, maxStreamID, 0, false); !slices.EqualFunc(got, want, equal) {
+		t.Fatalf("stream holds %d entries that differ from the %d expected", len(got), len(want))
+	}
+	reversed := slices.Clone(want)
+	slices.Reverse(reversed)
+	if got := st.rangeEntries(streamID{}, maxStreamID, 0, true); !slices.EqualFunc(got, reversed, equal) {
+		t.Fatal("the stream read backwards differs from the expected entries")
+	}
+	if len(want) > 20 {
+		start, end := want[5].id, want[len(want)-5].id
+		if got := st.rangeEntries(start, end, 10, false); !slices.EqualFunc(got, want[5:15], equal) {
+			t.Fatal("a forward range with a count differs from the expected entries")
+		}
+		if got := st.rangeEntries(start, end, 10, true); !slices.EqualFunc(got, reversed[4:14], equal) {
+			t.Fatal("a reverse range with a count differs from the expected entries")
+		}
+	}
+	for _, chunk := range st.chunks {
+		if chunk.live() == 0 {
+			t.Fatal("the stream holds an empty chunk")
+		}
+	}
+}
+
+func TestStreamChunks(t *testing.T) {
+	st := newStream()
+	var want []streamEntry
+	for i := 0; i < 1000; i++ {
+		fields := [][]byte{[]byte("temperature"), []byte(strconv.Itoa(i)), []byte("unit"), []byte("C")}
+		// entries whose fields differ from their chunk's first entry
+		if i%7 == 0 {
+			fields = [][]byte{[]byte("event"), []byte(strconv.Itoa(i))}
+		}
+		entry := streamEntry{streamID{uint64(1000 + i/3), uint64(i % 3)}, fields}
+		st.add(entry)
+		want = append(want, entry)
+	}
+	if len(st.chunks) < 1000/streamChunkMaxEntries {
+		t.Fatalf("1000 entries were packed in %d chunks", len(st.chunks))
+	}
+	checkStream(t, st, want)
+
+	// deleting most of a chunk compacts it, deleting all of it
+	// drops it
+	for i := 0; i < 300; i++ {
+		if i%10 != 9 {
+			if !st.remove(want[i].id) {
+				t.Fatalf("%v wasn't removed", want[i].id)
+			}
+		}
+	}
+	if st.remove(want[0].id) || st.remove(streamID{1, 1}) {
+		t.Fatal("removed an entry that isn't there")
+	}
+	var kept []streamEntry
+	for i, entry := range want {
+		if i >= 300 || i%10 == 9 {
+			kept = append(kept, entry)
+		}
+	}
+	want = kept
+	checkStream(t, st, want)
+	if st.maxDeletedID != (streamID{1099, 1}) {
+		t.Errorf("maxDeletedID = %v", st.maxDeletedID)
+	}
+
+	if removed := st.trim(streamTrimOptions{maxLen: 500}); removed != int64(len(want)-500) {
+		t.Errorf("trimming to 500 entries removed %d", removed)
+	}
+	want = want[len(want)-500:]
+	checkStream(t, st, want)
+	minID := want[123].id
+	if removed := st.trim(streamTrimOptions{byMinID: true, minID: minID}); removed != 123 {
+		t.Errorf("trimming to %v removed %d entries", minID, removed)
+	}
+	want = want[123:]
+	checkStream(t, st, want)
+	// an approximate trim only drops whole chunks
+	first := st.chunks[0].live()
+	if removed := st.trim(streamTrimOptions{maxLen: int64(len(want) - first + 1), approx: true}); removed != 0 {
+		t.Errorf("an approximate trim removed %d entries out of a chunk it couldn't drop", removed)
+	}
+	if removed := st.trim(streamTrimOptions{maxLen: int64(len(want) - first), approx: true}); removed != int64(first) {
+		t.Errorf("an approximate trim removed %d entries, want the %d of the first chunk", removed, first)
+	}
+	checkStream(t, st, want[first:])
+}
+
+// TestStreamFromCorruptedElements checks that entries claiming more
+// fields than the elements hold are rejected, including counts that
+// overflow once doubled
+func TestStreamFromCorruptedElements(t *testing.T) {
+	for _, fieldCount := range []string{"2", "4611686018427387904", "9223372036854775807"} {
+		elements := [][]byte{[]byte("1-1"), []byte("0-0"), []byte("1"), []byte("1"),
+			[]byte("1-1"), []byte(fieldCount), []byte("field"), []byte("value")}
+		if _, err := streamFromElements(elements); err == nil {
+			t.Errorf("an entry of %s fields holding one was accepted", fieldCount)
+		}
+	}
+	elements := [][]byte{[]byte("1-1"), []byte("0-0"), []byte("1"), []byte("1"),
+		[]byte("1-1"), []byte("1"), []byte("field"), []byte("value")}
+	if _, err := streamFromElements(elements); err != nil {
+		t.Errorf("a valid stream was rejected: %v", err)
+	}
+}
+
+func TestXadd(t *testing.T) {
+	s := newStore()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"XADD", "s", "1-1", "a", "1"}, bulkReply("1-1")},
+		{[]string{"XADD", "s", "1-*", "a", "2"}, bulkReply("1-2")},
+		{[]string{"XADD", "s", "1", "a", "3"}, "-The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{[]string{"XADD", "s", "2", "a", "3"}, bulkReply("2-0")},
+		{[]string{"XADD", "s", "1-*", "a", "3"}, "-The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{[]string{"XADD", "s", "3-*", "a", "4"}, bulkReply("3-0")},
+		{[]string{"XADD", "s", "0-0", "a", "1"}, "-The ID specified in XADD must be greater than 0-0\r\n"},
+		{[]string{"XADD", "s", "x-1", "a", "1"}, "-" + invalidStreamID + "\r\n"},
+		{[]string{"XADD", "s", "*-*", "a", "1"}, "-" + invalidStreamID + "\r\n"},
+		{[]string{"XADD", "s", "4-1", "a"}, "-wrong number of arguments for 'xadd' command\r\n"},
+		{[]string{"XADD", "s", "MAXLEN", "2", "4-1", "a", "5"}, bulkReply("4-1")},
+		{[]string{"XLEN", "s"}, integerReply(2)},
+		{[]string{"XADD", "s", "MAXLEN", "1", "MINID", "1", "*", "a", "1"}, "-syntax error, MAXLEN and MINID options at the same time are not compatible\r\n"},
+		{[]string{"XADD", "s", "LIMIT", "10", "*", "a", "1"}, "-syntax error, LIMIT cannot be used without specifying a trimming strategy\r\n"},
+		{[]string{"XADD", "missing", "NOMKSTREAM", "*", "a", "1"}, "$-1\r\n"},
+		{[]string{"EXISTS", "missing"}, integerReply(0)},
+		{[]string{"XADD", "new", "0-*", "a", "1"}, bulkReply("0-1")},
+		{[]string{"XADD", "max", "18446744073709551615-18446744073709551615", "a", "1"}, bulkReply("18446744073709551615-18446744073709551615")},
+		{[]string{"XADD", "max", "*", "a", "1"}, "-The stream has exhausted the last possible ID, unable to add more items\r\n"},
+		{[]string{"XADD", "max", "18446744073709551615-*", "a", "1"}, "-The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q replied %q, want %q", test.args, got, test.want)
+		}
+	}
+	// an automatic ID follows the clock, or the last ID when it's ahead
+	reply := run(t, s, "XADD", "clock", "*", "a", "1")
+	id, ok := parseStreamID([]byte(reply[len("$00\r\n"):len(reply)-2]), 0)
+	if !ok || id.ms == 0 || id.seq != 0 {
+		t.Errorf("XADD * replied %q", reply)
+	}
+	run(t, s, "XADD", "ahead", "99999999999999-5", "a", "1")
+	if got := run(t, s, "XADD", "ahead", "*", "a", "1"); got != bulkReply("99999999999999-6") {
+		t.Errorf("XADD * after an ID ahead of the clock replied %q", got)
+	}
+}
+
+// `entryReply` serialises a stream entry
+func entryReply(id string, fields ...string) string {
+	return "*2\r\n" + bulkReply(id) + bulkArray(fields...)
+}
+
+func TestConsumerGroups(t *testing.T) {
+	s := newStore()
+	run(t, s, "XADD", "s", "1-0", "a", "1")
+	run(t, s, "XADD", "s", "2-0", "b", "2")
+	run(t, s, "XADD", "s", "3-0", "c", "3")
+	entry1, entry2, entry3 := entryReply("1-0", "a", "1"), entryReply("2-0", "b", "2"), entryReply("3-0", "c", "3")
+	read := func(entries ...string) string {
+		return "*1\r\n*2\r\n" + bulkReply("s") + "*" + strconv.Itoa(len(entries)) + "\r\n" + strings.Join(entries, "")
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"XGROUP", "CREATE", "s", "g", "0"}, "+OK\r\n"},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$"}, "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{[]string{"XGROUP", "CREATE", "missing", "g", "$"}, "-The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"},
+		{[]string{"XGROUP", "CREATE", "new", "g", "$", "MKSTREAM"}, "+OK\r\n"},
+		{[]string{"XLEN", "new"}, integerReply(0)},
+		{[]string{"XGROUP", "CREATE", "s", "other", "0", "ENTRIESREAD", "-2"}, "-value for ENTRIESREAD must be positive or -1\r\n"},
+		{[]string{"XGROUP", "BOGUS", "s", "g"}, "-unknown subcommand 'BOGUS' for 'xgroup' command\r\n"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"}, read(entry1, entry2)},
+		{[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"}, read(entry3)},
+		{[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"}, "*-1\r\n"},
+		// a consumer's history holds the entries it has pending
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"}, read(entry1, entry2)},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "1-0"}, read(entry2)},
+		{[]string{"XREADGROUP", "GROUP", "missing", "alice", "STREAMS", "s", ">"},
+			"-NOGROUP No such key 's' or consumer group 'missing' in XREADGROUP with GROUP option\r\n"},
+		{[]string{"XPENDING", "s", "g"}, "*4\r\n" + integerReply(3) + bulkReply("1-0") + bulkReply("3-0") +
+			"*2\r\n" + bulkArray("alice", "2") + bulkArray("bob", "1")},
+		{[]string{"XPENDING", "s", "missing"}, "-NOGROUP No such key 's' or consumer group 'missing'\r\n"},
+		{[]string{"XACK", "s", "g", "1-0", "9-0", "1-0"}, integerReply(1)},
+		{[]string{"XACK", "s", "g", "x"}, "-" + invalidStreamID + "\r\n"},
+		{[]string{"XACK", "missing", "g", "1-0"}, integerReply(0)},
+		{[]string{"XPENDING", "s", "g", "IDLE", "3600000", "-", "+", "10"}, "*0\r\n"},
+		{[]string{"XCLAIM", "s", "g", "carol", "3600000", "2-0"}, "*0\r\n"},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "2-0"}, "*1\r\n" + entry2},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "2-0", "JUSTID"}, bulkArray("2-0")},
+		// an acknowledged entry is only claimed with FORCE
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "1-0"}, "*0\r\n"},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "1-0", "FORCE", "RETRYCOUNT", "7"}, "*1\r\n" + entry1},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "1-0", "BOGUS"}, "-Unrecognized XCLAIM option 'BOGUS'\r\n"},
+		{[]string{"XCLAIM", "s", "g", "carol", "x", "1-0"}, "-Invalid min-idle-time argument for XCLAIM\r\n"},
+		{[]string{"XPENDING", "s", "g"}, "*4\r\n" + integerReply(3) + bulkReply("1-0") + bulkReply("3-0") +
+			"*2\r\n" + bulkArray("bob", "1") + bulkArray("carol", "2")},
+		// deleted entries are acknowledged rather than claimed
+		{[]string{"XDEL", "s", "3-0"}, integerReply(1)},
+		{[]string{"XAUTOCLAIM", "s", "g", "dave", "0", "0", "COUNT", "1"},
+			"*3\r\n" + bulkReply("2-0") + "*1\r\n" + entry1 + "*0\r\n"},
+		{[]string{"XAUTOCLAIM", "s", "g", "dave", "0", "(1-0", "JUSTID"},
+			"*3\r\n" + bulkReply("0-0") + bulkArray("2-0") + bulkArray("3-0")},
+		{[]string{"XAUTOCLAIM", "s", "g", "dave", "0", "0", "COUNT", "0"}, "-COUNT must be > 0\r\n"},
+		{[]string{"XPENDING", "s", "g", "-", "+", "10", "bob"}, "*0\r\n"},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "g", "erin"}, integerReply(1)},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "g", "erin"}, integerReply(0)},
+		// deleting a consumer replies with the entries it had pending
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "dave"}, integerReply(2)},
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "dave"}, integerReply(0)},
+		{[]string{"XPENDING", "s", "g"}, "*4\r\n" + integerReply(0) + "$-1\r\n$-1\r\n*-1\r\n"},
+		{[]string{"XGROUP", "SETID", "s", "g", "0"}, "+OK\r\n"},
+		{[]string{"XREADGROUP", "GROUP", "g", "erin", "NOACK", "STREAMS", "s", ">"}, read(entry1, entry2)},
+		{[]string{"XPENDING", "s", "g"}, "*4\r\n" + integerReply(0) + "$-1\r\n$-1\r\n*-1\r\n"},
+		{[]string{"XGROUP", "DESTROY", "s", "g"}, integerReply(1)},
+		{[]string{"XGROUP", "DESTROY", "s", "g"}, integerReply(0)},
+		{[]string{"XGROUP", "SETID", "s", "g", "0"}, "-NOGROUP No such consumer group 'g' for key name 's'\r\n"},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestConsumerGroupDeliveries(t *testing.T) {
+	s := newStore()
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		run(t, s, "XADD", "s", id, "f", "v")
+	}
+	run(t, s, "XGROUP", "CREATE", "s", "g", "0")
+	st, group, _ := s.getGroup("s", "g")
+	if lag, ok := st.lag(group); !ok || lag != 3 {
+		t.Errorf("the lag of a new group is %d, %v, want 3", lag, ok)
+	}
+	run(t, s, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">")
+	if lag, ok := st.lag(group); group.entriesRead != 1 || !ok || lag != 2 {
+		t.Errorf("after reading an entry the group read %d with a lag of %d, %v", group.entriesRead, lag, ok)
+	}
+	id := streamID{1, 0}
+	// `pending` returns the consumer and delivery count of the entry
+	pending := func() (string, uint64) {
+		entry, ok := group.pending.entries[id]
+		if !ok {
+			t.Fatalf("%v isn't pending", id)
+		}
+		return entry.consumer.name, entry.deliveryCount
+	}
+	run(t, s, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+	run(t, s, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+	if consumer, count := pending(); consumer != "alice" || count != 3 {
+		t.Errorf("%v was delivered to %s %d times, want alice 3 times", id, consumer, count)
+	}
+	run(t, s, "XCLAIM", "s", "g", "bob", "0", "1-0", "JUSTID")
+	if consumer, count := pending(); consumer != "bob" || count != 3 {
+		t.Errorf("after XCLAIM JUSTID, %v was delivered to %s %d times, want bob 3 times", id, consumer, count)
+	}
+	run(t, s, "XCLAIM", "s", "g", "carol", "0", "1-0", "IDLE", "5000")
+	if consumer, count := pending(); consumer != "carol" || count != 4 {
+		t.Errorf("after XCLAIM, %v was delivered to %s %d times, want carol 4 times", id, consumer, count)
+	}
+	if alice := group.consumers["alice"]; alice.pending.length() != 0 {
+		t.Error("a claimed entry is still pending for its previous consumer")
+	}
+	if got := run(t, s, "XPENDING", "s", "g", "IDLE", "4000", "-", "+", "10"); !strings.HasPrefix(got, "*1\r\n") {
+		t.Errorf("XPENDING of entries idle for 4s replied %q, want the entry claimed with IDLE 5000", got)
+	}
+	run(t, s, "XCLAIM", "s", "g", "carol", "0", "1-0", "RETRYCOUNT", "10", "LASTID", "2-0")
+	if _, count := pending(); count != 10 || group.lastID != (streamID{2, 0}) {
+		t.Errorf("XCLAIM RETRYCOUNT 10 LASTID 2-0 left %d deliveries and the last ID %v", count, group.lastID)
+	}
+	// a deleted entry after the last one read makes the lag unknown
+	run(t, s, "XDEL", "s", "3-0")
+	if _, ok := st.lag(group); ok {
+		t.Error("the lag is known past a deleted entry")
+	}
+}
+
+func TestBlockingXreadgroup(t *testing.T) {
+	s := newStore()
+	run(t, s, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	reply := make(chan string, 1)
+	go func() {
+		reply <- runBlocking(t, s, nil, "XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	}()
+	waitForBlocked(t, s, "s", 1)
+	run(t, s, "XADD", "s", "1-0", "f", "v")
+	select {
+	case got := <-reply:
+		if want := "*1\r\n*2\r\n" + bulkReply("s") + "*1\r\n" + entryReply("1-0", "f", "v"); got != want {
+			t.Errorf("the blocked XREADGROUP was served %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked XREADGROUP wasn't served")
+	}
+	// destroying the group wakes its readers up with an error
+	go func() {
+		reply <- runBlocking(t, s, nil, "XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	}()
+	waitForBlocked(t, s, "s", 1)
+	run(t, s, "XGROUP", "DESTROY", "s", "g")
+	select {
+	case got := <-reply:
+		if want := "-NOGROUP the consumer group this client was blocked on no longer exists\r\n"; got != want {
+			t.Errorf("the reader of a destroyed group was served %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reader of a destroyed group wasn't woken up")
+	}
+}
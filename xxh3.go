@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// This file implements the 64 bit variant of XXH3 with the default
+// secret and no seed, the hash redis uses for DIGEST, IFDEQ and
+// IFDNE, so that digests computed by clients match the server's.
+// See https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+
+const (
+	xxhPrime32_1 uint64 = 0x9E3779B1
+	xxhPrime32_2 uint64 = 0x85EBCA77
+	xxhPrime32_3 uint64 = 0xC2B2AE3D
+	xxhPrime64_1 uint64 = 0x9E3779B185EBCA87
+	xxhPrime64_2 uint64 = 0xC2B2AE3D27D4EB4F
+	xxhPrime64_3 uint64 = 0x165667B19E3779F9
+	xxhPrime64_4 uint64 = 0x85EBCA77C2B2AE63
+	xxhPrime64_5 uint64 = 0x27D4EB2F165667C5
+
+	xxh3StripeLength = 64
+	// offsets into the secret of the rounds of 129 to 240 byte
+	// inputs past the 128th byte, and of their last 16 bytes
+	xxh3MidSizeStartOffset = 3
+	xxh3MidSizeLastOffset  = 136 - 17
+	// number of stripes consumed between two scrambles
+	xxh3StripesPerBlock = (len(xxh3Secret) - xxh3StripeLength) / 8
+	xxh3BlockLength     = xxh3StripeLength * xxh3StripesPerBlock
+)
+
+// the default secret of XXH3
+var xxh3Secret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+// `read32` and `read64` read little endian integers at offset
+func read32(data []byte, offset int) uint64 {
+	return uint64(binary.LittleEndian.Uint32(data[offset:]))
+}
+
+func read64(data []byte, offset int) uint64 {
+	return binary.LittleEndian.Uint64(data[offset:])
+}
+
+// `xxh3Secret64` reads the secret at offset
+func xxh3Secret64(offset int) uint64 {
+	return read64(xxh3Secret[:], offset)
+}
+
+// `mulFold64` multiplies two 64 bit integers and folds the 128 bit
+// product by xoring its halves
+func mulFold64(a, b uint64) uint64 {
+	high, low := bits.Mul64(a, b)
+	return high ^ low
+}
+
+func xxh64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= xxhPrime64_2
+	h ^= h >> 29
+	h *= xxhPrime64_3
+	h ^= h >> 32
+	return h
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= 0x165667919E3779F9
+	h ^= h >> 32
+	return h
+}
+
+func xxh3rrmxmx(h uint64, length uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= 0x9FB21C651E98DF25
+	h ^= (h >> 35) + length
+	h *= 0x9FB21C651E98DF25
+	h ^= h >> 28
+	return h
+}
+
+// `mix16` mixes 16 bytes of data at offset with the secret at
+// secretOffset
+func mix16(data []byte, offset int, secretOffset int) uint64 {
+	return mulFold64(
+		read64(data, offset)^xxh3Secret64(secretOffset),
+		read64(data, offset+8)^xxh3Secret64(secretOffset+8),
+	)
+}
+
+// `xxh3Hash64` returns the XXH3 64 bit hash of data
+func xxh3Hash64(data []byte) uint64 {
+	length := len(data)
+	switch {
+	case length == 0:
+		return xxh64Avalanche(xxh3Secret64(56) ^ xxh3Secret64(64))
+	case length <= 3:
+		combined := uint64(data[length-1]) | uint64(length)<<8 |
+			uint64(data[0])<<16 | uint64(data[length>>1])<<24
+		return xxh64Avalanche(combined ^ (read32(xxh3Secret[:], 0) ^ read32(xxh3Secret[:], 4)))
+	case length <= 8:
+		combined := read32(data, length-4) | read32(data, 0)<<32
+		return xxh3rrmxmx(combined^(xxh3Secret64(8)^xxh3Secret64(16)), uint64(length))
+	case length <= 16:
+		low := read64(data, 0) ^ (xxh3Secret64(24) ^ xxh3Secret64(32))
+		high := read64(data, length-8) ^ (xxh3Secret64(40) ^ xxh3Secret64(48))
+		accumulator := uint64(length) + bits.ReverseBytes64(low) + high + mulFold64(low, high)
+		return xxh3Avalanche(accumulator)
+	case length <= 128:
+		accumulator := uint64(length) * xxhPrime64_1
+		// pairs of 16 bytes from both ends, working inwards
+		for i := (length - 1) / 32; i >= 0; i-- {
+			accumulator += mix16(data, 16*i, 32*i)
+			accumulator += mix16(data, length-16*(i+1), 32*i+16)
+		}
+		return xxh3Avalanche(accumulator)
+	case length <= 240:
+		accumulator := uint64(length) * xxhPrime64_1
+		for i := 0; i < 8; i++ {
+			accumulator += mix16(data, 16*i, 16*i)
+		}
+		accumulator = xxh3Avalanche(accumulator)
+		for i := 8; i < length/16; i++ {
+			accumulator += mix16(data, 16*i, 16*(i-8)+xxh3MidSizeStartOffset)
+		}
+		accumulator += mix16(data, length-16, xxh3MidSizeLastOffset)
+		return xxh3Avalanche(accumulator)
+	}
+	return xxh3HashLong(data)
+}
+
+// `xxh3Accumulate` mixes a 64 byte stripe of data at offset into
+// the accumulators, with the secret at secretOffset
+func xxh3Accumulate(accumulators *[8]uint64, data []byte, offset int, secretOffset int) {
+	for i := 0; i < 8; i++ {
+		value := read64(data, offset+8*i)
+		key := value ^ xxh3Secret64(secretOffset+8*i)
+		accumulators[i^1] += value
+		accumulators[i] += (key & 0xFFFFFFFF) * (key >> 32)
+	}
+}
+
+// `xxh3Scramble` scrambles the accumulators at the end of a block
+func xxh3Scramble(accumulators *[8]uint64) {
+	secretOffset := len(xxh3Secret) - xxh3StripeLength
+	for i := range accumulators {
+		accumulator := accumulators[i]
+		accumulator ^= accumulator >> 47
+		accumulator ^= xxh3Secret64(secretOffset + 8*i)
+		accumulators[i] = accumulator * xxhPrime32_1
+	}
+}
+
+// `xxh3HashLong` hashes data longer than 240 bytes
+func xxh3HashLong(data []byte) uint64 {
+	length := len(data)
+	accumulators := [8]uint64{
+		xxhPrime32_3, xxhPrime64_1, xxhPrime64_2, xxhPrime64_3,
+		xxhPrime64_4, xxhPrime32_2, xxhPrime64_5, xxhPrime32_1,
+	}
+	blocks := (length - 1) / xxh3BlockLength
+	for block := 0; block < blocks; block++ {
+		for stripe := 0; stripe < xxh3StripesPerBlock; stripe++ {
+			xxh3Accumulate(&accumulators, data, block*xxh3BlockLength+stripe*xxh3StripeLength, 8*stripe)
+		}
+		xxh3Scramble(&accumulators)
+	}
+	// the last, partial, block and the last stripe, which may
+	// overlap the stripes before it
+	stripes := ((length - 1) - blocks*xxh3BlockLength) / xxh3StripeLength
+	for stripe := 0; stripe < stripes; stripe++ {
+		xxh3Accumulate(&accumulators, data, blocks*xxh3BlockLength+stripe*xxh3StripeLength, 8*stripe)
+	}
+	xxh3Accumulate(&accumulators, data, length-xxh3StripeLength, len(xxh3Secret)-xxh3StripeLength-7)
+
+	result := uint64(length) * xxhPrime64_1
+	for i := 0; i < 4; i++ {
+		result += mulFold64(
+			accumulators[2*i]^xxh3Secret64(11+16*i),
+			accumulators[2*i+1]^xxh3Secret64(11+16*i+8),
+		)
+	}
+	return xxh3Avalanche(result)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestXXH3Hash64(t *testing.T) {
+	// inputs of each length class, filled with byte(i*31+7)
+	tests := []struct {
+		length int
+		want   uint64
+	}{
+		{0, 0x2d06800538d394c2},
+		{1, 0x4c5cca45d0f4811f},
+		{3, 0x15f7093b173d005c},
+		{4, 0xdca012f95811b6b9},
+		{8, 0xdec6a9a43575982e},
+		{9, 0xcbe393399f17ffbd},
+		{16, 0x7e484c18d74895d0},
+		{17, 0x208bde5ee2bed407},
+		{128, 0xf92b70eaa21a6288},
+		{129, 0xf8f76713f2bb60fa},
+		{240, 0xccc7375172c41f03},
+		{241, 0x0b3b630948ce4a00},
+		{1024, 0x23bc880ebf0d29c6},
+		{1025, 0xc09fdfbc398c7d82},
+		{5000, 0x559fff92c2b7f8ee},
+	}
+	for _, test := range tests {
+		data := make([]byte, test.length)
+		for i := range data {
+			data[i] = byte(i*31 + 7)
+		}
+		if got := xxh3Hash64(data); got != test.want {
+			t.Errorf("xxh3Hash64 of %d bytes = %#016x, want %#016x", test.length, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,1284 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/MohitPanchariya/goRed/resp"
+)
+
+// zset is a sorted set, a collection of unique members ordered by
+// score. The scores map looks up the score of a member in O(1) and
+// the skiplist keeps the members ordered for range queries
+type zset struct {
+	scores map[string]float64
+	zsl    *skiplist
+}
+
+func newZset() *zset {
+	return &zset{
+		scores: make(map[string]float64),
+		zsl:    newSkiplist(),
+	}
+}
+
+// `zsetEntry` is a member of a sorted set along with its score
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+func (z *zset) length() int {
+	return z.zsl.length
+}
+
+// `add` sets the score of member, it reports whether
+// member was added rather than updated
+func (z *zset) add(member string, score float64) bool {
+	current, ok := z.scores[member]
+	if ok {
+		if current != score {
+			z.zsl.delete(current, member)
+			z.zsl.insert(score, member)
+			z.scores[member] = score
+		}
+		return false
+	}
+	z.zsl.insert(score, member)
+	z.scores[member] = score
+	return true
+}
+
+// `remove` removes member, it reports false if it wasn't a member
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.scores, member)
+	return true
+}
+
+// `zsetRange` is a range of a sorted set, either by score or,
+// for members sharing a score, lexicographical
+type zsetRange interface {
+	// aboveMin reports whether node is at or above the range's minimum
+	aboveMin(node *skiplistNode) bool
+	// belowMax reports whether node is at or below the range's maximum
+	belowMax(node *skiplistNode) bool
+	// empty reports whether no node can be in the range
+	empty() bool
+}
+
+// `scoreRange` is a range of scores, with either bound
+// optionally exclusive
+type scoreRange struct {
+	min, max                   float64
+	minExclusive, maxExclusive bool
+}
+
+func (r scoreRange) aboveMin(node *skiplistNode) bool {
+	if r.minExclusive {
+		return node.score > r.min
+	}
+	return node.score >= r.min
+}
+
+func (r scoreRange) belowMax(node *skiplistNode) bool {
+	if r.maxExclusive {
+		return node.score < r.max
+	}
+	return node.score <= r.max
+}
+
+func (r scoreRange) empty() bool {
+	return r.min > r.max || (r.min == r.max && (r.minExclusive || r.maxExclusive))
+}
+
+// `parseScoreBound` parses a bound of a score range, a leading '('
+// makes it exclusive. It returns the score and whether it's exclusive
+func parseScoreBound(arg []byte) (float64, bool, bool) {
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, ok := parseFloat(arg)
+	return score, exclusive, ok
+}
+
+// `parseScoreRange` parses the min and max of a score range
+func parseScoreRange(min, max []byte) (scoreRange, bool) {
+	var r scoreRange
+	var minOk, maxOk bool
+	r.min, r.minExclusive, minOk = parseScoreBound(min)
+	r.max, r.maxExclusive, maxOk = parseScoreBound(max)
+	return r, minOk && maxOk
+}
+
+// `lexBound` is a bound of a lexicographical range. infinity is
+// -1 for "-", the smallest string, and 1 for "+", the largest one
+type lexBound struct {
+	value     string
+	exclusive bool
+	infinity  int
+}
+
+// `lexRange` is a lexicographical range of members
+type lexRange struct {
+	min, max lexBound
+}
+
+func (r lexRange) aboveMin(node *skiplistNode) bool {
+	switch r.min.infinity {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if r.min.exclusive {
+		return node.member > r.min.value
+	}
+	return node.member >= r.min.value
+}
+
+func (r lexRange) belowMax(node *skiplistNode) bool {
+	switch r.max.infinity {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	if r.max.exclusive {
+		return node.member < r.max.value
+	}
+	return node.member <= r.max.value
+}
+
+func (r lexRange) empty() bool {
+	if r.min.infinity == 1 || r.max.infinity == -1 {
+		return true
+	}
+	if r.min.infinity == -1 || r.max.infinity == 1 {
+		return false
+	}
+	return r.min.value > r.max.value ||
+		(r.min.value == r.max.value && (r.min.exclusive || r.max.exclusive))
+}
+
+// `parseLexBound` parses a bound of a lexicographical range,
+// either "-", "+", or a member prefixed with '[' or '('
+func parseLexBound(arg []byte) (lexBound, bool) {
+	if len(arg) == 0 {
+		return lexBound{}, false
+	}
+	switch arg[0] {
+	case '-':
+		return lexBound{infinity: -1}, len(arg) == 1
+	case '+':
+		return lexBound{infinity: 1}, len(arg) == 1
+	case '[':
+		return lexBound{value: string(arg[1:])}, true
+	case '(':
+		return lexBound{value: string(arg[1:]), exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+// `parseLexRange` parses the min and max of a lexicographical range
+func parseLexRange(min, max []byte) (lexRange, bool) {
+	var r lexRange
+	var minOk, maxOk bool
+	r.min, minOk = parseLexBound(min)
+	r.max, maxOk = parseLexBound(max)
+	return r, minOk && maxOk
+}
+
+// `rangeByRank` returns the entries between the 0 based ranks start
+// and stop, negative ranks count from the end. With reverse, ranks
+// are counted from the highest score
+func (z *zset) rangeByRank(start, stop int64, reverse bool) []zsetEntry {
+	length := int64(z.length())
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= length {
+		return nil
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	entries := make([]zsetEntry, 0, stop-start+1)
+	var x *skiplistNode
+	if reverse {
+		x = z.zsl.byRank(int(length - start))
+	} else {
+		x = z.zsl.byRank(int(start + 1))
+	}
+	for i := start; i <= stop; i++ {
+		entries = append(entries, zsetEntry{x.member, x.score})
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return entries
+}
+
+// `rangeIn` returns the entries in r, skipping the first offset of
+// them and returning up to count, a negative count returns them all
+func (z *zset) rangeIn(r zsetRange, reverse bool, offset, count int64) []zsetEntry {
+	var entries []zsetEntry
+	if offset < 0 {
+		return entries
+	}
+	var x *skiplistNode
+	if reverse {
+		x = z.zsl.lastInRange(r)
+	} else {
+		x = z.zsl.firstInRange(r)
+	}
+	for x != nil && count != 0 {
+		if reverse {
+			if !r.aboveMin(x) {
+				break
+			}
+		} else if !r.belowMax(x) {
+			break
+		}
+		if offset > 0 {
+			offset--
+		} else {
+			entries = append(entries, zsetEntry{x.member, x.score})
+			count--
+		}
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return entries
+}
+
+// `countIn` returns the number of members in r
+func (z *zset) countIn(r zsetRange) int {
+	first := z.zsl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.lastInRange(r)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// `toRESPArray` serialises the sorted set as members each followed
+// by their score, in a form that `zsetFromElements` reads back
+func (z *zset) toRESPArray() ([]byte, error) {
+	return zsetEntriesReply(z.rangeByRank(0, -1, false), true)
+}
+
+// `zsetFromElements` builds a sorted set out of the array
+// produced by `toRESPArray`
+func zsetFromElements(elements [][]byte) (*zset, error) {
+	if len(elements)%2 != 0 {
+		return nil, resp.ErrInvalidClientData
+	}
+	z := newZset()
+	for i := 0; i < len(elements); i += 2 {
+		score, ok := parseFloat(elements[i+1])
+		if !ok {
+			return nil, resp.ErrInvalidClientData
+		}
+		z.add(string(elements[i]), score)
+	}
+	return z, nil
+}
+
+// `formatScore` formats a score the shortest way that parses back
+// to the same float, "inf" and "-inf" for the infinities
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	if abs := math.Abs(score); abs == 0 || (abs >= 1e-4 && abs < 1e17) {
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// `scoreBulkString` returns a score as a bulk string
+func scoreBulkString(score float64) *resp.BulkString {
+	formatted := formatScore(score)
+	return &resp.BulkString{Data: []byte(formatted), Size: len(formatted)}
+}
+
+// `zsetEntriesReply` serialises entries as an array of members,
+// each followed by its score when withScores is set
+func zsetEntriesReply(entries []zsetEntry, withScores bool) ([]byte, error) {
+	var response resp.Array
+	for _, entry := range entries {
+		response.Elements = append(response.Elements,
+			&resp.BulkString{Data: []byte(entry.member), Size: len(entry.member)})
+		if withScores {
+			response.Elements = append(response.Elements, scoreBulkString(entry.score))
+		}
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// `getZset` retrieves the sorted set stored at key. It also
+// reports whether the key exists and whether it holds a sorted set
+func (s *store) getZset(key string) (*zset, bool, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, false, true
+	}
+	if value.valueType != "zset" {
+		return nil, true, false
+	}
+	return value.value.(*zset), true, true
+}
+
+// `storeZset` stores z at destination, replacing its value. When z
+// is empty destination is deleted instead
+func (s *store) storeZset(destination string, z *zset, event string) {
+	if z.length() == 0 {
+		if _, exists := s.get(destination); exists {
+			delete(s.db, destination)
+			s.notifyKeyspaceEvent(notifyGeneric, "del", destination)
+		}
+		return
+	}
+	s.set(destination, &redisValue{
+		value:     z,
+		valueType: "zset",
+	})
+	s.notifyKeyspaceEvent(notifyZset, event, destination)
+}
+
+// `zaddFlags` holds the options of ZADD
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// ZADD command adds members to the sorted set stored at key, or
+// updates their scores
+func zadd(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("zadd")
+	}
+	var flags zaddFlags
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			flags.ch = true
+		case "INCR":
+			flags.incr = true
+		default:
+			break options
+		}
+	}
+	elements := args[i:]
+	if len(elements) == 0 || len(elements)%2 != 0 {
+		return errorReply("invalid syntax")
+	}
+	if flags.nx && flags.xx {
+		return errorReply("XX and NX options at the same time are not compatible")
+	}
+	if (flags.gt && flags.nx) || (flags.lt && flags.nx) || (flags.gt && flags.lt) {
+		return errorReply("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags.incr && len(elements) > 2 {
+		return errorReply("INCR option supports a single increment-element pair")
+	}
+	return zaddGeneric(string(args[0]), elements, flags, s)
+}
+
+// ZINCRBY command increments the score of a member of a sorted set
+func zincrby(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("zincrby")
+	}
+	return zaddGeneric(string(args[0]), args[1:], zaddFlags{incr: true}, s)
+}
+
+// `zaddGeneric` implements ZADD and ZINCRBY, elements holds
+// score member pairs
+func zaddGeneric(key string, elements [][]byte, flags zaddFlags, s *store) ([]byte, error) {
+	scores := make([]float64, len(elements)/2)
+	for i := range scores {
+		score, ok := parseFloat(elements[2*i])
+		if !ok {
+			return errorReply("value is not a valid float")
+		}
+		scores[i] = score
+	}
+	z, exists, isZset := s.getZset(key)
+	if !isZset {
+		return wrongType()
+	}
+	var added, updated int64
+	// with INCR, the reply is the new score or nil when
+	// the update was prevented by one of the flags
+	var incrScore *float64
+	if exists || !flags.xx {
+		if !exists {
+			z = newZset()
+			s.set(key, &redisValue{
+				value:     z,
+				valueType: "zset",
+			})
+		}
+		for i, score := range scores {
+			member := string(elements[2*i+1])
+			current, ok := z.scores[member]
+			if ok {
+				if flags.nx {
+					continue
+				}
+				if flags.incr {
+					score += current
+					if math.IsNaN(score) {
+						return errorReply("resulting score is not a number (NaN)")
+					}
+				}
+				if (flags.lt && score >= current) || (flags.gt && score <= current) {
+					continue
+				}
+				if score != current {
+					z.add(member, score)
+					updated++
+				}
+			} else {
+				if flags.xx {
+					continue
+				}
+				z.add(member, score)
+				added++
+			}
+			incrScore = &score
+		}
+	}
+	if added+updated > 0 {
+		if flags.incr {
+			s.notifyKeyspaceEvent(notifyZset, "zincr", key)
+		} else {
+			s.notifyKeyspaceEvent(notifyZset, "zadd", key)
+		}
+	}
+	if flags.incr {
+		if incrScore == nil {
+			return nilBulkString()
+		}
+		return scoreBulkString(*incrScore).Serialise()
+	}
+	response := resp.Integer{
+		Data: added,
+	}
+	if flags.ch {
+		response.Data += updated
+	}
+	return response.Serialise()
+}
+
+// ZREM command removes members from a sorted set
+func zrem(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("zrem")
+	}
+	key := string(args[0])
+	z, exists, isZset := s.getZset(key)
+	if !isZset {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	for _, member := range args[1:] {
+		if z.remove(string(member)) {
+			response.Data++
+		}
+	}
+	if response.Data > 0 {
+		s.notifyKeyspaceEvent(notifyZset, "zrem", key)
+		s.deleteIfEmpty(key, z.length())
+	}
+	return response.Serialise()
+}
+
+// ZSCORE command returns the score of a member of a sorted set
+func zscore(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 2 {
+		return wrongNumberOfArgs("zscore")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	if !exists {
+		return nilBulkString()
+	}
+	score, ok := z.scores[string(args[1])]
+	if !ok {
+		return nilBulkString()
+	}
+	return scoreBulkString(score).Serialise()
+}
+
+// ZMSCORE command returns the scores of members of a sorted set
+func zmscore(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 2 {
+		return wrongNumberOfArgs("zmscore")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	var response resp.Array
+	for _, member := range args[1:] {
+		score, ok := 0.0, false
+		if exists {
+			score, ok = z.scores[string(member)]
+		}
+		if ok {
+			response.Elements = append(response.Elements, scoreBulkString(score))
+		} else {
+			response.Elements = append(response.Elements, &resp.BulkString{Size: -1})
+		}
+	}
+	response.Size = len(response.Elements)
+	return response.Serialise()
+}
+
+// ZCARD command returns the number of members of a sorted set
+func zcard(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 1 {
+		return wrongNumberOfArgs("zcard")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists {
+		response.Data = int64(z.length())
+	}
+	return response.Serialise()
+}
+
+// ZCOUNT command returns the number of members of a sorted set
+// with a score between min and max
+func zcount(args [][]byte, s *store) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs("zcount")
+	}
+	r, ok := parseScoreRange(args[1], args[2])
+	if !ok {
+		return errorReply("min or max is not a float")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if exists {
+		response.Data = int64(z.countIn(r))
+	}
+	return response.Serialise()
+}
+
+// `zrankGeneric` implements ZRANK and ZREVRANK
+func zrankGeneric(args [][]byte, s *store, command string, reverse bool) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return wrongNumberOfArgs(command)
+	}
+	withScore := len(args) == 3
+	if withScore && strings.ToUpper(string(args[2])) != "WITHSCORE" {
+		return errorReply("invalid syntax")
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	var score float64
+	ok := false
+	if exists {
+		score, ok = z.scores[string(args[1])]
+	}
+	if !ok {
+		if withScore {
+			return nilArray()
+		}
+		return nilBulkString()
+	}
+	rank := int64(z.zsl.rank(score, string(args[1]))) - 1
+	if reverse {
+		rank = int64(z.length()) - 1 - rank
+	}
+	if withScore {
+		response := resp.Array{
+			Size: 2,
+			Elements: []resp.RESPDatatype{
+				&resp.Integer{Data: rank},
+				scoreBulkString(score),
+			},
+		}
+		return response.Serialise()
+	}
+	response := resp.Integer{
+		Data: rank,
+	}
+	return response.Serialise()
+}
+
+// ZRANK command returns the rank of a member of a sorted set,
+// ordered from the lowest score
+func zrank(args [][]byte, s *store) ([]byte, error) {
+	return zrankGeneric(args, s, "zrank", false)
+}
+
+// ZREVRANK command returns the rank of a member of a sorted set,
+// ordered from the highest score
+func zrevrank(args [][]byte, s *store) ([]byte, error) {
+	return zrankGeneric(args, s, "zrevrank", true)
+}
+
+// the kinds of range of ZRANGE
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// `zrangeSpec` holds the parsed arguments of ZRANGE and ZRANGESTORE
+type zrangeSpec struct {
+	by      int
+	reverse bool
+	// start and stop are set for ranges by rank
+	start, stop int64
+	// r is set for ranges by score and by lex
+	r          zsetRange
+	offset     int64
+	count      int64
+	withScores bool
+}
+
+// `parseZrange` parses the arguments of ZRANGE following the key,
+// start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES].
+// WITHSCORES is only accepted when allowWithScores is set. A non
+// empty string is the error to reply with
+func parseZrange(args [][]byte, allowWithScores bool) (zrangeSpec, string) {
+	spec := zrangeSpec{
+		count: -1,
+	}
+	limit := false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); {
+		case option == "WITHSCORES" && allowWithScores:
+			spec.withScores = true
+		case option == "BYSCORE":
+			spec.by = zrangeByScore
+		case option == "BYLEX":
+			spec.by = zrangeByLex
+		case option == "REV":
+			spec.reverse = true
+		case option == "LIMIT" && i+2 < len(args):
+			offset, ok := parseInteger(args[i+1])
+			count, countOk := parseInteger(args[i+2])
+			if !ok || !countOk {
+				return spec, "value is not an integer or out of range"
+			}
+			spec.offset, spec.count = offset, count
+			limit = true
+			i += 2
+		default:
+			return spec, "invalid syntax"
+		}
+	}
+	if limit && spec.by == zrangeByRank {
+		return spec, "syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"
+	}
+	if spec.withScores && spec.by == zrangeByLex {
+		return spec, "syntax error, WITHSCORES not supported in combination with BYLEX"
+	}
+	min, max := args[0], args[1]
+	// reversed ranges by score or lex are given from max to min
+	if spec.reverse && spec.by != zrangeByRank {
+		min, max = max, min
+	}
+	switch spec.by {
+	case zrangeByRank:
+		start, startOk := parseInteger(min)
+		stop, stopOk := parseInteger(max)
+		if !startOk || !stopOk {
+			return spec, "value is not an integer or out of range"
+		}
+		spec.start, spec.stop = start, stop
+	case zrangeByScore:
+		r, ok := parseScoreRange(min, max)
+		if !ok {
+			return spec, "min or max is not a float"
+		}
+		spec.r = r
+	case zrangeByLex:
+		r, ok := parseLexRange(min, max)
+		if !ok {
+			return spec, "min or max not valid string range item"
+		}
+		spec.r = r
+	}
+	return spec, ""
+}
+
+// `rangeBySpec` returns the entries of the range described by spec
+func (z *zset) rangeBySpec(spec zrangeSpec) []zsetEntry {
+	if spec.by == zrangeByRank {
+		return z.rangeByRank(spec.start, spec.stop, spec.reverse)
+	}
+	return z.rangeIn(spec.r, spec.reverse, spec.offset, spec.count)
+}
+
+// ZRANGE command returns a range of members of a sorted set,
+// by rank, score or lexicographically
+func zrange(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("zrange")
+	}
+	spec, message := parseZrange(args[1:], true)
+	if message != "" {
+		return errorReply(message)
+	}
+	z, exists, isZset := s.getZset(string(args[0]))
+	if !isZset {
+		return wrongType()
+	}
+	if !exists {
+		return zsetEntriesReply(nil, false)
+	}
+	return zsetEntriesReply(z.rangeBySpec(spec), spec.withScores)
+}
+
+// ZRANGESTORE command stores a range of members of a sorted
+// set at destination
+func zrangestore(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 4 {
+		return wrongNumberOfArgs("zrangestore")
+	}
+	spec, message := parseZrange(args[2:], false)
+	if message != "" {
+		return errorReply(message)
+	}
+	z, exists, isZset := s.getZset(string(args[1]))
+	if !isZset {
+		return wrongType()
+	}
+	result := newZset()
+	if exists {
+		for _, entry := range z.rangeBySpec(spec) {
+			result.add(entry.member, entry.score)
+		}
+	}
+	s.storeZset(string(args[0]), result, "zrangestore")
+	response := resp.Integer{
+		Data: int64(result.length()),
+	}
+	return response.Serialise()
+}
+
+// `parseMinMax` parses the MIN|MAX argument of the sorted set
+// commands, it returns true for MAX
+func parseMinMax(arg []byte) (bool, bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "MIN":
+		return false, true
+	case "MAX":
+		return true, true
+	}
+	return false, false
+}
+
+// `zsetPop` pops up to count members of the sorted set at key, the
+// ones with the lowest scores or the highest ones when max is set
+func (s *store) zsetPop(key string, z *zset, max bool, count int64) []zsetEntry {
+	entries := z.rangeByRank(0, count-1, max)
+	for _, entry := range entries {
+		z.remove(entry.member)
+	}
+	if len(entries) > 0 {
+		if max {
+			s.notifyKeyspaceEvent(notifyZset, "zpopmax", key)
+		} else {
+			s.notifyKeyspaceEvent(notifyZset, "zpopmin", key)
+		}
+		s.deleteIfEmpty(key, z.length())
+	}
+	return entries
+}
+
+// `zpopGeneric` implements ZPOPMIN and ZPOPMAX
+func zpopGeneric(args [][]byte, s *store, command string, max bool) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumberOfArgs(command)
+	}
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		count, ok = parseInteger(args[1])
+		if !ok {
+			return errorReply("value is not an integer or out of range")
+		}
+		if count < 0 {
+			return errorReply("value is out of range, must be positive")
+		}
+	}
+	key := string(args[0])
+	z, exists, isZset := s.getZset(key)
+	if !isZset {
+		return wrongType()
+	}
+	if !exists || count == 0 {
+		return zsetEntriesReply(nil, true)
+	}
+	return zsetEntriesReply(s.zsetPop(key, z, max, count), true)
+}
+
+// ZPOPMIN command removes and returns the members of a sorted
+// set with the lowest scores
+func zpopmin(args [][]byte, s *store) ([]byte, error) {
+	return zpopGeneric(args, s, "zpopmin", false)
+}
+
+// ZPOPMAX command removes and returns the members of a sorted
+// set with the highest scores
+func zpopmax(args [][]byte, s *store) ([]byte, error) {
+	return zpopGeneric(args, s, "zpopmax", true)
+}
+
+// `serveZpop` pops the member with the lowest or highest score of
+// the sorted set at key, replying with the key, member and score
+func serveZpop(key string, max bool, s *store) ([]byte, bool, error) {
+	z, exists, isZset := s.getZset(key)
+	if !isZset {
+		response, err := wrongType()
+		return response, true, err
+	}
+	// an empty sorted set, which shouldn't be stored, has nothing to pop
+	if !exists || z.length() == 0 {
+		return nil, false, nil
+	}
+	entry := s.zsetPop(key, z, max, 1)[0]
+	response := resp.Array{
+		Size: 3,
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte(key), Size: len(key)},
+			&resp.BulkString{Data: []byte(entry.member), Size: len(entry.member)},
+			scoreBulkString(entry.score),
+		},
+	}
+	data, err := response.Serialise()
+	return data, true, err
+}
+
+// `serveZmpop` pops up to count members of the sorted set at key,
+// replying with the key and the popped members and scores
+func serveZmpop(key string, max bool, count int64, s *store) ([]byte, bool, error) {
+	z, exists, isZset := s.getZset(key)
+	if !isZset {
+		response, err := wrongType()
+		return response, true, err
+	}
+	// an empty sorted set, which shouldn't be stored, has nothing to pop
+	if !exists || z.length() == 0 {
+		return nil, false, nil
+	}
+	var popped []resp.RESPDatatype
+	for _, entry := range s.zsetPop(key, z, max, count) {
+		popped = append(popped, &resp.Array{
+			Size: 2,
+			Elements: []resp.RESPDatatype{
+				&resp.BulkString{Data: []byte(entry.member), Size: len(entry.member)},
+				scoreBulkString(entry.score),
+			},
+		})
+	}
+	response := resp.Array{
+		Size: 2,
+		Elements: []resp.RESPDatatype{
+			&resp.BulkString{Data: []byte(key), Size: len(key)},
+			&resp.Array{Size: len(popped), Elements: popped},
+		},
+	}
+	data, err := response.Serialise()
+	return data, true, err
+}
+
+// ZMPOP command pops members from the first non empty sorted
+// set among the given keys
+func zmpop(args [][]byte, s *store) ([]byte, error) {
+	if len(args) < 3 {
+		return wrongNumberOfArgs("zmpop")
+	}
+	keys, max, count, message := parseMpop(args, parseMinMax)
+	if message != "" {
+		return errorReply(message)
+	}
+	for _, key := range keys {
+		data, ok, err := serveZmpop(key, max, count, s)
+		if ok {
+			return data, err
+		}
+	}
+	return nilArray()
+}
+
+// the ways ZUNION and ZINTER combine the scores of a member
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// `aggregateScores` combines two scores of a member. NaN, the sum
+// of opposite infinities, is turned into 0
+func aggregateScores(aggregate int, a, b float64) float64 {
+	var score float64
+	switch aggregate {
+	case aggregateMin:
+		score = math.Min(a, b)
+	case aggregateMax:
+		score = math.Max(a, b)
+	default:
+		score = a + b
+	}
+	if math.IsNaN(score) {
+		return 0
+	}
+	return score
+}
+
+// `zsetOperation` holds the parsed arguments of ZUNION, ZINTER,
+// ZDIFF and their STORE variants
+type zsetOperation struct {
+	keys       [][]byte
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// `parseZsetOperation` parses numkeys key [key ...] [WEIGHTS weight
+// [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]. WEIGHTS and
+// AGGREGATE are only accepted with allowWeights, WITHSCORES with
+// allowWithScores. A non empty string is the error to reply with
+func parseZsetOperation(args [][]byte, command string, allowWeights, allowWithScores bool) (zsetOperation, string) {
+	var operation zsetOperation
+	numkeys, ok := parseInteger(args[0])
+	if !ok {
+		return operation, "value is not an integer or out of range"
+	}
+	if numkeys <= 0 {
+		return operation, "at least 1 input key is needed for '" + command + "' command"
+	}
+	if numkeys > int64(len(args)-1) {
+		return operation, "invalid syntax"
+	}
+	operation.keys = args[1 : 1+numkeys]
+	operation.weights = make([]float64, numkeys)
+	for i := range operation.weights {
+		operation.weights[i] = 1
+	}
+	args = args[1+numkeys:]
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); {
+		case option == "WEIGHTS" && allowWeights && i+int(numkeys) < len(args):
+			for j := range operation.weights {
+				weight, ok := parseFloat(args[i+1+j])
+				if !ok {
+					return operation, "weight value is not a float"
+				}
+				operation.weights[j] = weight
+			}
+			i += int(numkeys)
+		case option == "AGGREGATE" && allowWeights && i+1 < len(args):
+			i++
+			switch strings.ToUpper(string(args[i])) {
+			case "SUM":
+				operation.aggregate = aggregateSum
+			case "MIN":
+				operation.aggregate = aggregateMin
+			case "MAX":
+				operation.aggregate = aggregateMax
+			default:
+				return operation, "invalid syntax"
+			}
+		case option == "WITHSCORES" && allowWithScores:
+			operation.withScores = true
+		default:
+			return operation, "invalid syntax"
+		}
+	}
+	return operation, ""
+}
+
+// `getZsetScores` retrieves the members and scores of the sorted set
+// stored at key. Sets are accepted as well, with every member scoring
+// 1, and a missing key is empty. It reports false for any other type
+func (s *store) getZsetScores(key string) (map[string]float64, bool) {
+	value, ok := s.get(key)
+	if !ok {
+		return nil, true
+	}
+	switch value.valueType {
+	case "zset":
+		return value.value.(*zset).scores, true
+	case "set":
+		members := value.value.(*redisSet).list()
+		scores := make(map[string]float64, len(members))
+		for _, member := range members {
+			scores[member] = 1
+		}
+		return scores, true
+	}
+	return nil, false
+}
+
+// `zsetAlgebra` computes the union, intersection or difference
+// of the sorted sets in operation
+func (s *store) zsetAlgebra(kind string, operation zsetOperation) (*zset, bool) {
+	inputs := make([]map[string]float64, len(operation.keys))
+	for i, key := range operation.keys {
+		scores, ok := s.getZsetScores(string(key))
+		if !ok {
+			return nil, false
+		}
+		inputs[i] = scores
+	}
+	// a weight of 0 turns an infinite score into NaN, scored 0
+	weighted := func(score float64, i int) float64 {
+		score *= operation.weights[i]
+		if math.IsNaN(score) {
+			return 0
+		}
+		return score
+	}
+	result := newZset()
+	switch kind {
+	case "union":
+		scores := make(map[string]float64)
+		for i, input := range inputs {
+			for member, score := range input {
+				score = weighted(score, i)
+				if current, ok := scores[member]; ok {
+					score = aggregateScores(operation.aggregate, current, score)
+				}
+				scores[member] = score
+			}
+		}
+		for member, score := range scores {
+			result.add(member, score)
+		}
+	case "inter":
+		// walk the smallest input, checking the others
+		smallest := 0
+		for i, input := range inputs {
+			if len(input) < len(inputs[smallest]) {
+				smallest = i
+			}
+		}
+		for member := range inputs[smallest] {
+			var score float64
+			inAll := true
+			for i, input := range inputs {
+				other, ok := input[member]
+				if !ok {
+					inAll = false
+					break
+				}
+				if i == 0 {
+					score = weighted(other, i)
+				} else {
+					score = aggregateScores(operation.aggregate, score, weighted(other, i))
+				}
+			}
+			if inAll {
+				result.add(member, score)
+			}
+		}
+	case "diff":
+		for member, score := range inputs[0] {
+			inOther := false
+			for _, input := range inputs[1:] {
+				if _, ok := input[member]; ok {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				result.add(member, score)
+			}
+		}
+	}
+	return result, true
+}
+
+// `zsetAlgebraGeneric` implements ZUNION, ZINTER and ZDIFF
+func zsetAlgebraGeneric(args [][]byte, s *store, kind string) ([]byte, error) {
+	command := "z" + kind
+	if len(args) < 2 {
+		return wrongNumberOfArgs(command)
+	}
+	operation, message := parseZsetOperation(args, command, kind != "diff", true)
+	if message != "" {
+		return errorReply(message)
+	}
+	result, ok := s.zsetAlgebra(kind, operation)
+	if !ok {
+		return wrongType()
+	}
+	return zsetEntriesReply(result.rangeByRank(0, -1, false), operation.withScores)
+}
+
+// `zsetAlgebraStoreGeneric` implements ZUNIONSTORE, ZINTERSTORE and
+// ZDIFFSTORE, which store the result at the destination key
+func zsetAlgebraStoreGeneric(args [][]byte, s *store, kind string) ([]byte, error) {
+	command := "z" + kind + "store"
+	if len(args) < 3 {
+		return wrongNumberOfArgs(command)
+	}
+	operation, message := parseZsetOperation(args[1:], command, kind != "diff", false)
+	if message != "" {
+		return errorReply(message)
+	}
+	result, ok := s.zsetAlgebra(kind, operation)
+	if !ok {
+		return wrongType()
+	}
+	s.storeZset(string(args[0]), result, command)
+	response := resp.Integer{
+		Data: int64(result.length()),
+	}
+	return response.Serialise()
+}
+
+// ZUNION command returns the union of sorted sets
+func zunion(args [][]byte, s *store) ([]byte, error) {
+	return zsetAlgebraGeneric(args, s, "union")
+}
+
+// ZINTER command returns the intersection of sorted sets
+func zinter(args [][]byte, s *store) ([]byte, error) {
+	return zsetAlgebraGeneric(args, s, "inter")
+}
+
+// ZDIFF command returns the members of the first sorted set
+// that aren't in any of the following ones
+func zdiff(args [][]byte, s *store) ([]byte, error) {
+	return zsetAlgebraGeneric(args, s, "diff")
+}
+
+// ZUNIONSTORE command stores the union of sorted sets at destination
+func zunionstore(args [][]byte, s *store) ([]byte, error) {
+	return zsetAlgebraStoreGeneric(args, s, "union")
+}
+
+// ZINTERSTORE command stores the intersection of sorted sets
+// at destination
+func zinterstore(args [][]byte, s *store) ([]byte, error) {
+	return zsetAlgebraStoreGeneric(args, s, "inter")
+}
+
+// ZDIFFSTORE command stores the difference of sorted sets
+// at destination
+func zdiffstore(args [][]byte, s *store) ([]byte, error) {
+	return zsetAlgebraStoreGeneric(args, s, "diff")
+}
+
+// `zremrangeGeneric` implements the ZREMRANGEBY commands, removing
+// the members in the range between args[1] and args[2]
+func zremrangeGeneric(args [][]byte, s *store, command string, by int) ([]byte, error) {
+	if len(args) != 3 {
+		return wrongNumberOfArgs(command)
+	}
+	spec := zrangeSpec{
+		by:    by,
+		count: -1,
+	}
+	var ok bool
+	switch by {
+	case zrangeByRank:
+		var stopOk bool
+		spec.start, ok = parseInteger(args[1])
+		spec.stop, stopOk = parseInteger(args[2])
+		if !ok || !stopOk {
+			return errorReply("value is not an integer or out of range")
+		}
+	case zrangeByScore:
+		spec.r, ok = parseScoreRange(args[1], args[2])
+		if !ok {
+			return errorReply("min or max is not a float")
+		}
+	case zrangeByLex:
+		spec.r, ok = parseLexRange(args[1], args[2])
+		if !ok {
+			return errorReply("min or max not valid string range item")
+		}
+	}
+	key := string(args[0])
+	z, exists, isZset := s.getZset(key)
+	if !isZset {
+		return wrongType()
+	}
+	response := resp.Integer{}
+	if !exists {
+		return response.Serialise()
+	}
+	for _, entry := range z.rangeBySpec(spec) {
+		z.remove(entry.member)
+		response.Data++
+	}
+	if response.Data > 0 {
+		s.notifyKeyspaceEvent(notifyZset, command, key)
+		s.deleteIfEmpty(key, z.length())
+	}
+	return response.Serialise()
+}
+
+// ZREMRANGEBYSCORE command removes the members of a sorted set
+// with a score between min and max
+func zremrangebyscore(args [][]byte, s *store) ([]byte, error) {
+	return zremrangeGeneric(args, s, "zremrangebyscore", zrangeByScore)
+}
+
+// ZREMRANGEBYRANK command removes the members of a sorted set
+// with a rank between start and stop
+func zremrangebyrank(args [][]byte, s *store) ([]byte, error) {
+	return zremrangeGeneric(args, s, "zremrangebyrank", zrangeByRank)
+}
+
+// ZREMRANGEBYLEX command removes the members of a sorted set
+// between min and max lexicographically
+func zremrangebylex(args [][]byte, s *store) ([]byte, error) {
+	return zremrangeGeneric(args, s, "zremrangebylex", zrangeByLex)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// `checkSkiplist` checks that zsl holds exactly the entries of want,
+// in order, with spans and backward links that agree with the order
+func checkSkiplist(t *testing.T, zsl *skiplist, want []zsetEntry) {
+	t.Helper()
+	if zsl.length != len(want) {
+		t.Fatalf("skiplist holds %d nodes, want %d", zsl.length, len(want))
+	}
+	// the rank of each node, read from the lowest level
+	ranks := make(map[*skiplistNode]int)
+	var previous *skiplistNode
+	rank := 0
+	for x := zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if rank >= len(want) || x.member != want[rank].member || x.score != want[rank].score {
+			t.Fatalf("node %d is %s with score %v, want %+v", rank, x.member, x.score, want[min(rank, len(want)-1)])
+		}
+		if x.backward != previous {
+			t.Fatalf("the backward link of %s doesn't lead to the previous node", x.member)
+		}
+		rank++
+		ranks[x] = rank
+		previous = x
+	}
+	if zsl.tail != previous {
+		t.Fatal("the tail isn't the last node")
+	}
+	for i := 0; i < skiplistMaxLevel; i++ {
+		if i >= zsl.level {
+			if zsl.header.level[i].forward != nil {
+				t.Fatalf("level %d is in use, above the skiplist's %d levels", i, zsl.level)
+			}
+			continue
+		}
+		if i > 0 && zsl.header.level[zsl.level-1].forward == nil {
+			t.Fatalf("the top level %d is empty", zsl.level-1)
+		}
+		traversed := 0
+		for x := zsl.header; x.level[i].forward != nil; x = x.level[i].forward {
+			traversed += x.level[i].span
+			if ranks[x.level[i].forward] != traversed {
+				t.Fatalf("spans on level %d add up to %d at the node of rank %d", i, traversed, ranks[x.level[i].forward])
+			}
+		}
+	}
+	for i, entry := range want {
+		if got := zsl.rank(entry.score, entry.member); got != i+1 {
+			t.Fatalf("rank of %s = %d, want %d", entry.member, got, i+1)
+		}
+		if x := zsl.byRank(i + 1); x == nil || x.member != entry.member {
+			t.Fatalf("byRank(%d) didn't return %s", i+1, entry.member)
+		}
+	}
+	if zsl.byRank(len(want)+1) != nil || zsl.rank(0, "missing") != 0 {
+		t.Fatal("found a node that isn't in the skiplist")
+	}
+}
+
+// `compareEntries` orders entries by score, then member
+func compareEntries(a, b zsetEntry) int {
+	return cmp.Or(cmp.Compare(a.score, b.score), cmp.Compare(a.member, b.member))
+}
+
+func TestSkiplist(t *testing.T) {
+	z := newZset()
+	scores := make(map[string]float64)
+	for i := 0; i < 3000; i++ {
+		// few distinct scores, so that members often share one
+		member := "m" + strconv.Itoa(rand.Intn(500))
+		score := float64(rand.Intn(20))
+		switch rand.Intn(10) {
+		case 0:
+			score = math.Inf(1 - 2*rand.Intn(2))
+		case 1:
+			z.remove(member)
+			delete(scores, member)
+			continue
+		}
+		z.add(member, score)
+		scores[member] = score
+		if i%250 == 0 {
+			var want []zsetEntry
+			for member, score := range scores {
+				want = append(want, zsetEntry{member, score})
+			}
+			slices.SortFunc(want, compareEntries)
+			checkSkiplist(t, z.zsl, want)
+		}
+	}
+	for member := range scores {
+		if !z.remove(member) {
+			t.Fatalf("%s wasn't removed", member)
+		}
+	}
+	if z.remove("m0") {
+		t.Fatal("removed a member of an empty sorted set")
+	}
+	checkSkiplist(t, z.zsl, nil)
+	if z.zsl.level != 1 {
+		t.Errorf("an empty skiplist has %d levels", z.zsl.level)
+	}
+}
+
+func TestZsetRanges(t *testing.T) {
+	// members sharing a score are ordered lexicographically, lex
+	// ranges are only meaningful over a single score
+	byScore, byLex := newZset(), newZset()
+	var scoreEntries, lexEntries []zsetEntry
+	for i := 0; i < 300; i++ {
+		member := string(rune('a'+i%26)) + strconv.Itoa(i)
+		byScore.add(member, float64(i/10))
+		byLex.add(member, 0)
+		scoreEntries = append(scoreEntries, zsetEntry{member, float64(i / 10)})
+		lexEntries = append(lexEntries, zsetEntry{member, 0})
+	}
+	slices.SortFunc(scoreEntries, compareEntries)
+	slices.SortFunc(lexEntries, compareEntries)
+	for i := 0; i < 1000; i++ {
+		reverse := rand.Intn(2) == 0
+		z, entries := byScore, scoreEntries
+		var r zsetRange = scoreRange{
+			min:          float64(rand.Intn(35) - 2),
+			max:          float64(rand.Intn(35) - 2),
+			minExclusive: rand.Intn(2) == 0,
+			maxExclusive: rand.Intn(2) == 0,
+		}
+		if i%2 == 1 {
+			bound := func() lexBound {
+				return lexBound{
+					value:     string(rune('a' + rand.Intn(28))),
+					exclusive: rand.Intn(2) == 0,
+					infinity:  rand.Intn(5) - 2,
+				}
+			}
+			z, entries, r = byLex, lexEntries, lexRange{bound(), bound()}
+		}
+		var want []zsetEntry
+		for _, entry := range entries {
+			node := &skiplistNode{member: entry.member, score: entry.score}
+			if !r.empty() && r.aboveMin(node) && r.belowMax(node) {
+				want = append(want, entry)
+			}
+		}
+		if reverse {
+			slices.Reverse(want)
+		}
+		if got := z.rangeIn(r, reverse, 0, -1); !slices.Equal(got, want) {
+			t.Fatalf("range %+v returned %d entries, want %d", r, len(got), len(want))
+		}
+		if got := z.countIn(r); got != len(want) {
+			t.Fatalf("range %+v counted %d entries, want %d", r, got, len(want))
+		}
+		offset, count := rand.Intn(20), rand.Intn(20)
+		limited := want[min(offset, len(want)):]
+		limited = limited[:min(count, len(limited))]
+		if got := z.rangeIn(r, reverse, int64(offset), int64(count)); !slices.Equal(got, limited) {
+			t.Fatalf("range %+v with LIMIT %d %d returned %d entries, want %d", r, offset, count, len(got), len(limited))
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		reverse := rand.Intn(2) == 0
+		ranked := slices.Clone(scoreEntries)
+		if reverse {
+			slices.Reverse(ranked)
+		}
+		start, stop := rand.Intn(700)-350, rand.Intn(700)-350
+		first, last := start, stop
+		if first < 0 {
+			first = max(first+len(ranked), 0)
+		}
+		if last < 0 {
+			last += len(ranked)
+		}
+		var want []zsetEntry
+		if first <= last && first < len(ranked) {
+			want = ranked[first : min(last, len(ranked)-1)+1]
+		}
+		if got := byScore.rangeByRank(int64(start), int64(stop), reverse); !slices.Equal(got, want) {
+			t.Fatalf("ranks %d to %d returned %d entries, want %d", start, stop, len(got), len(want))
+		}
+	}
+}
+
+func TestZsetCommands(t *testing.T) {
+	s := newStore()
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ZADD", "z", "1", "one", "2", "two", "3", "three"}, integerReply(3)},
+		{[]string{"ZADD", "z", "1", "uno"}, integerReply(1)},
+		{[]string{"ZADD", "z", "NX", "5", "one", "4", "four"}, integerReply(1)},
+		{[]string{"ZADD", "z", "XX", "CH", "1.5", "one", "9", "nine"}, integerReply(1)},
+		{[]string{"ZADD", "z", "GT", "CH", "1", "one", "3", "two"}, integerReply(1)},
+		{[]string{"ZADD", "z", "LT", "CH", "5", "four", "3.5", "four"}, integerReply(1)},
+		{[]string{"ZMSCORE", "z", "one", "two", "four", "nine"}, "*4\r\n" + bulkReply("1.5") + bulkReply("3") + bulkReply("3.5") + "$-1\r\n"},
+		{[]string{"ZADD", "z", "INCR", "2", "one"}, bulkReply("3.5")},
+		{[]string{"ZADD", "z", "NX", "INCR", "2", "one"}, "$-1\r\n"},
+		{[]string{"ZINCRBY", "z", "-0.5", "one"}, bulkReply("3")},
+		{[]string{"ZADD", "z", "NX", "XX", "1", "one"}, "-XX and NX options at the same time are not compatible\r\n"},
+		{[]string{"ZADD", "z", "GT", "LT", "1", "one"}, "-GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{[]string{"ZADD", "z", "INCR", "1", "one", "1", "two"}, "-INCR option supports a single increment-element pair\r\n"},
+		{[]string{"ZADD", "z", "1", "one", "2"}, "-invalid syntax\r\n"},
+		{[]string{"ZADD", "z", "nan", "one"}, "-value is not a valid float\r\n"},
+		{[]string{"ZADD", "inf", "inf", "a"}, integerReply(1)},
+		{[]string{"ZINCRBY", "inf", "-inf", "a"}, "-resulting score is not a number (NaN)\r\n"},
+		{[]string{"ZCARD", "z"}, integerReply(5)},
+		{[]string{"ZRANGE", "z", "0", "-1", "WITHSCORES"},
+			bulkArray("uno", "1", "one", "3", "three", "3", "two", "3", "four", "3.5")},
+		{[]string{"ZRANGE", "z", "-2", "-1"}, bulkArray("two", "four")},
+		{[]string{"ZRANGE", "z", "0", "1", "REV"}, bulkArray("four", "two")},
+		{[]string{"ZRANGE", "z", "(1", "3", "BYSCORE"}, bulkArray("one", "three", "two")},
+		{[]string{"ZRANGE", "z", "+inf", "(3", "BYSCORE", "REV"}, bulkArray("four")},
+		{[]string{"ZRANGE", "z", "-inf", "+inf", "BYSCORE", "LIMIT", "1", "2"}, bulkArray("one", "three")},
+		{[]string{"ZRANGE", "z", "0", "-1", "LIMIT", "1", "2"},
+			"-syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{[]string{"ZRANGE", "z", "-", "+", "BYLEX", "WITHSCORES"},
+			"-syntax error, WITHSCORES not supported in combination with BYLEX\r\n"},
+		{[]string{"ZRANGE", "z", "a", "b", "BYSCORE"}, "-min or max is not a float\r\n"},
+		{[]string{"ZRANGE", "z", "a", "+", "BYLEX"}, "-min or max not valid string range item\r\n"},
+		{[]string{"ZRANGE", "z", "0", "-1", "BOGUS"}, "-invalid syntax\r\n"},
+		{[]string{"ZRANGE", "missing", "0", "-1"}, "*0\r\n"},
+		{[]string{"ZCOUNT", "z", "3", "3"}, integerReply(3)},
+		{[]string{"ZCOUNT", "z", "(3", "+inf"}, integerReply(1)},
+		{[]string{"ZCOUNT", "z", "x", "1"}, "-min or max is not a float\r\n"},
+		{[]string{"ZRANK", "z", "three"}, integerReply(2)},
+		{[]string{"ZREVRANK", "z", "three", "WITHSCORE"}, "*2\r\n" + integerReply(2) + bulkReply("3")},
+		{[]string{"ZRANK", "z", "missing"}, "$-1\r\n"},
+		{[]string{"ZRANK", "z", "missing", "WITHSCORE"}, "*-1\r\n"},
+		{[]string{"ZSCORE", "z", "four"}, bulkReply("3.5")},
+		{[]string{"ZSCORE", "z", "missing"}, "$-1\r\n"},
+		{[]string{"ZRANGESTORE", "dst", "z", "3", "+inf", "BYSCORE", "LIMIT", "0", "2"}, integerReply(2)},
+		{[]string{"ZRANGE", "dst", "0", "-1"}, bulkArray("one", "three")},
+		{[]string{"ZRANGESTORE", "dst", "z", "5", "+inf", "BYSCORE"}, integerReply(0)},
+		{[]string{"EXISTS", "dst"}, integerReply(0)},
+		{[]string{"ZRANGESTORE", "dst", "z", "0", "-1", "WITHSCORES"}, "-invalid syntax\r\n"},
+		{[]string{"ZREM", "z", "one", "missing", "two"}, integerReply(2)},
+		{[]string{"ZREM", "z", "uno", "three", "four"}, integerReply(3)},
+		{[]string{"EXISTS", "z"}, integerReply(0)},
+		{[]string{"ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d"}, integerReply(4)},
+		{[]string{"ZRANGE", "lex", "[b", "(d", "BYLEX"}, bulkArray("b", "c")},
+		{[]string{"ZRANGE", "lex", "+", "(b", "BYLEX", "REV", "LIMIT", "1", "5"}, bulkArray("c")},
+		{[]string{"SET", "str", "x"}, "+OK\r\n"},
+		{[]string{"ZADD", "str", "1", "a"}, wrongType},
+		{[]string{"ZRANGE", "str", "0", "-1"}, wrongType},
+		{[]string{"ZSCORE", "str", "a"}, wrongType},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+	// scores are formatted the shortest way that reads back the same
+	for _, score := range []float64{0.1, 1e-5, 1e17, 123456789.125, -2.5, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		formatted := formatScore(score)
+		if parsed, ok := parseFloat([]byte(formatted)); !ok || parsed != score {
+			t.Errorf("%v was formatted as %s", score, formatted)
+		}
+	}
+}
+
+func TestZsetAlgebra(t *testing.T) {
+	s := newStore()
+	run(t, s, "ZADD", "zset1", "1", "one", "2", "two")
+	run(t, s, "ZADD", "zset2", "1", "one", "2", "two", "3", "three")
+	run(t, s, "SADD", "set", "two", "four")
+	run(t, s, "ZADD", "inf", "inf", "one", "-inf", "two")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		// the replies redis documents for the same sorted sets
+		{[]string{"ZUNIONSTORE", "out", "2", "zset1", "zset2", "WEIGHTS", "2", "3"}, integerReply(3)},
+		{[]string{"ZRANGE", "out", "0", "-1", "WITHSCORES"}, bulkArray("one", "5", "three", "9", "two", "10")},
+		{[]string{"ZINTERSTORE", "out", "2", "zset1", "zset2", "WEIGHTS", "2", "3"}, integerReply(2)},
+		{[]string{"ZRANGE", "out", "0", "-1", "WITHSCORES"}, bulkArray("one", "5", "two", "10")},
+		{[]string{"ZUNION", "2", "zset1", "zset2", "AGGREGATE", "MAX", "WITHSCORES"},
+			bulkArray("one", "1", "two", "2", "three", "3")},
+		{[]string{"ZINTER", "2", "zset2", "zset1", "AGGREGATE", "MIN"}, bulkArray("one", "two")},
+		{[]string{"ZDIFF", "2", "zset2", "zset1", "WITHSCORES"}, bulkArray("three", "3")},
+		// set members score 1
+		{[]string{"ZUNION", "2", "zset1", "set", "WITHSCORES"}, bulkArray("four", "1", "one", "1", "two", "3")},
+		{[]string{"ZINTER", "3", "zset1", "zset2", "missing"}, "*0\r\n"},
+		// opposite infinities and infinities weighted by 0 score 0
+		{[]string{"ZUNION", "2", "inf", "inf", "WEIGHTS", "1", "-1", "WITHSCORES"}, bulkArray("one", "0", "two", "0")},
+		{[]string{"ZUNION", "1", "inf", "WEIGHTS", "0", "WITHSCORES"}, bulkArray("one", "0", "two", "0")},
+		// the destination can be one of the inputs
+		{[]string{"ZDIFFSTORE", "zset2", "2", "zset2", "zset1"}, integerReply(1)},
+		{[]string{"ZRANGE", "zset2", "0", "-1"}, bulkArray("three")},
+		{[]string{"ZINTERSTORE", "zset2", "2", "zset2", "zset1"}, integerReply(0)},
+		{[]string{"EXISTS", "zset2"}, integerReply(0)},
+		{[]string{"ZUNION", "0", "zset1"}, "-at least 1 input key is needed for 'zunion' command\r\n"},
+		{[]string{"ZUNIONSTORE", "out", "0", "zset1"}, "-at least 1 input key is needed for 'zunionstore' command\r\n"},
+		{[]string{"ZUNION", "3", "zset1", "set"}, "-invalid syntax\r\n"},
+		{[]string{"ZUNION", "2", "zset1", "set", "WEIGHTS", "1"}, "-invalid syntax\r\n"},
+		{[]string{"ZUNION", "1", "zset1", "WEIGHTS", "x"}, "-weight value is not a float\r\n"},
+		{[]string{"ZUNION", "1", "zset1", "AGGREGATE", "AVG"}, "-invalid syntax\r\n"},
+		{[]string{"ZDIFF", "1", "zset1", "WEIGHTS", "1"}, "-invalid syntax\r\n"},
+		{[]string{"ZUNIONSTORE", "out", "1", "zset1", "WITHSCORES"}, "-invalid syntax\r\n"},
+		{[]string{"SET", "str", "x"}, "+OK\r\n"},
+		{[]string{"ZUNION", "2", "zset1", "str"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"ZADD", "pop", "1", "a", "2", "b", "3", "c", "4", "d"}, integerReply(4)},
+		{[]string{"ZPOPMIN", "pop"}, bulkArray("a", "1")},
+		{[]string{"ZPOPMAX", "pop", "2"}, bulkArray("d", "4", "c", "3")},
+		{[]string{"ZPOPMIN", "pop", "0"}, "*0\r\n"},
+		{[]string{"ZPOPMIN", "pop", "-1"}, "-value is out of range, must be positive\r\n"},
+		{[]string{"ZMPOP", "2", "missing", "pop", "MAX", "COUNT", "5"}, "*2\r\n" + bulkReply("pop") + "*1\r\n" + bulkArray("b", "2")},
+		{[]string{"EXISTS", "pop"}, integerReply(0)},
+		{[]string{"ZPOPMIN", "pop"}, "*0\r\n"},
+		{[]string{"ZMPOP", "1", "pop", "MIN"}, "*-1\r\n"},
+		{[]string{"ZMPOP", "1", "pop", "LOWEST"}, "-invalid syntax\r\n"},
+		{[]string{"ZREMRANGEBYSCORE", "out", "(5", "10"}, integerReply(1)},
+		{[]string{"ZREMRANGEBYRANK", "out", "0", "-1"}, integerReply(1)},
+		{[]string{"EXISTS", "out"}, integerReply(0)},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestBlockingZpop(t *testing.T) {
+	s := newStore()
+	if got := runBlocking(t, s, nil, "BZPOPMIN", "z", "0.01"); got != "*-1\r\n" {
+		t.Errorf("BZPOPMIN timed out with %q, want a nil array", got)
+	}
+	run(t, s, "ZADD", "other", "1", "a", "2", "b")
+	if got := runBlocking(t, s, nil, "BZPOPMAX", "z", "other", "0"); got != bulkArray("other", "b", "2") {
+		t.Errorf("BZPOPMAX of a non empty sorted set replied %q", got)
+	}
+	reply := make(chan string, 1)
+	go func() {
+		reply <- runBlocking(t, s, nil, "BZPOPMIN", "z", "0")
+	}()
+	waitForBlocked(t, s, "z", 1)
+	run(t, s, "ZADD", "z", "3", "c", "1", "x")
+	select {
+	case got := <-reply:
+		if got != bulkArray("z", "x", "1") {
+			t.Errorf("the blocked BZPOPMIN was served %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked BZPOPMIN wasn't served")
+	}
+	go func() {
+		reply <- runBlocking(t, s, nil, "BZMPOP", "0", "1", "empty", "MAX", "COUNT", "2")
+	}()
+	waitForBlocked(t, s, "empty", 1)
+	run(t, s, "ZADD", "empty", "1", "a", "2", "b", "3", "c")
+	select {
+	case got := <-reply:
+		if want := "*2\r\n" + bulkReply("empty") + "*2\r\n" + bulkArray("c", "3") + bulkArray("b", "2"); got != want {
+			t.Errorf("the blocked BZMPOP was served %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked BZMPOP wasn't served")
+	}
+}
+
+// TestZpopEmptyZset checks that an empty sorted set, which commands
+// never leave behind, is skipped like a missing one
+func TestZpopEmptyZset(t *testing.T) {
+	s := newStore()
+	s.db["empty"] = redisValue{valueType: "zset", value: newZset()}
+	run(t, s, "ZADD", "z", "1", "a")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"BZPOPMIN", "empty", "0"}, "*-1\r\n"},
+		{[]string{"BZMPOP", "0", "1", "empty", "MIN"}, "*-1\r\n"},
+		{[]string{"BZPOPMAX", "empty", "z", "0"}, bulkArray("z", "a", "1")},
+	}
+	for _, test := range tests {
+		if got := run(t, s, test.args...); got != test.want {
+			t.Errorf("%q = %q, want %q", test.args, got, test.want)
+		}
+	}
+}